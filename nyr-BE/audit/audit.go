@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"nyr/models"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions
+const (
+	ActionLogin            = "auth.login"
+	ActionLoginRefused     = "auth.login_refused"
+	ActionIdentityLinked   = "auth.identity_linked"
+	ActionIdentityUnlinked = "auth.identity_unlinked"
+	ActionTokenCreated     = "token.created"
+	ActionTokenRevoked     = "token.revoked"
+	ActionProfileUpdated   = "profile.updated"
+	ActionRoleChanged      = "user.role_changed"
+	ActionStatusChanged    = "user.status_changed"
+	ActionBlockRemoved     = "block.removed"
+	ActionWebhookDeleted   = "webhook.deleted"
+	ActionModeration       = "moderation.action"
+)
+
+// Types of audit targets, besides the report target types
+const (
+	TargetUser    = "user"
+	TargetToken   = "token"
+	TargetWebhook = "webhook"
+)
+
+// Event describes an action to record. The actor defaults to the logged in user.
+type Event struct {
+	Action     string
+	ActorID    string
+	TargetType string
+	TargetID   string
+	Before     map[string]interface{}
+	After      map[string]interface{}
+	Metadata   map[string]interface{}
+}
+
+// Store persists audit entries, it only ever inserts
+type Store interface {
+	Insert(ctx context.Context, entry models.AuditLog) error
+}
+
+var store Store
+
+// SetStore sets where entries are written. Until it is called nothing is recorded.
+func SetStore(s Store) {
+	store = s
+}
+
+// Record writes an audit entry for the request. A failure to write is logged
+// but never fails the request, the action itself already happened.
+func Record(c *gin.Context, e Event) {
+	if e.ActorID == "" {
+		e.ActorID = c.GetString("user_id")
+	}
+	entry := newEntry(e)
+	entry.IP = c.ClientIP()
+	entry.UserAgent = c.Request.UserAgent()
+	write(entry)
+}
+
+// RecordSystem writes an audit entry for an action the server took on its own, outside
+// of a request. Without an actor the entry has none.
+func RecordSystem(e Event) {
+	write(newEntry(e))
+}
+
+func newEntry(e Event) models.AuditLog {
+	entry := models.AuditLog{
+		ID:         primitive.NewObjectID(),
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		Changes:    Diff(e.Before, e.After),
+		Metadata:   e.Metadata,
+		CreatedAt:  time.Now(),
+	}
+	if actorID, err := primitive.ObjectIDFromHex(e.ActorID); err == nil {
+		entry.ActorID = &actorID
+	}
+	return entry
+}
+
+func write(entry models.AuditLog) {
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := store.Insert(ctx, entry); err != nil {
+		log.Printf("Error writing audit log for %s: %v", entry.Action, err)
+	}
+}
+
+// Diff returns the fields whose value differs between before and after.
+// Missing fields and zero values are treated alike, as they are in a document.
+func Diff(before, after map[string]interface{}) map[string]models.AuditChange {
+	changes := map[string]models.AuditChange{}
+	for key, b := range before {
+		if a := after[key]; !equal(a, b) {
+			changes[key] = models.AuditChange{Before: b, After: a}
+		}
+	}
+	for key, a := range after {
+		if _, ok := before[key]; !ok && !isZero(a) {
+			changes[key] = models.AuditChange{Before: nil, After: a}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func equal(a, b interface{}) bool {
+	if isZero(a) && isZero(b) {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isZero(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
@@ -2,13 +2,27 @@ package config
 
 import (
 	"os"
+	"strings"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/google"
 )
 
+// OIDCSettings holds the settings for a generic OpenID Connect provider.
+// Endpoints are not configured here, they are discovered from the issuer.
+type OIDCSettings struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
 var (
 	GoogleOAuthConfig *oauth2.Config
+	GitHubOAuthConfig *oauth2.Config
+	OIDCConfig        *OIDCSettings
 )
 
 func InitializeOAuthConfig() {
@@ -19,4 +33,43 @@ func InitializeOAuthConfig() {
 		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
 		Endpoint:     google.Endpoint,
 	}
+
+	// GitHub is only enabled when a client ID is configured
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		GitHubOAuthConfig = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("GITHUB_REDIRECT_URL"),
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint:     github.Endpoint,
+		}
+	}
+
+	// Generic OIDC is only enabled when an issuer is configured
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		name := os.Getenv("OIDC_PROVIDER_NAME")
+		if name == "" {
+			name = "oidc"
+		}
+		OIDCConfig = &OIDCSettings{
+			Name:         name,
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		}
+	}
+}
+
+// AdminEmails returns the emails from ADMIN_EMAILS, whose accounts are always admins.
+// This is how the first admin is bootstrapped, later admins can be granted the role.
+func AdminEmails() []string {
+	emails := []string{}
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
 }
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/audit"
+	"nyr/config"
+	"nyr/middleware"
+	"nyr/models"
+	"nyr/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BootstrapAdmins makes every existing account listed in ADMIN_EMAILS an admin, as long
+// as the account signed up with the address verified by its provider. An address an account
+// only gained by linking another identity doesn't count. Accounts created later get the role
+// when they sign up with a verified address.
+func BootstrapAdmins() {
+	emails := config.AdminEmails()
+	if len(emails) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users, err := repos.Users.FindBySignUpEmails(ctx, emails)
+	if err != nil {
+		log.Printf("Error bootstrapping admins: %v", err)
+		return
+	}
+
+	granted := 0
+	for _, user := range users {
+		if user.Role == models.RoleAdmin {
+			continue
+		}
+		// Only the accounts this instance promotes are audited, another may race it
+		promoted, err := repos.Users.GrantAdmin(ctx, user.ID)
+		if err != nil {
+			log.Printf("Error bootstrapping admin %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		if promoted {
+			granted++
+			recordBootstrapAdmin(user, user.Role)
+		}
+	}
+	if granted > 0 {
+		log.Printf("Granted admin role to %d bootstrap account(s)", granted)
+	}
+}
+
+// recordBootstrapAdmin audits the admin role granted to the user because of ADMIN_EMAILS
+func recordBootstrapAdmin(user models.User, before string) {
+	audit.RecordSystem(audit.Event{
+		Action:     audit.ActionRoleChanged,
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.Hex(),
+		Before:     map[string]interface{}{"role": before},
+		After:      map[string]interface{}{"role": models.RoleAdmin},
+		Metadata:   map[string]interface{}{"reason": "ADMIN_EMAILS", "email": user.Email},
+	})
+}
+
+// isBootstrapAdmin reports whether the email is listed in ADMIN_EMAILS
+func isBootstrapAdmin(email string) bool {
+	return email != "" && containsString(config.AdminEmails(), strings.ToLower(email))
+}
+
+// ListUsersByRole lists users, optionally filtered by the role query parameter
+func ListUsersByRole(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	role := c.Query("role")
+	if role != "" && !models.ValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": models.Roles})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users, err := repos.Users.List(ctx, repository.UserQuery{Role: role, Skip: (page - 1) * limit, Limit: limit})
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users, "page": page, "limit": limit})
+}
+
+// GrantRole sets the role of the user in the URL
+func GrantRole(c *gin.Context) {
+	var requestBody struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidRole(requestBody.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": models.Roles})
+		return
+	}
+
+	setRole(c, requestBody.Role)
+}
+
+// RevokeRole turns the user in the URL back into a regular user
+func RevokeRole(c *gin.Context) {
+	setRole(c, models.RoleUser)
+}
+
+func setRole(c *gin.Context, role string) {
+	targetObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	before, err := repos.Users.SetRole(ctx, targetObjectID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case errors.Is(err, repository.ErrLastAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": "Can't remove the last admin"})
+		default:
+			log.Printf("Error updating role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		}
+		return
+	}
+	middleware.InvalidateUser(targetObjectID.Hex())
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionRoleChanged,
+		TargetType: audit.TargetUser,
+		TargetID:   targetObjectID.Hex(),
+		Before:     map[string]interface{}{"role": before.Role},
+		After:      map[string]interface{}{"role": role},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully", "user_id": before.ID, "role": role})
+}
+
+// BanUser bans the user in the URL. Banned users can't sign in and their tokens stop working.
+func BanUser(c *gin.Context) {
+	var requestBody struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+
+	setStatus(c, repository.StatusUpdate{
+		Status: models.UserStatusBanned,
+		Reason: strings.TrimSpace(requestBody.Reason),
+	})
+}
+
+// SuspendUser suspends the user in the URL for the given number of days
+func SuspendUser(c *gin.Context) {
+	var requestBody struct {
+		Reason string `json:"reason" binding:"required"`
+		Days   int    `json:"days" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason and the number of days are required"})
+		return
+	}
+	if requestBody.Days < 1 || requestBody.Days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+
+	until := time.Now().AddDate(0, 0, requestBody.Days)
+	setStatus(c, repository.StatusUpdate{
+		Status:         models.UserStatusSuspended,
+		Reason:         strings.TrimSpace(requestBody.Reason),
+		SuspendedUntil: &until,
+	})
+}
+
+// ReinstateUser lifts a ban or suspension from the user in the URL
+func ReinstateUser(c *gin.Context) {
+	setStatus(c, repository.StatusUpdate{Status: models.UserStatusActive})
+}
+
+func setStatus(c *gin.Context, update repository.StatusUpdate) {
+	targetObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if targetObjectID.Hex() == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't change your own status"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The previous user is returned so the change can be audited
+	update.At = time.Now()
+	before, err := repos.Users.UpdateStatus(ctx, targetObjectID, update)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error updating user status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status"})
+		}
+		return
+	}
+
+	// Apply the change on this instance right away, others pick it up when their cache expires
+	middleware.InvalidateUser(targetObjectID.Hex())
+
+	after := map[string]interface{}{"status": update.Status, "status_reason": nil, "suspended_until": nil}
+	if update.Reason != "" {
+		after["status_reason"] = update.Reason
+	}
+	if update.SuspendedUntil != nil {
+		after["suspended_until"] = *update.SuspendedUntil
+	}
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionStatusChanged,
+		TargetType: audit.TargetUser,
+		TargetID:   targetObjectID.Hex(),
+		Before:     map[string]interface{}{"status": before.Status, "status_reason": before.StatusReason, "suspended_until": before.SuspendedUntil},
+		After:      after,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Status updated successfully", "user_id": targetObjectID, "status": update.Status})
+}
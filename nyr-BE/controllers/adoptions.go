@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/policy"
+	"nyr/pubsub"
+	"nyr/repository"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdoptResolution makes the logged in user a copy of the resolution in the URL, "me too".
+// The copy has the text and tags of the original and links back to it. Each user adopts a
+// resolution once, adopting it again responds with the copy they already have.
+func AdoptResolution(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+
+	source, ok := interactableResolution(c, rID, userObjectID)
+	if !ok {
+		return
+	}
+	if source.UserID == userObjectID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't adopt your own resolution"})
+		return
+	}
+
+	// The text passes the content policy again, it may have changed since the original was written
+	decision := policy.Check(policy.KindResolution, source.Resolution)
+	if decision.Rejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": decision.Reason(), "violations": decision.Violations})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	adopted := models.Resolution{
+		RID:         primitive.NewObjectID(),
+		UserID:      userObjectID,
+		Resolution:  decision.Text,
+		Tags:        append([]string{}, source.Tags...),
+		AdoptedFrom: &source.RID,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	// The handles in the text are resolved for the new author, without notifying anyone again
+	adopted.Mentions, err = resolveMentions(ctx, adopted.Resolution, userObjectID, userObjectID)
+	if err != nil {
+		log.Printf("Error resolving mentions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adopt resolution"})
+		return
+	}
+
+	// The unique index makes a second adoption fail, even when two requests race
+	if err := repos.Resolutions.Insert(ctx, adopted); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			existing, err := repos.Resolutions.FindAdoption(ctx, userObjectID, source.RID)
+			if err != nil {
+				log.Printf("Error looking up adoption: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adopt resolution"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "You already adopted this resolution", "r_id": existing.RID})
+			return
+		}
+		log.Printf("Error inserting resolution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adopt resolution"})
+		return
+	}
+	if decision.Flagged {
+		flagForReview(models.ReportTargetResolution, adopted.RID, userObjectID, decision)
+	}
+
+	notifications.Notify(notifications.Event{
+		Type:         models.NotificationTypeAdoption,
+		RecipientID:  source.UserID,
+		ActorID:      userObjectID,
+		ResolutionID: &source.RID,
+	})
+	publish(pubsub.TopicFeed, pubsub.EventResolutionCreated, userObjectID, userObjectID, adopted)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Resolution adopted successfully",
+		"r_id":         adopted.RID,
+		"adopted_from": source.RID,
+		"mentions":     adopted.Mentions,
+	})
+}
+
+// ListAdopters returns a page of the users who adopted the resolution in the URL, newest
+// first, with their copies. Blocked or muted users are left out for a logged in viewer.
+func ListAdopters(c *gin.Context) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+	page, limit := pagination(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var viewer repository.Viewer
+	if userId := c.GetString("user_id"); userId != "" {
+		userObjectID, _ := primitive.ObjectIDFromHex(userId)
+		viewer.Hidden, viewer.Blockers, err = viewerFilters(ctx, userObjectID)
+		if err != nil {
+			log.Printf("Error loading blocks: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve adopters"})
+			return
+		}
+	}
+
+	// The resolution must be one the viewer could open
+	resolution, err := repos.Resolutions.FindVisible(ctx, rID)
+	for _, blocker := range viewer.Blockers {
+		if err == nil && blocker == resolution.UserID {
+			err = repository.ErrNotFound
+		}
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Resolution not found"})
+			return
+		}
+		log.Printf("Error looking up resolution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve resolution"})
+		return
+	}
+
+	adopters, err := repos.Resolutions.Adopters(ctx, repository.AdopterQuery{
+		RID:    rID,
+		Skip:   (page - 1) * limit,
+		Limit:  limit,
+		Viewer: viewer,
+	})
+	if err != nil {
+		log.Printf("Error loading adopters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve adopters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"adopters": adopters,
+		"page":     page,
+		"limit":    limit,
+	})
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"nyr/repository"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetAuditLog lists audit entries, newest first. They can be filtered by actor, target,
+// action and a time range given as RFC 3339 timestamps in from and to.
+func GetAuditLog(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := repository.AuditQuery{
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		Action:     c.Query("action"),
+		Skip:       (page - 1) * limit,
+		Limit:      limit,
+	}
+	if actor := c.Query("actor"); actor != "" {
+		actorID, err := primitive.ObjectIDFromHex(actor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor ID"})
+			return
+		}
+		query.ActorID = &actorID
+	}
+	for param, bound := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 timestamp"})
+			return
+		}
+		*bound = &t
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entries, err := repos.AuditLogs.List(ctx, query)
+	if err != nil {
+		log.Printf("Error listing audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "page": page, "limit": limit})
+}
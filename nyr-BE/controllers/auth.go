@@ -2,18 +2,20 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
+	"nyr/audit"
 	"nyr/config"
-	"nyr/db"
 	"nyr/models"
+	"nyr/providers"
+	"nyr/repository"
+	"nyr/utils"
 	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -22,7 +24,11 @@ var (
 )
 
 func GoogleLogin(c *gin.Context) {
-	url := config.GoogleOAuthConfig.AuthCodeURL("state")
+	state, ok := newOAuthState(c, "google")
+	if !ok {
+		return
+	}
+	url := config.GoogleOAuthConfig.AuthCodeURL(state)
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
@@ -33,62 +39,40 @@ func GoogleCallback(c *gin.Context) {
 		return
 	}
 
-	token, err := config.GoogleOAuthConfig.Exchange(context.Background(), code)
+	provider, err := providers.Get("google")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Google sign in is not configured"})
 		return
 	}
 
-	client := config.GoogleOAuthConfig.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+	identity, err := provider.Exchange(c.Request.Context(), code)
 	if err != nil {
+		log.Printf("Error exchanging Google code: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user info"})
 		return
 	}
-	defer resp.Body.Close()
 
-	var userInfo map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user info"})
+	user, _, err := findOrCreateUser(c.Request.Context(), identity)
+	if err != nil {
+		log.Printf("Error resolving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	email := userInfo["email"].(string)
-	username := userInfo["name"].(string)
-	image := userInfo["picture"].(string)
-
-	collection := db.GetCollection("users")
-	var existingUser models.User
-	err = collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&existingUser)
-	if err != nil {
-		// Create a new user if not found
-		newUser := models.User{
-			ID:        primitive.NewObjectID(),
-			Name:      username,
-			Email:     email,
-			Image:     image,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		_, err := collection.InsertOne(context.Background(), newUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-			return
-		}
-		existingUser = newUser
+	if user.Status == models.UserStatusBanned {
+		recordLogin(c, user, audit.ActionLoginRefused, false)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account banned", "reason": user.StatusReason})
+		return
 	}
 
-	// Generate JWT token
-	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": existingUser.ID.Hex(),
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	}).SignedString(jwtSecretKey)
+	tokenString, err := generateToken(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": tokenString, "user": existingUser})
+	recordLogin(c, user, audit.ActionLogin, false)
+	c.JSON(http.StatusOK, gin.H{"token": tokenString, "user": user})
 }
 
 func GoogleLoginLatest(c *gin.Context) {
@@ -101,60 +85,247 @@ func GoogleLoginLatest(c *gin.Context) {
 		return
 	}
 
-	// Validate the token with Google's API
-	tokenInfoURL := fmt.Sprintf("https://oauth2.googleapis.com/tokeninfo?id_token=%s", requestBody.Token)
+	provider, err := providers.Get("google")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Google sign in is not configured"})
+		return
+	}
 
-	resp, err := http.Get(tokenInfoURL)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Google token"})
+	google, ok := provider.(*providers.Google)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Google sign in is not configured"})
 		return
 	}
-	defer resp.Body.Close()
 
-	var userInfo map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode user info"})
+	// Validate the token with Google's API
+	identity, err := google.VerifyIDToken(c.Request.Context(), requestBody.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Google token"})
 		return
 	}
 
 	// Process user info (e.g., save to database)
-	email := userInfo["email"].(string)
-	username := userInfo["name"].(string)
-	image := userInfo["picture"].(string)
-
-	collection := db.GetCollection("users")
-	var firstlogin bool
-	var existingUser models.User
-	err = collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&existingUser)
-	firstlogin = false
+	user, firstlogin, err := findOrCreateUser(c.Request.Context(), identity)
+	if err != nil {
+		log.Printf("Error resolving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	respondWithSession(c, user, firstlogin)
+}
+
+// ListProviders returns the names of the identity providers users can sign in with
+func ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": providers.Names()})
+}
+
+// ProviderLogin redirects to the sign in page of the provider in the URL. The app sends the
+// challenge of a verifier it keeps until the callback, see utils.NewOAuthState.
+func ProviderLogin(c *gin.Context) {
+	provider, err := providers.Get(c.Param("provider"))
 	if err != nil {
-		// Create a new user if not found
-		newUser := models.User{
-			ID:        primitive.NewObjectID(),
-			Name:      username,
-			Email:     email,
-			Image:     image,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, ok := newOAuthState(c, provider.Name())
+	if !ok {
+		return
+	}
+
+	url := provider.AuthCodeURL(state)
+	if url == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+// ProviderCallback exchanges the authorization code from a provider for a session
+func ProviderCallback(c *gin.Context) {
+	var requestBody struct {
+		Code     string `json:"code" binding:"required"`
+		State    string `json:"state" binding:"required"`
+		Verifier string `json:"verifier" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	identity, ok := exchangeIdentity(c, requestBody.Code, requestBody.State, requestBody.Verifier)
+	if !ok {
+		return
+	}
+
+	user, firstlogin, err := findOrCreateUser(c.Request.Context(), identity)
+	if err != nil {
+		log.Printf("Error resolving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	respondWithSession(c, user, firstlogin)
+}
+
+// newOAuthState issues the state for a sign in at the provider, bound to the challenge in
+// the query. It writes the error response itself and reports whether the caller should continue.
+func newOAuthState(c *gin.Context, provider string) (string, bool) {
+	challenge := c.Query("challenge")
+	if !utils.ValidOAuthChallenge(challenge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A challenge is required"})
+		return "", false
+	}
+
+	state, err := utils.NewOAuthState(provider, challenge)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+		return "", false
+	}
+	return state, true
+}
+
+// exchangeIdentity verifies the state and trades the code with the provider in the URL.
+// It writes the error response itself and reports whether the caller should continue.
+func exchangeIdentity(c *gin.Context, code, state, verifier string) (*providers.Identity, bool) {
+	provider, err := providers.Get(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return nil, false
+	}
+
+	if err := utils.VerifyOAuthState(state, provider.Name(), verifier); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return nil, false
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), code)
+	if err != nil {
+		log.Printf("Error exchanging code with %s: %v", provider.Name(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to sign in with " + provider.Name()})
+		return nil, false
+	}
+
+	return identity, true
+}
+
+// findOrCreateUser resolves an identity to a user. Users are matched by their linked
+// identity first, then by verified email (linking the identity to that account), and
+// are created otherwise. A new account only keeps the email when the provider verified it,
+// otherwise anyone could sign up with someone else's address and later receive their
+// verified sign in. The boolean result reports whether the user was created.
+func findOrCreateUser(ctx context.Context, identity *providers.Identity) (models.User, bool, error) {
+	email := models.NormalizeEmail(identity.Email)
+	linked := models.Identity{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         email,
+		EmailVerified: identity.EmailVerified,
+		LinkedAt:      time.Now(),
+	}
+
+	existingUser, err := repos.Users.FindByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return existingUser, false, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return models.User{}, false, err
+	}
+
+	// Only a verified email is trusted to link a new identity to an existing account
+	if email != "" && identity.EmailVerified {
+		existingUser, err = repos.Users.LinkIdentityByEmail(ctx, email, linked)
+		if err == nil {
+			return existingUser, false, nil
 		}
-		_, err := collection.InsertOne(context.Background(), newUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-			return
+		if !errors.Is(err, repository.ErrNotFound) {
+			return models.User{}, false, err
 		}
-		firstlogin = true
-		existingUser = newUser
 	}
 
-	// Generate JWT token
-	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": existingUser.ID.Hex(),
+	// Create a new user if not found
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	newUser := models.User{
+		ID:         primitive.NewObjectID(),
+		Name:       name,
+		Image:      identity.Image,
+		Identities: []models.Identity{linked},
+		Role:       models.RoleUser,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if identity.EmailVerified {
+		newUser.Email = email
+	}
+	// Anyone can claim an unverified address, only a verified one earns the bootstrap role
+	bootstrap := isBootstrapAdmin(newUser.Email)
+	if bootstrap {
+		newUser.Role = models.RoleAdmin
+	}
+	err = repos.Users.Insert(ctx, newUser)
+	if errors.Is(err, repository.ErrDuplicate) && newUser.Email != "" {
+		// A concurrent sign in with the address created the account first, or an account
+		// holds the address without a verified identity to link to and keeps it
+		existingUser, err = repos.Users.LinkIdentityByEmail(ctx, email, linked)
+		if err == nil {
+			return existingUser, false, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return models.User{}, false, err
+		}
+		newUser.Email = ""
+		bootstrap = false
+		err = repos.Users.Insert(ctx, newUser)
+	}
+	if err != nil {
+		return models.User{}, false, err
+	}
+	if bootstrap {
+		recordBootstrapAdmin(newUser, models.RoleUser)
+	}
+
+	return newUser, true, nil
+}
+
+// generateToken signs the session JWT for a user
+func generateToken(user models.User) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID.Hex(),
 		"exp":     time.Now().Add(24 * time.Hour).Unix(),
 	}).SignedString(jwtSecretKey)
+}
+
+// respondWithSession writes the login response shared by every sign in method.
+// Banned accounts are refused here, suspended ones can sign in but not act until the suspension ends.
+func respondWithSession(c *gin.Context, user models.User, firstlogin bool) {
+	if user.Status == models.UserStatusBanned {
+		recordLogin(c, user, audit.ActionLoginRefused, firstlogin)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account banned", "reason": user.StatusReason})
+		return
+	}
+
+	tokenString, err := generateToken(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "token": tokenString, "user": existingUser, "firstlogin": firstlogin})
+	recordLogin(c, user, audit.ActionLogin, firstlogin)
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "token": tokenString, "user": user, "firstlogin": firstlogin})
+}
+
+// recordLogin audits a sign in attempt, the route tells which sign in method was used
+func recordLogin(c *gin.Context, user models.User, action string, firstlogin bool) {
+	audit.Record(c, audit.Event{
+		Action:     action,
+		ActorID:    user.ID.Hex(),
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.Hex(),
+		Metadata:   map[string]interface{}{"route": c.FullPath(), "first_login": firstlogin},
+	})
 }
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/repository"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListBlocks returns the users the logged in user blocked or muted, filtered by the kind query parameter
+func ListBlocks(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	kind := c.Query("kind")
+	if kind != "" && kind != models.BlockKindBlock && kind != models.BlockKindMute {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be block or mute"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	blocks, err := repos.Blocks.List(ctx, userObjectID, kind)
+	if err != nil {
+		log.Printf("Error listing blocks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blocks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
+
+// BlockUser blocks or mutes another user
+func BlockUser(c *gin.Context) {
+	var requestBody struct {
+		UserID primitive.ObjectID `json:"user_id" binding:"required"`
+		Kind   string             `json:"kind"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if requestBody.Kind == "" {
+		requestBody.Kind = models.BlockKindBlock
+	}
+	if requestBody.Kind != models.BlockKindBlock && requestBody.Kind != models.BlockKindMute {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be block or mute"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if requestBody.UserID == userObjectID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't " + requestBody.Kind + " yourself"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := repos.Users.FindByID(ctx, requestBody.UserID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error looking up user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + requestBody.Kind + " user"})
+		}
+		return
+	}
+
+	created, err := repos.Blocks.Add(ctx, models.Block{
+		UserID:    userObjectID,
+		TargetID:  requestBody.UserID,
+		Kind:      requestBody.Kind,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error inserting block: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + requestBody.Kind + " user"})
+		return
+	}
+
+	// A block also ends following in both directions
+	if requestBody.Kind == models.BlockKindBlock {
+		if err := repos.Follows.RemoveBetween(ctx, userObjectID, requestBody.UserID); err != nil {
+			log.Printf("Error removing follows after block: %v", err)
+		}
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"message": "User was already " + blockedWord(requestBody.Kind)})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "User " + blockedWord(requestBody.Kind) + " successfully"})
+}
+
+// UnblockUser removes a block or mute, the kind query parameter defaults to block
+func UnblockUser(c *gin.Context) {
+	targetObjectID, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	kind := c.DefaultQuery("kind", models.BlockKindBlock)
+	if kind != models.BlockKindBlock && kind != models.BlockKindMute {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be block or mute"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	removed, err := repos.Blocks.Remove(ctx, userObjectID, targetObjectID, kind)
+	if err != nil {
+		log.Printf("Error deleting block: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove " + kind})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not " + blockedWord(kind)})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionBlockRemoved,
+		TargetType: audit.TargetUser,
+		TargetID:   targetObjectID.Hex(),
+		Metadata:   map[string]interface{}{"kind": kind},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User un" + blockedWord(kind) + " successfully"})
+}
+
+// blockedWord returns "blocked" or "muted" for the response messages
+func blockedWord(kind string) string {
+	if kind == models.BlockKindMute {
+		return "muted"
+	}
+	return "blocked"
+}
+
+// isBlockedBy reports whether owner has blocked viewer
+func isBlockedBy(ctx context.Context, owner, viewer primitive.ObjectID) (bool, error) {
+	return repos.Blocks.Exists(ctx, owner, viewer, models.BlockKindBlock)
+}
+
+// viewerFilters returns the authors whose content is hidden from the viewer in feeds and
+// comment lists (users the viewer blocked or muted, and users who blocked the viewer), and
+// separately the users who blocked the viewer, whose resolutions the viewer can't open at all
+func viewerFilters(ctx context.Context, viewer primitive.ObjectID) ([]primitive.ObjectID, []primitive.ObjectID, error) {
+	blocks, err := repos.Blocks.Involving(ctx, viewer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hidden := []primitive.ObjectID{}
+	blockers := []primitive.ObjectID{}
+	for _, b := range blocks {
+		if b.UserID == viewer {
+			hidden = append(hidden, b.TargetID)
+		} else {
+			hidden = append(hidden, b.UserID)
+			blockers = append(blockers, b.UserID)
+		}
+	}
+	return hidden, blockers, nil
+}
+
+// interactableResolution loads a resolution the user wants to like or comment on. It checks
+// that the resolution exists, is not hidden and that its owner has not blocked the user.
+// It writes the error response itself and reports whether the caller should continue.
+func interactableResolution(c *gin.Context, rID, userID primitive.ObjectID) (models.Resolution, bool) {
+	resolution, reqErr := loadInteractableResolution(rID, userID)
+	if reqErr != nil {
+		c.JSON(reqErr.Status, reqErr.Body)
+		return resolution, false
+	}
+	return resolution, true
+}
+
+// loadInteractableResolution is interactableResolution for callers that respond on their own
+func loadInteractableResolution(rID, userID primitive.ObjectID) (models.Resolution, *requestError) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resolution, err := repos.Resolutions.FindVisible(ctx, rID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return resolution, &requestError{http.StatusNotFound, gin.H{"error": "Resolution not found"}}
+		}
+		log.Printf("Error looking up resolution: %v", err)
+		return resolution, &requestError{http.StatusInternalServerError, gin.H{"error": "Failed to retrieve resolution"}}
+	}
+
+	blocked, err := isBlockedBy(ctx, resolution.UserID, userID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		return resolution, &requestError{http.StatusInternalServerError, gin.H{"error": "Failed to check blocks"}}
+	}
+	if blocked {
+		return resolution, &requestError{http.StatusForbidden, gin.H{"error": "You can't interact with this resolution"}}
+	}
+
+	return resolution, nil
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/repository"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxCollectionLength is the longest bookmark collection name, in characters
+const maxCollectionLength = 50
+
+// BookmarkResolution saves the resolution in the URL for the logged in user, in the
+// collection from the optional body. Bookmarking it again moves it to that collection.
+func BookmarkResolution(c *gin.Context) {
+	var requestBody struct {
+		Collection string `json:"collection"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	collection := strings.TrimSpace(requestBody.Collection)
+	if utf8.RuneCountInString(collection) > maxCollectionLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Collection names can be at most 50 characters"})
+		return
+	}
+
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	// The resolution must exist and its owner must not have blocked the user
+	if _, ok := interactableResolution(c, rID, userObjectID); !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	created, err := repos.Bookmarks.Set(ctx, models.Bookmark{
+		ID:         primitive.NewObjectID(),
+		UserID:     userObjectID,
+		RID:        rID,
+		Collection: collection,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error saving bookmark: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to bookmark resolution"})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"message":    "Resolution bookmarked successfully",
+		"r_id":       rID,
+		"collection": collection,
+		"bookmarked": true,
+	})
+}
+
+// UnbookmarkResolution removes the resolution in the URL from the logged in user's bookmarks.
+// It works on resolutions that were hidden since, and removing it again changes nothing.
+func UnbookmarkResolution(c *gin.Context) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := repos.Bookmarks.Remove(ctx, userObjectID, rID); err != nil {
+		log.Printf("Error removing bookmark: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove bookmark"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Bookmark removed successfully",
+		"r_id":       rID,
+		"bookmarked": false,
+	})
+}
+
+// ListBookmarks returns a page of the logged in user's bookmarks, newest first, optionally
+// of one collection. Hidden resolutions and blocked or muted authors are left out.
+func ListBookmarks(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	page, limit := pagination(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hidden, _, err := viewerFilters(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error loading blocks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve bookmarks"})
+		return
+	}
+
+	bookmarks, err := repos.Bookmarks.List(ctx, repository.BookmarkQuery{
+		UserID:     userObjectID,
+		Collection: strings.TrimSpace(c.Query("collection")),
+		Skip:       (page - 1) * limit,
+		Limit:      limit,
+		Viewer:     repository.Viewer{Hidden: hidden},
+	})
+	if err != nil {
+		log.Printf("Error loading bookmarks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve bookmarks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bookmarks": bookmarks,
+		"page":      page,
+		"limit":     limit,
+	})
+}
+
+// ListBookmarkCollections returns the logged in user's bookmark collections with their sizes
+func ListBookmarkCollections(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collections, err := repos.Bookmarks.Collections(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error loading bookmark collections: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve collections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"collections": collections})
+}
@@ -2,10 +2,15 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
-	"nyr/db"
+	"nyr/emails"
 	"nyr/models"
+	"nyr/notifications"
+	"nyr/policy"
+	"nyr/pubsub"
+	"nyr/repository"
 	"strings"
 	"time"
 
@@ -13,6 +18,13 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// requestError is the status and JSON body of a failed request, returned by logic that is
+// shared between handlers which respond in different ways
+type requestError struct {
+	Status int
+	Body   gin.H
+}
+
 // CreateComment handles the creation of a new comment.
 func CreateComment(c *gin.Context) {
 	var newComment models.Comments
@@ -22,39 +34,126 @@ func CreateComment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// Check that the resolution ID and user ID are provided
-	if newComment.RID.IsZero() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ResolutionID is required"})
+
+	userId := c.GetString("user_id")
+	userObjectID, _ := primitive.ObjectIDFromHex(userId)
+
+	newComment, reqErr := createComment(userObjectID, newComment)
+	if reqErr != nil {
+		c.JSON(reqErr.Status, reqErr.Body)
 		return
 	}
+
+	// Return the success message along with the ID of the newly created comment
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Comment created successfully",
+		"comment_id": newComment.ID,
+		"mentions":   newComment.Mentions,
+	})
+}
+
+// createComment checks and stores a comment by the user, then tells everyone involved.
+// Every way of commenting goes through it, the API as well as Slack.
+func createComment(userObjectID primitive.ObjectID, newComment models.Comments) (models.Comments, *requestError) {
+	// Check that the resolution ID is provided
+	if newComment.RID.IsZero() {
+		return newComment, &requestError{http.StatusBadRequest, gin.H{"error": "ResolutionID is required"}}
+	}
+	if newComment.Kind != "" && newComment.Kind != models.CommentKindCheckIn {
+		return newComment, &requestError{http.StatusBadRequest, gin.H{"error": "kind must be empty or checkin"}}
+	}
 	newComment.Comment = strings.TrimSpace(newComment.Comment)
 	if newComment.Comment == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment cannot be empty"})
-		return
+		return newComment, &requestError{http.StatusBadRequest, gin.H{"error": "Comment cannot be empty"}}
 	}
 
-	userId := c.GetString("user_id")
-	userObjectID, _ := primitive.ObjectIDFromHex(userId)
+	// Run the content policy, which can reject the text, mask parts of it or flag it for review
+	decision := policy.Check(policy.KindComment, newComment.Comment)
+	if decision.Rejected {
+		return newComment, &requestError{http.StatusBadRequest, gin.H{"error": decision.Reason(), "violations": decision.Violations}}
+	}
+	newComment.Comment = decision.Text
+
+	// The resolution must exist and its owner must not have blocked the commenter
+	resolution, reqErr := loadInteractableResolution(newComment.RID, userObjectID)
+	if reqErr != nil {
+		return newComment, reqErr
+	}
+	if newComment.Kind == models.CommentKindCheckIn && resolution.UserID != userObjectID {
+		return newComment, &requestError{http.StatusForbidden, gin.H{"error": "Only the owner can check in on a resolution"}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A reply must answer a visible comment on the same resolution
+	var parent models.Comments
+	if newComment.ParentID != nil {
+		var err error
+		parent, err = repos.Comments.FindVisible(ctx, *newComment.ParentID, newComment.RID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return newComment, &requestError{http.StatusNotFound, gin.H{"error": "Parent comment not found"}}
+			}
+			log.Printf("Error looking up parent comment: %v", err)
+			return newComment, &requestError{http.StatusInternalServerError, gin.H{"error": "Failed to create comment"}}
+		}
+	}
+
+	// Mentions come from the text, never from the request
+	mentioned, err := resolveMentions(ctx, newComment.Comment, userObjectID, resolution.UserID)
+	if err != nil {
+		log.Printf("Error resolving mentions: %v", err)
+		return newComment, &requestError{http.StatusInternalServerError, gin.H{"error": "Failed to create comment"}}
+	}
+	newComment.Mentions = mentioned
 
 	// Set created and updated times
+	newComment.ID = primitive.NewObjectID()
 	newComment.UserID = userObjectID
+	newComment.Hidden = false
 	newComment.CreatedAt = time.Now()
 	newComment.UpdatedAt = time.Now()
 
-	// Get the comments collection from the database
-	collection := db.GetCollection("comments")
-
 	// Insert the new comment into the database
-	result, err := collection.InsertOne(context.Background(), newComment)
-	if err != nil {
+	if err := repos.Comments.Insert(ctx, newComment); err != nil {
 		log.Printf("Error inserting comment: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
-		return
+		return newComment, &requestError{http.StatusInternalServerError, gin.H{"error": "Failed to create comment"}}
 	}
 
-	// Return the success message along with the ID of the newly created comment
-	c.JSON(http.StatusCreated, gin.H{
-		"message":    "Comment created successfully",
-		"comment_id": result.InsertedID,
-	})
+	if decision.Flagged {
+		flagForReview(models.ReportTargetComment, newComment.ID, userObjectID, decision)
+	}
+
+	// The author of the parent hears about a reply, the owner about every other comment,
+	// and mentioned users who don't hear about it already are told they were mentioned
+	notified := []primitive.ObjectID{}
+	notifyOwner := true
+	if newComment.ParentID != nil {
+		notified = append(notified, parent.UserID)
+		notifications.Notify(notifications.Event{
+			Type:         models.NotificationTypeReply,
+			RecipientID:  parent.UserID,
+			ActorID:      userObjectID,
+			ResolutionID: &resolution.RID,
+			CommentID:    &newComment.ID,
+		})
+		emails.Reply(resolution, parent, newComment)
+		notifyOwner = parent.UserID != resolution.UserID
+	}
+	if notifyOwner {
+		notified = append(notified, resolution.UserID)
+		notifications.Notify(notifications.Event{
+			Type:         models.NotificationTypeComment,
+			RecipientID:  resolution.UserID,
+			ActorID:      userObjectID,
+			ResolutionID: &resolution.RID,
+			CommentID:    &newComment.ID,
+		})
+		emails.Comment(resolution, newComment)
+	}
+	notifyMentions(newComment.Mentions, userObjectID, &resolution.RID, &newComment.ID, notified...)
+	publish(pubsub.ResolutionTopic(newComment.RID), pubsub.EventCommentCreated, userObjectID, resolution.UserID, newComment)
+
+	return newComment, nil
 }
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/repository"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FollowUser makes the logged in user follow the user in the URL
+func FollowUser(c *gin.Context) {
+	targetObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if targetObjectID == userObjectID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't follow yourself"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := repos.Users.FindByID(ctx, targetObjectID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error looking up user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow user"})
+		}
+		return
+	}
+
+	blocked, err := isBlockedBy(ctx, targetObjectID, userObjectID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check blocks"})
+		return
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't follow this user"})
+		return
+	}
+
+	created, err := repos.Follows.Add(ctx, models.Follow{UserID: userObjectID, TargetID: targetObjectID, CreatedAt: time.Now()})
+	if err != nil {
+		log.Printf("Error inserting follow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow user"})
+		return
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"message": "You already follow this user"})
+		return
+	}
+
+	notifications.Notify(followEvent(targetObjectID, userObjectID))
+	c.JSON(http.StatusCreated, gin.H{"message": "User followed successfully"})
+}
+
+// UnfollowUser makes the logged in user stop following the user in the URL
+func UnfollowUser(c *gin.Context) {
+	targetObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	removed, err := repos.Follows.Remove(ctx, userObjectID, targetObjectID)
+	if err != nil {
+		log.Printf("Error deleting follow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow user"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You don't follow this user"})
+		return
+	}
+
+	notifications.Retract(followEvent(targetObjectID, userObjectID))
+	c.JSON(http.StatusOK, gin.H{"message": "User unfollowed successfully"})
+}
+
+func followEvent(targetID, userID primitive.ObjectID) notifications.Event {
+	return notifications.Event{
+		Type:        models.NotificationTypeFollow,
+		RecipientID: targetID,
+		ActorID:     userID,
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"nyr/db"
+	"nyr/models"
+	"nyr/repository"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// maxFeedLimit is the most resolutions a single feed page can return
+const maxFeedLimit = 50
+
 // GetResolutions fetches the resolutions with like count, comment count, and user information
 func GetResolutions(c *gin.Context) {
 	// Check if the user is logged in
@@ -35,122 +38,61 @@ func GetResolutions(c *gin.Context) {
 	}
 
 	// Get the page and limit query parameters
-	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-	if err != nil {
-		page = 1 // default to page 1 if no page parameter is provided or invalid
-	}
-	limit, err := strconv.Atoi(c.DefaultQuery("limit", "15"))
-	if err != nil {
-		limit = 6 // default to 6 items per page
-	}
+	page, limit := pagination(c)
 
-	sortFactor := c.DefaultQuery("sort", "likes")
+	sortFactor := c.DefaultQuery("sort", repository.SortLikes)
+	if sortFactor != repository.SortNewest {
+		sortFactor = repository.SortLikes // Default to sorting by like count
+	}
 
-	sortField := bson.M{} // Initialize an empty sort field map
+	// Hidden resolutions are never listed, and logged in users don't see blocked or muted authors
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Dynamically determine the sort field based on the sortFactor
-	if sortFactor == "created_at" {
-		sortField["created_at"] = -1 // Sort by "created_at" in descending order
-	} else {
-		sortField["like_count"] = -1 // Default to sorting by "like_count" in descending order
+	query := repository.FeedQuery{Sort: sortFactor, Skip: (page - 1) * limit, Limit: limit}
+	if isLoggedIn {
+		hidden, _, err := viewerFilters(ctx, userObjectID)
+		if err != nil {
+			log.Printf("Error loading blocks: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve resolutions"})
+			return
+		}
+		query.Viewer.Hidden = hidden
 	}
 
-	// Calculate skip for pagination
-	skip := (page - 1) * limit
-
-	// Get the resolutions collection
-	resolutionsCollection := db.GetCollection("resolutions")
-
-	// Query to get resolutions with like count, comment count, and user information
-	cursor, err := resolutionsCollection.Aggregate(context.Background(), []bson.M{
-		{
-			"$lookup": bson.M{
-				"from":         "likes", // Join with the "likes" collection
-				"localField":   "_id",   // Match the resolution ID (_id in resolutions)
-				"foreignField": "r_id",  // Match with r_id in likes
-				"as":           "likes", // Store the matched likes in a field named "likes"
-			},
-		},
-		{
-			"$lookup": bson.M{
-				"from":         "comments", // Join with the "comments" collection
-				"localField":   "_id",      // Match the resolution ID (_id in resolutions)
-				"foreignField": "r_id",     // Match with r_id in comments
-				"as":           "comments", // Store the matched comments in a field named "comments"
-			},
-		},
-		{
-			"$lookup": bson.M{
-				"from":         "users",   // Join with the "users" collection
-				"localField":   "user_id", // Match the user_id in resolutions
-				"foreignField": "_id",     // Match with the _id in users
-				"as":           "user",    // Store the matched user in a field named "user"
-			},
-		},
-		{
-			"$project": bson.M{
-				"resolution":    1,                                                      // Include the resolution field
-				"like_count":    bson.M{"$size": "$likes"},                              // Count likes
-				"comment_count": bson.M{"$size": "$comments"},                           // Count comments
-				"tags":          1,                                                      // Include tags array
-				"user_id":       1,                                                      // Include user_id to link the resolution to the user
-				"created_at":    1,                                                      // Include created_at field
-				"updated_at":    1,                                                      // Include updated_at field
-				"user_name":     bson.M{"$arrayElemAt": []interface{}{"$user.name", 0}}, // Get the user's name
-			},
-		},
-		{
-			"$sort": sortField,
-		},
-		{
-			"$skip": skip,
-		},
-		{
-			"$limit": limit,
-		},
-	})
-
+	summaries, err := repos.Resolutions.Feed(ctx, query)
 	if err != nil {
-		log.Printf("Error during aggregation: %v", err)
+		log.Printf("Error loading feed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve resolutions"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	// Parse the aggregation result into a slice of resolutions
-	var resolutions []bson.M
-	if err := cursor.All(context.Background(), &resolutions); err != nil {
-		log.Printf("Error parsing aggregation result: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
-		return
+	// If user is logged in, check how they reacted to the page's resolutions and which
+	// they bookmarked, one query each for the whole page
+	reactions := map[primitive.ObjectID]string{}
+	bookmarked := map[primitive.ObjectID]bool{}
+	if isLoggedIn {
+		reactions, err = repos.Reactions.ByUser(ctx, userObjectID, models.ReactionTargetResolution, summaryIDs(summaries))
+		if err != nil {
+			log.Printf("Error checking user likes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check user likes"})
+			return
+		}
+		bookmarked, err = repos.Bookmarks.BookmarkedBy(ctx, userObjectID, summaryIDs(summaries))
+		if err != nil {
+			log.Printf("Error checking user bookmarks: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check user bookmarks"})
+			return
+		}
 	}
 
-	// If user is logged in, check if user has liked any resolution
-	for i := range resolutions {
-		rID, ok := resolutions[i]["_id"].(primitive.ObjectID)
-		if ok {
-			// Query the "likes" collection to check if the logged-in user has liked this resolution
-			likeFilter := bson.M{
-				"user_id": userObjectID, // Match the logged-in user_id
-				"r_id":    rID,          // Match the current resolution_id
-			}
-
-			// Use FindOne to check if the user has liked this resolution
-			var userLike bson.M
-			err := db.GetCollection("likes").FindOne(context.Background(), likeFilter).Decode(&userLike)
-			if err != nil {
-				// If no like document is found, the user has not liked this resolution
-				if err == mongo.ErrNoDocuments {
-					resolutions[i]["hasLiked"] = false
-				} else {
-					log.Printf("Error checking user like: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check user likes"})
-					return
-				}
-			} else {
-				// If a like document is found, the user has liked this resolution
-				resolutions[i]["hasLiked"] = true
-			}
+	resolutions := make([]feedResolution, len(summaries))
+	for i, summary := range summaries {
+		resolutions[i] = feedResolution{
+			Summary:       summary,
+			HasLiked:      reactions[summary.ID] == models.ReactionLike,
+			MyReaction:    reactions[summary.ID],
+			HasBookmarked: bookmarked[summary.ID],
 		}
 	}
 
@@ -161,3 +103,39 @@ func GetResolutions(c *gin.Context) {
 		"limit":       limit,
 	})
 }
+
+// pagination reads the page and limit query parameters, falling back to the defaults
+func pagination(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		page = 1 // default to page 1 if no page parameter is provided or invalid
+	}
+	if page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "15"))
+	if err != nil || limit < 1 {
+		limit = 6 // default to 6 items per page
+	}
+	if limit > maxFeedLimit {
+		limit = maxFeedLimit // don't let clients ask for the whole collection at once
+	}
+	return page, limit
+}
+
+// feedResolution is a resolution in the feed with the viewer's reaction and bookmark
+type feedResolution struct {
+	repository.Summary
+	HasLiked      bool   `json:"hasLiked"`
+	MyReaction    string `json:"myReaction,omitempty"`
+	HasBookmarked bool   `json:"hasBookmarked"`
+}
+
+// summaryIDs returns the IDs of the listed resolutions
+func summaryIDs(summaries []repository.Summary) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(summaries))
+	for i, summary := range summaries {
+		ids[i] = summary.ID
+	}
+	return ids
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/repository"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LinkIdentity links an account at the provider in the URL to the logged in user
+func LinkIdentity(c *gin.Context) {
+	var requestBody struct {
+		Code     string `json:"code" binding:"required"`
+		State    string `json:"state" binding:"required"`
+		Verifier string `json:"verifier" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	identity, ok := exchangeIdentity(c, requestBody.Code, requestBody.State, requestBody.Verifier)
+	if !ok {
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The identity must not already belong to another account
+	owner, err := repos.Users.FindByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if owner.ID == userObjectID {
+			c.JSON(http.StatusOK, gin.H{"message": "Identity already linked"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "Identity is linked to another account"})
+		}
+		return
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Error looking up identity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
+
+	linked := models.Identity{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         models.NormalizeEmail(identity.Email),
+		EmailVerified: identity.EmailVerified,
+		LinkedAt:      time.Now(),
+	}
+	if err := repos.Users.AddIdentity(ctx, userObjectID, linked); err != nil {
+		if errors.Is(err, repository.ErrIdentityLinked) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Identity is linked to another account"})
+			return
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error linking identity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionIdentityLinked,
+		TargetType: audit.TargetUser,
+		TargetID:   userObjectID.Hex(),
+		Metadata:   map[string]interface{}{"provider": linked.Provider, "email": linked.Email},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Identity linked successfully", "identity": linked})
+}
+
+// UnlinkIdentity removes one of the logged in user's identities at the provider in the URL.
+// Users with several accounts at the provider pick one with ?subject=. The last identity
+// can't be removed, otherwise the user could no longer sign in.
+func UnlinkIdentity(c *gin.Context) {
+	provider := c.Param("provider")
+	subject := c.Query("subject")
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if subject == "" {
+		user, err := repos.Users.FindByID(ctx, userObjectID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			log.Printf("Error fetching user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+			return
+		}
+		matching := 0
+		for _, identity := range user.Identities {
+			if identity.Provider == provider {
+				subject = identity.Subject
+				matching++
+			}
+		}
+		if matching > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Several identities are linked at this provider, choose one with subject"})
+			return
+		}
+	}
+
+	removed, err := repos.Users.RemoveIdentity(ctx, userObjectID, provider, subject)
+	if err != nil {
+		log.Printf("Error unlinking identity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Identity is not linked or is the only way to sign in"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionIdentityUnlinked,
+		TargetType: audit.TargetUser,
+		TargetID:   userObjectID.Hex(),
+		Metadata:   map[string]interface{}{"provider": provider},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked successfully"})
+}
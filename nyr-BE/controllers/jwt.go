@@ -2,17 +2,16 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"net/http"
-	"nyr/db"
 	"nyr/models"
+	"nyr/repository"
 	"nyr/utils"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func VerifyTokenHandler(c *gin.Context) {
@@ -36,7 +35,6 @@ func VerifyTokenHandler(c *gin.Context) {
 		return
 	}
 
-	userCollection := db.GetCollection("users") // Replace with your MongoDB helper
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -46,10 +44,9 @@ func VerifyTokenHandler(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	err = userCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	user, err := repos.Users.FindByID(ctx, objectID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -57,6 +54,11 @@ func VerifyTokenHandler(c *gin.Context) {
 		return
 	}
 
+	if user.Status == models.UserStatusBanned {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account banned", "reason": user.StatusReason})
+		return
+	}
+
 	// Return user details
 	c.JSON(http.StatusOK, user)
 }
@@ -4,15 +4,15 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"nyr/db"
 	"nyr/models"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// ToggleLikeResolution likes or unlikes a resolution depending on the current state. Clients
+// that can retry should use PUT and DELETE /resolution/:id/like, which are idempotent.
 func ToggleLikeResolution(c *gin.Context) {
 	var request struct {
 		RID primitive.ObjectID `json:"r_id" binding:"required"`
@@ -27,44 +27,51 @@ func ToggleLikeResolution(c *gin.Context) {
 		return
 	}
 
-	// Get the "likes" collection from the database
-	collection := db.GetCollection("likes")
-
-	filter := bson.D{
-		bson.E{Key: "user_id", Value: userObjectID},
-		bson.E{Key: "r_id", Value: request.RID},
+	// The resolution must exist and its owner must not have blocked the user
+	resolution, ok := interactableResolution(c, request.RID, userObjectID)
+	if !ok {
+		return
 	}
 
-	// Check if a like already exists for the user and resolution
-	var existingLike models.Likes
-	err := collection.FindOne(context.Background(), filter).Decode(&existingLike)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	if err == nil {
-		// If the like exists, remove it (unlike)
-		_, err := collection.DeleteOne(context.Background(), filter)
-		if err != nil {
-			log.Printf("Error deleting like from MongoDB: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlike resolution"})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"message": "Resolution unliked successfully"})
+	target := newResolutionTarget(resolution)
+
+	// If the like exists, remove it (unlike)
+	removed, err := repos.Reactions.Remove(ctx, userObjectID, models.ReactionTargetResolution, request.RID, models.ReactionLike)
+	if err != nil {
+		log.Printf("Error deleting like: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlike resolution"})
 		return
 	}
-
-	// If no like exists, insert a new like (like the post)
-	newLike := models.Likes{
-		ID:        primitive.NewObjectID(),
-		UserID:    userObjectID,
-		RID:       request.RID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if removed != "" {
+		reactionChanged(ctx, target, userObjectID, removed, "")
+		c.JSON(http.StatusOK, gin.H{"message": "Resolution unliked successfully"})
+		return
 	}
 
-	_, err = collection.InsertOne(context.Background(), newLike)
+	// If no like exists, like the post, which replaces any other reaction of the user
+	previous, err := repos.Reactions.Set(ctx, newReaction(userObjectID, target, models.ReactionLike))
 	if err != nil {
-		log.Printf("Error inserting like into MongoDB: %v\n", err)
+		log.Printf("Error inserting like: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like resolution"})
 		return
 	}
+	if previous != models.ReactionLike {
+		reactionChanged(ctx, target, userObjectID, previous, models.ReactionLike)
+	}
 	c.JSON(http.StatusCreated, gin.H{"message": "Resolution liked successfully"})
 }
+
+// LikeResolution likes the resolution in the URL. Liking it again changes nothing,
+// so clients can retry and double clicks are harmless.
+func LikeResolution(c *gin.Context) {
+	react(c, resolutionTarget, models.ReactionLike)
+}
+
+// UnlikeResolution removes the like from the resolution in the URL, if there is one.
+// Other reactions of the user are left alone.
+func UnlikeResolution(c *gin.Context) {
+	unreact(c, ownResolutionTarget, models.ReactionLike)
+}
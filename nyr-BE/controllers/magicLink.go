@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"nyr/mailer"
+	"nyr/models"
+	"nyr/providers"
+	"nyr/repository"
+	"nyr/utils"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+// RequestMagicLink emails a single use sign in link to the given address.
+// The response is the same whether or not an account exists for the address.
+func RequestMagicLink(c *gin.Context) {
+	var requestBody struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		return
+	}
+	email := models.NormalizeEmail(requestBody.Email)
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign in link"})
+		return
+	}
+
+	link := models.MagicLink{
+		ID:        hex.EncodeToString(idBytes),
+		Email:     email,
+		ExpiresAt: time.Now().Add(magicLinkTTL),
+		CreatedAt: time.Now(),
+	}
+
+	token, err := utils.NewMagicLinkToken(link.ID, link.Email, magicLinkTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign in link"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := repos.MagicLinks.Insert(ctx, link); err != nil {
+		log.Printf("Error inserting magic link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign in link"})
+		return
+	}
+
+	signInURL := os.Getenv("MAGIC_LINK_URL") + "?token=" + url.QueryEscape(token)
+	err = mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your ResolveXYZ sign in link",
+		Text: fmt.Sprintf("Use this link to sign in to ResolveXYZ:\n\n%s\n\nThe link expires in %d minutes and can only be used once. If you didn't ask for it, you can ignore this email.\n",
+			signInURL, int(magicLinkTTL.Minutes())),
+	})
+	if err != nil {
+		log.Printf("Error sending magic link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send sign in link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address can receive email, a sign in link is on its way"})
+}
+
+// VerifyMagicLink exchanges a magic link token for the same session as the other sign in methods
+func VerifyMagicLink(c *gin.Context) {
+	var requestBody struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := utils.VerifyMagicLinkToken(requestBody.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign in link"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	link, err := repos.MagicLinks.Redeem(ctx, claims.ID, claims.Email, time.Now())
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Error redeeming magic link: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify sign in link"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign in link"})
+		return
+	}
+
+	// The link proves the address, it joins only an account that verified the address too
+	user, firstlogin, err := findOrCreateUser(ctx, &providers.Identity{
+		Provider:      "email",
+		Subject:       link.Email,
+		Email:         link.Email,
+		EmailVerified: true,
+	})
+	if err != nil {
+		log.Printf("Error resolving user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	respondWithSession(c, user, firstlogin)
+}
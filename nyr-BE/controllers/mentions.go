@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"nyr/mentions"
+	"nyr/models"
+	"nyr/notifications"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxMentions is the most users one text can mention, further handles stay plain text
+const maxMentions = 10
+
+// resolveMentions finds the @handles in the text by the author and keeps the ones that
+// resolve to a user the author may mention. Neither may have blocked the other, banned users
+// can't be mentioned, and on a comment the owner of the resolution must not have blocked the
+// mentioned user, who couldn't see the resolution anyway.
+func resolveMentions(ctx context.Context, text string, authorID, ownerID primitive.ObjectID) ([]models.Mention, error) {
+	found := mentions.Parse(text)
+	if len(found) == 0 {
+		return []models.Mention{}, nil
+	}
+	handles := mentions.Handles(found)
+	if len(handles) > maxMentions {
+		handles = handles[:maxMentions]
+	}
+
+	users, err := repos.Users.FindByHandles(ctx, handles)
+	if err != nil {
+		return nil, err
+	}
+
+	// One query for the blocks between the author and anyone, and one for the owner's
+	excluded := map[primitive.ObjectID]bool{}
+	blocks, err := repos.Blocks.Involving(ctx, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != authorID {
+		ownerBlocks, err := repos.Blocks.Involving(ctx, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range ownerBlocks {
+			if b.UserID == ownerID && b.Kind == models.BlockKindBlock {
+				excluded[b.TargetID] = true
+			}
+		}
+	}
+	for _, b := range blocks {
+		if b.Kind != models.BlockKindBlock {
+			continue
+		}
+		if b.UserID == authorID {
+			excluded[b.TargetID] = true
+		} else {
+			excluded[b.UserID] = true
+		}
+	}
+
+	byHandle := map[string]primitive.ObjectID{}
+	for _, user := range users {
+		if excluded[user.ID] || user.EffectiveStatus(time.Now()) == models.UserStatusBanned {
+			continue
+		}
+		byHandle[user.Handle] = user.ID
+	}
+
+	resolved := []models.Mention{}
+	for _, mention := range found {
+		if userID, ok := byHandle[mention.Handle]; ok {
+			mention.UserID = userID
+			resolved = append(resolved, mention)
+		}
+	}
+	return resolved, nil
+}
+
+// notifyMentions notifies each mentioned user once, except the ones in skip who already
+// hear about the content another way
+func notifyMentions(mentioned []models.Mention, actorID primitive.ObjectID, resolutionID, commentID *primitive.ObjectID, skip ...primitive.ObjectID) {
+	notified := map[primitive.ObjectID]bool{}
+	for _, id := range skip {
+		notified[id] = true
+	}
+	for _, mention := range mentioned {
+		if notified[mention.UserID] {
+			continue
+		}
+		notified[mention.UserID] = true
+		notifications.Notify(notifications.Event{
+			Type:         models.NotificationTypeMention,
+			RecipientID:  mention.UserID,
+			ActorID:      actorID,
+			ResolutionID: resolutionID,
+			CommentID:    commentID,
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/audit"
+	"nyr/middleware"
+	"nyr/models"
+	"nyr/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions a moderator can take on a reported target
+const (
+	ModerationHide    = "hide"
+	ModerationDismiss = "dismiss"
+	ModerationWarn    = "warn"
+	ModerationSuspend = "suspend"
+)
+
+const defaultSuspensionDays = 7
+
+// GetModerationQueue lists reported targets with open reports, grouped per target and
+// ordered by the highest severity and then by the number of reports
+func GetModerationQueue(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queue, err := repos.Reports.Queue(ctx, repository.QueueQuery{
+		TargetType: c.Query("target_type"),
+		Skip:       (page - 1) * limit,
+		Limit:      limit,
+	})
+	if err != nil {
+		log.Printf("Error listing moderation queue: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve moderation queue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queue": queue, "page": page, "limit": limit})
+}
+
+// ModerateTarget applies a moderator action to a reported target and closes its open reports
+func ModerateTarget(c *gin.Context) {
+	var requestBody struct {
+		TargetType  string             `json:"target_type" binding:"required"`
+		TargetID    primitive.ObjectID `json:"target_id" binding:"required"`
+		Action      string             `json:"action" binding:"required"`
+		Reason      string             `json:"reason"`
+		SuspendDays int                `json:"suspend_days"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	requestBody.Reason = strings.TrimSpace(requestBody.Reason)
+
+	moderatorID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	targetUserID, err := reportTargetOwner(ctx, requestBody.TargetType, requestBody.TargetID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Target not found"})
+		} else if errors.Is(err, errUnknownTarget) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target_type must be resolution, comment or user"})
+		} else {
+			log.Printf("Error looking up moderation target: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate target"})
+		}
+		return
+	}
+
+	now := time.Now()
+	status := models.ReportStatusActioned
+	response := gin.H{"message": "Moderation action applied"}
+
+	switch requestBody.Action {
+	case ModerationHide:
+		switch requestBody.TargetType {
+		case models.ReportTargetResolution:
+			err = repos.Resolutions.Hide(ctx, requestBody.TargetID, now)
+		case models.ReportTargetComment:
+			err = repos.Comments.Hide(ctx, requestBody.TargetID, now)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only resolutions and comments can be hidden"})
+			return
+		}
+
+	case ModerationDismiss:
+		status = models.ReportStatusDismissed
+
+	case ModerationWarn:
+		if requestBody.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to warn a user"})
+			return
+		}
+		if !canModerateUser(ctx, c, targetUserID) {
+			return
+		}
+		err = repos.Warnings.Insert(ctx, models.Warning{
+			ID:          primitive.NewObjectID(),
+			UserID:      targetUserID,
+			ModeratorID: moderatorID,
+			Reason:      requestBody.Reason,
+			TargetType:  requestBody.TargetType,
+			TargetID:    requestBody.TargetID,
+			CreatedAt:   now,
+		})
+
+	case ModerationSuspend:
+		if requestBody.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to suspend a user"})
+			return
+		}
+		days := requestBody.SuspendDays
+		if days == 0 {
+			days = defaultSuspensionDays
+		}
+		if days < 1 || days > 365 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "suspend_days must be between 1 and 365"})
+			return
+		}
+		if !canModerateUser(ctx, c, targetUserID) {
+			return
+		}
+		until := now.AddDate(0, 0, days)
+		_, err = repos.Users.UpdateStatus(ctx, targetUserID, repository.StatusUpdate{
+			Status:         models.UserStatusSuspended,
+			Reason:         requestBody.Reason,
+			SuspendedUntil: &until,
+			At:             now,
+		})
+		middleware.InvalidateUser(targetUserID.Hex())
+		response["suspended_until"] = until
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be hide, dismiss, warn or suspend"})
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error applying moderation action %s: %v", requestBody.Action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate target"})
+		return
+	}
+
+	// Close every open report on the target with the outcome
+	closed, err := repos.Reports.Close(ctx, requestBody.TargetType, requestBody.TargetID, repository.ReportOutcome{
+		Status:     status,
+		Action:     requestBody.Action,
+		ResolvedBy: moderatorID,
+		At:         now,
+	})
+	if err != nil {
+		log.Printf("Error closing reports: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Action applied but failed to close reports"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionModeration,
+		TargetType: requestBody.TargetType,
+		TargetID:   requestBody.TargetID.Hex(),
+		Metadata: map[string]interface{}{
+			"action":          requestBody.Action,
+			"reason":          requestBody.Reason,
+			"target_user_id":  targetUserID.Hex(),
+			"reports_closed":  closed,
+			"suspended_until": response["suspended_until"],
+		},
+	})
+
+	response["reports_closed"] = closed
+	c.JSON(http.StatusOK, response)
+}
+
+// canModerateUser stops moderators from acting against other staff, only admins can.
+// It writes the error response itself and reports whether the caller should continue.
+func canModerateUser(ctx context.Context, c *gin.Context, targetUserID primitive.ObjectID) bool {
+	target, err := repos.Users.FindByID(ctx, targetUserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error loading moderation target user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate target"})
+		}
+		return false
+	}
+
+	if target.HasRole(models.RoleModerator) && c.GetString("user_role") != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can act against moderators and admins"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/emails"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/repository"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetNotifications lists the logged in user's notifications, most recent activity first,
+// together with the number of unread ones. unread=true leaves out read notifications.
+func GetNotifications(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := repos.Notifications.List(ctx, repository.NotificationQuery{
+		UserID:     userObjectID,
+		UnreadOnly: c.Query("unread") == "true",
+		Skip:       (page - 1) * limit,
+		Limit:      limit,
+	})
+	if err != nil {
+		log.Printf("Error listing notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notifications"})
+		return
+	}
+
+	items := []gin.H{}
+	for _, n := range results {
+		actorName := ""
+		if n.Actor != nil {
+			actorName = n.Actor.Name
+		}
+		items = append(items, gin.H{
+			"id":          n.ID,
+			"type":        n.Type,
+			"message":     notifications.Message(n.Notification, actorName),
+			"actor":       n.Actor,
+			"actor_count": len(n.ActorIDs),
+			"r_id":        n.ResolutionID,
+			"comment_id":  n.CommentID,
+			"read":        n.Read,
+			"created_at":  n.CreatedAt,
+			"updated_at":  n.UpdatedAt,
+		})
+	}
+
+	unread, err := repos.Notifications.CountUnread(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error counting unread notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": items, "unread_count": unread, "page": page, "limit": limit})
+}
+
+// MarkNotificationRead marks one of the logged in user's notifications as read
+func MarkNotificationRead(c *gin.Context) {
+	notificationObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	marked, err := repos.Notifications.MarkRead(ctx, notificationObjectID, userObjectID, time.Now())
+	if err != nil {
+		log.Printf("Error marking notification read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+		return
+	}
+	if !marked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// MarkAllNotificationsRead marks every unread notification of the logged in user as read
+func MarkAllNotificationsRead(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updated, err := repos.Notifications.MarkAllRead(ctx, userObjectID, time.Now())
+	if err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read", "updated": updated})
+}
+
+// GetNotificationPreferences returns whether each notification type is on for the logged in user
+func GetNotificationPreferences(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := repos.Users.FindByID(ctx, userObjectID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error loading user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve preferences"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": notificationPreferences(user)})
+}
+
+// UpdateNotificationPreferences turns notification types on or off, types left out keep their setting
+func UpdateNotificationPreferences(c *gin.Context) {
+	var requestBody map[string]bool
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for notificationType := range requestBody {
+		if !containsString(models.NotificationTypes, notificationType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type: " + notificationType, "types": models.NotificationTypes})
+			return
+		}
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := repos.Users.UpdateNotificationPreferences(ctx, userObjectID, requestBody)
+	if err != nil {
+		log.Printf("Error updating notification preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Preferences updated successfully", "preferences": notificationPreferences(user)})
+}
+
+// notificationPreferences lists every notification type with its setting
+func notificationPreferences(user models.User) map[string]bool {
+	preferences := map[string]bool{}
+	for _, notificationType := range models.NotificationTypes {
+		preferences[notificationType] = notifications.Enabled(user, notificationType)
+	}
+	return preferences
+}
+
+// GetEmailPreferences returns whether each email category is on for the logged in user
+func GetEmailPreferences(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := repos.Users.FindByID(ctx, userObjectID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error loading user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve preferences"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": emailPreferences(user)})
+}
+
+// UpdateEmailPreferences turns email categories on or off, the weekly digest is turned on here
+func UpdateEmailPreferences(c *gin.Context) {
+	var requestBody map[string]bool
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for category := range requestBody {
+		if !containsString(models.EmailCategories, category) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown email category: " + category, "categories": models.EmailCategories})
+			return
+		}
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := repos.Users.UpdateEmailPreferences(ctx, userObjectID, requestBody)
+	if err != nil {
+		log.Printf("Error updating email preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Preferences updated successfully", "preferences": emailPreferences(user)})
+}
+
+// emailPreferences lists every email category with its setting
+func emailPreferences(user models.User) map[string]bool {
+	preferences := map[string]bool{}
+	for _, category := range models.EmailCategories {
+		preferences[category] = emails.Enabled(user, category)
+	}
+	return preferences
+}
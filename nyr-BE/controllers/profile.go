@@ -2,12 +2,17 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
-	"nyr/db"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/policy"
+	"nyr/repository"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -23,24 +28,95 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	requestBody.Name = strings.TrimSpace(requestBody.Name)
+	if requestBody.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+		return
+	}
+
+	// Run the content policy, which can reject the name, mask parts of it or flag it for review
+	decision := policy.Check(policy.KindName, requestBody.Name)
+	if decision.Rejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": decision.Reason(), "violations": decision.Violations})
+		return
+	}
+	requestBody.Name = decision.Text
+
 	userObjectID, _ := primitive.ObjectIDFromHex(userID)
-	filter := bson.M{"_id": userObjectID}
-	update := bson.M{"$set": bson.M{"name": requestBody.Name}}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	userCollection := db.GetCollection("users")
-	result, err := userCollection.UpdateOne(ctx, filter, update)
+	// The previous user is returned so the change can be audited
+	before, err := repos.Users.UpdateName(ctx, userObjectID, requestBody.Name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		}
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionProfileUpdated,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Before:     map[string]interface{}{"name": before.Name},
+		After:      map[string]interface{}{"name": requestBody.Name},
+	})
+
+	if decision.Flagged {
+		flagForReview(models.ReportTargetUser, userObjectID, userObjectID, decision)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "User name updated successfully"})
 }
+
+// UpdateHandle sets the handle others @mention the logged in user by
+func UpdateHandle(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var requestBody struct {
+		Handle string `json:"handle" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Handles are case insensitive, so they are stored lowercase
+	handle := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(requestBody.Handle), "@"))
+	if !models.ValidHandle(handle) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Handle must be 3 to 30 letters, digits or underscores"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before, err := repos.Users.UpdateHandle(ctx, userObjectID, handle)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicate):
+			c.JSON(http.StatusConflict, gin.H{"error": "Handle is already taken"})
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			log.Printf("Error updating handle: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update handle"})
+		}
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionProfileUpdated,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Before:     map[string]interface{}{"handle": before.Handle},
+		After:      map[string]interface{}{"handle": handle},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Handle updated successfully", "handle": handle})
+}
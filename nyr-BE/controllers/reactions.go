@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/pubsub"
+	"nyr/repository"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reactionTarget is what a reaction is left on, a resolution or one of its comments
+type reactionTarget struct {
+	Type       string
+	ID         primitive.ObjectID
+	OwnerID    primitive.ObjectID
+	Resolution models.Resolution
+	// Hidden is set for targets moderators hid or that no longer exist, reactions can be
+	// taken back from them but changes aren't published
+	Hidden bool
+}
+
+// targetLoader loads the target in the URL for the user, it writes the error response itself
+type targetLoader func(c *gin.Context, userID primitive.ObjectID) (reactionTarget, bool)
+
+// ReactToResolution sets the user's reaction to the resolution in the URL, replacing their
+// earlier one. Sending the same reaction again changes nothing.
+func ReactToResolution(c *gin.Context) {
+	if reactionType, ok := bindReactionType(c); ok {
+		react(c, resolutionTarget, reactionType)
+	}
+}
+
+// RemoveReaction removes the user's reaction to the resolution in the URL, whatever its type
+func RemoveReaction(c *gin.Context) {
+	unreact(c, ownResolutionTarget, "")
+}
+
+// ReactToComment sets the user's reaction to the comment in the URL, like ReactToResolution
+func ReactToComment(c *gin.Context) {
+	if reactionType, ok := bindReactionType(c); ok {
+		react(c, commentTarget, reactionType)
+	}
+}
+
+// RemoveCommentReaction removes the user's reaction to the comment in the URL, whatever its type
+func RemoveCommentReaction(c *gin.Context) {
+	unreact(c, ownCommentTarget, "")
+}
+
+// bindReactionType reads a known reaction type from the request body
+func bindReactionType(c *gin.Context) (string, bool) {
+	var requestBody struct {
+		Type string `json:"type" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if !containsString(models.ReactionTypes, requestBody.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reaction: " + requestBody.Type, "types": models.ReactionTypes})
+		return "", false
+	}
+	return requestBody.Type, true
+}
+
+// react sets the user's reaction to the target in the URL and responds with the reactions
+func react(c *gin.Context, load targetLoader, reactionType string) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	target, ok := load(c, userObjectID)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A single atomic upsert, the unique index keeps one reaction per user and target
+	previous, err := repos.Reactions.Set(ctx, newReaction(userObjectID, target, reactionType))
+	if err != nil {
+		log.Printf("Error setting reaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reaction"})
+		return
+	}
+
+	respondWithReactions(ctx, c, target, userObjectID, previous, reactionType)
+}
+
+// unreact removes the user's reaction to the target in the URL, only if it has the
+// type when one is given, and responds with the reactions
+func unreact(c *gin.Context, load targetLoader, reactionType string) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	target, ok := load(c, userObjectID)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	removed, err := repos.Reactions.Remove(ctx, userObjectID, target.Type, target.ID, reactionType)
+	if err != nil {
+		log.Printf("Error removing reaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reaction"})
+		return
+	}
+
+	// Nothing changed when there was no such reaction, though one of another type may be left
+	previous, current := removed, ""
+	if removed == "" && reactionType != "" {
+		reactions, err := repos.Reactions.ByUser(ctx, userObjectID, target.Type, []primitive.ObjectID{target.ID})
+		if err != nil {
+			log.Printf("Error loading reaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reaction"})
+			return
+		}
+		current = reactions[target.ID]
+		previous = current
+	}
+
+	respondWithReactions(ctx, c, target, userObjectID, previous, current)
+}
+
+// resolutionTarget loads the resolution in the URL, which must exist and whose owner must
+// not have blocked the user
+func resolutionTarget(c *gin.Context, userID primitive.ObjectID) (reactionTarget, bool) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return reactionTarget{}, false
+	}
+
+	resolution, ok := interactableResolution(c, rID, userID)
+	return newResolutionTarget(resolution), ok
+}
+
+// commentTarget loads the comment in the URL. Neither the owner of its resolution nor its
+// author may have blocked the user.
+func commentTarget(c *gin.Context, userID primitive.ObjectID) (reactionTarget, bool) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return reactionTarget{}, false
+	}
+	commentID, err := primitive.ObjectIDFromHex(c.Param("comment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return reactionTarget{}, false
+	}
+
+	resolution, ok := interactableResolution(c, rID, userID)
+	if !ok {
+		return reactionTarget{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	comment, err := repos.Comments.FindVisible(ctx, commentID, rID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+			return reactionTarget{}, false
+		}
+		log.Printf("Error looking up comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve comment"})
+		return reactionTarget{}, false
+	}
+
+	blocked, err := isBlockedBy(ctx, comment.UserID, userID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check blocks"})
+		return reactionTarget{}, false
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't interact with this comment"})
+		return reactionTarget{}, false
+	}
+
+	return reactionTarget{
+		Type:       models.ReactionTargetComment,
+		ID:         comment.ID,
+		OwnerID:    comment.UserID,
+		Resolution: resolution,
+	}, true
+}
+
+// ownResolutionTarget loads the resolution in the URL for taking a reaction back. Users can
+// always take back their own reactions, so hidden resolutions and blocks don't stop them,
+// like removing a bookmark.
+func ownResolutionTarget(c *gin.Context, userID primitive.ObjectID) (reactionTarget, bool) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return reactionTarget{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resolution, ok := anyResolution(ctx, c, rID)
+	return newResolutionTarget(resolution), ok
+}
+
+// ownCommentTarget loads the comment in the URL for taking a reaction back, see ownResolutionTarget
+func ownCommentTarget(c *gin.Context, userID primitive.ObjectID) (reactionTarget, bool) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return reactionTarget{}, false
+	}
+	commentID, err := primitive.ObjectIDFromHex(c.Param("comment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return reactionTarget{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resolution, ok := anyResolution(ctx, c, rID)
+	if !ok {
+		return reactionTarget{}, false
+	}
+
+	comment, err := repos.Comments.Find(ctx, commentID)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && comment.RID != rID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return reactionTarget{}, false
+	}
+	if err != nil {
+		log.Printf("Error looking up comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve comment"})
+		return reactionTarget{}, false
+	}
+
+	return reactionTarget{
+		Type:       models.ReactionTargetComment,
+		ID:         comment.ID,
+		OwnerID:    comment.UserID,
+		Resolution: resolution,
+		Hidden:     comment.Hidden || resolution.Hidden,
+	}, true
+}
+
+// anyResolution loads the resolution whether or not it is hidden
+func anyResolution(ctx context.Context, c *gin.Context, rID primitive.ObjectID) (models.Resolution, bool) {
+	resolution, err := repos.Resolutions.Find(ctx, rID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resolution not found"})
+		return resolution, false
+	}
+	if err != nil {
+		log.Printf("Error looking up resolution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve resolution"})
+		return resolution, false
+	}
+	return resolution, true
+}
+
+func newResolutionTarget(resolution models.Resolution) reactionTarget {
+	return reactionTarget{
+		Type:       models.ReactionTargetResolution,
+		ID:         resolution.RID,
+		OwnerID:    resolution.UserID,
+		Resolution: resolution,
+		Hidden:     resolution.Hidden,
+	}
+}
+
+// respondWithReactions tells everyone involved about a change from the previous to the
+// current reaction, then responds with the user's reaction and the counts
+func respondWithReactions(ctx context.Context, c *gin.Context, target reactionTarget, userID primitive.ObjectID, previous, current string) {
+	counts, err := repos.Reactions.Counts(ctx, target.Type, target.ID)
+	if err != nil {
+		log.Printf("Error counting reactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count reactions"})
+		return
+	}
+
+	// Only a change is news, repeating a request doesn't notify anyone again
+	if previous != current {
+		notifyReactionChange(target, userID, previous, current)
+		if !target.Hidden {
+			publishReactionUpdate(target, userID, previous, current, counts)
+		}
+	}
+
+	response := gin.H{
+		"r_id":            target.Resolution.RID,
+		"reaction":        current,
+		"liked":           current == models.ReactionLike,
+		"like_count":      counts[models.ReactionLike],
+		"reaction_counts": counts,
+	}
+	if target.Type == models.ReactionTargetComment {
+		response["comment_id"] = target.ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// reactionChanged notifies and publishes a change for handlers that don't respond with the counts
+func reactionChanged(ctx context.Context, target reactionTarget, userID primitive.ObjectID, previous, current string) {
+	notifyReactionChange(target, userID, previous, current)
+
+	counts, err := repos.Reactions.Counts(ctx, target.Type, target.ID)
+	if err != nil {
+		log.Printf("Error counting reactions: %v", err)
+		return
+	}
+	publishReactionUpdate(target, userID, previous, current, counts)
+}
+
+func newReaction(userID primitive.ObjectID, target reactionTarget, reactionType string) models.Reaction {
+	return models.Reaction{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		TargetType: target.Type,
+		TargetID:   target.ID,
+		Type:       reactionType,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+}
+
+// notifyReactionChange takes the old reaction out of the owner's notifications and adds the new one
+func notifyReactionChange(target reactionTarget, userID primitive.ObjectID, previous, current string) {
+	if target.OwnerID.IsZero() {
+		return
+	}
+	if previous != "" {
+		notifications.Retract(reactionEvent(target, userID, previous))
+	}
+	if current != "" {
+		notifications.Notify(reactionEvent(target, userID, current))
+	}
+}
+
+// reactionEvent is the notification about a reaction, likes keep their own notification type
+func reactionEvent(target reactionTarget, userID primitive.ObjectID, reactionType string) notifications.Event {
+	notificationType := models.NotificationTypeReaction
+	if reactionType == models.ReactionLike {
+		notificationType = models.NotificationTypeLike
+	}
+	event := notifications.Event{
+		Type:         notificationType,
+		RecipientID:  target.OwnerID,
+		ActorID:      userID,
+		ResolutionID: &target.Resolution.RID,
+	}
+	if target.Type == models.ReactionTargetComment {
+		event.CommentID = &target.ID
+	}
+	return event
+}
+
+// publishReactionUpdate tells the resolution's subscribers and webhooks about the new reaction counts.
+// A like of the resolution that came or went is also published as a like update, for
+// clients that only know likes.
+func publishReactionUpdate(target reactionTarget, userID primitive.ObjectID, previous, current string, counts map[string]int64) {
+	rID := target.Resolution.RID
+	publish(pubsub.ResolutionTopic(rID), pubsub.EventReactionUpdated, userID, target.Resolution.UserID, gin.H{
+		"r_id":            rID,
+		"target_type":     target.Type,
+		"target_id":       target.ID,
+		"user_id":         userID,
+		"reaction":        current,
+		"previous":        previous,
+		"reaction_counts": counts,
+	})
+
+	if target.Type == models.ReactionTargetResolution && (previous == models.ReactionLike || current == models.ReactionLike) {
+		publish(pubsub.ResolutionTopic(rID), pubsub.EventLikeUpdated, userID, target.Resolution.UserID, gin.H{
+			"r_id":       rID,
+			"user_id":    userID,
+			"liked":      current == models.ReactionLike,
+			"like_count": counts[models.ReactionLike],
+		})
+	}
+}
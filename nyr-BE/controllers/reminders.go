@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/reminders"
+	"nyr/repository"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListReminders returns the logged in user's check in reminders
+func ListReminders(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := repos.Reminders.ListByUser(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error listing reminders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reminders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reminders": list})
+}
+
+// SetReminder creates or replaces the check in reminder of the logged in user's resolution in
+// the URL. hour is local to the user's timezone, weekday (0 is Sunday) is used by weekly and
+// day_of_month by monthly reminders.
+func SetReminder(c *gin.Context) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+
+	var requestBody struct {
+		Frequency  string `json:"frequency" binding:"required"`
+		Hour       *int   `json:"hour"`
+		Weekday    *int   `json:"weekday"`
+		DayOfMonth *int   `json:"day_of_month"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reminder := models.Reminder{Frequency: requestBody.Frequency, Hour: 9, Weekday: int(time.Monday), DayOfMonth: 1}
+	if requestBody.Frequency != models.ReminderDaily && requestBody.Frequency != models.ReminderWeekly && requestBody.Frequency != models.ReminderMonthly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be daily, weekly or monthly"})
+		return
+	}
+	if requestBody.Hour != nil {
+		reminder.Hour = *requestBody.Hour
+	}
+	if requestBody.Weekday != nil {
+		reminder.Weekday = *requestBody.Weekday
+	}
+	if requestBody.DayOfMonth != nil {
+		reminder.DayOfMonth = *requestBody.DayOfMonth
+	}
+	if reminder.Hour < 0 || reminder.Hour > 23 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hour must be between 0 and 23"})
+		return
+	}
+	if reminder.Weekday < 0 || reminder.Weekday > 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "weekday must be between 0 (Sunday) and 6 (Saturday)"})
+		return
+	}
+	// Every month has the 28th
+	if reminder.DayOfMonth < 1 || reminder.DayOfMonth > 28 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "day_of_month must be between 1 and 28"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Only the owner sets reminders for a resolution, hidden or not
+	resolution, err := repos.Resolutions.Find(ctx, rID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Error looking up resolution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set reminder"})
+		return
+	}
+	if err != nil || resolution.UserID != userObjectID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resolution not found"})
+		return
+	}
+
+	user, err := repos.Users.FindByID(ctx, userObjectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	reminder.UserID = userObjectID
+	reminder.RID = rID
+	reminder.Timezone = user.Timezone
+	reminder.NextRunAt = reminders.NextRun(reminder, now)
+	reminder.CreatedAt = now
+	reminder.UpdatedAt = now
+
+	reminder, err = repos.Reminders.Set(ctx, reminder)
+	if err != nil {
+		log.Printf("Error saving reminder: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set reminder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reminder set successfully", "reminder": reminder})
+}
+
+// DeleteReminder removes the check in reminder of the logged in user's resolution in the URL
+func DeleteReminder(c *gin.Context) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deleted, err := repos.Reminders.Delete(ctx, userObjectID, rID)
+	if err != nil {
+		log.Printf("Error deleting reminder: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reminder"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reminder deleted successfully"})
+}
+
+// UpdateTimezone sets the logged in user's timezone and moves their reminders to it
+func UpdateTimezone(c *gin.Context) {
+	var requestBody struct {
+		Timezone string `json:"timezone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := time.LoadLocation(requestBody.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone, use an IANA name like Europe/Berlin"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := repos.Users.UpdateTimezone(ctx, userObjectID, requestBody.Timezone); err != nil {
+		log.Printf("Error updating timezone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update timezone"})
+		return
+	}
+
+	// Reschedule the reminders at the same local hour in the new timezone
+	list, err := repos.Reminders.ListByUser(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error loading reminders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Timezone updated but failed to reschedule reminders"})
+		return
+	}
+	now := time.Now()
+	for _, reminder := range list {
+		reminder.Timezone = requestBody.Timezone
+		if err := repos.Reminders.Reschedule(ctx, reminder.ID, reminder.Timezone, reminders.NextRun(reminder, now)); err != nil {
+			log.Printf("Error rescheduling reminder: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Timezone updated successfully", "timezone": requestBody.Timezone})
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/policy"
+	"nyr/repository"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateReport flags a resolution, comment or user for the moderators.
+// A user has at most one open report per target, reporting again updates it.
+func CreateReport(c *gin.Context) {
+	var requestBody struct {
+		TargetType string             `json:"target_type" binding:"required"`
+		TargetID   primitive.ObjectID `json:"target_id" binding:"required"`
+		Reason     string             `json:"reason" binding:"required"`
+		Details    string             `json:"details"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	severity, ok := models.ReportReasons[requestBody.Reason]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reason"})
+		return
+	}
+	requestBody.Details = strings.TrimSpace(requestBody.Details)
+	if len(requestBody.Details) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Details can't be longer than 1000 characters"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	targetUserID, err := reportTargetOwner(ctx, requestBody.TargetType, requestBody.TargetID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reported content not found"})
+		} else if errors.Is(err, errUnknownTarget) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target_type must be resolution, comment or user"})
+		} else {
+			log.Printf("Error looking up report target: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report"})
+		}
+		return
+	}
+	if targetUserID == userObjectID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't report yourself"})
+		return
+	}
+
+	// The reporter's open report on the target is updated, so repeated reports don't pile up
+	now := time.Now()
+	report := models.Report{
+		ID:           primitive.NewObjectID(),
+		ReporterID:   userObjectID,
+		TargetType:   requestBody.TargetType,
+		TargetID:     requestBody.TargetID,
+		TargetUserID: targetUserID,
+		Reason:       requestBody.Reason,
+		Details:      requestBody.Details,
+		Severity:     severity,
+		Status:       models.ReportStatusOpen,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	created, err := repos.Reports.Open(ctx, report)
+	if err != nil {
+		log.Printf("Error inserting report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report"})
+		return
+	}
+
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"message": "You already reported this, your report was updated"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Report submitted successfully", "report_id": report.ID})
+}
+
+var errUnknownTarget = errors.New("unknown report target type")
+
+// reportTargetOwner returns the user responsible for a report target
+func reportTargetOwner(ctx context.Context, targetType string, targetID primitive.ObjectID) (primitive.ObjectID, error) {
+	switch targetType {
+	case models.ReportTargetResolution:
+		resolution, err := repos.Resolutions.Find(ctx, targetID)
+		return resolution.UserID, err
+	case models.ReportTargetComment:
+		comment, err := repos.Comments.Find(ctx, targetID)
+		return comment.UserID, err
+	case models.ReportTargetUser:
+		user, err := repos.Users.FindByID(ctx, targetID)
+		return user.ID, err
+	default:
+		return primitive.NilObjectID, errUnknownTarget
+	}
+}
+
+// flagForReview puts content that the content policy flagged into the moderation queue.
+// Automatic reports have no reporter.
+func flagForReview(targetType string, targetID, ownerID primitive.ObjectID, decision policy.Decision) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	err := repos.Reports.Insert(ctx, models.Report{
+		ID:           primitive.NewObjectID(),
+		ReporterID:   primitive.NilObjectID,
+		TargetType:   targetType,
+		TargetID:     targetID,
+		TargetUserID: ownerID,
+		Reason:       models.ReportReasonContentPolicy,
+		Details:      "Flagged by rules: " + strings.Join(decision.FlaggedRules(), ", "),
+		Severity:     models.ReportReasons["spam"],
+		Status:       models.ReportStatusOpen,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	if err != nil {
+		log.Printf("Error flagging %s %s for review: %v", targetType, targetID.Hex(), err)
+	}
+}
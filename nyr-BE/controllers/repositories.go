@@ -0,0 +1,11 @@
+package controllers
+
+import "nyr/repository"
+
+// repos is the storage the handlers read and write through
+var repos repository.Repositories
+
+// SetRepositories sets the storage used by the handlers, the routes set it on startup
+func SetRepositories(r repository.Repositories) {
+	repos = r
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"nyr/db"
 	"nyr/models"
+	"nyr/policy"
+	"nyr/pubsub"
 
 	"strings"
 	"time"
@@ -28,21 +29,47 @@ func CreateResolution(c *gin.Context) {
 		return
 	}
 
+	// Run the content policy, which can reject the text, mask parts of it or flag it for review
+	decision := policy.Check(policy.KindResolution, newResolution.Resolution)
+	if decision.Rejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": decision.Reason(), "violations": decision.Violations})
+		return
+	}
+	newResolution.Resolution = decision.Text
+
 	userId := c.GetString("user_id")
 	userObjectID, _ := primitive.ObjectIDFromHex(userId)
 	newResolution.UserID = userObjectID
+	newResolution.Hidden = false
+	newResolution.AdoptedFrom = nil // Only adopting a resolution links to another
 	newResolution.RID = primitive.NewObjectID()
 	newResolution.CreatedAt = time.Now()
 	newResolution.UpdatedAt = time.Now()
-	collection := db.GetCollection("resolutions")
-	result, err := collection.InsertOne(context.Background(), newResolution)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Mentions come from the text, never from the request
+	mentioned, err := resolveMentions(ctx, newResolution.Resolution, userObjectID, userObjectID)
 	if err != nil {
-		log.Printf("Error inserting into MongoDB: %v\n", err)
+		log.Printf("Error resolving mentions: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create resolution"})
 		return
 	}
+	newResolution.Mentions = mentioned
+
+	if err := repos.Resolutions.Insert(ctx, newResolution); err != nil {
+		log.Printf("Error inserting resolution: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create resolution"})
+		return
+	}
+	if decision.Flagged {
+		flagForReview(models.ReportTargetResolution, newResolution.RID, userObjectID, decision)
+	}
+	notifyMentions(newResolution.Mentions, userObjectID, &newResolution.RID, nil)
+	publish(pubsub.TopicFeed, pubsub.EventResolutionCreated, userObjectID, userObjectID, newResolution)
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Resolution created successfully",
-		"r_id":    result.InsertedID,
+		"message":  "Resolution created successfully",
+		"r_id":     newResolution.RID,
+		"mentions": newResolution.Mentions,
 	})
 }
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/repository"
+	"nyr/slack"
+	"nyr/utils"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	slackProvider    = "slack"
+	slackLinkTTL     = 15 * time.Minute
+	slackCommandHelp = "Usage:\n• `/resolve link` connect your ResolveXYZ account\n• `/resolve checkin <resolution id> <update>` check in on your resolution\n• `/resolve comment <resolution id> <comment>` comment on a resolution"
+)
+
+// SlackCommand handles the /resolve slash command. Slack shows the reply to the user,
+// so every outcome is answered with 200 and a message.
+func SlackCommand(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form"})
+		return
+	}
+	command := slack.ParseSlashCommand(c.Request.PostForm)
+
+	subcommand, rest, _ := strings.Cut(strings.TrimSpace(command.Text), " ")
+	switch strings.ToLower(subcommand) {
+	case "link":
+		token, err := utils.NewSlackLinkToken(command.TeamID, command.TeamDomain, command.UserID, command.UserName, slackLinkTTL)
+		if err != nil {
+			c.JSON(http.StatusOK, slack.Ephemeral("Failed to create a link, please try again"))
+			return
+		}
+		linkURL := os.Getenv("SLACK_LINK_URL") + "?token=" + url.QueryEscape(token)
+		c.JSON(http.StatusOK, slack.Ephemeral("Open this link within 15 minutes and confirm to connect your ResolveXYZ account to @"+
+			command.UserName+" in "+command.TeamDomain+": "+linkURL+"\nDon't open links to connect Slack that someone else sent you."))
+
+	case "checkin", "comment":
+		rID, text, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		kind := ""
+		if strings.ToLower(subcommand) == "checkin" {
+			kind = models.CommentKindCheckIn
+		}
+		_, reply := slackComment(command.TeamID, command.UserID, rID, text, kind)
+		c.JSON(http.StatusOK, reply)
+
+	default:
+		c.JSON(http.StatusOK, slack.Ephemeral(slackCommandHelp))
+	}
+}
+
+// SlackInteractivity handles modal submissions. The "checkin" and "comment" modals have
+// r_id and text inputs. Other interactions are acknowledged and ignored.
+func SlackInteractivity(c *gin.Context) {
+	var payload slack.InteractionPayload
+	if err := json.Unmarshal([]byte(c.PostForm("payload")), &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	if payload.Type != "view_submission" || (payload.View.CallbackID != "checkin" && payload.View.CallbackID != "comment") {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	kind := ""
+	if payload.View.CallbackID == "checkin" {
+		kind = models.CommentKindCheckIn
+	}
+	ok, reply := slackComment(payload.Team.ID, payload.User.ID, payload.Value("r_id"), payload.Value("text"), kind)
+	if !ok {
+		// Shown next to the text input in the modal
+		c.JSON(http.StatusOK, gin.H{"response_action": "errors", "errors": gin.H{"text": reply.Text}})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// slackComment creates a comment or check in for the account linked to the Slack user,
+// through the same path as the API, and returns the reply for Slack
+func slackComment(teamID, slackUserID, rID, text, kind string) (bool, slack.Message) {
+	user, err := slackUser(teamID, slackUserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, slack.Ephemeral("Your Slack account isn't connected yet, run `/resolve link` first")
+	}
+	if err != nil {
+		log.Printf("Error looking up Slack user: %v", err)
+		return false, slack.Ephemeral("Something went wrong, please try again")
+	}
+	if user.EffectiveStatus(time.Now()) != models.UserStatusActive {
+		return false, slack.Ephemeral("Your ResolveXYZ account can't post right now")
+	}
+
+	rObjectID, err := primitive.ObjectIDFromHex(rID)
+	if err != nil {
+		return false, slack.Ephemeral("That isn't a valid resolution ID.\n" + slackCommandHelp)
+	}
+
+	comment, reqErr := createComment(user.ID, models.Comments{RID: rObjectID, Comment: text, Kind: kind})
+	if reqErr != nil {
+		message, _ := reqErr.Body["error"].(string)
+		return false, slack.Ephemeral(message)
+	}
+
+	if kind == models.CommentKindCheckIn {
+		return true, slack.InChannel(user.Name + " checked in: " + comment.Comment)
+	}
+	return true, slack.Ephemeral("Comment posted")
+}
+
+// slackUser finds the account linked to a Slack user
+func slackUser(teamID, slackUserID string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return repos.Users.FindByIdentity(ctx, slackProvider, teamID+":"+slackUserID)
+}
+
+// PreviewSlackLink shows which Slack workspace and user a /resolve link token would connect,
+// so the app can ask the user to confirm before calling LinkSlackAccount
+func PreviewSlackLink(c *gin.Context) {
+	claims, err := utils.VerifySlackLinkToken(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"team_id":         claims.TeamID,
+		"team_domain":     claims.TeamDomain,
+		"slack_user_id":   claims.SlackUserID,
+		"slack_user_name": claims.SlackUserName,
+	})
+}
+
+// LinkSlackAccount connects the Slack user from a /resolve link token to the logged in account.
+// Anyone can send a link, so it only links once the user confirmed the account shown by
+// PreviewSlackLink. It is stored as an identity, so it can be removed like the others.
+func LinkSlackAccount(c *gin.Context) {
+	var requestBody struct {
+		Token   string `json:"token" binding:"required"`
+		Confirm bool   `json:"confirm"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := utils.VerifySlackLinkToken(requestBody.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	if !requestBody.Confirm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirm the Slack workspace and user to link"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	subject := claims.TeamID + ":" + claims.SlackUserID
+
+	existing, err := slackUser(claims.TeamID, claims.SlackUserID)
+	if err == nil {
+		if existing.ID == userObjectID {
+			c.JSON(http.StatusOK, gin.H{"message": "Slack account already linked"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "Slack account is linked to another account"})
+		}
+		return
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Error looking up Slack user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link Slack account"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// One Slack account per user, another one has to be unlinked first
+	linked := models.Identity{Provider: slackProvider, Subject: subject, LinkedAt: time.Now()}
+	if err := repos.Users.AddSoleIdentity(ctx, userObjectID, linked); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrIdentityLinked):
+			// Another request linked the Slack user since it was looked up
+			c.JSON(http.StatusConflict, gin.H{"error": "Slack account is linked to another account"})
+		case errors.Is(err, repository.ErrDuplicate):
+			c.JSON(http.StatusConflict, gin.H{"error": "Another Slack account is linked, unlink it first"})
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			log.Printf("Error linking Slack account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link Slack account"})
+		}
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionIdentityLinked,
+		TargetType: audit.TargetUser,
+		TargetID:   userObjectID.Hex(),
+		Metadata:   map[string]interface{}{"provider": slackProvider, "subject": subject},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Slack account linked successfully", "identity": linked})
+}
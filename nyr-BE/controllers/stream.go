@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"nyr/pubsub"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Comment line sent on idle streams, so proxies don't close the connection
+const streamHeartbeat = 25 * time.Second
+
+// StreamFeed streams newly created resolutions as Server-Sent Events
+func StreamFeed(c *gin.Context) {
+	hidden, ok := streamViewerFilters(c)
+	if !ok {
+		return
+	}
+	streamEvents(c, []string{pubsub.TopicFeed}, hidden)
+}
+
+// StreamResolution streams new comments and like counts of the resolution in the URL
+func StreamResolution(c *gin.Context) {
+	rID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution ID"})
+		return
+	}
+
+	viewer, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if _, ok := interactableResolution(c, rID, viewer); !ok {
+		return
+	}
+
+	hidden, ok := streamViewerFilters(c)
+	if !ok {
+		return
+	}
+	streamEvents(c, []string{pubsub.ResolutionTopic(rID)}, hidden)
+}
+
+// StreamNotifications streams the logged in user's new notifications
+func StreamNotifications(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	streamEvents(c, []string{pubsub.UserTopic(userObjectID)}, nil)
+}
+
+// streamViewerFilters returns the users whose activity is left out of the viewer's streams
+func streamViewerFilters(c *gin.Context) ([]primitive.ObjectID, bool) {
+	viewer, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		return nil, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hidden, _, err := viewerFilters(ctx, viewer)
+	if err != nil {
+		log.Printf("Error loading blocks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check blocks"})
+		return nil, false
+	}
+	return hidden, true
+}
+
+// streamEvents writes the events of the topics to the response until the client disconnects.
+// Events caused by a hidden user are skipped.
+func streamEvents(c *gin.Context, topics []string, hidden []primitive.ObjectID) {
+	ctx := c.Request.Context()
+	events, err := pubsub.Default.Subscribe(ctx, topics...)
+	if err != nil {
+		log.Printf("Error subscribing to %v: %v", topics, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe"})
+		return
+	}
+
+	skip := map[string]bool{}
+	for _, id := range hidden {
+		skip[id.Hex()] = true
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if skip[event.ActorID] {
+				continue
+			}
+			c.SSEvent(event.Type, event)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/utils"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultTokenLifetimeDays = 90
+	maxTokenLifetimeDays     = 365
+	maxTokensPerUser         = 50
+)
+
+// ListTokens returns the logged in user's personal access tokens, without the tokens themselves
+func ListTokens(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tokens, err := repos.Tokens.List(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error listing tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// CreateToken creates a personal access token. The raw token is only returned in this response.
+func CreateToken(c *gin.Context) {
+	var requestBody struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes" binding:"required"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	requestBody.Name = strings.TrimSpace(requestBody.Name)
+	if requestBody.Name == "" || len(requestBody.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be between 1 and 100 characters"})
+		return
+	}
+
+	scopes := []string{}
+	for _, scope := range requestBody.Scopes {
+		if !validScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope, "scopes": models.TokenScopes})
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required", "scopes": models.TokenScopes})
+		return
+	}
+
+	days := defaultTokenLifetimeDays
+	if requestBody.ExpiresInDays != nil {
+		days = *requestBody.ExpiresInDays
+	}
+	if days < 1 || days > maxTokenLifetimeDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 365"})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := repos.Tokens.CountActive(ctx, userObjectID)
+	if err != nil {
+		log.Printf("Error counting tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	if count >= maxTokensPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many active tokens, revoke one first"})
+		return
+	}
+
+	raw, hash, err := utils.GeneratePersonalAccessToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	expiresAt := time.Now().AddDate(0, 0, days)
+	token := models.PersonalAccessToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userObjectID,
+		Name:      requestBody.Name,
+		Prefix:    raw[:len(utils.PersonalAccessTokenPrefix)+4],
+		TokenHash: hash,
+		Scopes:    scopes,
+		ExpiresAt: &expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := repos.Tokens.Insert(ctx, token); err != nil {
+		log.Printf("Error inserting token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionTokenCreated,
+		TargetType: audit.TargetToken,
+		TargetID:   token.ID.Hex(),
+		After:      map[string]interface{}{"name": token.Name, "scopes": token.Scopes, "expires_at": token.ExpiresAt},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Token created successfully, copy it now as it won't be shown again",
+		"token":   raw,
+		"details": token,
+	})
+}
+
+// RevokeToken revokes one of the logged in user's personal access tokens
+func RevokeToken(c *gin.Context) {
+	tokenObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	revoked, err := repos.Tokens.Revoke(ctx, tokenObjectID, userObjectID, time.Now())
+	if err != nil {
+		log.Printf("Error revoking token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionTokenRevoked,
+		TargetType: audit.TargetToken,
+		TargetID:   tokenObjectID.Hex(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
+
+func validScope(scope string) bool {
+	return containsString(models.TokenScopes, scope)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"nyr/emails"
+	"nyr/models"
+	"nyr/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// unsubscribePage asks to confirm before anything changes, link scanners and prefetchers
+// only GET the link. Done is set on the page shown after the POST.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Unsubscribe</title>
+</head>
+<body>
+{{if .Done}}
+<p>You have been unsubscribed. You won't get emails anymore because {{.Reason}}.</p>
+{{else}}
+<p>You get these emails because {{.Reason}}. Do you want to stop them?</p>
+<form method="post">
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<button type="submit">Unsubscribe</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// ConfirmUnsubscribe shows what the signed link in an email turns off, without changing
+// anything. Browsers get a page that POSTs the link back, other clients JSON.
+func ConfirmUnsubscribe(c *gin.Context) {
+	_, category, ok := unsubscribeLink(c)
+	if !ok {
+		return
+	}
+	respondUnsubscribe(c, false, category, "Confirm to unsubscribe")
+}
+
+// Unsubscribe turns an email category off from the signed link in an email, without signing in.
+// Mail clients POST to the link for one-click unsubscribe, browsers after ConfirmUnsubscribe.
+func Unsubscribe(c *gin.Context) {
+	userObjectID, category, ok := unsubscribeLink(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := repos.Users.UpdateEmailPreferences(ctx, userObjectID, map[string]bool{category: false}); err != nil {
+		log.Printf("Error unsubscribing: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe"})
+		return
+	}
+
+	respondUnsubscribe(c, true, category, "You have been unsubscribed")
+}
+
+// unsubscribeLink checks the signature of the link and returns its user and category.
+// It writes the error response itself and reports whether the caller should continue.
+func unsubscribeLink(c *gin.Context) (primitive.ObjectID, string, bool) {
+	userID := c.Query("user")
+	category := c.Query("category")
+	if !containsString(models.EmailCategories, category) || !utils.VerifyUnsubscribeToken(userID, category, c.Query("token")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unsubscribe link"})
+		return primitive.NilObjectID, "", false
+	}
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unsubscribe link"})
+		return primitive.NilObjectID, "", false
+	}
+	return userObjectID, category, true
+}
+
+func respondUnsubscribe(c *gin.Context, done bool, category, message string) {
+	if c.NegotiateFormat(binding.MIMEJSON, binding.MIMEHTML) == binding.MIMEHTML {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := unsubscribePage.Execute(c.Writer, gin.H{"Done": done, "Reason": emails.Reason(category)}); err != nil {
+			log.Printf("Error rendering unsubscribe page: %v", err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "category": category, "unsubscribed": done})
+}
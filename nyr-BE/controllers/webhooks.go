@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/pubsub"
+	"nyr/repository"
+	"nyr/utils"
+	"nyr/webhooks"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxWebhooksPerUser = 10
+
+// webhookOwner returns the user the request manages webhooks for. The admin routes manage
+// the global webhooks, which have no user, every other route the logged in user's own.
+func webhookOwner(c *gin.Context) *primitive.ObjectID {
+	if strings.HasPrefix(c.FullPath(), "/admin/") {
+		return nil
+	}
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	return &userObjectID
+}
+
+// ListWebhooks returns the webhooks of the logged in user, or the global ones for admins
+func ListWebhooks(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hooks, err := repos.Webhooks.List(ctx, webhookOwner(c))
+	if err != nil {
+		log.Printf("Error listing webhooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+// CreateWebhook registers an endpoint for the given events. The signing secret is only returned here.
+func CreateWebhook(c *gin.Context) {
+	var requestBody struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateWebhookURL(requestBody.URL); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	events, msg := validateWebhookEvents(requestBody.Events)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg, "events": models.WebhookEvents})
+		return
+	}
+
+	owner := webhookOwner(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := repos.Webhooks.Count(ctx, owner)
+	if err != nil {
+		log.Printf("Error counting webhooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	if count >= maxWebhooksPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many webhooks, delete one first"})
+		return
+	}
+
+	secret, err := utils.GenerateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	now := time.Now()
+	hook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		UserID:    owner,
+		URL:       requestBody.URL,
+		Secret:    secret,
+		Events:    events,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := repos.Webhooks.Insert(ctx, hook); err != nil {
+		log.Printf("Error inserting webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Webhook created successfully, copy the secret now as it won't be shown again",
+		"secret":  secret,
+		"webhook": hook,
+	})
+}
+
+// UpdateWebhook changes the URL or events of a webhook, or turns it on or off.
+// Turning a webhook back on clears its failures.
+func UpdateWebhook(c *gin.Context) {
+	hookObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	var requestBody struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update := repository.WebhookUpdate{URL: requestBody.URL, Active: requestBody.Active, At: time.Now()}
+	if requestBody.URL != nil {
+		if msg := validateWebhookURL(*requestBody.URL); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+	if requestBody.Events != nil {
+		events, msg := validateWebhookEvents(requestBody.Events)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg, "events": models.WebhookEvents})
+			return
+		}
+		update.Events = events
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hook, err := repos.Webhooks.Update(ctx, hookObjectID, webhookOwner(c), update)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook updated successfully", "webhook": hook})
+}
+
+// DeleteWebhook removes a webhook together with its delivery log
+func DeleteWebhook(c *gin.Context) {
+	hookObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	deleted, err := repos.Webhooks.Delete(ctx, hookObjectID, webhookOwner(c))
+	if err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	if err := repos.Deliveries.DeleteByWebhook(ctx, hookObjectID); err != nil {
+		log.Printf("Error deleting webhook deliveries: %v", err)
+	}
+
+	audit.Record(c, audit.Event{
+		Action:     audit.ActionWebhookDeleted,
+		TargetType: audit.TargetWebhook,
+		TargetID:   hookObjectID.Hex(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook, newest first, optionally filtered by status
+func ListWebhookDeliveries(c *gin.Context) {
+	hookObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !ownsWebhook(ctx, c, hookObjectID) {
+		return
+	}
+
+	deliveries, err := repos.Deliveries.List(ctx, repository.DeliveryQuery{
+		WebhookID: hookObjectID,
+		Status:    c.Query("status"),
+		Skip:      (page - 1) * limit,
+		Limit:     limit,
+	})
+	if err != nil {
+		log.Printf("Error listing webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "page": page, "limit": limit})
+}
+
+// ReplayWebhookDelivery sends a past delivery again as a new delivery with the same payload
+func ReplayWebhookDelivery(c *gin.Context) {
+	hookObjectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	deliveryObjectID, err := primitive.ObjectIDFromHex(c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hook, err := repos.Webhooks.Find(ctx, hookObjectID, webhookOwner(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook"})
+		return
+	}
+	if !hook.Active {
+		c.JSON(http.StatusConflict, gin.H{"error": "Webhook is disabled, turn it on before replaying"})
+		return
+	}
+
+	original, err := repos.Deliveries.Find(ctx, deliveryObjectID, hookObjectID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up webhook delivery: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve delivery"})
+		return
+	}
+
+	now := time.Now()
+	replay := models.WebhookDelivery{
+		ID:            primitive.NewObjectID(),
+		WebhookID:     hookObjectID,
+		EventID:       original.EventID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: &now,
+		ReplayOf:      &original.ID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := repos.Deliveries.Insert(ctx, replay); err != nil {
+		log.Printf("Error inserting webhook replay: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay delivery"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued", "delivery": replay})
+}
+
+// ownsWebhook checks that the webhook belongs to the request's owner.
+// It writes the error response itself and reports whether the caller should continue.
+func ownsWebhook(ctx context.Context, c *gin.Context, hookObjectID primitive.ObjectID) bool {
+	_, err := repos.Webhooks.Find(ctx, hookObjectID, webhookOwner(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return false
+	}
+	if err != nil {
+		log.Printf("Error looking up webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook"})
+		return false
+	}
+	return true
+}
+
+// validateWebhookURL returns why the URL can't be used, or "" if it can.
+// Plain http is only accepted when WEBHOOK_ALLOW_HTTP is set, for local development.
+// Hosts that are obviously internal are refused here, the delivery client checks the
+// address every host resolves to when it connects.
+func validateWebhookURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "url must be an absolute URL"
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && os.Getenv("WEBHOOK_ALLOW_HTTP") == "true") {
+		return "url must use https"
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip := net.ParseIP(host); ip != nil && !webhooks.PublicIP(ip) {
+		return "url must point to a public address"
+	}
+	if (host == "localhost" || strings.HasSuffix(host, ".localhost")) && !webhooks.PublicIP(net.IPv6loopback) {
+		return "url must point to a public address"
+	}
+	return ""
+}
+
+// validateWebhookEvents removes duplicates and returns why the events can't be used, or "" if they can
+func validateWebhookEvents(requested []string) ([]string, string) {
+	events := []string{}
+	for _, event := range requested {
+		if !containsString(models.WebhookEvents, event) {
+			return nil, "Unknown event: " + event
+		}
+		if !containsString(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return nil, "At least one event is required"
+	}
+	return events, ""
+}
+
+// publish sends the event to the bus and queues its webhook deliveries. owner is the user
+// whose resolution the event is about, their webhooks receive it along with the global ones.
+// Queueing happens here, not from a bus subscriber, so a busy bus can't lose deliveries.
+func publish(topic, eventType string, actorID, owner primitive.ObjectID, data interface{}) {
+	event := pubsub.NewEvent(topic, eventType, actorID, data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := webhooks.Enqueue(ctx, repos, event, owner); err != nil {
+		log.Printf("Error queueing %s webhook deliveries: %v", eventType, err)
+	}
+
+	pubsub.PublishEvent(event)
+}
@@ -39,8 +39,3 @@ func Disconnect() {
 		}
 	}
 }
-
-// Returns a collection object
-func GetCollection(collectionName string) *mongo.Collection {
-	return DB.Collection(collectionName)
-}
@@ -0,0 +1,136 @@
+package emails
+
+import (
+	"context"
+	"errors"
+	"log"
+	"nyr/models"
+	"nyr/repository"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const digestPeriod = 7 * 24 * time.Hour
+
+type digestActivity struct {
+	Resolution string
+	URL        string
+	Likes      int64
+	Comments   int64
+}
+
+type digestResolution struct {
+	Resolution string
+	URL        string
+	Author     string
+	Likes      int64
+}
+
+// RunDigests sends the weekly digest to every user who opted in and is due, checking every interval
+func RunDigests(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sendDueDigests(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDueDigests claims due users one at a time by moving their last digest time, so
+// several instances running at once never send the same digest twice
+func sendDueDigests(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		user, err := repos.Users.ClaimDigest(ctx, now, digestPeriod)
+		if errors.Is(err, repository.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error claiming digest recipient: %v", err)
+			return
+		}
+
+		if err := sendDigest(ctx, user, now.Add(-digestPeriod)); err != nil {
+			log.Printf("Error sending digest to %s: %v", user.ID.Hex(), err)
+		}
+	}
+}
+
+// sendDigest sends the digest of the activity since the given time, nothing is sent for an empty week
+func sendDigest(ctx context.Context, user models.User, since time.Time) error {
+	own, err := repos.Resolutions.Activity(ctx, user.ID, since)
+	if err != nil {
+		return err
+	}
+
+	activity := []digestActivity{}
+	reminders := []digestActivity{}
+	for _, r := range own {
+		if r.Likes > 0 || r.Comments > 0 {
+			activity = append(activity, digestActivity{Resolution: r.Resolution, URL: ResolutionURL(r.ID), Likes: r.Likes, Comments: r.Comments})
+		}
+		if r.CheckIns == 0 && len(reminders) < 5 {
+			reminders = append(reminders, digestActivity{Resolution: r.Resolution, URL: ResolutionURL(r.ID)})
+		}
+	}
+
+	top, err := topFromFollowing(ctx, user.ID, since)
+	if err != nil {
+		return err
+	}
+
+	if len(activity) == 0 && len(top) == 0 && len(reminders) == 0 {
+		return nil
+	}
+
+	return send(ctx, user, models.EmailCategoryDigest, "Your week on ResolveXYZ", "digest", map[string]interface{}{
+		"Activity":  activity,
+		"Top":       top,
+		"Reminders": reminders,
+	})
+}
+
+// topFromFollowing returns the most liked resolutions posted since the given time by the
+// users the user follows, leaving out anyone the user blocked or muted and anyone who blocked them
+func topFromFollowing(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]digestResolution, error) {
+	following, err := repos.Follows.Following(ctx, userID)
+	if err != nil || len(following) == 0 {
+		return nil, err
+	}
+
+	blocks, err := repos.Blocks.Involving(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := []primitive.ObjectID{}
+	for _, b := range blocks {
+		if b.UserID == userID {
+			hidden = append(hidden, b.TargetID)
+		} else {
+			hidden = append(hidden, b.UserID)
+		}
+	}
+
+	summaries, err := repos.Resolutions.Feed(ctx, repository.FeedQuery{
+		Sort:    repository.SortLikes,
+		Limit:   5,
+		Viewer:  repository.Viewer{Hidden: hidden},
+		Authors: following,
+		Since:   since,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	top := []digestResolution{}
+	for _, s := range summaries {
+		top = append(top, digestResolution{Resolution: s.Resolution, URL: ResolutionURL(s.ID), Author: s.UserName, Likes: s.LikeCount})
+	}
+	return top, nil
+}
@@ -0,0 +1,93 @@
+package emails
+
+import (
+	"context"
+	"nyr/models"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSendDueDigests(t *testing.T) {
+	ctx := context.Background()
+	r, mail := setup(t)
+	digest := func(u *models.User) { u.EmailPreferences = map[string]bool{models.EmailCategoryDigest: true} }
+	alice := addUser(t, r, "Alice", digest)
+	bob := addUser(t, r, "Bob", nil)
+	carol := addUser(t, r, "Carol", nil)
+	// Quiet has nothing to read about, Suspended isn't due
+	addUser(t, r, "Quiet", digest)
+	addUser(t, r, "Suspended", func(u *models.User) {
+		digest(u)
+		u.Status = models.UserStatusSuspended
+	})
+
+	now := time.Now()
+	resolution := func(owner models.User, text string, created time.Time) primitive.ObjectID {
+		t.Helper()
+		rID := primitive.NewObjectID()
+		if err := r.Resolutions.Insert(ctx, models.Resolution{RID: rID, UserID: owner.ID, Resolution: text, CreatedAt: created}); err != nil {
+			t.Fatal(err)
+		}
+		return rID
+	}
+	like := func(user models.User, rID primitive.ObjectID) {
+		t.Helper()
+		if _, err := r.Reactions.Set(ctx, models.Reaction{UserID: user.ID, TargetType: models.ReactionTargetResolution, TargetID: rID, Type: models.ReactionLike, CreatedAt: now, UpdatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	marathon := resolution(alice, "run a marathon", now.AddDate(0, -1, 0))
+	resolution(alice, "learn to juggle", now.AddDate(0, -1, 0))
+	like(bob, marathon)
+	if err := r.Comments.Insert(ctx, models.Comments{ID: primitive.NewObjectID(), UserID: bob.ID, RID: marathon, Comment: "go", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	// Alice checked in on the marathon but not on juggling
+	if err := r.Comments.Insert(ctx, models.Comments{ID: primitive.NewObjectID(), UserID: alice.ID, RID: marathon, Comment: "10k done", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice follows Bob and Carol but muted Carol, and Bob's old resolution is out of the week
+	like(carol, resolution(bob, "read 20 books", now.Add(-time.Hour)))
+	resolution(bob, "learn Spanish", now.AddDate(0, 0, -10))
+	resolution(carol, "swim a mile", now.Add(-time.Hour))
+	for _, target := range []models.User{bob, carol} {
+		if _, err := r.Follows.Add(ctx, models.Follow{UserID: alice.ID, TargetID: target.ID, CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Blocks.Add(ctx, models.Block{UserID: alice.ID, TargetID: carol.ID, Kind: models.BlockKindMute}); err != nil {
+		t.Fatal(err)
+	}
+
+	sendDueDigests(ctx)
+
+	sent := mail.take()
+	if len(sent) != 1 || sent[0].To != alice.Email {
+		t.Fatalf("sent %+v, want only Alice's digest", sent)
+	}
+	for _, want := range []string{
+		"- run a marathon: 1 new likes, 1 new comments",
+		"- read 20 books by Bob (1 likes)",
+		"haven't posted an update this week on:\n- learn to juggle",
+	} {
+		if !strings.Contains(sent[0].Text, want) {
+			t.Errorf("digest is missing %q:\n%s", want, sent[0].Text)
+		}
+	}
+	for _, unwanted := range []string{"learn Spanish", "swim a mile", "learn to juggle:"} {
+		if strings.Contains(sent[0].Text, unwanted) {
+			t.Errorf("digest has %q:\n%s", unwanted, sent[0].Text)
+		}
+	}
+
+	// Everyone due was claimed, nobody gets a second digest this week
+	sendDueDigests(ctx)
+	if sent := mail.take(); len(sent) != 0 {
+		t.Errorf("sent %+v again", sent)
+	}
+}
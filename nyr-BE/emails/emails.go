@@ -0,0 +1,190 @@
+package emails
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	htmltemplate "html/template"
+	"log"
+	"net/url"
+	"nyr/mailer"
+	"nyr/models"
+	"nyr/repository"
+	"nyr/utils"
+	"os"
+	texttemplate "text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// repos is where recipients and their activity are looked up
+var repos repository.Repositories
+
+// SetRepositories sets where recipients are looked up, the routes set it on startup
+func SetRepositories(r repository.Repositories) {
+	repos = r
+}
+
+//go:embed templates
+var templateFiles embed.FS
+
+type templates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var emailTemplates = map[string]templates{}
+
+func init() {
+	for _, name := range []string{"comment", "reply", "digest", "reminder"} {
+		emailTemplates[name] = templates{
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html")),
+			text: texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/layout.txt", "templates/"+name+".txt")),
+		}
+	}
+}
+
+// Why a user gets each category, shown next to the unsubscribe link
+var categoryReasons = map[string]string{
+	models.EmailCategoryComments:  "someone commented on your resolution",
+	models.EmailCategoryReplies:   "someone replied to your comment",
+	models.EmailCategoryDigest:    "you signed up for the weekly digest",
+	models.EmailCategoryReminders: "you set a check in reminder",
+}
+
+// Reason is why a user gets email of the category
+func Reason(category string) string {
+	return categoryReasons[category]
+}
+
+// Enabled reports whether the user gets email of the category
+func Enabled(user models.User, category string) bool {
+	if enabled, ok := user.EmailPreferences[category]; ok {
+		return enabled
+	}
+	return models.EmailCategoryDefaults[category]
+}
+
+// UnsubscribeURL is the signed one-click link that turns the category off for the user
+func UnsubscribeURL(userID primitive.ObjectID, category string) string {
+	query := url.Values{}
+	query.Set("user", userID.Hex())
+	query.Set("category", category)
+	query.Set("token", utils.UnsubscribeToken(userID.Hex(), category))
+	return os.Getenv("API_URL") + "/unsubscribe?" + query.Encode()
+}
+
+// ResolutionURL links to a resolution in the app
+func ResolutionURL(rID primitive.ObjectID) string {
+	return os.Getenv("APP_URL") + "/?r_id=" + rID.Hex()
+}
+
+// send renders the template for the recipient and mails it with the unsubscribe headers
+func send(ctx context.Context, recipient models.User, category, subject, name string, data map[string]interface{}) error {
+	unsubscribeURL := UnsubscribeURL(recipient.ID, category)
+	data["Recipient"] = recipient.Name
+	data["Reason"] = categoryReasons[category]
+	data["UnsubscribeURL"] = unsubscribeURL
+
+	var html, text bytes.Buffer
+	if err := emailTemplates[name].html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return err
+	}
+	if err := emailTemplates[name].text.ExecuteTemplate(&text, "layout", data); err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mailer.Message{
+		To:      recipient.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			// One-click unsubscribe from the mail client, RFC 8058
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// Comment queues an email to the owner of a resolution about a new comment on it
+func Comment(resolution models.Resolution, comment models.Comments) {
+	enqueue("comment", func() {
+		notifyByEmail(resolution.UserID, comment.UserID, models.EmailCategoryComments, "comment",
+			" commented on your resolution", map[string]interface{}{
+				"Resolution": resolution.Resolution,
+				"Comment":    comment.Comment,
+				"URL":        ResolutionURL(resolution.RID),
+			})
+	})
+}
+
+// Reply queues an email to the author of a comment about a reply to it
+func Reply(resolution models.Resolution, parent, reply models.Comments) {
+	enqueue("reply", func() {
+		notifyByEmail(parent.UserID, reply.UserID, models.EmailCategoryReplies, "reply",
+			" replied to your comment", map[string]interface{}{
+				"Resolution": resolution.Resolution,
+				"Parent":     parent.Comment,
+				"Comment":    reply.Comment,
+				"URL":        ResolutionURL(resolution.RID),
+			})
+	})
+}
+
+// Reminder emails the user a check in reminder for their resolution, if they didn't turn reminder emails off
+func Reminder(user models.User, resolution models.Resolution) {
+	if !Enabled(user, models.EmailCategoryReminders) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := send(ctx, user, models.EmailCategoryReminders, "Time to check in on your resolution", "reminder", map[string]interface{}{
+		"Resolution": resolution.Resolution,
+		"URL":        ResolutionURL(resolution.RID),
+	})
+	if err != nil {
+		log.Printf("Error sending reminder email: %v", err)
+	}
+}
+
+// notifyByEmail sends an activity email, unless the recipient caused it, turned the category
+// off, is banned or blocked or muted the actor. Failures are logged.
+func notifyByEmail(recipientID, actorID primitive.ObjectID, category, name, action string, data map[string]interface{}) {
+	if recipientID == actorID {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	recipient, err := repos.Users.FindByID(ctx, recipientID)
+	if err != nil {
+		log.Printf("Error loading email recipient: %v", err)
+		return
+	}
+	if !Enabled(recipient, category) || recipient.Status == models.UserStatusBanned {
+		return
+	}
+
+	for _, kind := range []string{models.BlockKindBlock, models.BlockKindMute} {
+		blocked, err := repos.Blocks.Exists(ctx, recipientID, actorID, kind)
+		if err != nil || blocked {
+			return
+		}
+	}
+
+	actor, err := repos.Users.FindByID(ctx, actorID)
+	if err != nil {
+		log.Printf("Error loading email actor: %v", err)
+		return
+	}
+	data["Actor"] = actor.Name
+
+	if err := send(ctx, recipient, category, actor.Name+action, name, data); err != nil {
+		log.Printf("Error sending %s email: %v", name, err)
+	}
+}
@@ -0,0 +1,114 @@
+package emails
+
+import (
+	"context"
+	"nyr/mailer"
+	"nyr/models"
+	"nyr/repository"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sentMail stands in for the mailer and keeps the messages it is asked to send
+type sentMail struct {
+	sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *sentMail) Send(ctx context.Context, msg mailer.Message) error {
+	m.Lock()
+	defer m.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// take returns the messages sent since the last call
+func (m *sentMail) take() []mailer.Message {
+	m.Lock()
+	defer m.Unlock()
+	messages := m.messages
+	m.messages = nil
+	return messages
+}
+
+// setup points the package at empty memory repositories and a mailer that keeps what it sends
+func setup(t *testing.T) (repository.Repositories, *sentMail) {
+	t.Helper()
+	previousRepos, previousMailer := repos, mailer.Default
+	r, m := repository.NewMemory(), &sentMail{}
+	SetRepositories(r)
+	mailer.Default = m
+	t.Cleanup(func() {
+		SetRepositories(previousRepos)
+		mailer.Default = previousMailer
+	})
+	return r, m
+}
+
+func addUser(t *testing.T, r repository.Repositories, name string, edit func(*models.User)) models.User {
+	t.Helper()
+	user := models.User{ID: primitive.NewObjectID(), Name: name, Email: strings.ToLower(name) + "@example.com", CreatedAt: time.Now()}
+	if edit != nil {
+		edit(&user)
+	}
+	if err := r.Users.Insert(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestNotifyByEmail(t *testing.T) {
+	ctx := context.Background()
+	r, mail := setup(t)
+	alice := addUser(t, r, "Alice", nil)
+	bob := addUser(t, r, "Bob", nil)
+	carol := addUser(t, r, "Carol", nil)
+	dave := addUser(t, r, "Dave", nil)
+	off := addUser(t, r, "Off", func(u *models.User) { u.EmailPreferences = map[string]bool{models.EmailCategoryComments: false} })
+	banned := addUser(t, r, "Banned", func(u *models.User) { u.Status = models.UserStatusBanned })
+	for _, b := range []models.Block{
+		{UserID: alice.ID, TargetID: carol.ID, Kind: models.BlockKindBlock},
+		{UserID: alice.ID, TargetID: dave.ID, Kind: models.BlockKindMute},
+	} {
+		if _, err := r.Blocks.Add(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name      string
+		recipient models.User
+		actor     models.User
+		sent      bool
+	}{
+		{"sent", alice, bob, true},
+		{"own activity", alice, alice, false},
+		{"category turned off", off, bob, false},
+		{"banned recipient", banned, bob, false},
+		{"blocked actor", alice, carol, false},
+		{"muted actor", alice, dave, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			notifyByEmail(tc.recipient.ID, tc.actor.ID, models.EmailCategoryComments, "comment", " commented on your resolution",
+				map[string]interface{}{"Resolution": "run a marathon", "Comment": "go go go", "URL": "https://app.example.com"})
+
+			sent := mail.take()
+			if !tc.sent {
+				if len(sent) != 0 {
+					t.Errorf("sent %+v", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].To != tc.recipient.Email || sent[0].Subject != tc.actor.Name+" commented on your resolution" ||
+				!strings.Contains(sent[0].Text, "go go go") {
+				t.Errorf("sent %+v, want one comment email to %s", sent, tc.recipient.Name)
+			}
+		})
+	}
+}
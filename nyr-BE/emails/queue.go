@@ -0,0 +1,45 @@
+package emails
+
+import (
+	"context"
+	"log"
+)
+
+// queueSize is how many activity emails can wait for a worker, more are dropped
+const queueSize = 256
+
+// queue holds the activity emails the handlers queue until a worker sends them
+var queue = make(chan job, queueSize)
+
+type job struct {
+	name string
+	send func()
+}
+
+// RunWorkers sends the queued activity emails on a fixed number of workers until ctx is
+// done, so a burst of comments can't start an unbounded number of sends
+func RunWorkers(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-queue:
+					j.send()
+				}
+			}
+		}()
+	}
+	<-ctx.Done()
+}
+
+// enqueue queues an email for the workers. Handlers never wait for mail, when the queue is
+// full the email is dropped and logged.
+func enqueue(name string, send func()) {
+	select {
+	case queue <- job{name: name, send: send}:
+	default:
+		log.Printf("Email queue is full, dropping %s email", name)
+	}
+}
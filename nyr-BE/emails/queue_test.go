@@ -0,0 +1,29 @@
+package emails
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	// Without workers the queue fills up and further emails are dropped
+	var sent sync.WaitGroup
+	sent.Add(queueSize)
+	for i := 0; i < queueSize+10; i++ {
+		enqueue("test", sent.Done)
+	}
+	if len(queue) != queueSize {
+		t.Fatalf("got %d queued emails, want %d", len(queue), queueSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go RunWorkers(ctx, 4)
+
+	// Every queued email is sent once, sending one that was dropped would panic the WaitGroup
+	sent.Wait()
+	if len(queue) != 0 {
+		t.Errorf("%d emails are left in the queue", len(queue))
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+)
+
+// LogMailer writes messages to a file, or to the log when no path is set.
+// It is meant for local development, where no SMTP server is available.
+type LogMailer struct {
+	From string
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Path == "" {
+		log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+		return nil
+	}
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(buildMessage(m.From, msg)); err != nil {
+		return err
+	}
+	_, err = f.WriteString("\r\n\r\n")
+	return err
+}
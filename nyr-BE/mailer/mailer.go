@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Message is a single email. HTML is optional, Text is always sent.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the mailer used by the application, set up by Initialize
+var Default Mailer = &LogMailer{}
+
+// Initialize picks the mailer from MAIL_DRIVER. "smtp" sends real email, anything
+// else writes messages to MAIL_LOG_FILE (or the log) for local development.
+func Initialize() {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "ResolveXYZ <no-reply@resolvexyz.com>"
+	}
+
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		Default = &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	default:
+		Default = &LogMailer{From: from, Path: os.Getenv("MAIL_LOG_FILE")}
+	}
+}
+
+// Send delivers a message with the default mailer
+func Send(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("message has no recipient")
+	}
+	return Default.Send(ctx, msg)
+}
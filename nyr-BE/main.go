@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"nyr/audit"
 	"nyr/config"
+	"nyr/controllers"
 	"nyr/db"
+	"nyr/emails"
+	"nyr/mailer"
+	"nyr/migrations"
+	"nyr/policy"
+	"nyr/providers"
+	"nyr/reminders"
+	"nyr/repository"
 	"nyr/routes"
+	"nyr/webhooks"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -20,11 +32,57 @@ func main() {
 	}
 
 	config.InitializeOAuthConfig()
+	providers.Initialize()
+	mailer.Initialize()
 
 	db.Connect()
 
+	// "migrate" applies pending migrations and exits, "migrate status" lists them
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
+	// deployments that run "migrate" as a separate step can turn this off
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		err := migrations.Run(ctx, db.DB)
+		cancel()
+		if err != nil {
+			log.Fatal("Failed to run migrations:", err)
+		}
+	}
+
+	repos := repository.NewMongo(db.DB)
+	audit.SetStore(repos.AuditLogs)
+
 	router := gin.Default()
-	routes.InitRoutes(router)
+
+	// only trust X-Forwarded-For from known proxies, rate limits are keyed by client IP
+	if err := routes.TrustProxies(router, os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+	// before the workers start, they share the repositories the routes set up
+	routes.InitRoutes(router, repos)
+
+	controllers.BootstrapAdmins()
+
+	// reload the content policy rules whenever the file changes
+	if path := os.Getenv("CONTENT_POLICY_FILE"); path != "" {
+		go policy.Watch(context.Background(), path, 10*time.Second)
+	}
+
+	// send the comment and reply emails the handlers queue
+	go emails.RunWorkers(context.Background(), 4)
+
+	// send the weekly digest to users who opted in
+	go emails.RunDigests(context.Background(), time.Hour)
+
+	// send check in reminders as they come due
+	go reminders.Run(context.Background(), repos, time.Minute)
+
+	// send the webhook deliveries the handlers queue
+	go webhooks.RunDeliveries(context.Background(), repos, 5*time.Second)
 
 	//getting PORT from env file
 	port := os.Getenv("PORT")
@@ -35,3 +93,32 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// migrate runs the migrate subcommand
+func migrate(args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if len(args) > 0 && args[0] == "status" {
+		applied, err := migrations.History(ctx, db.DB)
+		if err != nil {
+			log.Fatal("Failed to load migrations:", err)
+		}
+		pending, err := migrations.Pending(ctx, db.DB)
+		if err != nil {
+			log.Fatal("Failed to load migrations:", err)
+		}
+		for _, a := range applied {
+			fmt.Printf("applied  %3d  %s  %s\n", a.Version, a.AppliedAt.Format(time.RFC3339), a.Description)
+		}
+		for _, m := range pending {
+			fmt.Printf("pending  %3d  %s\n", m.Version, m.Description)
+		}
+		return
+	}
+
+	if err := migrations.Run(ctx, db.DB); err != nil {
+		log.Fatal("Failed to run migrations:", err)
+	}
+	fmt.Println("Database is up to date")
+}
@@ -0,0 +1,52 @@
+// Package mentions finds @handle mentions in resolution and comment text
+package mentions
+
+import (
+	"nyr/models"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9_]+)`)
+
+// Parse returns every @handle in the text with its offsets in characters, in order.
+// Handles are lowercased and the user IDs are left for the caller to resolve. An @ right
+// after a letter or digit, like in an email address, doesn't start a mention.
+func Parse(text string) []models.Mention {
+	found := []models.Mention{}
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if start > 0 {
+			previous, _ := utf8.DecodeLastRuneInString(text[:start])
+			if previous == '@' || previous == '_' || unicode.IsLetter(previous) || unicode.IsDigit(previous) {
+				continue
+			}
+		}
+
+		handle := strings.ToLower(text[match[2]:match[3]])
+		if !models.ValidHandle(handle) {
+			continue
+		}
+		found = append(found, models.Mention{
+			Handle: handle,
+			Start:  utf8.RuneCountInString(text[:start]),
+			End:    utf8.RuneCountInString(text[:end]),
+		})
+	}
+	return found
+}
+
+// Handles returns the distinct handles of the mentions, in the order they first appear
+func Handles(found []models.Mention) []string {
+	handles := []string{}
+	seen := map[string]bool{}
+	for _, mention := range found {
+		if !seen[mention.Handle] {
+			seen[mention.Handle] = true
+			handles = append(handles, mention.Handle)
+		}
+	}
+	return handles
+}
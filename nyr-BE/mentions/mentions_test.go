@@ -0,0 +1,47 @@
+package mentions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	type span struct {
+		Handle     string
+		Start, End int
+	}
+	cases := []struct {
+		name string
+		text string
+		want []span
+	}{
+		{"none", "run a marathon", []span{}},
+		{"one", "run with @Alice", []span{{"alice", 9, 15}}},
+		{"at the start", "@bob_1 and @carol!", []span{{"bob_1", 0, 6}, {"carol", 11, 17}}},
+		{"offsets in characters", "café ☕ @alice", []span{{"alice", 7, 13}}},
+		{"email address", "mail alice@example.com", []span{}},
+		{"double at", "@@alice", []span{}},
+		{"too short", "@al", []span{}},
+		{"too long", "@" + "a234567890123456789012345678901", []span{}},
+		{"repeated", "@alice @alice", []span{{"alice", 0, 6}, {"alice", 7, 13}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := []span{}
+			for _, m := range Parse(tc.text) {
+				got = append(got, span{m.Handle, m.Start, m.End})
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandles(t *testing.T) {
+	got := Handles(Parse("@bob @alice @bob"))
+	if want := []string{"bob", "alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Handles = %v, want %v", got, want)
+	}
+}
@@ -1,10 +1,17 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"nyr/models"
+	"nyr/repository"
+	"nyr/utils"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
@@ -12,13 +19,19 @@ import (
 
 var jwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
 
+// Ways a request can be authenticated, stored in the context as "auth_method"
+const (
+	AuthMethodSession = "session"
+	AuthMethodToken   = "token"
+)
+
 // Claims to use in future
 type Claims struct {
 	UserId string `json:"user_id"`
 	jwt.StandardClaims
 }
 
-// AuthMiddleware is the middleware to protect routes with JWT authentication
+// AuthMiddleware is the middleware to protect routes with JWT or personal access token authentication
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the token from the Authorization header
@@ -37,6 +50,25 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Personal access tokens are looked up by hash instead of parsed
+		if utils.IsPersonalAccessToken(tokenString[1]) {
+			pat, err := lookupPersonalAccessToken(tokenString[1])
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
+			}
+			if !checkAccountStatus(c, pat.UserID.Hex()) {
+				c.Abort()
+				return
+			}
+			c.Set("user_id", pat.UserID.Hex())
+			c.Set("auth_method", AuthMethodToken)
+			c.Set("token_scopes", pat.Scopes)
+			c.Next()
+			return
+		}
+
 		// Parse the JWT token
 		token, err := jwt.ParseWithClaims(tokenString[1], &Claims{}, func(token *jwt.Token) (interface{}, error) {
 			// Check token signing method
@@ -54,8 +86,14 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Extract claims (you can use these in your handlers)
-		if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.UserId != "" {
+			// The token stays valid after a ban, so the account is checked on every request
+			if !checkAccountStatus(c, claims.UserId) {
+				c.Abort()
+				return
+			}
 			c.Set("user_id", claims.UserId)
+			c.Set("auth_method", AuthMethodSession)
 		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			c.Abort()
@@ -85,6 +123,20 @@ func PostsMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Personal access tokens need the read scope to see the viewer specific fields
+		if utils.IsPersonalAccessToken(tokenString[1]) {
+			pat, err := lookupPersonalAccessToken(tokenString[1])
+			if err != nil || !hasScope(pat.Scopes, models.ScopeRead) || !isAccountActive(pat.UserID.Hex()) {
+				c.Set("user_id", "")
+			} else {
+				c.Set("user_id", pat.UserID.Hex())
+				c.Set("auth_method", AuthMethodToken)
+				c.Set("token_scopes", pat.Scopes)
+			}
+			c.Next()
+			return
+		}
+
 		// Parse the JWT token
 		token, err := jwt.ParseWithClaims(tokenString[1], &Claims{}, func(token *jwt.Token) (interface{}, error) {
 			// Check token signing method
@@ -102,13 +154,118 @@ func PostsMiddleware() gin.HandlerFunc {
 		}
 
 		// If the token is valid, extract the claims and set user_id in the context
-		if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.UserId != "" && isAccountActive(claims.UserId) {
 			c.Set("user_id", claims.UserId) // Set user_id from claims
+			c.Set("auth_method", AuthMethodSession)
 		} else {
-			c.Set("user_id", "") // If claims are invalid, set user_id as nil
+			c.Set("user_id", "") // If claims are invalid or the account is not active, set user_id as nil
 		}
 
 		// Proceed to the next handler
 		c.Next()
 	}
 }
+
+// RequireScope rejects personal access tokens that were not granted the scope.
+// Sessions from a login are not limited by scopes.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") == AuthMethodToken && !hasScope(c.GetStringSlice("token_scopes"), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// SessionOnly rejects personal access tokens, for routes that manage the account itself
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") != AuthMethodSession {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action requires signing in"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkAccountStatus rejects requests from banned, suspended or deleted accounts.
+// It writes the error response itself and reports whether the request may continue.
+func checkAccountStatus(c *gin.Context, userID string) bool {
+	user, err := loadUser(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		} else {
+			log.Printf("Error loading user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load account"})
+		}
+		return false
+	}
+
+	switch user.EffectiveStatus(time.Now()) {
+	case models.UserStatusBanned:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account banned", "reason": user.StatusReason})
+		return false
+	case models.UserStatusSuspended:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":           "Account suspended",
+			"reason":          user.StatusReason,
+			"suspended_until": user.SuspendedUntil,
+		})
+		return false
+	}
+
+	c.Set("user_role", user.Role)
+	return true
+}
+
+// isAccountActive reports whether the user exists and is neither banned nor suspended
+func isAccountActive(userID string) bool {
+	user, err := loadUser(userID)
+	return err == nil && user.EffectiveStatus(time.Now()) == models.UserStatusActive
+}
+
+// tokens is where personal access tokens are looked up
+var tokens repository.Tokens
+
+// SetTokens sets where personal access tokens are looked up, the routes set it on startup
+func SetTokens(t repository.Tokens) {
+	tokens = t
+}
+
+// lookupPersonalAccessToken finds an active token by its hash and records that it was used
+func lookupPersonalAccessToken(raw string) (*models.PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pat, err := tokens.FindActive(ctx, utils.HashPersonalAccessToken(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if pat.ExpiresAt != nil && now.After(*pat.ExpiresAt) {
+		return nil, errors.New("token has expired")
+	}
+
+	// Only write last_used_at once a minute, so busy scripts don't cause a write per request
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > time.Minute {
+		if err := tokens.Touch(ctx, pat.ID, now); err != nil {
+			log.Printf("Error updating token last used time: %v", err)
+		}
+	}
+
+	return &pat, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"nyr/ratelimit"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit limits requests per user, or per client IP for anonymous requests.
+// On authenticated routes it must run after AuthMiddleware so the user is known, on
+// Slack routes after VerifySlackSignature so they're limited per Slack user.
+// Requests are let through if the store fails, rate limiting is not worth an outage.
+func RateLimit(store ratelimit.Store, policy ratelimit.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := policy.Name + ":ip:" + c.ClientIP()
+		if userID := c.GetString("user_id"); userID != "" {
+			key = policy.Name + ":user:" + userID
+		} else if slackUser := c.GetString("slack_user"); slackUser != "" {
+			key = policy.Name + ":slack:" + slackUser
+		}
+
+		result, err := store.Take(c.Request.Context(), key, policy)
+		if err != nil {
+			log.Printf("Error checking rate limit: %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy.String())
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(result.Reset.Seconds())))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please slow down"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole protects routes so only users with at least the given role can use them.
+// It must run after AuthMiddleware, and sets "user_role" for the handlers.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := loadUser(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		if !user.HasRole(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_role", user.Role)
+		c.Next()
+	}
+}
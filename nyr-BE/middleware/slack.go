@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"nyr/slack"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifySlackSignature rejects requests that were not signed with SLACK_SIGNING_SECRET.
+// The body is put back after reading, so handlers can still parse the form.
+func VerifySlackSignature() gin.HandlerFunc {
+	secret := os.Getenv("SLACK_SIGNING_SECRET")
+
+	return func(c *gin.Context) {
+		if secret == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Slack integration is not configured"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = slack.Verify(secret, c.GetHeader("X-Slack-Request-Timestamp"), c.GetHeader("X-Slack-Signature"), body, time.Now())
+		if err != nil {
+			log.Printf("Rejected Slack request: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Set("slack_user", slackSender(c))
+		c.Next()
+	}
+}
+
+// slackSender returns the team and user that sent a slash command or interaction. Every
+// request comes from Slack's servers, so rate limits count them per Slack user instead.
+func slackSender(c *gin.Context) string {
+	if payload := c.PostForm("payload"); payload != "" {
+		var interaction slack.InteractionPayload
+		if err := json.Unmarshal([]byte(payload), &interaction); err != nil || interaction.User.ID == "" {
+			return ""
+		}
+		return interaction.Team.ID + ":" + interaction.User.ID
+	}
+	command := slack.ParseSlashCommand(c.Request.PostForm)
+	if command.UserID == "" {
+		return ""
+	}
+	return command.TeamID + ":" + command.UserID
+}
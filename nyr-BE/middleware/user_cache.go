@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"nyr/models"
+	"nyr/repository"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userCacheTTL bounds how long a status or role change can take to reach other
+// server instances, changes made on this instance are applied immediately
+const userCacheTTL = 30 * time.Second
+
+type cachedUser struct {
+	user    models.User
+	expires time.Time
+}
+
+// userCache keeps recently loaded users so that checking the account status
+// doesn't cost a database round trip on every authenticated request
+var userCache = struct {
+	sync.Mutex
+	entries map[string]cachedUser
+}{entries: map[string]cachedUser{}}
+
+// users is where accounts are loaded from
+var users repository.Users
+
+// SetUsers sets where accounts are loaded from, the routes set it on startup
+func SetUsers(u repository.Users) {
+	users = u
+}
+
+// loadUser returns the user with the given ID, from the cache when possible
+func loadUser(userID string) (*models.User, error) {
+	now := time.Now()
+
+	userCache.Lock()
+	entry, ok := userCache.entries[userID]
+	userCache.Unlock()
+	if ok && now.Before(entry.expires) {
+		return &entry.user, nil
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := users.FindByID(ctx, userObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	userCache.Lock()
+	defer userCache.Unlock()
+	// Drop expired entries now and then so the cache doesn't grow without bound
+	if len(userCache.entries) > 10000 {
+		for id, e := range userCache.entries {
+			if now.After(e.expires) {
+				delete(userCache.entries, id)
+			}
+		}
+	}
+	userCache.entries[userID] = cachedUser{user: user, expires: now.Add(userCacheTTL)}
+
+	return &user, nil
+}
+
+// InvalidateUser drops a user from the cache, call it after changing the user's status or role
+func InvalidateUser(userID string) {
+	userCache.Lock()
+	defer userCache.Unlock()
+	delete(userCache.entries, userID)
+}
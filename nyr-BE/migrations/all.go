@@ -0,0 +1,466 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All lists every migration in the order they run. Append new ones at the end with the
+// next version, and never change one that was released.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "remove duplicate likes, follows, blocks and reminders",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			// These were written with find-then-insert or unindexed upserts, so concurrent
+			// requests could store the same pair twice. The unique indexes in the next
+			// migration can't be built until the duplicates are gone.
+			return removeDuplicates(ctx, database, map[string][]string{
+				"likes":     {"user_id", "r_id"},
+				"follows":   {"user_id", "target_id"},
+				"blocks":    {"user_id", "target_id", "kind"},
+				"reminders": {"user_id", "r_id"},
+			})
+		},
+	},
+	{
+		Version:     2,
+		Description: "create indexes",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"users": {
+					{Keys: bson.D{{Key: "email", Value: 1}}},
+					{Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}}},
+				},
+				"resolutions": {
+					{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+				},
+				"comments": {
+					{Keys: bson.D{{Key: "r_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				},
+				"likes": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "r_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "r_id", Value: 1}}},
+				},
+				"follows": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "target_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "target_id", Value: 1}}},
+				},
+				"blocks": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "target_id", Value: 1}, {Key: "kind", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "kind", Value: 1}}},
+				},
+				"reminders": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "r_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "next_run_at", Value: 1}}},
+				},
+				"notifications": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}}},
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "group_key", Value: 1}, {Key: "read", Value: 1}}},
+				},
+				"personal_access_tokens": {
+					{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				},
+				"webhooks": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				},
+				"webhook_deliveries": {
+					{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+					{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				},
+				"reports": {
+					{Keys: bson.D{{Key: "status", Value: 1}, {Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}}},
+				},
+				"audit_logs": {
+					{Keys: bson.D{{Key: "created_at", Value: -1}}},
+					{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+					{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				},
+			})
+		},
+	},
+	{
+		Version:     3,
+		Description: "copy likes into reactions",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			// $merge matches on the unique index, so it has to exist first
+			err := createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"reactions": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "type", Value: 1}}},
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			// The likes collection is left in place so this can be rolled back
+			cursor, err := database.Collection("likes").Aggregate(ctx, []bson.M{
+				{"$project": bson.M{
+					"user_id":     1,
+					"target_type": "resolution",
+					"target_id":   "$r_id",
+					"type":        "like",
+					"created_at":  1,
+					"updated_at":  1,
+				}},
+				{"$merge": bson.M{
+					"into":           "reactions",
+					"on":             bson.A{"user_id", "target_type", "target_id"},
+					"whenMatched":    "keepExisting",
+					"whenNotMatched": "insert",
+				}},
+			}, options.Aggregate().SetAllowDiskUse(true))
+			if err != nil {
+				return fmt.Errorf("copying likes: %w", err)
+			}
+			return cursor.Close(ctx)
+		},
+	},
+	{
+		Version:     4,
+		Description: "index user handles",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			// Most users have no handle yet, only the ones that are set must be unique
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"users": {
+					{
+						Keys:    bson.D{{Key: "handle", Value: 1}},
+						Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"handle": bson.M{"$type": "string"}}),
+					},
+				},
+			})
+		},
+	},
+	{
+		Version:     5,
+		Description: "index bookmarks",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"bookmarks": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "r_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "collection", Value: 1}, {Key: "created_at", Value: -1}}},
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				},
+			})
+		},
+	},
+	{
+		Version:     6,
+		Description: "index adopted resolutions",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			// A user adopts a resolution at most once, resolutions that aren't copies are left out
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"resolutions": {
+					{Keys: bson.D{{Key: "adopted_from", Value: 1}, {Key: "created_at", Value: -1}}},
+					{
+						Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "adopted_from", Value: 1}},
+						Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"adopted_from": bson.M{"$type": "objectId"}}),
+					},
+				},
+			})
+		},
+	},
+	{
+		Version:     7,
+		Description: "lowercase user emails",
+		Up:          lowercaseEmails,
+	},
+	{
+		Version:     8,
+		Description: "make user emails unique",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			if err := uniqueEmails(ctx, database); err != nil {
+				return err
+			}
+			// Replaces the plain index from version 2, accounts without an email are left out
+			users := database.Collection("users")
+			if _, err := users.Indexes().DropOne(ctx, "email_1"); err != nil {
+				return fmt.Errorf("dropping the users email index: %w", err)
+			}
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"users": {
+					{
+						Keys:    bson.D{{Key: "email", Value: 1}},
+						Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+					},
+				},
+			})
+		},
+	},
+	{
+		Version:     9,
+		Description: "make user identities unique",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			if err := uniqueIdentities(ctx, database); err != nil {
+				return err
+			}
+			// Replaces the plain index from version 2, users without identities are left out
+			users := database.Collection("users")
+			if _, err := users.Indexes().DropOne(ctx, "identities.provider_1_identities.subject_1"); err != nil {
+				return fmt.Errorf("dropping the users identities index: %w", err)
+			}
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"users": {
+					{
+						Keys:    bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+						Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+					},
+				},
+			})
+		},
+	},
+	{
+		Version:     10,
+		Description: "make unread notification groups unique",
+		Up: func(ctx context.Context, database *mongo.Database) error {
+			// Only one unread notification per group, read ones of the same group are kept
+			err := removeDuplicatesWhere(ctx, database, "notifications", bson.M{"read": false}, []string{"user_id", "group_key"})
+			if err != nil {
+				return err
+			}
+			// Replaces the plain index from version 2. Notify upserts the unread notification
+			// of a group, the index makes concurrent upserts of the same group collide instead
+			// of both inserting.
+			notifications := database.Collection("notifications")
+			if _, err := notifications.Indexes().DropOne(ctx, "user_id_1_group_key_1_read_1"); err != nil {
+				return fmt.Errorf("dropping the notifications group index: %w", err)
+			}
+			return createIndexes(ctx, database, map[string][]mongo.IndexModel{
+				"notifications": {
+					{
+						Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "group_key", Value: 1}},
+						Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"read": false}),
+					},
+				},
+			})
+		},
+	},
+}
+
+// uniqueIdentities unlinks identities that concurrent links stored on several users from
+// all but the oldest of them, and logs who lost one
+func uniqueIdentities(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection("users")
+	cursor, err := users.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"identities.subject": bson.M{"$exists": true}}},
+		{"$unwind": "$identities"},
+		{"$group": bson.M{
+			"_id":   bson.M{"provider": "$identities.provider", "subject": "$identities.subject"},
+			"ids":   bson.M{"$addToSet": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("finding duplicate identities: %w", err)
+	}
+	var duplicates []struct {
+		Identity struct {
+			Provider string `bson:"provider"`
+			Subject  string `bson:"subject"`
+		} `bson:"_id"`
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("finding duplicate identities: %w", err)
+	}
+
+	for _, d := range duplicates {
+		if len(d.IDs) < 2 {
+			// Linked twice to the same user, the index doesn't mind
+			continue
+		}
+		// $addToSet doesn't keep the order, the oldest user has the smallest ID
+		sort.Slice(d.IDs, func(i, j int) bool { return d.IDs[i].Hex() < d.IDs[j].Hex() })
+		_, err := users.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": d.IDs[1:]}},
+			bson.M{"$pull": bson.M{"identities": bson.M{"provider": d.Identity.Provider, "subject": d.Identity.Subject}}},
+		)
+		if err != nil {
+			return fmt.Errorf("unlinking duplicate identities: %w", err)
+		}
+		others := []string{}
+		for _, id := range d.IDs[1:] {
+			others = append(others, id.Hex())
+		}
+		log.Printf("Unlinked a %s identity from users %s, user %s keeps it", d.Identity.Provider, strings.Join(others, ", "), d.IDs[0].Hex())
+	}
+	return nil
+}
+
+// uniqueEmails clears the emails sign in can't trust before they are made unique. Accounts
+// with identities keep their email only when one of them verified it, accounts from before
+// identities signed up with a verified Google address. Of the accounts left sharing an
+// address the oldest keeps it, the others are logged and can still sign in with their identities.
+func uniqueEmails(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection("users")
+	result, err := users.UpdateMany(ctx,
+		bson.M{
+			"email":      bson.M{"$gt": ""},
+			"identities": bson.M{"$not": bson.M{"$size": 0}, "$exists": true},
+			"$expr": bson.M{"$not": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$identities", bson.A{}}},
+				"as":    "identity",
+				"in":    bson.M{"$and": bson.A{bson.M{"$eq": bson.A{"$$identity.email", "$email"}}, bson.M{"$eq": bson.A{"$$identity.email_verified", true}}}},
+			}}}}},
+		},
+		bson.M{"$set": bson.M{"email": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("clearing unverified emails: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Cleared the unverified emails of %d users", result.ModifiedCount)
+	}
+
+	cursor, err := users.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"email": bson.M{"$gt": ""}}},
+		{"$sort": bson.M{"_id": 1}},
+		{"$group": bson.M{"_id": "$email", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("finding duplicate emails: %w", err)
+	}
+	var duplicates []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("finding duplicate emails: %w", err)
+	}
+
+	for _, d := range duplicates {
+		if _, err := users.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": d.IDs[1:]}}, bson.M{"$set": bson.M{"email": ""}}); err != nil {
+			return fmt.Errorf("clearing duplicate emails: %w", err)
+		}
+		others := []string{}
+		for _, id := range d.IDs[1:] {
+			others = append(others, id.Hex())
+		}
+		log.Printf("Users %s shared an email with user %s, which kept it", strings.Join(others, ", "), d.IDs[0].Hex())
+	}
+	return nil
+}
+
+// lowercaseEmails stores the emails of users and their identities in lower case, sign in
+// matches them exactly. $toLower only handles ASCII, so they are rewritten one by one.
+func lowercaseEmails(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection("users")
+	cursor, err := users.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"email": 1, "identities": 1}))
+	if err != nil {
+		return fmt.Errorf("finding users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var user struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Email      string             `bson:"email"`
+			Identities []bson.M           `bson:"identities"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return fmt.Errorf("decoding user: %w", err)
+		}
+
+		// Mirrors models.NormalizeEmail as it was when this was released
+		normalize := func(email string) string { return strings.ToLower(strings.TrimSpace(email)) }
+		set := bson.M{}
+		if email := normalize(user.Email); email != user.Email {
+			set["email"] = email
+		}
+		for i, identity := range user.Identities {
+			if email, ok := identity["email"].(string); ok && normalize(email) != email {
+				set[fmt.Sprintf("identities.%d.email", i)] = normalize(email)
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		if _, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set}); err != nil {
+			return fmt.Errorf("lowercasing the emails of user %s: %w", user.ID.Hex(), err)
+		}
+		updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("finding users: %w", err)
+	}
+	if updated > 0 {
+		log.Printf("Lowercased the emails of %d users", updated)
+	}
+	return nil
+}
+
+// createIndexes creates the indexes of each collection. Creating an index that already
+// exists with the same options does nothing.
+func createIndexes(ctx context.Context, database *mongo.Database, indexes map[string][]mongo.IndexModel) error {
+	for collection, models := range indexes {
+		if _, err := database.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("indexing %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// removeDuplicates keeps the oldest document of every group that shares the keys of its
+// collection and deletes the rest
+func removeDuplicates(ctx context.Context, database *mongo.Database, keys map[string][]string) error {
+	for collection, fields := range keys {
+		if err := removeDuplicatesWhere(ctx, database, collection, bson.M{}, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDuplicatesWhere is removeDuplicates for the documents of one collection that match the filter
+func removeDuplicatesWhere(ctx context.Context, database *mongo.Database, collection string, filter bson.M, fields []string) error {
+	group := bson.M{}
+	for _, field := range fields {
+		group[field] = "$" + field
+	}
+
+	cursor, err := database.Collection(collection).Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$sort": bson.M{"_id": 1}},
+		{"$group": bson.M{"_id": group, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("finding duplicate %s: %w", collection, err)
+	}
+
+	var duplicates []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("finding duplicate %s: %w", collection, err)
+	}
+
+	extra := []primitive.ObjectID{}
+	for _, d := range duplicates {
+		extra = append(extra, d.IDs[1:]...)
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+
+	result, err := database.Collection(collection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": extra}})
+	if err != nil {
+		return fmt.Errorf("removing duplicate %s: %w", collection, err)
+	}
+	log.Printf("Removed %d duplicate %s", result.DeletedCount, collection)
+	return nil
+}
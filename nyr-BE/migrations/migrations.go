@@ -0,0 +1,118 @@
+// Package migrations brings the database schema up to date. Every migration has a version,
+// applied versions are recorded in the migrations collection and never run again.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const collectionName = "migrations"
+
+// Migration is one step of the schema. Two instances starting together can both apply a
+// migration before either records it, so Up must be safe to run twice.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, database *mongo.Database) error
+}
+
+// Applied is the record of a migration that ran
+type Applied struct {
+	Version     int       `json:"version" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"applied_at" bson:"applied_at"`
+}
+
+// Run applies every migration in All that wasn't applied yet, oldest first.
+// It stops at the first migration that fails, the ones before it stay applied.
+func Run(ctx context.Context, database *mongo.Database) error {
+	return run(ctx, database, All)
+}
+
+// Pending returns the migrations in All that weren't applied yet
+func Pending(ctx context.Context, database *mongo.Database) ([]Migration, error) {
+	return pending(ctx, database, All)
+}
+
+// History returns the applied migrations, oldest first
+func History(ctx context.Context, database *mongo.Database) ([]Applied, error) {
+	cursor, err := database.Collection(collectionName).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	applied := []Applied{}
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func run(ctx context.Context, database *mongo.Database, migrations []Migration) error {
+	todo, err := pending(ctx, database, migrations)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range todo {
+		log.Printf("Applying migration %d: %s", m.Version, m.Description)
+		if err := m.Up(ctx, database); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		_, err := database.Collection(collectionName).InsertOne(ctx, Applied{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+	}
+	return nil
+}
+
+func pending(ctx context.Context, database *mongo.Database, migrations []Migration) ([]Migration, error) {
+	if err := validate(migrations); err != nil {
+		return nil, err
+	}
+
+	applied, err := History(ctx, database)
+	if err != nil {
+		return nil, fmt.Errorf("loading applied migrations: %w", err)
+	}
+	done := map[int]bool{}
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	todo := []Migration{}
+	for _, m := range migrations {
+		if !done[m.Version] {
+			todo = append(todo, m)
+		}
+	}
+	return todo, nil
+}
+
+// validate checks that versions are positive and strictly increasing, so a migration
+// added in the wrong place or with a reused version is caught before anything runs
+func validate(migrations []Migration) error {
+	last := 0
+	for _, m := range migrations {
+		if m.Version <= last {
+			return fmt.Errorf("migration %d (%s) must have a version above %d", m.Version, m.Description, last)
+		}
+		if m.Up == nil {
+			return fmt.Errorf("migration %d (%s) has no Up", m.Version, m.Description)
+		}
+		last = m.Version
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func noop(ctx context.Context, database *mongo.Database) error { return nil }
+
+func TestAllIsValid(t *testing.T) {
+	if err := validate(All); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name       string
+		migrations []Migration
+		valid      bool
+	}{
+		{"empty", nil, true},
+		{"increasing", []Migration{{1, "a", noop}, {2, "b", noop}, {5, "c", noop}}, true},
+		{"zero version", []Migration{{0, "a", noop}}, false},
+		{"reused version", []Migration{{1, "a", noop}, {1, "b", noop}}, false},
+		{"out of order", []Migration{{2, "a", noop}, {1, "b", noop}}, false},
+		{"without Up", []Migration{{1, "a", nil}}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validate(tc.migrations); (err == nil) != tc.valid {
+				t.Errorf("got %v, want valid %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+// testDatabase returns an empty database on the server in MONGO_TEST_URI, which is dropped
+// afterwards. Tests skip without it, except in CI where it must be set.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" && os.Getenv("CI") != "" {
+		t.Fatal("MONGO_TEST_URI must be set in CI")
+	}
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	database := client.Database("nyr_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return database
+}
+
+func TestRunAppliesEachMigrationOnce(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+
+	runs := map[int]int{}
+	migration := func(version int, err error) Migration {
+		return Migration{version, "test", func(ctx context.Context, database *mongo.Database) error {
+			runs[version]++
+			return err
+		}}
+	}
+	failing := errors.New("failed")
+
+	// A failing migration stops the run, the ones before it stay applied
+	err := run(ctx, database, []Migration{migration(1, nil), migration(2, failing), migration(3, nil)})
+	if !errors.Is(err, failing) {
+		t.Fatalf("got %v, want the failure of migration 2", err)
+	}
+
+	if err := run(ctx, database, []Migration{migration(1, nil), migration(2, nil), migration(3, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(ctx, database, []Migration{migration(1, nil), migration(2, nil), migration(3, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if runs[1] != 1 || runs[2] != 2 || runs[3] != 1 {
+		t.Errorf("got runs %v, want 1 once, 2 twice and 3 once", runs)
+	}
+
+	applied, err := History(ctx, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 || applied[0].Version != 1 || applied[2].Version != 3 {
+		t.Errorf("got history %+v, want versions 1 to 3", applied)
+	}
+}
+
+func TestRunRemovesDuplicateLikes(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	likes := database.Collection("likes")
+
+	userID, rID := primitive.NewObjectID(), primitive.NewObjectID()
+	first := primitive.NewObjectID()
+	for _, id := range []primitive.ObjectID{first, primitive.NewObjectID(), primitive.NewObjectID()} {
+		if _, err := likes.InsertOne(ctx, bson.M{"_id": id, "user_id": userID, "r_id": rID, "created_at": time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := likes.InsertOne(ctx, bson.M{"user_id": primitive.NewObjectID(), "r_id": rID, "created_at": time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Run(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := likes.CountDocuments(ctx, bson.M{"r_id": rID})
+	if err != nil || count != 2 {
+		t.Fatalf("got %d likes (%v), want 2", count, err)
+	}
+	if err := likes.FindOne(ctx, bson.M{"_id": first}).Err(); err != nil {
+		t.Errorf("the oldest like was removed: %v", err)
+	}
+
+	// The unique index refuses another like of the same resolution by the same user
+	_, err = likes.InsertOne(ctx, bson.M{"user_id": userID, "r_id": rID, "created_at": time.Now()})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("got %v, want a duplicate key error", err)
+	}
+
+	// The remaining likes were copied into reactions
+	count, err = database.Collection("reactions").CountDocuments(ctx, bson.M{"target_type": "resolution", "target_id": rID, "type": "like"})
+	if err != nil || count != 2 {
+		t.Errorf("got %d like reactions (%v), want 2", count, err)
+	}
+
+	pending, err := Pending(ctx, database)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("got %d pending migrations (%v), want none", len(pending), err)
+	}
+}
+
+func TestRunKeepsOneUnreadNotificationPerGroup(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	notifications := database.Collection("notifications")
+
+	userID := primitive.NewObjectID()
+	first := primitive.NewObjectID()
+	for _, n := range []bson.M{
+		{"_id": first, "user_id": userID, "group_key": "like:1", "read": false},
+		{"_id": primitive.NewObjectID(), "user_id": userID, "group_key": "like:1", "read": false},
+		{"_id": primitive.NewObjectID(), "user_id": userID, "group_key": "like:1", "read": true},
+		{"_id": primitive.NewObjectID(), "user_id": userID, "group_key": "like:1", "read": true},
+	} {
+		if _, err := notifications.InsertOne(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Run(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+
+	unread, err := notifications.CountDocuments(ctx, bson.M{"user_id": userID, "read": false})
+	if err != nil || unread != 1 {
+		t.Fatalf("got %d unread notifications (%v), want 1", unread, err)
+	}
+	if err := notifications.FindOne(ctx, bson.M{"_id": first}).Err(); err != nil {
+		t.Errorf("the oldest unread notification was removed: %v", err)
+	}
+	read, err := notifications.CountDocuments(ctx, bson.M{"user_id": userID, "read": true})
+	if err != nil || read != 2 {
+		t.Errorf("got %d read notifications (%v), want both kept", read, err)
+	}
+
+	// Another unread notification of the group is refused, a read one is not
+	_, err = notifications.InsertOne(ctx, bson.M{"user_id": userID, "group_key": "like:1", "read": false})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("got %v, want a duplicate key error", err)
+	}
+	if _, err := notifications.InsertOne(ctx, bson.M{"user_id": userID, "group_key": "like:1", "read": true}); err != nil {
+		t.Errorf("inserting a read notification: %v", err)
+	}
+}
+
+func TestRunLowercasesEmails(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	users := database.Collection("users")
+
+	legacy, current := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, u := range []bson.M{
+		{"_id": legacy, "email": " Alice@Example.com", "identities": bson.A{
+			bson.M{"provider": "google", "subject": "1", "email": "Alice@Example.COM", "email_verified": true},
+			bson.M{"provider": "github", "subject": "2"},
+		}},
+		{"_id": current, "email": "bob@example.com"},
+	} {
+		if _, err := users.InsertOne(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Run(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Email      string   `bson:"email"`
+		Identities []bson.M `bson:"identities"`
+	}
+	if err := users.FindOne(ctx, bson.M{"_id": legacy}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "alice@example.com" || len(got.Identities) != 2 || got.Identities[0]["email"] != "alice@example.com" {
+		t.Fatalf("got %+v, want the emails in lower case", got)
+	}
+	if _, ok := got.Identities[1]["email"]; ok {
+		t.Errorf("got %+v, want no email added to the identity without one", got.Identities[1])
+	}
+	if err := users.FindOne(ctx, bson.M{"_id": current}).Decode(&got); err != nil || got.Email != "bob@example.com" {
+		t.Errorf("got %q (%v), want the lower case email kept", got.Email, err)
+	}
+}
+
+func TestRunMakesEmailsUnique(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	users := database.Collection("users")
+
+	verified, unverified := primitive.NewObjectID(), primitive.NewObjectID()
+	older, newer := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, u := range []bson.M{
+		{"_id": verified, "email": "alice@example.com", "identities": bson.A{
+			bson.M{"provider": "github", "subject": "1", "email": "alice@example.com", "email_verified": true},
+		}},
+		// Signed up with an address its provider didn't verify
+		{"_id": unverified, "email": "alice@example.com", "identities": bson.A{
+			bson.M{"provider": "github", "subject": "2", "email": "alice@example.com"},
+		}},
+		// Accounts from before identities share an address
+		{"_id": older, "email": "bob@example.com"},
+		{"_id": newer, "email": "bob@example.com"},
+	} {
+		if _, err := users.InsertOne(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Run(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		id    primitive.ObjectID
+		email string
+	}{
+		{verified, "alice@example.com"},
+		{unverified, ""},
+		{older, "bob@example.com"},
+		{newer, ""},
+	} {
+		var got struct {
+			Email string `bson:"email"`
+		}
+		if err := users.FindOne(ctx, bson.M{"_id": tc.id}).Decode(&got); err != nil || got.Email != tc.email {
+			t.Errorf("user %s has email %q (%v), want %q", tc.id.Hex(), got.Email, err, tc.email)
+		}
+	}
+
+	// The index keeps them unique from now on, accounts without an email don't collide
+	if _, err := users.InsertOne(ctx, bson.M{"email": "bob@example.com"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("inserting a taken email returned %v, want a duplicate key error", err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email": ""}); err != nil {
+		t.Errorf("inserting another user without an email: %v", err)
+	}
+}
+
+func TestRunMakesIdentitiesUnique(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	users := database.Collection("users")
+
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	slack := bson.M{"provider": "slack", "subject": "T1:U1"}
+	for _, u := range []bson.M{
+		{"_id": first, "identities": bson.A{bson.M{"provider": "google", "subject": "1"}, slack}},
+		// Linked to the same Slack user by a concurrent request
+		{"_id": second, "identities": bson.A{bson.M{"provider": "google", "subject": "2"}, slack}},
+		// Users without identities don't collide
+		{"email": "a@example.com"},
+		{"email": "b@example.com"},
+	} {
+		if _, err := users.InsertOne(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Run(ctx, database); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		id         primitive.ObjectID
+		identities int
+	}{
+		{first, 2},
+		{second, 1},
+	} {
+		var got struct {
+			Identities []bson.M `bson:"identities"`
+		}
+		if err := users.FindOne(ctx, bson.M{"_id": tc.id}).Decode(&got); err != nil || len(got.Identities) != tc.identities {
+			t.Errorf("user %s has identities %v (%v), want %d", tc.id.Hex(), got.Identities, err, tc.identities)
+		}
+	}
+
+	_, err := users.UpdateOne(ctx, bson.M{"_id": second}, bson.M{"$push": bson.M{"identities": slack}})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("linking a linked identity returned %v, want a duplicate key error", err)
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditLog is an append-only record of a security or moderation relevant action
+type AuditLog struct {
+	ID         primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
+	ActorID    *primitive.ObjectID    `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Action     string                 `json:"action" bson:"action"`
+	TargetType string                 `json:"target_type,omitempty" bson:"target_type,omitempty"`
+	TargetID   string                 `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	IP         string                 `json:"ip" bson:"ip"`
+	UserAgent  string                 `json:"user_agent" bson:"user_agent"`
+	Changes    map[string]AuditChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	CreatedAt  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// AuditChange is the value of a field before and after an action
+type AuditChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
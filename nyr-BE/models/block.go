@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	BlockKindBlock = "block"
+	BlockKindMute  = "mute"
+)
+
+// Block records that a user blocked or muted another user.
+// A block keeps the target away from the user's resolutions, a mute only hides
+// the target's content from the user.
+type Block struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TargetID  primitive.ObjectID `json:"target_id" bson:"target_id"`
+	Kind      string             `json:"kind" bson:"kind"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
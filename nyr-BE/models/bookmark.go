@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bookmark saves someone's resolution for later, a user bookmarks a resolution at most once.
+// Collection is an optional name the user groups bookmarks by, empty for none.
+type Bookmark struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	RID        primitive.ObjectID `json:"r_id" bson:"r_id"`
+	Collection string             `json:"collection,omitempty" bson:"collection,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+}
@@ -6,11 +6,20 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// CommentKindCheckIn marks a comment by the owner reporting progress on their resolution
+const CommentKindCheckIn = "checkin"
+
 type Comments struct {
-	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
-	RID       primitive.ObjectID `json:"r_id,omitempty" bson:"r_id,omitempty"`
-	Comment   string             `json:"comment" bson:"comment"`
-	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	ID      primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID  primitive.ObjectID `json:"user_id" bson:"user_id"`
+	RID     primitive.ObjectID `json:"r_id,omitempty" bson:"r_id,omitempty"`
+	Comment string             `json:"comment" bson:"comment"`
+	// Mentions are set from the text when the comment is created
+	Mentions []Mention `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	// ParentID is set on replies to another comment
+	ParentID  *primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Kind      string              `json:"kind,omitempty" bson:"kind,omitempty"`
+	Hidden    bool                `json:"hidden,omitempty" bson:"hidden,omitempty"`
+	CreatedAt time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at" bson:"updated_at"`
 }
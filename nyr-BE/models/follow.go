@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Follow records that a user follows another user
+type Follow struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TargetID  primitive.ObjectID `json:"target_id" bson:"target_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -6,6 +6,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Likes are the likes from before reactions. The likes collection was copied to reactions,
+// where a like is a reaction of type ReactionLike, and is no longer written.
 type Likes struct {
 	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// MagicLink records an emailed sign in link so that it can only be used once
+type MagicLink struct {
+	ID        string     `json:"id" bson:"_id"`
+	Email     string     `json:"email" bson:"email"`
+	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+}
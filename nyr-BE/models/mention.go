@@ -0,0 +1,12 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Mention is an @handle in a text that was resolved to a user. Start and End are the
+// offsets of the @handle in characters (Unicode code points), End is exclusive.
+type Mention struct {
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Handle string             `json:"handle" bson:"handle"`
+	Start  int                `json:"start" bson:"start"`
+	End    int                `json:"end" bson:"end"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	NotificationTypeLike     = "like"
+	NotificationTypeComment  = "comment"
+	NotificationTypeReply    = "reply"
+	NotificationTypeFollow   = "follow"
+	NotificationTypeReminder = "reminder"
+	NotificationTypeReaction = "reaction"
+	NotificationTypeMention  = "mention"
+	NotificationTypeAdoption = "adoption"
+)
+
+var NotificationTypes = []string{NotificationTypeLike, NotificationTypeComment, NotificationTypeReply, NotificationTypeFollow, NotificationTypeReminder, NotificationTypeReaction, NotificationTypeMention, NotificationTypeAdoption}
+
+// Categories of email a user can turn on or off
+const (
+	EmailCategoryComments  = "comments"
+	EmailCategoryReplies   = "replies"
+	EmailCategoryDigest    = "digest"
+	EmailCategoryReminders = "reminders"
+)
+
+var EmailCategories = []string{EmailCategoryComments, EmailCategoryReplies, EmailCategoryDigest, EmailCategoryReminders}
+
+// EmailCategoryDefaults is whether a category is on before the user chose, the digest is opt-in
+var EmailCategoryDefaults = map[string]bool{
+	EmailCategoryComments:  true,
+	EmailCategoryReplies:   true,
+	EmailCategoryDigest:    false,
+	EmailCategoryReminders: true,
+}
+
+// Notification tells a user about activity around them. Notifications with the same
+// group key are merged while unread, so many likes on a resolution become one entry.
+type Notification struct {
+	ID           primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	Type         string               `json:"type" bson:"type"`
+	GroupKey     string               `json:"-" bson:"group_key"`
+	ActorIDs     []primitive.ObjectID `json:"actor_ids" bson:"actor_ids"`
+	LastActorID  primitive.ObjectID   `json:"last_actor_id" bson:"last_actor_id"`
+	ResolutionID *primitive.ObjectID  `json:"r_id,omitempty" bson:"r_id,omitempty"`
+	CommentID    *primitive.ObjectID  `json:"comment_id,omitempty" bson:"comment_id,omitempty"`
+	Read         bool                 `json:"read" bson:"read"`
+	ReadAt       *time.Time           `json:"read_at,omitempty" bson:"read_at,omitempty"`
+	CreatedAt    time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reactions a user can leave, a like is one of them
+const (
+	ReactionLike       = "like"
+	ReactionCheer      = "cheer"
+	ReactionMeToo      = "me_too"
+	ReactionYouGotThis = "you_got_this"
+	ReactionSkeptical  = "skeptical"
+)
+
+var ReactionTypes = []string{ReactionLike, ReactionCheer, ReactionMeToo, ReactionYouGotThis, ReactionSkeptical}
+
+// What a reaction can be left on
+const (
+	ReactionTargetResolution = "resolution"
+	ReactionTargetComment    = "comment"
+)
+
+// Reaction is a user's reaction to a target, a user has at most one per target
+type Reaction struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TargetType string             `json:"target_type" bson:"target_type"`
+	TargetID   primitive.ObjectID `json:"target_id" bson:"target_id"`
+	Type       string             `json:"type" bson:"type"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How often a check in reminder repeats
+const (
+	ReminderDaily   = "daily"
+	ReminderWeekly  = "weekly"
+	ReminderMonthly = "monthly"
+)
+
+// Reminder is a user's schedule for being reminded to check in on one of their resolutions.
+// Times are in the timezone, the scheduler sends it once next_run_at has passed.
+type Reminder struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	RID        primitive.ObjectID `json:"r_id" bson:"r_id"`
+	Frequency  string             `json:"frequency" bson:"frequency"`
+	Hour       int                `json:"hour" bson:"hour"`
+	Weekday    int                `json:"weekday" bson:"weekday"`
+	DayOfMonth int                `json:"day_of_month" bson:"day_of_month"`
+	Timezone   string             `json:"timezone" bson:"timezone"`
+	NextRunAt  time.Time          `json:"next_run_at" bson:"next_run_at"`
+	LastSentAt *time.Time         `json:"last_sent_at,omitempty" bson:"last_sent_at,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Things that can be reported
+const (
+	ReportTargetResolution = "resolution"
+	ReportTargetComment    = "comment"
+	ReportTargetUser       = "user"
+)
+
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusDismissed = "dismissed"
+	ReportStatusActioned  = "actioned"
+)
+
+// ReportReasonContentPolicy is used for reports created by the content policy, users can't pick it
+const ReportReasonContentPolicy = "content_policy"
+
+// ReportReasons maps every accepted reason to its severity, higher is more urgent
+var ReportReasons = map[string]int{
+	"spam":       1,
+	"off_topic":  1,
+	"other":      1,
+	"harassment": 3,
+	"hate":       4,
+	"self_harm":  4,
+}
+
+type Report struct {
+	ID           primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	ReporterID   primitive.ObjectID  `json:"reporter_id" bson:"reporter_id"`
+	TargetType   string              `json:"target_type" bson:"target_type"`
+	TargetID     primitive.ObjectID  `json:"target_id" bson:"target_id"`
+	TargetUserID primitive.ObjectID  `json:"target_user_id" bson:"target_user_id"`
+	Reason       string              `json:"reason" bson:"reason"`
+	Details      string              `json:"details,omitempty" bson:"details,omitempty"`
+	Severity     int                 `json:"severity" bson:"severity"`
+	Status       string              `json:"status" bson:"status"`
+	Action       string              `json:"action,omitempty" bson:"action,omitempty"`
+	ResolvedBy   *primitive.ObjectID `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	ResolvedAt   *time.Time          `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+	CreatedAt    time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// Warning is a moderator's warning to a user
+type Warning struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	ModeratorID primitive.ObjectID `json:"moderator_id" bson:"moderator_id"`
+	Reason      string             `json:"reason" bson:"reason"`
+	TargetType  string             `json:"target_type" bson:"target_type"`
+	TargetID    primitive.ObjectID `json:"target_id" bson:"target_id"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -11,6 +11,10 @@ type Resolution struct {
 	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
 	Resolution string             `json:"resolution" bson:"resolution"`
 	Tags       []string           `json:"tags" bson:"tags"`
-	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+	Mentions   []Mention          `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	// AdoptedFrom is the resolution this one was copied from with "me too"
+	AdoptedFrom *primitive.ObjectID `json:"adopted_from,omitempty" bson:"adopted_from,omitempty"`
+	Hidden      bool                `json:"hidden,omitempty" bson:"hidden,omitempty"`
+	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at" bson:"updated_at"`
 }
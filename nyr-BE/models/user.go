@@ -1,16 +1,117 @@
 package models
 
 import (
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
-	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Name      string             `json:"name" bson:"name"`
-	Email     string             `json:"email" bson:"email"`
-	Image     string             `json:"image" bson:"image"`
-	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	ID    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name  string             `json:"name" bson:"name"`
+	Email string             `json:"email" bson:"email"`
+	Image string             `json:"image" bson:"image"`
+	// Handle is the unique name others @mention the user by, users without one can't be mentioned
+	Handle string `json:"handle,omitempty" bson:"handle,omitempty"`
+	// Timezone is an IANA name like "Europe/Berlin", reminders are scheduled in it
+	Timezone       string     `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	Role           string     `json:"role,omitempty" bson:"role,omitempty"`
+	Identities     []Identity `json:"identities,omitempty" bson:"identities,omitempty"`
+	Status         string     `json:"status,omitempty" bson:"status,omitempty"`
+	StatusReason   string     `json:"status_reason,omitempty" bson:"status_reason,omitempty"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty" bson:"suspended_until,omitempty"`
+	// NotificationPreferences turns notification types off, a missing type is on
+	NotificationPreferences map[string]bool `json:"notification_preferences,omitempty" bson:"notification_preferences,omitempty"`
+	// EmailPreferences turns email categories on or off, missing ones use EmailCategoryDefaults
+	EmailPreferences map[string]bool `json:"email_preferences,omitempty" bson:"email_preferences,omitempty"`
+	LastDigestAt     *time.Time      `json:"-" bson:"last_digest_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at" bson:"updated_at"`
+}
+
+// Identity links an account at an identity provider to a user
+type Identity struct {
+	Provider string `json:"provider" bson:"provider"`
+	Subject  string `json:"subject" bson:"subject"`
+	Email    string `json:"email,omitempty" bson:"email,omitempty"`
+	// EmailVerified is whether the provider verified Email when the identity was linked
+	EmailVerified bool      `json:"email_verified,omitempty" bson:"email_verified,omitempty"`
+	LinkedAt      time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// Roles a user can have, each role includes the permissions of the ones before it
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBanned    = "banned"
+)
+
+// EffectiveStatus returns the status of the account at the given time.
+// A suspension that has run out counts as active.
+func (u User) EffectiveStatus(now time.Time) string {
+	switch u.Status {
+	case UserStatusBanned:
+		return UserStatusBanned
+	case UserStatusSuspended:
+		if u.SuspendedUntil != nil && now.Before(*u.SuspendedUntil) {
+			return UserStatusSuspended
+		}
+	}
+	return UserStatusActive
+}
+
+// SignedUpVerified reports whether the user's email came from the identity they signed up
+// with, their first one, and its provider verified it. Identities linked later by email don't count.
+func (u User) SignedUpVerified() bool {
+	return u.Email != "" && len(u.Identities) > 0 && u.Identities[0].EmailVerified && u.Identities[0].Email == u.Email
+}
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// HasRole reports whether the user's role includes the permissions of the required role.
+// Users without a stored role are regular users.
+func (u User) HasRole(required string) bool {
+	return roleRank(u.Role) >= roleRank(required)
+}
+
+func roleRank(role string) int {
+	switch role {
+	case RoleAdmin:
+		return 2
+	case RoleModerator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// ValidHandle reports whether handle is 3 to 30 lowercase letters, digits or underscores
+func ValidHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+// NormalizeEmail returns the form emails are stored and matched in. Mail servers treat the
+// part before the @ as case sensitive, but no provider hands out addresses that differ by case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
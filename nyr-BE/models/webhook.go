@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event types webhooks can subscribe to
+const (
+	WebhookEventResolutionCreated = "resolution.created"
+	WebhookEventCommentCreated    = "comment.created"
+	WebhookEventLikeUpdated       = "like.updated"
+	WebhookEventReactionUpdated   = "reaction.updated"
+)
+
+var WebhookEvents = []string{WebhookEventResolutionCreated, WebhookEventCommentCreated, WebhookEventLikeUpdated, WebhookEventReactionUpdated}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint that receives events. A user's webhook receives the events about
+// their own resolutions, a global one (without user) set up by an admin receives every event.
+type Webhook struct {
+	ID                  primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID              *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id"`
+	URL                 string              `json:"url" bson:"url"`
+	Secret              string              `json:"-" bson:"secret"`
+	Events              []string            `json:"events" bson:"events"`
+	Active              bool                `json:"active" bson:"active"`
+	ConsecutiveFailures int                 `json:"consecutive_failures" bson:"consecutive_failures"`
+	DisabledAt          *time.Time          `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+	CreatedAt           time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one webhook, with the outcome of its latest attempt
+type WebhookDelivery struct {
+	ID             primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	WebhookID      primitive.ObjectID  `json:"webhook_id" bson:"webhook_id"`
+	EventID        string              `json:"event_id" bson:"event_id"`
+	Event          string              `json:"event" bson:"event"`
+	Payload        string              `json:"payload" bson:"payload"`
+	Status         string              `json:"status" bson:"status"`
+	Attempts       int                 `json:"attempts" bson:"attempts"`
+	NextAttemptAt  *time.Time          `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	LastStatusCode int                 `json:"last_status_code,omitempty" bson:"last_status_code,omitempty"`
+	LastError      string              `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	ReplayOf       *primitive.ObjectID `json:"replay_of,omitempty" bson:"replay_of,omitempty"`
+	DeliveredAt    *time.Time          `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	CreatedAt      time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at" bson:"updated_at"`
+}
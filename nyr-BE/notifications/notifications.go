@@ -0,0 +1,179 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"nyr/models"
+	"nyr/pubsub"
+	"nyr/repository"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// repos is where notifications are stored and recipients are looked up
+var repos repository.Repositories
+
+// SetRepositories sets where notifications are stored, the routes set it on startup
+func SetRepositories(r repository.Repositories) {
+	repos = r
+}
+
+// Event is something a user may be notified about
+type Event struct {
+	Type         string
+	RecipientID  primitive.ObjectID
+	ActorID      primitive.ObjectID
+	ResolutionID *primitive.ObjectID
+	CommentID    *primitive.ObjectID
+}
+
+// groupKey decides which events are merged into one notification while it is unread.
+// Likes, other reactions and reminders are grouped per resolution or comment, adoptions per
+// resolution and follows all together, every comment, reply and mention stands alone.
+func groupKey(e Event) string {
+	switch e.Type {
+	case models.NotificationTypeLike, models.NotificationTypeReaction:
+		if e.CommentID != nil {
+			return e.Type + ":comment:" + e.CommentID.Hex()
+		}
+		return e.Type + ":" + e.ResolutionID.Hex()
+	case models.NotificationTypeComment:
+		return "comment:" + e.CommentID.Hex()
+	case models.NotificationTypeReply:
+		return "reply:" + e.CommentID.Hex()
+	case models.NotificationTypeReminder:
+		return "reminder:" + e.ResolutionID.Hex()
+	case models.NotificationTypeAdoption:
+		return "adoption:" + e.ResolutionID.Hex()
+	case models.NotificationTypeMention:
+		if e.CommentID != nil {
+			return "mention:comment:" + e.CommentID.Hex()
+		}
+		return "mention:" + e.ResolutionID.Hex()
+	default:
+		return e.Type
+	}
+}
+
+// Notify records the event for the recipient, unless they caused it themselves, turned the
+// type off or blocked or muted the actor. Failures are logged, they never fail the action.
+func Notify(e Event) {
+	if e.RecipientID == e.ActorID {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if ok, err := wants(ctx, e); err != nil || !ok {
+		if err != nil {
+			log.Printf("Error checking notification preferences: %v", err)
+		}
+		return
+	}
+
+	now := time.Now()
+	err := repos.Notifications.Add(ctx, models.Notification{
+		UserID:       e.RecipientID,
+		Type:         e.Type,
+		GroupKey:     groupKey(e),
+		LastActorID:  e.ActorID,
+		ResolutionID: e.ResolutionID,
+		CommentID:    e.CommentID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	if err != nil {
+		log.Printf("Error creating %s notification: %v", e.Type, err)
+		return
+	}
+
+	pubsub.Publish(pubsub.UserTopic(e.RecipientID), pubsub.EventNotificationCreated, e.ActorID, map[string]interface{}{
+		"type":       e.Type,
+		"r_id":       e.ResolutionID,
+		"comment_id": e.CommentID,
+	})
+}
+
+// Retract takes the actor back out of an unread notification, when a like or follow is undone.
+// The notification is removed once no actor is left.
+func Retract(e Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := repos.Notifications.Retract(ctx, e.RecipientID, groupKey(e), e.ActorID); err != nil {
+		log.Printf("Error retracting %s notification: %v", e.Type, err)
+	}
+}
+
+// wants reports whether the recipient accepts the event
+func wants(ctx context.Context, e Event) (bool, error) {
+	recipient, err := repos.Users.FindByID(ctx, e.RecipientID)
+	if err != nil {
+		return false, err
+	}
+	if !Enabled(recipient, e.Type) {
+		return false, nil
+	}
+
+	for _, kind := range []string{models.BlockKindBlock, models.BlockKindMute} {
+		blocked, err := repos.Blocks.Exists(ctx, e.RecipientID, e.ActorID, kind)
+		if err != nil || blocked {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Enabled reports whether the user gets notifications of the type
+func Enabled(user models.User, notificationType string) bool {
+	enabled, ok := user.NotificationPreferences[notificationType]
+	return !ok || enabled
+}
+
+// Message describes a notification, for example "Alice and 12 others liked your resolution"
+func Message(n models.Notification, actorName string) string {
+	if n.Type == models.NotificationTypeReminder {
+		return "Time to check in on your resolution"
+	}
+
+	if actorName == "" {
+		actorName = "Someone"
+	}
+	who := actorName
+	switch others := len(n.ActorIDs) - 1; {
+	case others == 1:
+		who = actorName + " and 1 other"
+	case others > 1:
+		who = fmt.Sprintf("%s and %d others", actorName, others)
+	}
+
+	switch n.Type {
+	case models.NotificationTypeLike:
+		return who + " liked your " + subject(n)
+	case models.NotificationTypeReaction:
+		return who + " reacted to your " + subject(n)
+	case models.NotificationTypeMention:
+		return who + " mentioned you in a " + subject(n)
+	case models.NotificationTypeAdoption:
+		return who + " adopted your resolution"
+	case models.NotificationTypeComment:
+		return who + " commented on your resolution"
+	case models.NotificationTypeReply:
+		return who + " replied to your comment"
+	case models.NotificationTypeFollow:
+		return who + " started following you"
+	default:
+		return who + " interacted with you"
+	}
+}
+
+// subject names what a like, reaction or mention notification is about
+func subject(n models.Notification) string {
+	if n.CommentID != nil {
+		return "comment"
+	}
+	return "resolution"
+}
@@ -0,0 +1,359 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of content the policy is applied to
+const (
+	KindResolution = "resolution"
+	KindComment    = "comment"
+	KindName       = "name"
+)
+
+// What happens to content that breaks a rule
+const (
+	ActionReject = "reject"
+	ActionFlag   = "flag"
+	ActionMask   = "mask"
+)
+
+// Rule types
+const (
+	RuleBlocklist     = "blocklist"
+	RuleRegex         = "regex"
+	RuleRepeatedChars = "repeated_chars"
+	RuleLinks         = "links"
+)
+
+// Config is the content of the rules file
+type Config struct {
+	MaxLength map[string]int `json:"max_length"`
+	Rules     []RuleConfig   `json:"rules"`
+}
+
+// RuleConfig configures one rule. Words is used by blocklist rules, Pattern by
+// regex rules, and Threshold by repeated_chars (run length) and links (max links).
+type RuleConfig struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Action    string   `json:"action"`
+	Kinds     []string `json:"kinds,omitempty"`
+	Words     []string `json:"words,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Threshold int      `json:"threshold,omitempty"`
+	Message   string   `json:"message,omitempty"`
+}
+
+// DefaultConfig is used until a rules file is loaded
+var DefaultConfig = Config{
+	MaxLength: map[string]int{
+		KindResolution: 500,
+		KindComment:    1000,
+		KindName:       50,
+	},
+	Rules: []RuleConfig{
+		{Name: "repeated-characters", Type: RuleRepeatedChars, Action: ActionFlag, Threshold: 10},
+		{Name: "too-many-links", Type: RuleLinks, Action: ActionFlag, Threshold: 3},
+	},
+}
+
+// Violation describes a rule that the content broke
+type Violation struct {
+	Rule    string `json:"rule"`
+	Action  string `json:"action"`
+	Message string `json:"message"`
+}
+
+// Decision is the outcome of checking content against the policy
+type Decision struct {
+	// Text is the content to store, with masked parts replaced
+	Text       string
+	Rejected   bool
+	Flagged    bool
+	Violations []Violation
+}
+
+// Reason returns a message for the first rule that rejected the content
+func (d Decision) Reason() string {
+	for _, v := range d.Violations {
+		if v.Action == ActionReject {
+			return v.Message
+		}
+	}
+	return ""
+}
+
+// FlaggedRules returns the names of the rules that flagged the content
+func (d Decision) FlaggedRules() []string {
+	names := []string{}
+	for _, v := range d.Violations {
+		if v.Action == ActionFlag {
+			names = append(names, v.Rule)
+		}
+	}
+	return names
+}
+
+var (
+	mu      sync.RWMutex
+	current = mustCompile(DefaultConfig)
+)
+
+// Check runs content of the given kind through every rule of the current policy
+func Check(kind, text string) Decision {
+	mu.RLock()
+	p := current
+	mu.RUnlock()
+
+	return p.check(kind, text)
+}
+
+// LoadFile replaces the current policy with the rules from a JSON file.
+// The current policy is kept if the file is invalid.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	p, err := compile(cfg)
+	if err != nil {
+		return fmt.Errorf("compiling %s: %w", path, err)
+	}
+
+	mu.Lock()
+	current = p
+	mu.Unlock()
+	return nil
+}
+
+type compiledRule struct {
+	RuleConfig
+	re *regexp.Regexp
+}
+
+type compiledPolicy struct {
+	maxLength map[string]int
+	rules     []compiledRule
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// wordBoundary matches a character that can't be part of a word. Go's \b only knows ASCII
+// letters, so it would find "straße" inside "straßen" and never match a Cyrillic word.
+const wordBoundary = `[^\p{L}\p{N}_]`
+
+func compile(cfg Config) (*compiledPolicy, error) {
+	p := &compiledPolicy{maxLength: cfg.MaxLength}
+	if p.maxLength == nil {
+		p.maxLength = DefaultConfig.MaxLength
+	}
+
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s-%d", rule.Type, i)
+		}
+		switch rule.Action {
+		case ActionReject, ActionFlag, ActionMask:
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q", rule.Name, rule.Action)
+		}
+
+		compiled := compiledRule{RuleConfig: rule}
+		switch rule.Type {
+		case RuleBlocklist:
+			words := []string{}
+			for _, w := range rule.Words {
+				if w = strings.TrimSpace(w); w != "" {
+					words = append(words, w)
+				}
+			}
+			if len(words) == 0 {
+				continue
+			}
+			// Longer words first, the first alternative that matches wins and a shorter
+			// prefix would then fail the check for the end of the word
+			sort.SliceStable(words, func(i, j int) bool {
+				return utf8.RuneCountInString(words[i]) > utf8.RuneCountInString(words[j])
+			})
+			for i, w := range words {
+				words[i] = regexp.QuoteMeta(w)
+			}
+			// Go's regexp can't look ahead, so only the boundary before the word is part of
+			// the pattern and apply checks the one after it
+			compiled.re = regexp.MustCompile(`(?i)(?:^|` + wordBoundary + `)(` + strings.Join(words, "|") + `)`)
+		case RuleRegex:
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+			compiled.re = re
+		case RuleRepeatedChars, RuleLinks:
+			if rule.Threshold < 1 {
+				return nil, fmt.Errorf("rule %s: threshold must be at least 1", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule %s: unknown type %q", rule.Name, rule.Type)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+
+	return p, nil
+}
+
+func mustCompile(cfg Config) *compiledPolicy {
+	p, err := compile(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *compiledPolicy) check(kind, text string) Decision {
+	d := Decision{Text: text}
+
+	if max, ok := p.maxLength[kind]; ok && max > 0 && utf8.RuneCountInString(text) > max {
+		d.Rejected = true
+		d.Violations = append(d.Violations, Violation{
+			Rule:    "max-length",
+			Action:  ActionReject,
+			Message: fmt.Sprintf("Text can't be longer than %d characters", max),
+		})
+		return d
+	}
+
+	for _, rule := range p.rules {
+		if !rule.appliesTo(kind) {
+			continue
+		}
+
+		matched, masked := rule.apply(d.Text)
+		if !matched {
+			continue
+		}
+
+		message := rule.Message
+		if message == "" {
+			message = "Text breaks the " + rule.Name + " rule"
+		}
+		d.Violations = append(d.Violations, Violation{Rule: rule.Name, Action: rule.Action, Message: message})
+
+		switch rule.Action {
+		case ActionReject:
+			d.Rejected = true
+		case ActionFlag:
+			d.Flagged = true
+		case ActionMask:
+			d.Text = masked
+		}
+	}
+
+	return d
+}
+
+func (r compiledRule) appliesTo(kind string) bool {
+	if len(r.Kinds) == 0 {
+		return true
+	}
+	for _, k := range r.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// apply reports whether the rule matches the text, and returns the text with the matches masked
+func (r compiledRule) apply(text string) (bool, string) {
+	switch r.Type {
+	case RuleBlocklist:
+		return maskWords(r.re, text)
+
+	case RuleRegex:
+		if !r.re.MatchString(text) {
+			return false, text
+		}
+		return true, r.re.ReplaceAllStringFunc(text, stars)
+
+	case RuleLinks:
+		links := linkPattern.FindAllStringIndex(text, -1)
+		if len(links) <= r.Threshold {
+			return false, text
+		}
+		return true, linkPattern.ReplaceAllString(text, "[link removed]")
+
+	case RuleRepeatedChars:
+		return maskRepeats(text, r.Threshold)
+	}
+	return false, text
+}
+
+// maskWords masks the blocked words the pattern finds, when they end at a word boundary
+func maskWords(re *regexp.Regexp, text string) (bool, string) {
+	var b strings.Builder
+	found := false
+	last := 0
+
+	for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[2], m[3]
+		if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(next) {
+			continue
+		}
+		found = true
+		b.WriteString(text[last:start])
+		b.WriteString(stars(text[start:end]))
+		last = end
+	}
+	if !found {
+		return false, text
+	}
+
+	b.WriteString(text[last:])
+	return true, b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+// maskRepeats finds runs of the same character that are at least threshold long
+// and shortens them to three characters
+func maskRepeats(text string, threshold int) (bool, string) {
+	var b strings.Builder
+	found := false
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		run := j - i
+		if run >= threshold && runes[i] != ' ' {
+			found = true
+			run = 3
+		}
+		b.WriteString(strings.Repeat(string(runes[i]), run))
+		i = j
+	}
+
+	return found, b.String()
+}
+
+func stars(match string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(match))
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	p := mustCompile(Config{
+		MaxLength: map[string]int{KindName: 10},
+		Rules: []RuleConfig{
+			// Before the masks, which replace words with runs of stars
+			{Name: "shouting", Type: RuleRepeatedChars, Action: ActionFlag, Threshold: 5},
+			{Name: "slurs", Type: RuleBlocklist, Action: ActionReject, Words: []string{"idiot", " дурак ", ""}, Message: "Be kind"},
+			{Name: "swearing", Type: RuleBlocklist, Action: ActionMask, Words: []string{"heck", "straße", "heckin"}},
+			{Name: "phone-numbers", Type: RuleRegex, Action: ActionMask, Pattern: `\d{3}-\d{4}`, Kinds: []string{KindComment}},
+			{Name: "crypto", Type: RuleRegex, Action: ActionFlag, Pattern: `(?i)bitcoin`},
+			{Name: "links", Type: RuleLinks, Action: ActionMask, Threshold: 1},
+		},
+	})
+
+	cases := []struct {
+		name     string
+		kind     string
+		text     string
+		want     string
+		rejected bool
+		flagged  bool
+		rules    []string
+	}{
+		{"clean", KindResolution, "run a marathon", "run a marathon", false, false, []string{}},
+		{"too long", KindName, "Bartholomew the Third", "Bartholomew the Third", true, false, []string{"max-length"}},
+		{"no limit for the kind", KindComment, strings.Repeat("a b ", 300), strings.Repeat("a b ", 300), false, false, []string{}},
+		{"reject", KindResolution, "what an Idiot!", "what an Idiot!", true, false, []string{"slurs"}},
+		{"reject inside a word", KindResolution, "idiotic plan", "idiotic plan", false, false, []string{}},
+		{"reject non-ASCII word", KindResolution, "ты ДУРАК", "ты ДУРАК", true, false, []string{"slurs"}},
+		{"non-ASCII word inside a word", KindResolution, "дураки", "дураки", false, false, []string{}},
+		{"mask", KindComment, "oh heck, heck!", "oh ****, ****!", false, false, []string{"swearing"}},
+		{"mask the longer word", KindComment, "heckin good", "****** good", false, false, []string{"swearing"}},
+		{"mask next to a non-ASCII letter", KindComment, "die Straße", "die ******", false, false, []string{"swearing"}},
+		{"no mask inside a non-ASCII word", KindComment, "Straßenbahn", "Straßenbahn", false, false, []string{}},
+		{"no mask after a non-ASCII letter", KindComment, "éheck", "éheck", false, false, []string{}},
+		{"mask with a regex", KindComment, "call 555-1234", "call ********", false, false, []string{"phone-numbers"}},
+		{"rule for another kind", KindResolution, "call 555-1234", "call 555-1234", false, false, []string{}},
+		{"flag", KindResolution, "buy Bitcoin", "buy Bitcoin", false, true, []string{"crypto"}},
+		{"flag repeated characters", KindResolution, "yessssss", "yessssss", false, true, []string{"shouting"}},
+		{"spaces aren't repeats", KindResolution, "a      b", "a      b", false, false, []string{}},
+		{"mask links over the threshold", KindComment, "see https://a.example and www.b.example", "see [link removed] and [link removed]", false, false, []string{"links"}},
+		{"links under the threshold", KindComment, "see https://a.example", "see https://a.example", false, false, []string{}},
+		{"several rules", KindComment, "heck, bitcoin idiot", "****, bitcoin idiot", true, true, []string{"slurs", "swearing", "crypto"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := p.check(tc.kind, tc.text)
+			rules := []string{}
+			for _, v := range d.Violations {
+				rules = append(rules, v.Rule)
+			}
+			if d.Text != tc.want || d.Rejected != tc.rejected || d.Flagged != tc.flagged || !reflect.DeepEqual(rules, tc.rules) {
+				t.Errorf("check(%q) = %q rejected %v flagged %v by %v, want %q rejected %v flagged %v by %v",
+					tc.text, d.Text, d.Rejected, d.Flagged, rules, tc.want, tc.rejected, tc.flagged, tc.rules)
+			}
+		})
+	}
+}
+
+func TestDecision(t *testing.T) {
+	d := Decision{Violations: []Violation{
+		{Rule: "links", Action: ActionFlag, Message: "Too many links"},
+		{Rule: "slurs", Action: ActionReject, Message: "Be kind"},
+		{Rule: "crypto", Action: ActionFlag},
+	}}
+	if got := d.Reason(); got != "Be kind" {
+		t.Errorf("Reason = %q", got)
+	}
+	if got := d.FlaggedRules(); !reflect.DeepEqual(got, []string{"links", "crypto"}) {
+		t.Errorf("FlaggedRules = %v", got)
+	}
+	if got := (Decision{}).Reason(); got != "" {
+		t.Errorf("Reason without violations = %q", got)
+	}
+}
+
+func TestDefaultMessage(t *testing.T) {
+	p := mustCompile(Config{Rules: []RuleConfig{{Type: RuleRegex, Action: ActionReject, Pattern: "nope"}}})
+	if got := p.check(KindComment, "nope").Reason(); got != "Text breaks the regex-0 rule" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		rule RuleConfig
+	}{
+		{"unknown action", RuleConfig{Type: RuleRegex, Action: "delete", Pattern: "a"}},
+		{"unknown type", RuleConfig{Type: "vibes", Action: ActionFlag}},
+		{"invalid pattern", RuleConfig{Type: RuleRegex, Action: ActionFlag, Pattern: "("}},
+		{"no threshold", RuleConfig{Type: RuleLinks, Action: ActionFlag}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := compile(Config{Rules: []RuleConfig{tc.rule}}); err == nil {
+				t.Errorf("compiled %+v", tc.rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Watch loads the rules file and reloads it whenever it changes, until the context is done.
+// The file is polled so that it also works for mounted config maps and network file systems.
+func Watch(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+
+	load := func() {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("Error reading content policy %s: %v", path, err)
+			return
+		}
+		if !info.ModTime().After(lastMod) {
+			return
+		}
+		lastMod = info.ModTime()
+
+		if err := LoadFile(path); err != nil {
+			log.Printf("Error loading content policy, keeping the previous rules: %v", err)
+			return
+		}
+		log.Printf("Loaded content policy from %s", path)
+	}
+
+	load()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			load()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		current = mustCompile(DefaultConfig)
+		mu.Unlock()
+	})
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	modified := time.Now().Add(-time.Hour)
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Every write looks newer, however coarse the file system's timestamps are
+		modified = modified.Add(time.Minute)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rejects := func(text string) bool {
+		return Check(KindComment, text).Rejected
+	}
+	eventually := func(what string, ok func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if ok() {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting until %s", what)
+	}
+
+	write(`{"rules": [{"name": "first", "type": "blocklist", "action": "reject", "words": ["apple"]}]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, path, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	eventually("the file is loaded", func() bool { return rejects("apple") })
+
+	t.Run("changes are reloaded", func(t *testing.T) {
+		write(`{"rules": [{"name": "second", "type": "blocklist", "action": "reject", "words": ["banana"]}]}`)
+		eventually("the change is loaded", func() bool { return rejects("banana") })
+		if rejects("apple") {
+			t.Error("the old rules are still applied")
+		}
+	})
+
+	for _, bad := range []struct {
+		name    string
+		content string
+	}{
+		{"invalid JSON", `{"rules": [`},
+		{"invalid rule", `{"rules": [{"name": "third", "type": "regex", "action": "reject", "pattern": "("}]}`},
+	} {
+		t.Run("keeps the rules after "+bad.name, func(t *testing.T) {
+			write(bad.content)
+			// Give the watcher a few polls to pick the bad file up
+			time.Sleep(50 * time.Millisecond)
+			if !rejects("banana") {
+				t.Fatal("the previous rules were dropped")
+			}
+		})
+	}
+
+	t.Run("recovers once the file is fixed", func(t *testing.T) {
+		write(`{"rules": [{"name": "fourth", "type": "blocklist", "action": "reject", "words": ["cherry"]}]}`)
+		eventually("the fixed file is loaded", func() bool { return rejects("cherry") })
+	})
+
+	t.Run("keeps the rules when the file is removed", func(t *testing.T) {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if !rejects("cherry") {
+			t.Fatal("the rules were dropped")
+		}
+	})
+}
+
+func TestLoadFile(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		current = mustCompile(DefaultConfig)
+		mu.Unlock()
+	})
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := LoadFile(path); err == nil {
+		t.Error("loaded a missing file")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"max_length": {"comment": 5}, "rules": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if d := Check(KindComment, "too long"); !d.Rejected || d.Reason() != "Text can't be longer than 5 characters" {
+		t.Errorf("got %+v, want the new limit", d)
+	}
+	// Kinds the file leaves out have no limit
+	if d := Check(KindName, "a very long name indeed, far more than fifty characters"); d.Rejected {
+		t.Errorf("got %+v, want no limit for names", d)
+	}
+}
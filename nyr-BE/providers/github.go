@@ -0,0 +1,109 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// GitHub signs users in with GitHub OAuth apps
+type GitHub struct {
+	Config *oauth2.Config
+	APIURL string
+	Client *http.Client
+}
+
+func NewGitHub(cfg *oauth2.Config) *GitHub {
+	return &GitHub{
+		Config: cfg,
+		APIURL: githubAPIURL,
+		Client: httpClient,
+	}
+}
+
+func (g *GitHub) Name() string {
+	return "github"
+}
+
+func (g *GitHub) AuthCodeURL(state string) string {
+	return g.Config.AuthCodeURL(state)
+}
+
+func (g *GitHub) Exchange(ctx context.Context, code string) (*Identity, error) {
+	token, err := g.Config.Exchange(context.WithValue(ctx, oauth2.HTTPClient, g.Client), code)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := g.get(ctx, token, "/user", &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, ErrInvalidIdentity
+	}
+
+	// The profile email may be private, so read the primary address instead
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := g.get(ctx, token, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Provider: g.Name(),
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+		Image:    user.AvatarURL,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+			break
+		}
+	}
+
+	return identity, nil
+}
+
+// get calls the GitHub API on behalf of the user and decodes the JSON response
+func (g *GitHub) get(ctx context.Context, token *oauth2.Token, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	token.SetAuthHeader(req)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling github %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("calling github %s: unexpected status %d", path, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding github %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package providers
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"nyr/config"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+// discoveryDocument is the subset of the OpenID provider metadata we use
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// keyRefreshInterval is how often at most the signing keys are fetched again for a token
+// signed with an unknown key, so forged key IDs can't make us hammer the issuer
+const keyRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type idTokenClaims struct {
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+	Picture       string      `json:"picture"`
+	jwt.RegisteredClaims
+}
+
+// OIDC signs users in with any OpenID Connect provider. The endpoints and
+// signing keys are discovered from the issuer on first use, so the provider
+// works against a local mock issuer as well as a real one.
+type OIDC struct {
+	Settings config.OIDCSettings
+	Client   *http.Client
+
+	mu          sync.Mutex
+	oauth       *oauth2.Config
+	jwks        string
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	issuer      string
+}
+
+func NewOIDC(settings config.OIDCSettings) *OIDC {
+	return &OIDC{
+		Settings: settings,
+		Client:   httpClient,
+	}
+}
+
+func (o *OIDC) Name() string {
+	return o.Settings.Name
+}
+
+// AuthCodeURL returns an empty string if the issuer can't be discovered
+func (o *OIDC) AuthCodeURL(state string) string {
+	cfg, err := o.discover(context.Background())
+	if err != nil {
+		return ""
+	}
+	return cfg.AuthCodeURL(state)
+}
+
+func (o *OIDC) Exchange(ctx context.Context, code string) (*Identity, error) {
+	cfg, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cfg.Exchange(context.WithValue(ctx, oauth2.HTTPClient, o.Client), code)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return o.VerifyIDToken(ctx, rawIDToken)
+}
+
+// VerifyIDToken checks the signature, issuer, audience and expiry of an ID token
+func (o *OIDC) VerifyIDToken(ctx context.Context, rawIDToken string) (*Identity, error) {
+	if _, err := o.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	token, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return o.key(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Issuer != o.issuer {
+		return nil, fmt.Errorf("invalid id token: unexpected issuer %q", claims.Issuer)
+	}
+	if !claims.VerifyAudience(o.Settings.ClientID, true) {
+		return nil, errors.New("invalid id token: unexpected audience")
+	}
+	if claims.Subject == "" {
+		return nil, ErrInvalidIdentity
+	}
+
+	// Some providers send email_verified as a string
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	return &Identity{
+		Provider:      o.Name(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+		Image:         claims.Picture,
+	}, nil
+}
+
+// discover loads the provider metadata once and builds the OAuth2 config from it
+func (o *OIDC) discover(ctx context.Context) (*oauth2.Config, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.oauth != nil {
+		return o.oauth, nil
+	}
+
+	wellKnown := strings.TrimSuffix(o.Settings.Issuer, "/") + "/.well-known/openid-configuration"
+	var doc discoveryDocument
+	if err := o.getJSON(ctx, wellKnown, &doc); err != nil {
+		return nil, fmt.Errorf("discovering issuer: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(o.Settings.Issuer, "/") {
+		return nil, fmt.Errorf("discovering issuer: metadata is for issuer %q", doc.Issuer)
+	}
+
+	o.issuer = doc.Issuer
+	o.jwks = doc.JWKSURI
+	o.oauth = &oauth2.Config{
+		ClientID:     o.Settings.ClientID,
+		ClientSecret: o.Settings.ClientSecret,
+		RedirectURL:  o.Settings.RedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}
+	return o.oauth, nil
+}
+
+// key returns the signing key with the given ID, refreshing the key set when
+// the ID is unknown so that key rotation on the issuer is picked up. The key set
+// is refreshed at most once per keyRefreshInterval.
+func (o *OIDC) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(o.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("no signing key with id %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	// Failed fetches count too, an unreachable issuer isn't asked again on every login
+	o.keysFetched = time.Now()
+	if err := o.getJSON(ctx, o.jwks, &set); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+
+	o.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := parseRSAKey(k)
+		if err != nil {
+			continue
+		}
+		o.keys[k.Kid] = key
+	}
+
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no signing key with id %q", kid)
+}
+
+func (o *OIDC) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func parseRSAKey(k jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
@@ -0,0 +1,219 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"nyr/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// issuer is a local OpenID provider serving discovery and a key set that can be rotated
+type issuer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	jwksCalls atomic.Int32
+	claimed   string
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	i := &issuer{keys: map[string]*rsa.PrivateKey{}}
+	i.Server = httptest.NewServer(http.HandlerFunc(i.serve))
+	t.Cleanup(i.Close)
+	i.rotate(t, "key-1")
+	return i
+}
+
+func (i *issuer) serve(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		iss := i.URL
+		if i.claimed != "" {
+			iss = i.claimed
+		}
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                iss,
+			AuthorizationEndpoint: i.URL + "/authorize",
+			TokenEndpoint:         i.URL + "/token",
+			JWKSURI:               i.URL + "/jwks",
+		})
+	case "/jwks":
+		i.jwksCalls.Add(1)
+		keys := []jsonWebKey{}
+		for kid, key := range i.keys {
+			keys = append(keys, jsonWebKey{
+				Kid: kid,
+				Kty: "RSA",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// rotate replaces the published keys with a new one
+func (i *issuer) rotate(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys = map[string]*rsa.PrivateKey{kid: key}
+}
+
+// sign returns an ID token signed with the published key, change can adjust the claims
+func (i *issuer) sign(t *testing.T, kid string, change func(*idTokenClaims)) string {
+	t.Helper()
+	i.mu.Lock()
+	key, ok := i.keys[kid]
+	i.mu.Unlock()
+	if !ok {
+		// A key the issuer never published
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+	}
+
+	claims := &idTokenClaims{
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          "Alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.URL,
+			Subject:   "alice-sub",
+			Audience:  jwt.ClaimStrings{"client-id"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	if change != nil {
+		change(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed
+}
+
+func (i *issuer) provider() *OIDC {
+	return NewOIDC(config.OIDCSettings{
+		Name:         "mock",
+		Issuer:       i.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://app.example.com/callback",
+	})
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	i := newIssuer(t)
+	o := i.provider()
+
+	url := o.AuthCodeURL("the-state")
+	if !strings.HasPrefix(url, i.URL+"/authorize?") || !strings.Contains(url, "state=the-state") || !strings.Contains(url, "client_id=client-id") {
+		t.Errorf("got auth URL %s", url)
+	}
+
+	identity, err := o.VerifyIDToken(context.Background(), i.sign(t, "key-1", nil))
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	want := Identity{Provider: "mock", Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+	if *identity != want {
+		t.Errorf("got %+v, want %+v", *identity, want)
+	}
+
+	// Metadata that names another issuer is refused
+	other := newIssuer(t)
+	other.claimed = "https://evil.example.com"
+	if url := other.provider().AuthCodeURL("s"); url != "" {
+		t.Errorf("got auth URL %s from metadata of another issuer", url)
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	i := newIssuer(t)
+	o := i.provider()
+
+	cases := []struct {
+		name  string
+		token string
+	}{
+		{"other issuer", i.sign(t, "key-1", func(c *idTokenClaims) { c.Issuer = "https://evil.example.com" })},
+		{"other audience", i.sign(t, "key-1", func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"someone-else"} })},
+		{"no audience", i.sign(t, "key-1", func(c *idTokenClaims) { c.Audience = nil })},
+		{"expired", i.sign(t, "key-1", func(c *idTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) })},
+		{"no subject", i.sign(t, "key-1", func(c *idTokenClaims) { c.Subject = "" })},
+		{"unknown key", i.sign(t, "key-never-published", nil)},
+		{"not signed", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJhbGljZS1zdWIifQ."},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if identity, err := o.VerifyIDToken(context.Background(), tc.token); err == nil {
+				t.Errorf("accepted the token as %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	i := newIssuer(t)
+	o := i.provider()
+	ctx := context.Background()
+
+	if _, err := o.VerifyIDToken(ctx, i.sign(t, "key-1", nil)); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if got := i.jwksCalls.Load(); got != 1 {
+		t.Fatalf("fetched the keys %d times, want 1", got)
+	}
+
+	// Unknown key IDs don't refetch the keys on every token
+	for n := 0; n < 5; n++ {
+		if _, err := o.VerifyIDToken(ctx, i.sign(t, "forged", nil)); err == nil {
+			t.Fatal("accepted a token signed with an unknown key")
+		}
+	}
+	if got := i.jwksCalls.Load(); got != 1 {
+		t.Errorf("fetched the keys %d times after unknown key IDs, want 1", got)
+	}
+
+	// Once the interval passed, a token with the rotated key is verified against the new key set
+	i.rotate(t, "key-2")
+	o.mu.Lock()
+	o.keysFetched = time.Now().Add(-keyRefreshInterval)
+	o.mu.Unlock()
+	if _, err := o.VerifyIDToken(ctx, i.sign(t, "key-2", nil)); err != nil {
+		t.Fatalf("VerifyIDToken after rotation: %v", err)
+	}
+	if got := i.jwksCalls.Load(); got != 2 {
+		t.Errorf("fetched the keys %d times, want 2", got)
+	}
+	if _, err := o.VerifyIDToken(ctx, i.sign(t, "key-1", nil)); err == nil {
+		t.Error("accepted a token signed with the retired key")
+	}
+}
@@ -0,0 +1,86 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"nyr/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidIdentity = errors.New("identity provider returned an invalid identity")
+
+	mu       sync.RWMutex
+	registry = map[string]Provider{}
+
+	// httpClient is used for all outgoing calls to identity providers
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Identity is the provider independent view of a signed in user
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Image         string
+}
+
+// Provider is an identity provider that supports the authorization code flow
+type Provider interface {
+	// Name is the identifier used in routes and stored on linked identities
+	Name() string
+	// AuthCodeURL returns the URL the user is redirected to for signing in
+	AuthCodeURL(state string) string
+	// Exchange trades an authorization code for the identity of the user
+	Exchange(ctx context.Context, code string) (*Identity, error)
+}
+
+// Register adds a provider to the registry, replacing any provider with the same name
+func Register(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[p.Name()] = p
+}
+
+// Get returns the registered provider with the given name
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := registry[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Names returns the names of all registered providers in sorted order
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Initialize registers every provider that is configured in the config package.
+// config.InitializeOAuthConfig must be called first.
+func Initialize() {
+	Register(NewGoogle(config.GoogleOAuthConfig))
+
+	if config.GitHubOAuthConfig != nil {
+		Register(NewGitHub(config.GitHubOAuthConfig))
+	}
+
+	if config.OIDCConfig != nil {
+		Register(NewOIDC(*config.OIDCConfig))
+	}
+}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBus is an in-process Bus
+type MemoryBus struct {
+	bufferSize int
+
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	topics map[string]bool
+	ch     chan Event
+}
+
+// NewMemoryBus creates a bus that buffers up to bufferSize events per subscriber,
+// events for a subscriber with a full buffer are dropped
+func NewMemoryBus(bufferSize int) *MemoryBus {
+	return &MemoryBus{
+		bufferSize:  bufferSize,
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscribers {
+		if !s.topics[event.Topic] && !s.topics[TopicAll] {
+			continue
+		}
+		// Never block the publisher on a slow subscriber
+		select {
+		case s.ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(ctx context.Context, topics ...string) (<-chan Event, error) {
+	s := &subscriber{
+		topics: map[string]bool{},
+		ch:     make(chan Event, b.bufferSize),
+	}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, s)
+		close(s.ch)
+		b.mu.Unlock()
+	}()
+
+	return s.ch, nil
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// received drains the events already buffered on the channel
+func received(ch <-chan Event) []string {
+	ids := []string{}
+	for {
+		select {
+		case event := <-ch:
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func publish(t *testing.T, bus Bus, topic string) Event {
+	t.Helper()
+	event := NewEvent(topic, EventCommentCreated, primitive.NilObjectID, nil)
+	if err := bus.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func subscribe(t *testing.T, bus Bus, topics ...string) <-chan Event {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ch, err := bus.Subscribe(ctx, topics...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func TestMemoryBusTopics(t *testing.T) {
+	bus := NewMemoryBus(8)
+	feed := subscribe(t, bus, TopicFeed)
+	user := subscribe(t, bus, UserTopic(primitive.NewObjectID()), TopicFeed)
+	all := subscribe(t, bus, TopicAll)
+
+	first := publish(t, bus, TopicFeed)
+	second := publish(t, bus, ResolutionTopic(primitive.NewObjectID()))
+
+	for _, tc := range []struct {
+		name string
+		ch   <-chan Event
+		want []string
+	}{
+		{"one topic", feed, []string{first.ID}},
+		{"several topics", user, []string{first.ID}},
+		{"every topic", all, []string{first.ID, second.ID}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := received(tc.ch)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got events %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("got events %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryBusDropsWhenFull(t *testing.T) {
+	bus := NewMemoryBus(2)
+	slow := subscribe(t, bus, TopicFeed)
+	fast := subscribe(t, bus, TopicFeed)
+
+	first, second := publish(t, bus, TopicFeed), publish(t, bus, TopicFeed)
+	if got := received(fast); len(got) != 2 {
+		t.Fatalf("got %v, want both events", got)
+	}
+
+	// The slow subscriber's buffer is full, publishing neither blocks nor reaches it
+	third := NewEvent(TopicFeed, EventCommentCreated, primitive.NilObjectID, nil)
+	done := make(chan struct{})
+	go func() {
+		bus.Publish(context.Background(), third)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+
+	if got := received(slow); len(got) != 2 || got[0] != first.ID || got[1] != second.ID {
+		t.Errorf("got %v, want the first two events", got)
+	}
+	if got := received(fast); len(got) != 1 || got[0] != third.ID {
+		t.Errorf("got %v, want the third event on the subscriber that kept up", got)
+	}
+}
+
+func TestMemoryBusUnsubscribes(t *testing.T) {
+	bus := NewMemoryBus(8)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := bus.Subscribe(ctx, TopicFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, open := <-ch:
+		if open {
+			t.Fatal("got an event, want the channel closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel wasn't closed")
+	}
+
+	bus.mu.RLock()
+	subscribers := len(bus.subscribers)
+	bus.mu.RUnlock()
+	if subscribers != 0 {
+		t.Errorf("got %d subscribers, want none", subscribers)
+	}
+	// Publishing after the subscriber left doesn't send on its closed channel
+	publish(t, bus, TopicFeed)
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event types published by the handlers
+const (
+	EventResolutionCreated   = "resolution.created"
+	EventCommentCreated      = "comment.created"
+	EventLikeUpdated         = "like.updated"
+	EventReactionUpdated     = "reaction.updated"
+	EventNotificationCreated = "notification.created"
+)
+
+// Topics events are published on. Resolution and user topics are per ID, see ResolutionTopic and UserTopic.
+const (
+	TopicFeed = "feed"
+	// TopicAll is only for subscribing, it receives the events of every topic
+	TopicAll = "*"
+)
+
+// Event is a change that subscribers may want to know about
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Topic     string      `json:"topic"`
+	ActorID   string      `json:"actor_id,omitempty"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Bus delivers published events to the subscribers of their topic. The in-process
+// MemoryBus only reaches subscribers on the same instance, a bus backed by Mongo change
+// streams can implement the same interface to reach every instance.
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	// Subscribe returns a channel with the events of the topics, which is closed when ctx is done.
+	// Subscribers that fall behind may miss events.
+	Subscribe(ctx context.Context, topics ...string) (<-chan Event, error)
+}
+
+var Default Bus = NewMemoryBus(64)
+
+// ResolutionTopic carries the activity on one resolution
+func ResolutionTopic(rID primitive.ObjectID) string {
+	return "resolution:" + rID.Hex()
+}
+
+// UserTopic carries the events for one user, like their notifications
+func UserTopic(userID primitive.ObjectID) string {
+	return "user:" + userID.Hex()
+}
+
+// NewEvent returns an event for Publish, handlers that also queue webhook deliveries
+// create the event first so both carry the same ID
+func NewEvent(topic, eventType string, actorID primitive.ObjectID, data interface{}) Event {
+	event := Event{
+		ID:        primitive.NewObjectID().Hex(),
+		Type:      eventType,
+		Topic:     topic,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+	if !actorID.IsZero() {
+		event.ActorID = actorID.Hex()
+	}
+	return event
+}
+
+// Publish sends an event on the default bus. Failures are logged, publishing never fails the action.
+func Publish(topic, eventType string, actorID primitive.ObjectID, data interface{}) {
+	PublishEvent(NewEvent(topic, eventType, actorID, data))
+}
+
+// PublishEvent sends an event made by NewEvent on the default bus, see Publish
+func PublishEvent(event Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := Default.Publish(ctx, event); err != nil {
+		log.Printf("Error publishing %s event: %v", event.Type, err)
+	}
+}
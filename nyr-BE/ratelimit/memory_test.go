@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a time the tests move forward by hand
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newTestStore() (*MemoryStore, *clock) {
+	c := &clock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore(0)
+	s.now = c.Now
+	return s, c
+}
+
+func take(t *testing.T, s *MemoryStore, key string, p Policy) Result {
+	t.Helper()
+	result, err := s.Take(context.Background(), key, p)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	return result
+}
+
+func TestTakeAllowsTheLimitThenRefuses(t *testing.T) {
+	s, _ := newTestStore()
+	p := Policy{Name: "test", Limit: 3, Window: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		result := take(t, s, "alice", p)
+		if !result.Allowed || result.Remaining != 2-i || result.Limit != 3 {
+			t.Fatalf("request %d got %+v, want allowed with %d remaining", i+1, result, 2-i)
+		}
+	}
+
+	result := take(t, s, "alice", p)
+	if result.Allowed {
+		t.Fatal("request over the limit was allowed")
+	}
+	// A token comes back every 20 seconds
+	if result.RetryAfter != 20*time.Second || result.Reset != time.Minute {
+		t.Errorf("got retry after %v and reset %v, want 20s and 1m", result.RetryAfter, result.Reset)
+	}
+}
+
+func TestTakeRefills(t *testing.T) {
+	s, c := newTestStore()
+	p := Policy{Name: "test", Limit: 2, Window: time.Minute}
+	take(t, s, "alice", p)
+	take(t, s, "alice", p)
+
+	c.now = c.now.Add(29 * time.Second)
+	if take(t, s, "alice", p).Allowed {
+		t.Error("allowed before a token refilled")
+	}
+	c.now = c.now.Add(2 * time.Second)
+	if !take(t, s, "alice", p).Allowed {
+		t.Error("refused after a token refilled")
+	}
+
+	// Waiting longer than the window never fills past the limit
+	c.now = c.now.Add(time.Hour)
+	if result := take(t, s, "alice", p); result.Remaining != 1 {
+		t.Errorf("got %d remaining after a long wait, want 1", result.Remaining)
+	}
+}
+
+func TestTakeKeepsKeysApart(t *testing.T) {
+	s, _ := newTestStore()
+	p := Policy{Name: "test", Limit: 1, Window: time.Minute}
+
+	take(t, s, "alice", p)
+	if take(t, s, "alice", p).Allowed {
+		t.Error("alice was allowed over her limit")
+	}
+	if !take(t, s, "bob", p).Allowed {
+		t.Error("bob was limited by alice's requests")
+	}
+}
+
+func TestDropFullRemovesOnlyRefilledBuckets(t *testing.T) {
+	s, c := newTestStore()
+	p := Policy{Name: "test", Limit: 2, Window: time.Minute}
+	take(t, s, "alice", p)
+	c.now = c.now.Add(20 * time.Second)
+	take(t, s, "bob", p)
+
+	// alice is full again after 30s, bob after 50s
+	c.now = c.now.Add(15 * time.Second)
+	s.dropFull()
+	if _, ok := s.buckets["alice"]; ok {
+		t.Error("alice's full bucket was kept")
+	}
+	if _, ok := s.buckets["bob"]; !ok {
+		t.Error("bob's bucket was dropped before it refilled")
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	p := Policy{Name: "login", Limit: 10, Window: 15 * time.Minute}
+	if got := p.String(); got != "10;w=900" {
+		t.Errorf("got %q, want 10;w=900", got)
+	}
+}
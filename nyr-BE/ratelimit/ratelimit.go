@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Policy is a token bucket that holds up to Limit tokens and refills Limit tokens per Window
+type Policy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// String formats the policy for the RateLimit-Policy header
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Limit, int(p.Window.Seconds()))
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next token is available, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. The in-memory store only limits a single instance,
+// a shared store (e.g. Redis) can implement the same interface later.
+type Store interface {
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
@@ -0,0 +1,62 @@
+package reminders
+
+import (
+	"nyr/models"
+	"time"
+)
+
+// Location loads the timezone of a reminder, unknown or empty names fall back to UTC
+func Location(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// NextRun returns the first time after the given time that the reminder is due, at the
+// reminder's hour in its timezone. Daylight saving changes keep the local hour, an hour the
+// clocks skip becomes the first moment after it and an hour they repeat is due the first time.
+func NextRun(r models.Reminder, after time.Time) time.Time {
+	loc := Location(r.Timezone)
+	local := after.In(loc)
+	year, month, day := local.Date()
+
+	switch r.Frequency {
+	case models.ReminderWeekly:
+		days := (r.Weekday - int(local.Weekday()) + 7) % 7
+		next := at(year, month, day+days, r.Hour, loc)
+		if !next.After(after) {
+			next = at(year, month, day+days+7, r.Hour, loc)
+		}
+		return next
+
+	case models.ReminderMonthly:
+		next := at(year, month, r.DayOfMonth, r.Hour, loc)
+		if !next.After(after) {
+			next = at(year, month+1, r.DayOfMonth, r.Hour, loc)
+		}
+		return next
+
+	default:
+		next := at(year, month, day, r.Hour, loc)
+		if !next.After(after) {
+			next = at(year, month, day+1, r.Hour, loc)
+		}
+		return next
+	}
+}
+
+// at returns the start of the hour on the day in loc. time.Date moves a wall clock time the
+// clocks skip to before the change, at moves it to the first moment after it instead.
+func at(year int, month time.Month, day, hour int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, loc)
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	if want := time.Date(year, month, day, hour, 0, 0, 0, time.UTC); wall.Before(want) {
+		return t.Add(want.Sub(wall))
+	}
+	return t
+}
@@ -0,0 +1,83 @@
+package reminders
+
+import (
+	"nyr/models"
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(loc *time.Location, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, loc)
+	}
+	daily := func(hour int) models.Reminder {
+		return models.Reminder{Frequency: models.ReminderDaily, Hour: hour, Timezone: "America/New_York"}
+	}
+
+	cases := []struct {
+		name     string
+		reminder models.Reminder
+		after    time.Time
+		want     time.Time
+	}{
+		{"daily later today", daily(9), local(newYork, time.June, 10, 8, 0), local(newYork, time.June, 10, 9, 0)},
+		{"daily tomorrow", daily(9), local(newYork, time.June, 10, 9, 0), local(newYork, time.June, 11, 9, 0)},
+		{"daily across the year", daily(9), local(newYork, time.December, 31, 10, 0), time.Date(2027, time.January, 1, 9, 0, 0, 0, newYork)},
+		{"unknown timezone is UTC", models.Reminder{Frequency: models.ReminderDaily, Hour: 9, Timezone: "Mars/Olympus"},
+			local(time.UTC, time.June, 10, 10, 0), local(time.UTC, time.June, 11, 9, 0)},
+		{"weekly this week", models.Reminder{Frequency: models.ReminderWeekly, Hour: 7, Weekday: int(time.Friday), Timezone: "America/New_York"},
+			local(newYork, time.June, 10, 12, 0), local(newYork, time.June, 12, 7, 0)},
+		{"weekly same day later", models.Reminder{Frequency: models.ReminderWeekly, Hour: 7, Weekday: int(time.Wednesday), Timezone: "America/New_York"},
+			local(newYork, time.June, 10, 6, 0), local(newYork, time.June, 10, 7, 0)},
+		{"weekly next week", models.Reminder{Frequency: models.ReminderWeekly, Hour: 7, Weekday: int(time.Wednesday), Timezone: "America/New_York"},
+			local(newYork, time.June, 10, 7, 0), local(newYork, time.June, 17, 7, 0)},
+		{"monthly this month", models.Reminder{Frequency: models.ReminderMonthly, Hour: 9, DayOfMonth: 15, Timezone: "America/New_York"},
+			local(newYork, time.June, 10, 12, 0), local(newYork, time.June, 15, 9, 0)},
+		{"monthly next month", models.Reminder{Frequency: models.ReminderMonthly, Hour: 9, DayOfMonth: 1, Timezone: "America/New_York"},
+			local(newYork, time.June, 10, 12, 0), local(newYork, time.July, 1, 9, 0)},
+		{"monthly on the last allowed day in February", models.Reminder{Frequency: models.ReminderMonthly, Hour: 9, DayOfMonth: 28, Timezone: "America/New_York"},
+			local(newYork, time.January, 30, 12, 0), local(newYork, time.February, 28, 9, 0)},
+		{"monthly after the end of February", models.Reminder{Frequency: models.ReminderMonthly, Hour: 9, DayOfMonth: 28, Timezone: "America/New_York"},
+			local(newYork, time.February, 28, 9, 0), local(newYork, time.March, 28, 9, 0)},
+		{"monthly across the year", models.Reminder{Frequency: models.ReminderMonthly, Hour: 9, DayOfMonth: 28, Timezone: "America/New_York"},
+			local(newYork, time.December, 31, 9, 0), time.Date(2027, time.January, 28, 9, 0, 0, 0, newYork)},
+		// Clocks in New York go from 2:00 to 3:00 on March 8 2026
+		{"hour skipped by the clocks", daily(2), local(newYork, time.March, 7, 2, 0), local(newYork, time.March, 8, 3, 0)},
+		{"day after the skipped hour", daily(2), local(newYork, time.March, 8, 3, 0), local(newYork, time.March, 9, 2, 0)},
+		{"same local hour after the change", daily(9), local(newYork, time.March, 7, 9, 0), local(newYork, time.March, 8, 9, 0)},
+		// and from 2:00 back to 1:00 on November 1 2026, so 1:00 happens twice
+		{"repeated hour is due the first time", daily(1), local(newYork, time.October, 31, 1, 0), time.Date(2026, time.November, 1, 5, 0, 0, 0, time.UTC)},
+		{"repeated hour isn't due again", daily(1), time.Date(2026, time.November, 1, 5, 0, 0, 0, time.UTC), local(newYork, time.November, 2, 1, 0)},
+		{"same local hour after falling back", daily(9), local(newYork, time.October, 31, 9, 0), local(newYork, time.November, 1, 9, 0)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := NextRun(tc.reminder, tc.after); !got.Equal(tc.want) {
+				t.Errorf("NextRun after %s = %s, want %s", tc.after, got.In(tc.want.Location()), tc.want)
+			}
+		})
+	}
+
+	t.Run("timezone change", func(t *testing.T) {
+		// UpdateTimezone reschedules from now with the reminder in the new timezone
+		reminder := daily(9)
+		now := local(newYork, time.June, 10, 12, 0)
+		if got := NextRun(reminder, now); !got.Equal(local(newYork, time.June, 11, 9, 0)) {
+			t.Fatalf("got %s before the change", got)
+		}
+		reminder.Timezone = "Asia/Tokyo"
+		// Noon in New York is 1:00 the next day in Tokyo
+		if got, want := NextRun(reminder, now), local(tokyo, time.June, 11, 9, 0); !got.Equal(want) {
+			t.Errorf("got %s, want %s", got.In(tokyo), want)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"log"
+	"nyr/emails"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/repository"
+	"time"
+)
+
+// Run sends due reminders, checking every interval until ctx is done
+func Run(ctx context.Context, repos repository.Repositories, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sendDue(ctx, repos)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDue claims due reminders one at a time. Claiming moves the reminder to its following
+// run, so when several instances run only one of them gets each reminder.
+func sendDue(ctx context.Context, repos repository.Repositories) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		reminder, err := repos.Reminders.Due(ctx, now)
+		if errors.Is(err, repository.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error finding due reminders: %v", err)
+			return
+		}
+
+		claimed, err := repos.Reminders.Advance(ctx, reminder, NextRun(reminder, now), now)
+		if err != nil {
+			log.Printf("Error claiming reminder: %v", err)
+			return
+		}
+		if !claimed {
+			continue
+		}
+
+		deliver(ctx, repos, reminder)
+	}
+}
+
+// deliver sends the reminder in-app and by email. Reminders of resolutions that no longer
+// exist are removed. Hidden resolutions and users who can't sign in are skipped, the
+// reminder comes back if moderators restore them.
+func deliver(ctx context.Context, repos repository.Repositories, reminder models.Reminder) {
+	resolution, err := repos.Resolutions.Find(ctx, reminder.RID)
+	if errors.Is(err, repository.ErrNotFound) {
+		if _, err := repos.Reminders.Delete(ctx, reminder.UserID, reminder.RID); err != nil {
+			log.Printf("Error removing reminder: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading reminder resolution: %v", err)
+		return
+	}
+	if resolution.Hidden {
+		return
+	}
+
+	user, err := repos.Users.FindByID(ctx, reminder.UserID)
+	if err != nil {
+		log.Printf("Error loading reminder user: %v", err)
+		return
+	}
+	if user.EffectiveStatus(time.Now()) != models.UserStatusActive {
+		return
+	}
+
+	notifications.Notify(notifications.Event{
+		Type:         models.NotificationTypeReminder,
+		RecipientID:  user.ID,
+		ResolutionID: &resolution.RID,
+	})
+	emails.Reminder(user, resolution)
+}
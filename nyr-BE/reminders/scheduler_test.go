@@ -0,0 +1,79 @@
+package reminders
+
+import (
+	"context"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/repository"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSendDue(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	notifications.SetRepositories(repos)
+
+	// Reminder emails are off, there is no mailer to send them
+	alice := models.User{ID: primitive.NewObjectID(), Name: "Alice", EmailPreferences: map[string]bool{models.EmailCategoryReminders: false}}
+	if err := repos.Users.Insert(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	resolution := func(hidden bool) primitive.ObjectID {
+		t.Helper()
+		r := models.Resolution{RID: primitive.NewObjectID(), UserID: alice.ID, Resolution: "run a marathon", Hidden: hidden}
+		if err := repos.Resolutions.Insert(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		return r.RID
+	}
+	due := time.Now().Add(-time.Minute)
+	reminder := func(rID primitive.ObjectID) {
+		t.Helper()
+		_, err := repos.Reminders.Set(ctx, models.Reminder{UserID: alice.ID, RID: rID, Frequency: models.ReminderDaily, Hour: 9, Timezone: "UTC", NextRunAt: due})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	visible, hidden, removed := resolution(false), resolution(true), primitive.NewObjectID()
+	for _, rID := range []primitive.ObjectID{visible, hidden, removed} {
+		reminder(rID)
+	}
+
+	sendDue(ctx, repos)
+
+	list, err := repos.Reminders.ListByUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byResolution := map[primitive.ObjectID]models.Reminder{}
+	for _, r := range list {
+		byResolution[r.RID] = r
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %+v, want the reminder of the removed resolution deleted", list)
+	}
+	for _, rID := range []primitive.ObjectID{visible, hidden} {
+		r, ok := byResolution[rID]
+		if !ok || !r.NextRunAt.After(time.Now()) || r.LastSentAt == nil {
+			t.Errorf("got %+v, want it kept and moved to its next run", r)
+		}
+	}
+
+	got, err := repos.Notifications.List(ctx, repository.NotificationQuery{UserID: alice.ID, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Type != models.NotificationTypeReminder || got[0].ResolutionID == nil || *got[0].ResolutionID != visible {
+		t.Errorf("got %+v, want one reminder of the visible resolution", got)
+	}
+
+	// Nothing is due any more
+	sendDue(ctx, repos)
+	if again, _ := repos.Notifications.List(ctx, repository.NotificationQuery{UserID: alice.ID, Limit: 10}); len(again) != 1 || !again[0].UpdatedAt.Equal(got[0].UpdatedAt) {
+		t.Errorf("got %+v after sending again", again)
+	}
+}
@@ -0,0 +1,1672 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"nyr/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testContract runs the behavior every implementation must share against fresh
+// repositories from newRepos
+func testContract(t *testing.T, newRepos func(t *testing.T) Repositories) {
+	t.Run("users", func(t *testing.T) { testUsers(t, newRepos(t)) })
+	t.Run("resolutions", func(t *testing.T) { testResolutions(t, newRepos(t)) })
+	t.Run("feed", func(t *testing.T) { testFeed(t, newRepos(t)) })
+	t.Run("digests", func(t *testing.T) { testDigests(t, newRepos(t)) })
+	t.Run("detail", func(t *testing.T) { testDetail(t, newRepos(t)) })
+	t.Run("comments", func(t *testing.T) { testComments(t, newRepos(t)) })
+	t.Run("reactions", func(t *testing.T) { testReactions(t, newRepos(t)) })
+	t.Run("blocks", func(t *testing.T) { testBlocks(t, newRepos(t)) })
+	t.Run("bookmarks", func(t *testing.T) { testBookmarks(t, newRepos(t)) })
+	t.Run("adoptions", func(t *testing.T) { testAdoptions(t, newRepos(t)) })
+	t.Run("webhooks", func(t *testing.T) { testWebhooks(t, newRepos(t)) })
+	t.Run("deliveries", func(t *testing.T) { testDeliveries(t, newRepos(t)) })
+	t.Run("roles", func(t *testing.T) { testRoles(t, newRepos(t)) })
+	t.Run("hiding", func(t *testing.T) { testHiding(t, newRepos(t)) })
+	t.Run("reminders", func(t *testing.T) { testReminders(t, newRepos(t)) })
+	t.Run("tokens", func(t *testing.T) { testTokens(t, newRepos(t)) })
+	t.Run("follows", func(t *testing.T) { testFollows(t, newRepos(t)) })
+	t.Run("notifications", func(t *testing.T) { testNotifications(t, newRepos(t)) })
+	t.Run("reports", func(t *testing.T) { testReports(t, newRepos(t)) })
+	t.Run("audit logs", func(t *testing.T) { testAuditLogs(t, newRepos(t)) })
+	t.Run("magic links", func(t *testing.T) { testMagicLinks(t, newRepos(t)) })
+}
+
+// base is a fixed time the fixtures are created relative to, Mongo keeps milliseconds
+var base = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func seedUser(t *testing.T, repos Repositories, name string) models.User {
+	t.Helper()
+	user := models.User{ID: primitive.NewObjectID(), Name: name, Email: name + "@example.com", Image: name + ".png", CreatedAt: base}
+	if err := repos.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	return user
+}
+
+func seedResolution(t *testing.T, repos Repositories, owner models.User, text string, created time.Time, hidden bool) models.Resolution {
+	t.Helper()
+	resolution := models.Resolution{
+		RID:        primitive.NewObjectID(),
+		UserID:     owner.ID,
+		Resolution: text,
+		Tags:       []string{"health"},
+		Hidden:     hidden,
+		CreatedAt:  created,
+		UpdatedAt:  created,
+	}
+	if err := repos.Resolutions.Insert(context.Background(), resolution); err != nil {
+		t.Fatalf("inserting resolution: %v", err)
+	}
+	return resolution
+}
+
+func seedComment(t *testing.T, repos Repositories, author models.User, rID primitive.ObjectID, text string, created time.Time, hidden bool) models.Comments {
+	t.Helper()
+	comment := models.Comments{
+		ID:        primitive.NewObjectID(),
+		UserID:    author.ID,
+		RID:       rID,
+		Comment:   text,
+		Hidden:    hidden,
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+	if err := repos.Comments.Insert(context.Background(), comment); err != nil {
+		t.Fatalf("inserting comment: %v", err)
+	}
+	return comment
+}
+
+func seedReaction(t *testing.T, repos Repositories, user models.User, targetType string, targetID primitive.ObjectID, reactionType string) string {
+	t.Helper()
+	reaction := models.Reaction{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Type:       reactionType,
+		CreatedAt:  base,
+		UpdatedAt:  base,
+	}
+	previous, err := repos.Reactions.Set(context.Background(), reaction)
+	if err != nil {
+		t.Fatalf("setting reaction: %v", err)
+	}
+	return previous
+}
+
+func seedLike(t *testing.T, repos Repositories, user models.User, rID primitive.ObjectID) {
+	t.Helper()
+	seedReaction(t, repos, user, models.ReactionTargetResolution, rID, models.ReactionLike)
+}
+
+func summaryIDs(summaries []Summary) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, s := range summaries {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, got, want []primitive.ObjectID) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d results %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("result %d is %s, want %s", i, got[i].Hex(), want[i].Hex())
+		}
+	}
+}
+
+func testUsers(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+
+	found, err := repos.Users.FindByID(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if found.Name != "alice" || found.Email != "alice@example.com" {
+		t.Errorf("FindByID returned %+v", found)
+	}
+
+	if _, err := repos.Users.FindByID(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByID of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	// Emails are unique, users without one don't collide
+	if err := repos.Users.Insert(ctx, models.User{ID: primitive.NewObjectID(), Name: "copy", Email: alice.Email, CreatedAt: base}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Insert of a taken email returned %v, want ErrDuplicate", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := repos.Users.Insert(ctx, models.User{ID: primitive.NewObjectID(), Name: "no email", CreatedAt: base}); err != nil {
+			t.Errorf("Insert without an email: %v", err)
+		}
+	}
+
+	before, err := repos.Users.UpdateName(ctx, alice.ID, "Alice B")
+	if err != nil {
+		t.Fatalf("UpdateName: %v", err)
+	}
+	if before.Name != "alice" {
+		t.Errorf("UpdateName returned name %q, want the previous name", before.Name)
+	}
+	found, _ = repos.Users.FindByID(ctx, alice.ID)
+	if found.Name != "Alice B" {
+		t.Errorf("name after UpdateName is %q", found.Name)
+	}
+
+	if _, err := repos.Users.UpdateName(ctx, primitive.NewObjectID(), "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateName of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	// Handles are unique and users are found by them
+	bob := seedUser(t, repos, "bob")
+	seedUser(t, repos, "carol")
+	if _, err := repos.Users.UpdateHandle(ctx, alice.ID, "alice"); err != nil {
+		t.Fatalf("UpdateHandle: %v", err)
+	}
+	if _, err := repos.Users.UpdateHandle(ctx, bob.ID, "alice"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("UpdateHandle to a taken handle returned %v, want ErrDuplicate", err)
+	}
+	before, err = repos.Users.UpdateHandle(ctx, alice.ID, "alice_b")
+	if err != nil || before.Handle != "alice" {
+		t.Errorf("UpdateHandle returned %+v, %v, want the previous handle", before, err)
+	}
+	if _, err := repos.Users.UpdateHandle(ctx, primitive.NewObjectID(), "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateHandle of a missing user returned %v, want ErrNotFound", err)
+	}
+	byHandle, err := repos.Users.FindByHandles(ctx, []string{"alice_b", "alice", "unknown"})
+	if err != nil {
+		t.Fatalf("FindByHandles: %v", err)
+	}
+	if len(byHandle) != 1 || byHandle[0].ID != alice.ID {
+		t.Errorf("FindByHandles returned %+v, want alice", byHandle)
+	}
+	if byHandle, _ := repos.Users.FindByHandles(ctx, nil); len(byHandle) != 0 {
+		t.Errorf("FindByHandles without handles returned %+v", byHandle)
+	}
+
+	// Identities are linked by email, whatever its case, and found again by provider and subject
+	identity := models.Identity{Provider: "google", Subject: "123", Email: "alice@example.com", LinkedAt: base}
+	if _, err := repos.Users.FindByIdentity(ctx, "google", "123"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByIdentity before linking returned %v, want ErrNotFound", err)
+	}
+	linked, err := repos.Users.LinkIdentityByEmail(ctx, " Alice@Example.COM", identity)
+	if err != nil {
+		t.Fatalf("LinkIdentityByEmail: %v", err)
+	}
+	if linked.ID != alice.ID || len(linked.Identities) != 1 || linked.Identities[0].Subject != "123" {
+		t.Errorf("LinkIdentityByEmail returned %+v", linked)
+	}
+	found, err = repos.Users.FindByIdentity(ctx, "google", "123")
+	if err != nil || found.ID != alice.ID {
+		t.Errorf("FindByIdentity returned %+v, %v, want alice", found, err)
+	}
+	if _, err := repos.Users.FindByIdentity(ctx, "github", "123"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByIdentity of another provider returned %v, want ErrNotFound", err)
+	}
+	if _, err := repos.Users.LinkIdentityByEmail(ctx, "nobody@example.com", identity); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LinkIdentityByEmail of an unknown email returned %v, want ErrNotFound", err)
+	}
+
+	// An address that only an unverified identity vouches for isn't linked, a verified one is
+	dave := models.User{ID: primitive.NewObjectID(), Name: "dave", Email: "dave@example.com", CreatedAt: base,
+		Identities: []models.Identity{{Provider: "github", Subject: "d1", Email: "dave@example.com", LinkedAt: base}}}
+	if err := repos.Users.Insert(ctx, dave); err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	daveGoogle := models.Identity{Provider: "google", Subject: "d2", Email: "dave@example.com", EmailVerified: true, LinkedAt: base}
+	if _, err := repos.Users.LinkIdentityByEmail(ctx, "dave@example.com", daveGoogle); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LinkIdentityByEmail of an unverified address returned %v, want ErrNotFound", err)
+	}
+	if err := repos.Users.AddIdentity(ctx, dave.ID, models.Identity{Provider: "github", Subject: "d3", Email: "dave@example.com", EmailVerified: true, LinkedAt: base}); err != nil {
+		t.Fatalf("AddIdentity: %v", err)
+	}
+	if linked, err := repos.Users.LinkIdentityByEmail(ctx, "dave@example.com", daveGoogle); err != nil || linked.ID != dave.ID {
+		t.Errorf("LinkIdentityByEmail of a verified address returned %+v, %v, want dave", linked, err)
+	}
+
+	// Identities of one provider are removed by subject, the last one stays
+	if removed, err := repos.Users.RemoveIdentity(ctx, alice.ID, "google", "123"); err != nil || removed {
+		t.Errorf("RemoveIdentity of the only identity returned %v, %v", removed, err)
+	}
+	second := models.Identity{Provider: "google", Subject: "456", Email: "alice@work.example.com", LinkedAt: base}
+	if err := repos.Users.AddIdentity(ctx, alice.ID, second); err != nil {
+		t.Fatalf("AddIdentity: %v", err)
+	}
+	if err := repos.Users.AddIdentity(ctx, primitive.NewObjectID(), second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddIdentity to a missing user returned %v, want ErrNotFound", err)
+	}
+	if err := repos.Users.AddSoleIdentity(ctx, alice.ID, models.Identity{Provider: "google", Subject: "789"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("AddSoleIdentity at a linked provider returned %v, want ErrDuplicate", err)
+	}
+	if err := repos.Users.AddSoleIdentity(ctx, primitive.NewObjectID(), models.Identity{Provider: "slack", Subject: "T1:U1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddSoleIdentity to a missing user returned %v, want ErrNotFound", err)
+	}
+	if err := repos.Users.AddSoleIdentity(ctx, bob.ID, models.Identity{Provider: "slack", Subject: "T1:U1"}); err != nil {
+		t.Errorf("AddSoleIdentity: %v", err)
+	}
+	if found, err := repos.Users.FindByIdentity(ctx, "slack", "T1:U1"); err != nil || found.ID != bob.ID {
+		t.Errorf("FindByIdentity after AddSoleIdentity returned %+v, %v, want bob", found, err)
+	}
+
+	// An identity links to one user only
+	erin := seedUser(t, repos, "erin")
+	if err := repos.Users.AddSoleIdentity(ctx, erin.ID, models.Identity{Provider: "slack", Subject: "T1:U1"}); !errors.Is(err, ErrIdentityLinked) {
+		t.Errorf("AddSoleIdentity of Bob's identity returned %v, want ErrIdentityLinked", err)
+	}
+	if err := repos.Users.AddIdentity(ctx, erin.ID, models.Identity{Provider: "google", Subject: "456"}); !errors.Is(err, ErrIdentityLinked) {
+		t.Errorf("AddIdentity of Alice's identity returned %v, want ErrIdentityLinked", err)
+	}
+	if removed, err := repos.Users.RemoveIdentity(ctx, alice.ID, "google", "789"); err != nil || removed {
+		t.Errorf("RemoveIdentity of an unlinked subject returned %v, %v", removed, err)
+	}
+	if removed, err := repos.Users.RemoveIdentity(ctx, alice.ID, "google", "123"); err != nil || !removed {
+		t.Fatalf("RemoveIdentity returned %v, %v", removed, err)
+	}
+	found, _ = repos.Users.FindByID(ctx, alice.ID)
+	if len(found.Identities) != 1 || found.Identities[0].Subject != "456" {
+		t.Errorf("identities after RemoveIdentity are %+v, want the other google account", found.Identities)
+	}
+
+	// Email preferences are changed one category at a time
+	repos.Users.UpdateEmailPreferences(ctx, alice.ID, map[string]bool{models.EmailCategoryDigest: true})
+	updated, err := repos.Users.UpdateEmailPreferences(ctx, alice.ID, map[string]bool{models.EmailCategoryComments: false})
+	if err != nil {
+		t.Fatalf("UpdateEmailPreferences: %v", err)
+	}
+	if len(updated.EmailPreferences) != 2 || !updated.EmailPreferences[models.EmailCategoryDigest] || updated.EmailPreferences[models.EmailCategoryComments] {
+		t.Errorf("UpdateEmailPreferences returned %v", updated.EmailPreferences)
+	}
+	if _, err := repos.Users.UpdateEmailPreferences(ctx, primitive.NewObjectID(), map[string]bool{models.EmailCategoryDigest: true}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateEmailPreferences of an unknown user returned %v, want ErrNotFound", err)
+	}
+
+	// And so are notification preferences
+	repos.Users.UpdateNotificationPreferences(ctx, alice.ID, map[string]bool{models.NotificationTypeLike: false})
+	updated, err = repos.Users.UpdateNotificationPreferences(ctx, alice.ID, map[string]bool{models.NotificationTypeFollow: false})
+	if err != nil {
+		t.Fatalf("UpdateNotificationPreferences: %v", err)
+	}
+	if len(updated.NotificationPreferences) != 2 || updated.NotificationPreferences[models.NotificationTypeLike] || updated.NotificationPreferences[models.NotificationTypeFollow] {
+		t.Errorf("UpdateNotificationPreferences returned %v", updated.NotificationPreferences)
+	}
+	if _, err := repos.Users.UpdateNotificationPreferences(ctx, primitive.NewObjectID(), map[string]bool{models.NotificationTypeLike: true}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateNotificationPreferences of an unknown user returned %v, want ErrNotFound", err)
+	}
+
+	if err := repos.Users.UpdateTimezone(ctx, alice.ID, "Europe/Berlin"); err != nil {
+		t.Fatalf("UpdateTimezone: %v", err)
+	}
+	if found, _ := repos.Users.FindByID(ctx, alice.ID); found.Timezone != "Europe/Berlin" {
+		t.Errorf("timezone after UpdateTimezone is %q", found.Timezone)
+	}
+	if err := repos.Users.UpdateTimezone(ctx, primitive.NewObjectID(), "Europe/Berlin"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateTimezone of an unknown user returned %v, want ErrNotFound", err)
+	}
+}
+
+func testResolutions(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+
+	first := seedResolution(t, repos, alice, "run a marathon", base, false)
+	hidden := seedResolution(t, repos, alice, "hidden", base.Add(time.Minute), true)
+	second := seedResolution(t, repos, alice, "read more", base.Add(2*time.Minute), false)
+	seedResolution(t, repos, bob, "learn go", base, false)
+	seedLike(t, repos, bob, first.RID)
+	seedComment(t, repos, bob, first.RID, "good luck", base, false)
+	seedComment(t, repos, bob, first.RID, "spam", base, true)
+
+	found, err := repos.Resolutions.FindVisible(ctx, first.RID)
+	if err != nil {
+		t.Fatalf("FindVisible: %v", err)
+	}
+	if found.Resolution != "run a marathon" || found.UserID != alice.ID {
+		t.Errorf("FindVisible returned %+v", found)
+	}
+	if _, err := repos.Resolutions.FindVisible(ctx, hidden.RID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindVisible of a hidden resolution returned %v, want ErrNotFound", err)
+	}
+	if _, err := repos.Resolutions.FindVisible(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindVisible of a missing resolution returned %v, want ErrNotFound", err)
+	}
+
+	// The owner's list has hidden resolutions too, oldest first
+	mine, err := repos.Resolutions.ListByUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("ListByUser: %v", err)
+	}
+	assertIDs(t, summaryIDs(mine), []primitive.ObjectID{first.RID, hidden.RID, second.RID})
+	if !mine[1].Hidden {
+		t.Error("ListByUser doesn't mark the hidden resolution")
+	}
+	if mine[0].LikeCount != 1 || mine[0].CommentCount != 1 {
+		t.Errorf("ListByUser counts are %d likes and %d comments, want 1 and 1", mine[0].LikeCount, mine[0].CommentCount)
+	}
+}
+
+func testFeed(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+
+	old := seedResolution(t, repos, alice, "old", base, false)
+	popular := seedResolution(t, repos, bob, "popular", base.Add(time.Minute), false)
+	fresh := seedResolution(t, repos, carol, "fresh", base.Add(2*time.Minute), false)
+	seedResolution(t, repos, alice, "hidden", base.Add(3*time.Minute), true)
+
+	seedLike(t, repos, alice, popular.RID)
+	seedLike(t, repos, carol, popular.RID)
+	seedLike(t, repos, bob, old.RID)
+	seedReaction(t, repos, alice, models.ReactionTargetResolution, fresh.RID, models.ReactionCheer)
+	seedComment(t, repos, alice, popular.RID, "go bob", base, false)
+	seedComment(t, repos, carol, popular.RID, "hidden comment", base, true)
+
+	byLikes, err := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortLikes, Limit: 10})
+	if err != nil {
+		t.Fatalf("Feed: %v", err)
+	}
+	assertIDs(t, summaryIDs(byLikes), []primitive.ObjectID{popular.RID, old.RID, fresh.RID})
+	if byLikes[0].LikeCount != 2 || byLikes[0].CommentCount != 1 {
+		t.Errorf("popular has %d likes and %d comments, want 2 and 1", byLikes[0].LikeCount, byLikes[0].CommentCount)
+	}
+	if byLikes[0].UserName != "bob" {
+		t.Errorf("popular has user name %q, want bob", byLikes[0].UserName)
+	}
+	if counts := byLikes[2].ReactionCounts; len(counts) != 1 || counts[models.ReactionCheer] != 1 || byLikes[2].LikeCount != 0 {
+		t.Errorf("fresh has reaction counts %v and %d likes, want one cheer", counts, byLikes[2].LikeCount)
+	}
+
+	newest, err := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortNewest, Limit: 10})
+	if err != nil {
+		t.Fatalf("Feed: %v", err)
+	}
+	assertIDs(t, summaryIDs(newest), []primitive.ObjectID{fresh.RID, popular.RID, old.RID})
+
+	// Pages follow on from each other
+	page, _ := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortNewest, Skip: 1, Limit: 1})
+	assertIDs(t, summaryIDs(page), []primitive.ObjectID{popular.RID})
+	page, _ = repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortNewest, Skip: 3, Limit: 1})
+	assertIDs(t, summaryIDs(page), []primitive.ObjectID{})
+
+	// Hidden authors are left out
+	filtered, _ := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortNewest, Limit: 10, Viewer: Viewer{Hidden: []primitive.ObjectID{bob.ID}}})
+	assertIDs(t, summaryIDs(filtered), []primitive.ObjectID{fresh.RID, old.RID})
+
+	// Only the authors' resolutions since the time, and none without authors
+	followed, _ := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortLikes, Limit: 10, Authors: []primitive.ObjectID{alice.ID, carol.ID}, Since: base.Add(time.Minute)})
+	assertIDs(t, summaryIDs(followed), []primitive.ObjectID{fresh.RID})
+	nobody, _ := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortLikes, Limit: 10, Authors: []primitive.ObjectID{}})
+	assertIDs(t, summaryIDs(nobody), []primitive.ObjectID{})
+}
+
+func testDigests(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+	week := 7 * 24 * time.Hour
+
+	for _, user := range []models.User{alice, carol} {
+		if _, err := repos.Users.UpdateEmailPreferences(ctx, user.ID, map[string]bool{models.EmailCategoryDigest: true}); err != nil {
+			t.Fatalf("UpdateEmailPreferences: %v", err)
+		}
+	}
+	if _, err := repos.Users.UpdateStatus(ctx, carol.ID, StatusUpdate{Status: models.UserStatusBanned, At: base}); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	claimed, err := repos.Users.ClaimDigest(ctx, base, week)
+	if err != nil || claimed.ID != alice.ID || claimed.LastDigestAt == nil || !claimed.LastDigestAt.Equal(base) {
+		t.Fatalf("ClaimDigest returned %+v, %v, want Alice with her digest recorded", claimed, err)
+	}
+	if _, err := repos.Users.ClaimDigest(ctx, base.Add(week-time.Minute), week); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ClaimDigest within the week returned %v, want ErrNotFound", err)
+	}
+	if claimed, err := repos.Users.ClaimDigest(ctx, base.Add(week), week); err != nil || claimed.ID != alice.ID {
+		t.Errorf("ClaimDigest a week later returned %+v, %v, want Alice again", claimed, err)
+	}
+
+	// Suspended users get a digest again once the suspension runs out
+	later := base.Add(week)
+	dave := seedUser(t, repos, "dave")
+	erin := seedUser(t, repos, "erin")
+	for user, until := range map[primitive.ObjectID]time.Time{dave.ID: later.Add(24 * time.Hour), erin.ID: later} {
+		if _, err := repos.Users.UpdateEmailPreferences(ctx, user, map[string]bool{models.EmailCategoryDigest: true}); err != nil {
+			t.Fatalf("UpdateEmailPreferences: %v", err)
+		}
+		if _, err := repos.Users.UpdateStatus(ctx, user, StatusUpdate{Status: models.UserStatusSuspended, SuspendedUntil: &until, At: base}); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+	}
+	if claimed, err := repos.Users.ClaimDigest(ctx, later, week); err != nil || claimed.ID != erin.ID {
+		t.Errorf("ClaimDigest after Erin's suspension returned %+v, %v, want Erin", claimed, err)
+	}
+	if claimed, err := repos.Users.ClaimDigest(ctx, later, week); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ClaimDigest during Dave's suspension returned %+v, %v, want ErrNotFound", claimed, err)
+	}
+	if claimed, err := repos.Users.ClaimDigest(ctx, later.Add(48*time.Hour), week); err != nil || claimed.ID != dave.ID {
+		t.Errorf("ClaimDigest after Dave's suspension returned %+v, %v, want Dave", claimed, err)
+	}
+
+	since := base.Add(time.Hour)
+	first := seedResolution(t, repos, alice, "first", base, false)
+	second := seedResolution(t, repos, alice, "second", base.Add(time.Minute), false)
+	seedResolution(t, repos, alice, "hidden", base.Add(2*time.Minute), true)
+	seedResolution(t, repos, bob, "not hers", base, false)
+
+	like := func(user models.User, at time.Time) {
+		t.Helper()
+		if _, err := repos.Reactions.Set(ctx, models.Reaction{
+			UserID: user.ID, TargetType: models.ReactionTargetResolution, TargetID: first.RID, Type: models.ReactionLike, CreatedAt: at, UpdatedAt: at,
+		}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	like(bob, since)
+	like(alice, since)
+	like(carol, base)
+	seedComment(t, repos, bob, first.RID, "go alice", since, false)
+	seedComment(t, repos, carol, first.RID, "hidden", since, true)
+	seedComment(t, repos, bob, first.RID, "before the week", base, false)
+	seedComment(t, repos, alice, first.RID, "checking in", since, false)
+
+	activity, err := repos.Resolutions.Activity(ctx, alice.ID, since)
+	if err != nil {
+		t.Fatalf("Activity: %v", err)
+	}
+	want := []Activity{
+		{ID: first.RID, Resolution: "first", Likes: 1, Comments: 1, CheckIns: 1},
+		{ID: second.RID, Resolution: "second"},
+	}
+	if len(activity) != len(want) {
+		t.Fatalf("got %+v, want %+v", activity, want)
+	}
+	for i := range want {
+		if activity[i] != want[i] {
+			t.Errorf("activity %d is %+v, want %+v", i, activity[i], want[i])
+		}
+	}
+}
+
+func testDetail(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+
+	resolution := seedResolution(t, repos, alice, "run a marathon", base, false)
+	hidden := seedResolution(t, repos, alice, "hidden", base, true)
+	older := seedComment(t, repos, bob, resolution.RID, "first", base, false)
+	newer := seedComment(t, repos, carol, resolution.RID, "second", base.Add(time.Minute), false)
+	seedComment(t, repos, carol, resolution.RID, "hidden", base.Add(2*time.Minute), true)
+	seedLike(t, repos, bob, resolution.RID)
+	seedReaction(t, repos, carol, models.ReactionTargetResolution, resolution.RID, models.ReactionYouGotThis)
+
+	detail, err := repos.Resolutions.Detail(ctx, resolution.RID, DetailQuery{})
+	if err != nil {
+		t.Fatalf("Detail: %v", err)
+	}
+	if detail.Resolution != "run a marathon" || detail.LikeCount != 1 || detail.CommentCount != 2 {
+		t.Errorf("Detail returned %+v", detail)
+	}
+	if counts := detail.ReactionCounts; len(counts) != 2 || counts[models.ReactionLike] != 1 || counts[models.ReactionYouGotThis] != 1 {
+		t.Errorf("Detail has reaction counts %v, want a like and a you_got_this", counts)
+	}
+	if detail.UserDetail == nil || detail.UserDetail.ID != alice.ID || detail.UserDetail.Name != "alice" || detail.UserDetail.Image != "alice.png" {
+		t.Errorf("Detail has author %+v, want alice", detail.UserDetail)
+	}
+	if len(detail.Comments) != 2 || detail.Comments[0].ID != newer.ID || detail.Comments[1].ID != older.ID {
+		t.Fatalf("Detail has comments %+v, want the newest first", detail.Comments)
+	}
+	if author := detail.Comments[1].UserDetail; author == nil || author.ID != bob.ID || author.Name != "bob" {
+		t.Errorf("comment has author %+v, want bob", author)
+	}
+
+	// Comments by hidden authors are left out
+	detail, err = repos.Resolutions.Detail(ctx, resolution.RID, DetailQuery{Viewer: Viewer{Hidden: []primitive.ObjectID{carol.ID}}})
+	if err != nil {
+		t.Fatalf("Detail: %v", err)
+	}
+	if len(detail.Comments) != 1 || detail.Comments[0].ID != older.ID || detail.CommentCount != 1 {
+		t.Errorf("Detail has comments %+v, want only bob's", detail.Comments)
+	}
+
+	// Comments carry their own reactions, and the top sort puts the most reacted to first
+	seedReaction(t, repos, alice, models.ReactionTargetComment, older.ID, models.ReactionLike)
+	seedReaction(t, repos, carol, models.ReactionTargetComment, older.ID, models.ReactionCheer)
+	detail, err = repos.Resolutions.Detail(ctx, resolution.RID, DetailQuery{CommentSort: CommentSortTop})
+	if err != nil {
+		t.Fatalf("Detail: %v", err)
+	}
+	if len(detail.Comments) != 2 || detail.Comments[0].ID != older.ID || detail.Comments[1].ID != newer.ID {
+		t.Fatalf("Detail has comments %+v, want the most reacted to first", detail.Comments)
+	}
+	if counts := detail.Comments[0].ReactionCounts; len(counts) != 2 || counts[models.ReactionCheer] != 1 || detail.Comments[0].LikeCount != 1 {
+		t.Errorf("comment has reaction counts %v and %d likes, want a like and a cheer", counts, detail.Comments[0].LikeCount)
+	}
+	if counts := detail.Comments[1].ReactionCounts; len(counts) != 0 || detail.Comments[1].LikeCount != 0 {
+		t.Errorf("comment without reactions has counts %v", counts)
+	}
+	if detail.LikeCount != 1 || len(detail.ReactionCounts) != 2 {
+		t.Errorf("comment reactions changed the resolution's counts to %v", detail.ReactionCounts)
+	}
+
+	// Blockers' resolutions and hidden ones are not found
+	if _, err := repos.Resolutions.Detail(ctx, resolution.RID, DetailQuery{Viewer: Viewer{Blockers: []primitive.ObjectID{alice.ID}}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Detail of a blocker's resolution returned %v, want ErrNotFound", err)
+	}
+	if _, err := repos.Resolutions.Detail(ctx, hidden.RID, DetailQuery{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Detail of a hidden resolution returned %v, want ErrNotFound", err)
+	}
+}
+
+func testComments(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	resolution := seedResolution(t, repos, alice, "run a marathon", base, false)
+	other := seedResolution(t, repos, alice, "read more", base, false)
+	comment := seedComment(t, repos, alice, resolution.RID, "day one done", base, false)
+	hidden := seedComment(t, repos, alice, resolution.RID, "hidden", base, true)
+
+	found, err := repos.Comments.FindVisible(ctx, comment.ID, resolution.RID)
+	if err != nil {
+		t.Fatalf("FindVisible: %v", err)
+	}
+	if found.Comment != "day one done" || found.UserID != alice.ID {
+		t.Errorf("FindVisible returned %+v", found)
+	}
+	if _, err := repos.Comments.FindVisible(ctx, comment.ID, other.RID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindVisible on another resolution returned %v, want ErrNotFound", err)
+	}
+	if _, err := repos.Comments.FindVisible(ctx, hidden.ID, resolution.RID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindVisible of a hidden comment returned %v, want ErrNotFound", err)
+	}
+}
+
+func testReactions(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	liked := seedResolution(t, repos, alice, "run a marathon", base, false)
+	other := seedResolution(t, repos, alice, "read more", base, false)
+	target := models.ReactionTargetResolution
+
+	if previous := seedReaction(t, repos, bob, models.ReactionTargetResolution, liked.RID, models.ReactionLike); previous != "" {
+		t.Errorf("first reaction replaced %q, want nothing", previous)
+	}
+	seedLike(t, repos, alice, liked.RID)
+	if previous := seedReaction(t, repos, bob, models.ReactionTargetResolution, liked.RID, models.ReactionLike); previous != models.ReactionLike {
+		t.Errorf("reacting twice replaced %q, want like", previous)
+	}
+
+	// A user has one reaction per target, a new type replaces the old one
+	if previous := seedReaction(t, repos, bob, models.ReactionTargetResolution, liked.RID, models.ReactionCheer); previous != models.ReactionLike {
+		t.Errorf("changing the reaction replaced %q, want like", previous)
+	}
+	counts, err := repos.Reactions.Counts(ctx, target, liked.RID)
+	if err != nil {
+		t.Fatalf("Counts: %v", err)
+	}
+	if len(counts) != 2 || counts[models.ReactionLike] != 1 || counts[models.ReactionCheer] != 1 {
+		t.Errorf("Counts returned %v, want a like and a cheer", counts)
+	}
+	if counts, err := repos.Reactions.Counts(ctx, target, other.RID); err != nil || len(counts) != 0 {
+		t.Errorf("Counts without reactions returned %v, %v", counts, err)
+	}
+
+	byUser, err := repos.Reactions.ByUser(ctx, bob.ID, target, []primitive.ObjectID{liked.RID, other.RID})
+	if err != nil {
+		t.Fatalf("ByUser: %v", err)
+	}
+	if len(byUser) != 1 || byUser[liked.RID] != models.ReactionCheer {
+		t.Errorf("ByUser returned %v", byUser)
+	}
+	if byUser, _ := repos.Reactions.ByUser(ctx, bob.ID, target, nil); len(byUser) != 0 {
+		t.Errorf("ByUser without targets returned %v", byUser)
+	}
+
+	// Removing a type only removes a reaction of that type
+	if removed, err := repos.Reactions.Remove(ctx, bob.ID, target, liked.RID, models.ReactionLike); err != nil || removed != "" {
+		t.Errorf("Remove of another type returned %q, %v, want nothing", removed, err)
+	}
+	if removed, err := repos.Reactions.Remove(ctx, bob.ID, target, liked.RID, ""); err != nil || removed != models.ReactionCheer {
+		t.Errorf("Remove returned %q, %v, want cheer", removed, err)
+	}
+	if removed, _ := repos.Reactions.Remove(ctx, bob.ID, target, liked.RID, ""); removed != "" {
+		t.Errorf("second Remove returned %q", removed)
+	}
+	if counts, _ := repos.Reactions.Counts(ctx, target, liked.RID); len(counts) != 1 || counts[models.ReactionLike] != 1 {
+		t.Errorf("Counts after Remove returned %v, want alice's like", counts)
+	}
+}
+
+func testBlocks(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+
+	block := func(user, target models.User, kind string) bool {
+		t.Helper()
+		created, err := repos.Blocks.Add(ctx, models.Block{UserID: user.ID, TargetID: target.ID, Kind: kind, CreatedAt: base})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		return created
+	}
+
+	if !block(alice, bob, models.BlockKindBlock) {
+		t.Error("Add of a new block reported it existed")
+	}
+	if block(alice, bob, models.BlockKindBlock) {
+		t.Error("Add of an existing block reported it was new")
+	}
+	block(alice, carol, models.BlockKindMute)
+	block(carol, alice, models.BlockKindBlock)
+	block(bob, alice, models.BlockKindMute)
+
+	if ok, err := repos.Blocks.Exists(ctx, alice.ID, bob.ID, models.BlockKindBlock); err != nil || !ok {
+		t.Errorf("Exists returned %v, %v, want true", ok, err)
+	}
+	if ok, _ := repos.Blocks.Exists(ctx, alice.ID, bob.ID, models.BlockKindMute); ok {
+		t.Error("Exists found a mute that was never added")
+	}
+
+	// Alice's own blocks and mutes, and Carol's block against her, but not Bob's mute
+	blocks, err := repos.Blocks.Involving(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("Involving: %v", err)
+	}
+	if len(blocks) != 3 {
+		t.Errorf("Involving returned %d blocks, want 3: %+v", len(blocks), blocks)
+	}
+	for _, b := range blocks {
+		if b.UserID == bob.ID {
+			t.Errorf("Involving returned Bob's mute %+v", b)
+		}
+	}
+
+	if removed, err := repos.Blocks.Remove(ctx, alice.ID, bob.ID, models.BlockKindBlock); err != nil || !removed {
+		t.Errorf("Remove returned %v, %v, want true", removed, err)
+	}
+	if removed, _ := repos.Blocks.Remove(ctx, alice.ID, bob.ID, models.BlockKindBlock); removed {
+		t.Error("second Remove reported a block")
+	}
+
+	// Listing has the targeted users, newest first
+	repos.Blocks.Add(ctx, models.Block{UserID: alice.ID, TargetID: bob.ID, Kind: models.BlockKindBlock, CreatedAt: base.Add(time.Hour)})
+	listed, err := repos.Blocks.List(ctx, alice.ID, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != 2 || listed[0].TargetID != bob.ID || listed[1].TargetID != carol.ID {
+		t.Fatalf("List returned %+v, want Bob's block then Carol's mute", listed)
+	}
+	if listed[0].UserDetail == nil || listed[0].UserDetail.Name != "bob" || listed[0].Kind != models.BlockKindBlock {
+		t.Errorf("List returned %+v, want Bob's name", listed[0])
+	}
+	if mutes, _ := repos.Blocks.List(ctx, alice.ID, models.BlockKindMute); len(mutes) != 1 || mutes[0].TargetID != carol.ID {
+		t.Errorf("List of mutes returned %+v, want Carol", mutes)
+	}
+}
+
+func testBookmarks(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+
+	first := seedResolution(t, repos, bob, "run a marathon", base, false)
+	second := seedResolution(t, repos, bob, "read more", base, false)
+	byCarol := seedResolution(t, repos, carol, "learn go", base, false)
+	hidden := seedResolution(t, repos, bob, "hidden", base, true)
+	seedLike(t, repos, alice, first.RID)
+
+	bookmark := func(r models.Resolution, collection string, at time.Time) bool {
+		t.Helper()
+		created, err := repos.Bookmarks.Set(ctx, models.Bookmark{
+			ID:         primitive.NewObjectID(),
+			UserID:     alice.ID,
+			RID:        r.RID,
+			Collection: collection,
+			CreatedAt:  at,
+			UpdatedAt:  at,
+		})
+		if err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		return created
+	}
+
+	if !bookmark(first, "", base) {
+		t.Error("Set of a new bookmark reported it existed")
+	}
+	bookmark(second, "fitness", base.Add(time.Minute))
+	bookmark(byCarol, "fitness", base.Add(2*time.Minute))
+	bookmark(hidden, "", base.Add(3*time.Minute))
+
+	// Bookmarking again moves the bookmark but keeps its place
+	if bookmark(first, "fitness", base.Add(4*time.Minute)) {
+		t.Error("Set of an existing bookmark reported it was new")
+	}
+
+	all, err := repos.Bookmarks.List(ctx, BookmarkQuery{UserID: alice.ID, Limit: 10})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := []primitive.ObjectID{}
+	for _, b := range all {
+		ids = append(ids, b.Resolution.ID)
+	}
+	assertIDs(t, ids, []primitive.ObjectID{byCarol.RID, second.RID, first.RID})
+	if last := all[2]; last.Collection != "fitness" || last.Resolution.LikeCount != 1 || last.Resolution.UserName != "bob" {
+		t.Errorf("List returned %+v, want the moved bookmark with its counts", last)
+	}
+
+	page, _ := repos.Bookmarks.List(ctx, BookmarkQuery{UserID: alice.ID, Skip: 1, Limit: 1})
+	if len(page) != 1 || page[0].Resolution.ID != second.RID {
+		t.Errorf("second page is %+v, want the second resolution", page)
+	}
+	filtered, _ := repos.Bookmarks.List(ctx, BookmarkQuery{UserID: alice.ID, Limit: 10, Viewer: Viewer{Hidden: []primitive.ObjectID{carol.ID}}})
+	if len(filtered) != 2 {
+		t.Errorf("List without carol returned %d bookmarks, want 2", len(filtered))
+	}
+	if others, _ := repos.Bookmarks.List(ctx, BookmarkQuery{UserID: bob.ID, Limit: 10}); len(others) != 0 {
+		t.Errorf("List of another user returned %+v", others)
+	}
+
+	if removed, err := repos.Bookmarks.Remove(ctx, alice.ID, second.RID); err != nil || !removed {
+		t.Errorf("Remove returned %v, %v, want true", removed, err)
+	}
+	if removed, _ := repos.Bookmarks.Remove(ctx, alice.ID, second.RID); removed {
+		t.Error("second Remove reported a bookmark")
+	}
+
+	collections, err := repos.Bookmarks.Collections(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("Collections: %v", err)
+	}
+	if len(collections) != 1 || collections[0].Name != "fitness" || collections[0].Count != 2 {
+		t.Errorf("Collections returned %+v, want fitness with 2", collections)
+	}
+	fitness, _ := repos.Bookmarks.List(ctx, BookmarkQuery{UserID: alice.ID, Collection: "fitness", Limit: 10})
+	if len(fitness) != 2 {
+		t.Errorf("List of fitness returned %d bookmarks, want 2", len(fitness))
+	}
+
+	bookmarked, err := repos.Bookmarks.BookmarkedBy(ctx, alice.ID, []primitive.ObjectID{first.RID, second.RID})
+	if err != nil {
+		t.Fatalf("BookmarkedBy: %v", err)
+	}
+	if len(bookmarked) != 1 || !bookmarked[first.RID] {
+		t.Errorf("BookmarkedBy returned %v", bookmarked)
+	}
+	if bookmarked, _ := repos.Bookmarks.BookmarkedBy(ctx, alice.ID, nil); len(bookmarked) != 0 {
+		t.Errorf("BookmarkedBy without resolutions returned %v", bookmarked)
+	}
+}
+
+func testAdoptions(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+	dave := seedUser(t, repos, "dave")
+
+	original := seedResolution(t, repos, alice, "run a marathon", base, false)
+	adopt := func(user models.User, at time.Time, hidden bool) (models.Resolution, error) {
+		t.Helper()
+		copied := models.Resolution{
+			RID:         primitive.NewObjectID(),
+			UserID:      user.ID,
+			Resolution:  original.Resolution,
+			Tags:        original.Tags,
+			AdoptedFrom: &original.RID,
+			Hidden:      hidden,
+			CreatedAt:   at,
+			UpdatedAt:   at,
+		}
+		return copied, repos.Resolutions.Insert(ctx, copied)
+	}
+
+	byBob, err := adopt(bob, base.Add(time.Minute), false)
+	if err != nil {
+		t.Fatalf("Insert of an adoption: %v", err)
+	}
+	byCarol, _ := adopt(carol, base.Add(2*time.Minute), false)
+	adopt(dave, base.Add(3*time.Minute), true)
+	if _, err := adopt(bob, base.Add(4*time.Minute), false); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("second adoption by bob returned %v, want ErrDuplicate", err)
+	}
+	// Resolutions that aren't copies are never duplicates
+	seedResolution(t, repos, bob, "read more", base, false)
+	seedResolution(t, repos, bob, "read more", base, false)
+
+	found, err := repos.Resolutions.FindAdoption(ctx, bob.ID, original.RID)
+	if err != nil || found.RID != byBob.RID {
+		t.Errorf("FindAdoption returned %v, %v, want bob's copy", found.RID, err)
+	}
+	if _, err := repos.Resolutions.FindAdoption(ctx, alice.ID, original.RID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindAdoption without a copy returned %v, want ErrNotFound", err)
+	}
+
+	// The hidden copy is neither counted nor listed
+	adopters, err := repos.Resolutions.Adopters(ctx, AdopterQuery{RID: original.RID, Limit: 10})
+	if err != nil {
+		t.Fatalf("Adopters: %v", err)
+	}
+	ids := []primitive.ObjectID{}
+	for _, a := range adopters {
+		ids = append(ids, a.RID)
+	}
+	assertIDs(t, ids, []primitive.ObjectID{byCarol.RID, byBob.RID})
+	if first := adopters[0]; first.UserDetail == nil || first.UserDetail.Name != "carol" || !first.AdoptedAt.Equal(byCarol.CreatedAt) {
+		t.Errorf("Adopters returned %+v, want carol with her copy", first)
+	}
+	page, _ := repos.Resolutions.Adopters(ctx, AdopterQuery{RID: original.RID, Skip: 1, Limit: 1})
+	if len(page) != 1 || page[0].RID != byBob.RID {
+		t.Errorf("second page is %+v, want bob's copy", page)
+	}
+	filtered, _ := repos.Resolutions.Adopters(ctx, AdopterQuery{RID: original.RID, Limit: 10, Viewer: Viewer{Hidden: []primitive.ObjectID{carol.ID}}})
+	if len(filtered) != 1 || filtered[0].RID != byBob.RID {
+		t.Errorf("Adopters without carol returned %+v", filtered)
+	}
+
+	detail, err := repos.Resolutions.Detail(ctx, original.RID, DetailQuery{})
+	if err != nil {
+		t.Fatalf("Detail: %v", err)
+	}
+	if detail.AdoptionCount != 2 || detail.AdoptedFrom != nil || detail.Source != nil {
+		t.Errorf("Detail of the original has %d adoptions and source %v", detail.AdoptionCount, detail.Source)
+	}
+	feed, _ := repos.Resolutions.Feed(ctx, FeedQuery{Sort: SortNewest, Limit: 10})
+	for _, summary := range feed {
+		if summary.ID == original.RID && summary.AdoptionCount != 2 {
+			t.Errorf("feed has %d adoptions of the original, want 2", summary.AdoptionCount)
+		}
+		if summary.ID == byBob.RID && (summary.AdoptedFrom == nil || *summary.AdoptedFrom != original.RID) {
+			t.Errorf("feed has bob's copy adopted from %v", summary.AdoptedFrom)
+		}
+	}
+
+	copied, err := repos.Resolutions.Detail(ctx, byBob.RID, DetailQuery{})
+	if err != nil {
+		t.Fatalf("Detail of the copy: %v", err)
+	}
+	if copied.AdoptedFrom == nil || *copied.AdoptedFrom != original.RID {
+		t.Errorf("copy adopted from %v, want the original", copied.AdoptedFrom)
+	}
+	if src := copied.Source; src == nil || src.ID != original.RID || src.Resolution != "run a marathon" || src.UserDetail == nil || src.UserDetail.Name != "alice" {
+		t.Errorf("copy has source %+v, want the original by alice", src)
+	}
+
+	// A viewer alice blocked still sees the copy, but not where it came from
+	blocked, _ := repos.Resolutions.Detail(ctx, byBob.RID, DetailQuery{Viewer: Viewer{Hidden: []primitive.ObjectID{alice.ID}, Blockers: []primitive.ObjectID{alice.ID}}})
+	if blocked.Source != nil || blocked.AdoptedFrom == nil {
+		t.Errorf("copy for a blocked viewer has source %+v", blocked.Source)
+	}
+}
+
+func seedWebhook(t *testing.T, repos Repositories, owner *primitive.ObjectID, created time.Time, events ...string) models.Webhook {
+	t.Helper()
+	hook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		UserID:    owner,
+		URL:       "https://example.com/hook",
+		Secret:    "secret",
+		Events:    events,
+		Active:    true,
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+	if err := repos.Webhooks.Insert(context.Background(), hook); err != nil {
+		t.Fatalf("inserting webhook: %v", err)
+	}
+	return hook
+}
+
+func webhookIDs(hooks []models.Webhook) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, h := range hooks {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func testWebhooks(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+
+	global := seedWebhook(t, repos, nil, base, models.WebhookEventResolutionCreated)
+	older := seedWebhook(t, repos, &alice.ID, base, models.WebhookEventResolutionCreated, models.WebhookEventCommentCreated)
+	newer := seedWebhook(t, repos, &alice.ID, base.Add(time.Minute), models.WebhookEventLikeUpdated)
+	bobs := seedWebhook(t, repos, &bob.ID, base, models.WebhookEventResolutionCreated)
+
+	hooks, err := repos.Webhooks.List(ctx, &alice.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	assertIDs(t, webhookIDs(hooks), []primitive.ObjectID{newer.ID, older.ID})
+	hooks, _ = repos.Webhooks.List(ctx, nil)
+	assertIDs(t, webhookIDs(hooks), []primitive.ObjectID{global.ID})
+	if count, err := repos.Webhooks.Count(ctx, &alice.ID); err != nil || count != 2 {
+		t.Errorf("Count returned %d, %v, want 2", count, err)
+	}
+
+	if _, err := repos.Webhooks.Find(ctx, older.ID, &bob.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find of another owner's webhook returned %v, want ErrNotFound", err)
+	}
+	if _, err := repos.Webhooks.Find(ctx, global.ID, &alice.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find of a global webhook for a user returned %v, want ErrNotFound", err)
+	}
+	if found, err := repos.Webhooks.FindByID(ctx, older.ID); err != nil || found.Secret != "secret" {
+		t.Errorf("FindByID returned %+v, %v", found, err)
+	}
+
+	// The global webhooks and Alice's own, but not Bob's
+	hooks, err = repos.Webhooks.Subscribed(ctx, models.WebhookEventResolutionCreated, alice.ID)
+	if err != nil {
+		t.Fatalf("Subscribed: %v", err)
+	}
+	if ids := webhookIDs(hooks); len(ids) != 2 || !contains(ids, global.ID) || !contains(ids, older.ID) {
+		t.Errorf("Subscribed returned %v, want the global webhook and Alice's", ids)
+	}
+
+	// Turning a webhook off stops its deliveries, turning it on again clears its failures
+	off, on := false, true
+	url := "https://example.com/other"
+	updated, err := repos.Webhooks.Update(ctx, bobs.ID, &bob.ID, WebhookUpdate{URL: &url, Active: &off, At: base.Add(time.Hour)})
+	if err != nil || updated.Active || updated.URL != url || updated.DisabledAt == nil || !updated.UpdatedAt.Equal(base.Add(time.Hour)) {
+		t.Errorf("Update returned %+v, %v", updated, err)
+	}
+	if hooks, _ := repos.Webhooks.Subscribed(ctx, models.WebhookEventResolutionCreated, bob.ID); len(hooks) != 1 {
+		t.Errorf("Subscribed returned %d webhooks, want only the global one", len(hooks))
+	}
+	if _, err := repos.Webhooks.Update(ctx, bobs.ID, &alice.ID, WebhookUpdate{Active: &on, At: base}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of another owner's webhook returned %v, want ErrNotFound", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		hook, err := repos.Webhooks.RecordFailure(ctx, older.ID, 3, base)
+		if err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+		if hook.ConsecutiveFailures != i || hook.Active != (i < 3) {
+			t.Errorf("after %d failures got %+v", i, hook)
+		}
+	}
+	updated, err = repos.Webhooks.Update(ctx, older.ID, &alice.ID, WebhookUpdate{Active: &on, At: base})
+	if err != nil || !updated.Active || updated.ConsecutiveFailures != 0 || updated.DisabledAt != nil {
+		t.Errorf("turning on returned %+v, %v", updated, err)
+	}
+	repos.Webhooks.RecordFailure(ctx, older.ID, 3, base)
+	if err := repos.Webhooks.ResetFailures(ctx, older.ID); err != nil {
+		t.Fatalf("ResetFailures: %v", err)
+	}
+	if hook, _ := repos.Webhooks.FindByID(ctx, older.ID); hook.ConsecutiveFailures != 0 {
+		t.Errorf("ResetFailures left %d failures", hook.ConsecutiveFailures)
+	}
+
+	if deleted, err := repos.Webhooks.Delete(ctx, older.ID, &bob.ID); err != nil || deleted {
+		t.Errorf("Delete of another owner's webhook returned %v, %v", deleted, err)
+	}
+	if deleted, err := repos.Webhooks.Delete(ctx, older.ID, &alice.ID); err != nil || !deleted {
+		t.Errorf("Delete returned %v, %v, want true", deleted, err)
+	}
+	if _, err := repos.Webhooks.FindByID(ctx, older.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByID of a deleted webhook returned %v, want ErrNotFound", err)
+	}
+}
+
+func testDeliveries(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	hook := seedWebhook(t, repos, nil, base, models.WebhookEventResolutionCreated)
+	other := seedWebhook(t, repos, nil, base, models.WebhookEventResolutionCreated)
+
+	deliver := func(webhookID primitive.ObjectID, status string, created time.Time, due *time.Time) models.WebhookDelivery {
+		t.Helper()
+		delivery := models.WebhookDelivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     webhookID,
+			EventID:       primitive.NewObjectID().Hex(),
+			Event:         models.WebhookEventResolutionCreated,
+			Payload:       `{}`,
+			Status:        status,
+			NextAttemptAt: due,
+			CreatedAt:     created,
+			UpdatedAt:     created,
+		}
+		if err := repos.Deliveries.Insert(ctx, delivery); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		return delivery
+	}
+	at := func(d time.Duration) *time.Time {
+		due := base.Add(d)
+		return &due
+	}
+
+	late := deliver(hook.ID, models.WebhookDeliveryPending, base, at(-time.Minute))
+	early := deliver(hook.ID, models.WebhookDeliveryPending, base.Add(time.Second), at(-time.Hour))
+	future := deliver(hook.ID, models.WebhookDeliveryPending, base.Add(2*time.Second), at(time.Hour))
+	done := deliver(hook.ID, models.WebhookDeliverySucceeded, base.Add(3*time.Second), nil)
+	deliver(other.ID, models.WebhookDeliveryFailed, base, nil)
+
+	deliveries, err := repos.Deliveries.List(ctx, DeliveryQuery{WebhookID: hook.ID, Limit: 10})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := []primitive.ObjectID{}
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	assertIDs(t, ids, []primitive.ObjectID{done.ID, future.ID, early.ID, late.ID})
+	deliveries, _ = repos.Deliveries.List(ctx, DeliveryQuery{WebhookID: hook.ID, Status: models.WebhookDeliveryPending, Skip: 1, Limit: 1})
+	if len(deliveries) != 1 || deliveries[0].ID != early.ID {
+		t.Errorf("second pending delivery is %+v, want %s", deliveries, early.ID.Hex())
+	}
+
+	if _, err := repos.Deliveries.Find(ctx, late.ID, other.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find with another webhook returned %v, want ErrNotFound", err)
+	}
+
+	// Due deliveries are claimed in the order they came due, each once until the lease ends
+	for i, want := range []models.WebhookDelivery{early, late} {
+		claimed, err := repos.Deliveries.Claim(ctx, base, base.Add(time.Duration(i+1)*time.Minute))
+		if err != nil || claimed.ID != want.ID {
+			t.Fatalf("Claim returned %s, %v, want %s", claimed.ID.Hex(), err, want.ID.Hex())
+		}
+	}
+	if _, err := repos.Deliveries.Claim(ctx, base, base.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Claim with nothing due returned %v, want ErrNotFound", err)
+	}
+	if claimed, err := repos.Deliveries.Claim(ctx, base.Add(time.Minute), base.Add(time.Hour)); err != nil || claimed.ID != early.ID {
+		t.Errorf("Claim after the lease returned %s, %v, want %s again", claimed.ID.Hex(), err, early.ID.Hex())
+	}
+
+	late.Status = models.WebhookDeliverySucceeded
+	late.Attempts = 1
+	late.NextAttemptAt = nil
+	if err := repos.Deliveries.Save(ctx, late); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if saved, err := repos.Deliveries.Find(ctx, late.ID, hook.ID); err != nil || saved.Status != models.WebhookDeliverySucceeded || saved.NextAttemptAt != nil {
+		t.Errorf("Find after Save returned %+v, %v", saved, err)
+	}
+	if err := repos.Deliveries.Save(ctx, models.WebhookDelivery{ID: primitive.NewObjectID()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Save of an unknown delivery returned %v, want ErrNotFound", err)
+	}
+
+	if err := repos.Deliveries.DeleteByWebhook(ctx, hook.ID); err != nil {
+		t.Fatalf("DeleteByWebhook: %v", err)
+	}
+	if left, _ := repos.Deliveries.List(ctx, DeliveryQuery{WebhookID: hook.ID, Limit: 10}); len(left) != 0 {
+		t.Errorf("DeleteByWebhook left %d deliveries", len(left))
+	}
+	if left, _ := repos.Deliveries.List(ctx, DeliveryQuery{WebhookID: other.ID, Limit: 10}); len(left) != 1 {
+		t.Errorf("DeleteByWebhook deleted the other webhook's deliveries")
+	}
+}
+
+func testRoles(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+
+	if _, err := repos.Users.SetRole(ctx, alice.ID, models.RoleAdmin); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	before, err := repos.Users.SetRole(ctx, bob.ID, models.RoleModerator)
+	if err != nil || before.Role != "" {
+		t.Errorf("SetRole returned %+v, %v, want bob without a role", before, err)
+	}
+	if _, err := repos.Users.SetRole(ctx, primitive.NewObjectID(), models.RoleAdmin); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetRole of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	// Users without a role are regular users
+	list := func(role string) []primitive.ObjectID {
+		t.Helper()
+		users, err := repos.Users.List(ctx, UserQuery{Role: role, Limit: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		ids := []primitive.ObjectID{}
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+	assertIDs(t, list(models.RoleAdmin), []primitive.ObjectID{alice.ID})
+	assertIDs(t, list(models.RoleUser), []primitive.ObjectID{carol.ID})
+	if all := list(""); len(all) != 3 {
+		t.Errorf("List without a role returned %d users, want 3", len(all))
+	}
+	if users, _ := repos.Users.List(ctx, UserQuery{Skip: 2, Limit: 10}); len(users) != 1 {
+		t.Errorf("second page returned %d users, want 1", len(users))
+	}
+
+	// The last admin keeps the role
+	if _, err := repos.Users.SetRole(ctx, alice.ID, models.RoleUser); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("demoting the last admin returned %v, want ErrLastAdmin", err)
+	}
+	if found, _ := repos.Users.FindByID(ctx, alice.ID); found.Role != models.RoleAdmin {
+		t.Errorf("last admin has role %q after the refused demotion", found.Role)
+	}
+	repos.Users.SetRole(ctx, bob.ID, models.RoleAdmin)
+	if before, err := repos.Users.SetRole(ctx, alice.ID, models.RoleModerator); err != nil || before.Role != models.RoleAdmin {
+		t.Errorf("demoting one of two admins returned %+v, %v", before, err)
+	}
+
+	// Statuses come with a reason and suspensions with an end, reinstating clears both
+	until := base.AddDate(0, 0, 7)
+	before, err = repos.Users.UpdateStatus(ctx, carol.ID, StatusUpdate{Status: models.UserStatusSuspended, Reason: "spam", SuspendedUntil: &until, At: base})
+	if err != nil || before.Status != "" {
+		t.Fatalf("UpdateStatus returned %+v, %v, want carol without a status", before, err)
+	}
+	found, _ := repos.Users.FindByID(ctx, carol.ID)
+	if found.Status != models.UserStatusSuspended || found.StatusReason != "spam" || found.SuspendedUntil == nil || !found.SuspendedUntil.Equal(until) {
+		t.Errorf("user after suspending is %+v", found)
+	}
+	before, err = repos.Users.UpdateStatus(ctx, carol.ID, StatusUpdate{Status: models.UserStatusActive, At: base})
+	if err != nil || before.StatusReason != "spam" {
+		t.Errorf("UpdateStatus returned %+v, %v, want the suspension", before, err)
+	}
+	found, _ = repos.Users.FindByID(ctx, carol.ID)
+	if found.Status != models.UserStatusActive || found.StatusReason != "" || found.SuspendedUntil != nil {
+		t.Errorf("user after reinstating is %+v", found)
+	}
+	if _, err := repos.Users.UpdateStatus(ctx, primitive.NewObjectID(), StatusUpdate{Status: models.UserStatusBanned, At: base}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateStatus of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	// Admins named by the verified email they signed up with are promoted once. Erin only
+	// gained a verified identity for her address later, Frank predates identities.
+	verified := models.User{ID: primitive.NewObjectID(), Name: "dave", Email: "dave@example.com", CreatedAt: base, Identities: []models.Identity{
+		{Provider: "google", Subject: "1", Email: "dave@example.com", EmailVerified: true},
+	}}
+	linkedLater := models.User{ID: primitive.NewObjectID(), Name: "erin", Email: "erin@example.com", CreatedAt: base, Identities: []models.Identity{
+		{Provider: "github", Subject: "2", Email: "erin@example.com"},
+		{Provider: "google", Subject: "3", Email: "erin@example.com", EmailVerified: true},
+	}}
+	legacy := models.User{ID: primitive.NewObjectID(), Name: "frank", Email: "frank@example.com", CreatedAt: base}
+	for _, user := range []models.User{verified, linkedLater, legacy} {
+		if err := repos.Users.Insert(ctx, user); err != nil {
+			t.Fatalf("inserting user: %v", err)
+		}
+	}
+	byEmail, err := repos.Users.FindBySignUpEmails(ctx, []string{"dave@example.com", "erin@example.com", "frank@example.com"})
+	if err != nil || len(byEmail) != 1 || byEmail[0].ID != verified.ID {
+		t.Errorf("FindBySignUpEmails returned %+v, %v, want dave", byEmail, err)
+	}
+	if granted, err := repos.Users.GrantAdmin(ctx, verified.ID); err != nil || !granted {
+		t.Errorf("GrantAdmin returned %v, %v", granted, err)
+	}
+	if found, _ := repos.Users.FindByID(ctx, verified.ID); found.Role != models.RoleAdmin {
+		t.Errorf("user after GrantAdmin has role %q", found.Role)
+	}
+	if granted, err := repos.Users.GrantAdmin(ctx, verified.ID); err != nil || granted {
+		t.Errorf("second GrantAdmin returned %v, %v, want nothing granted", granted, err)
+	}
+	if granted, err := repos.Users.GrantAdmin(ctx, primitive.NewObjectID()); err != nil || granted {
+		t.Errorf("GrantAdmin of a missing user returned %v, %v", granted, err)
+	}
+}
+
+func testHiding(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	resolution := seedResolution(t, repos, alice, "Run", base, false)
+	comment := seedComment(t, repos, alice, resolution.RID, "Day one", base, false)
+
+	if err := repos.Resolutions.Hide(ctx, resolution.RID, base); err != nil {
+		t.Fatalf("Hide: %v", err)
+	}
+	if err := repos.Comments.Hide(ctx, comment.ID, base); err != nil {
+		t.Fatalf("Hide: %v", err)
+	}
+	if _, err := repos.Resolutions.FindVisible(ctx, resolution.RID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindVisible of a hidden resolution returned %v, want ErrNotFound", err)
+	}
+	if found, err := repos.Resolutions.Find(ctx, resolution.RID); err != nil || !found.Hidden {
+		t.Errorf("Find of a hidden resolution returned %+v, %v", found, err)
+	}
+	if found, err := repos.Comments.Find(ctx, comment.ID); err != nil || !found.Hidden {
+		t.Errorf("Find of a hidden comment returned %+v, %v", found, err)
+	}
+
+	missing := primitive.NewObjectID()
+	if _, err := repos.Resolutions.Find(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find of a missing resolution returned %v, want ErrNotFound", err)
+	}
+	if _, err := repos.Comments.Find(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Find of a missing comment returned %v, want ErrNotFound", err)
+	}
+	if err := repos.Resolutions.Hide(ctx, missing, base); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Hide of a missing resolution returned %v, want ErrNotFound", err)
+	}
+	if err := repos.Comments.Hide(ctx, missing, base); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Hide of a missing comment returned %v, want ErrNotFound", err)
+	}
+}
+
+func testReminders(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	run := seedResolution(t, repos, alice, "Run", base, false)
+	read := seedResolution(t, repos, alice, "Read", base, false)
+
+	set := func(user models.User, rID primitive.ObjectID, frequency string, next time.Time) models.Reminder {
+		t.Helper()
+		stored, err := repos.Reminders.Set(ctx, models.Reminder{
+			UserID: user.ID, RID: rID, Frequency: frequency, Hour: 9, DayOfMonth: 1,
+			Timezone: "UTC", NextRunAt: next, CreatedAt: base, UpdatedAt: base,
+		})
+		if err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		return stored
+	}
+
+	daily := set(alice, run.RID, models.ReminderDaily, base.Add(2*time.Hour))
+	if daily.ID.IsZero() || daily.Frequency != models.ReminderDaily || daily.UserID != alice.ID {
+		t.Errorf("Set returned %+v", daily)
+	}
+	set(alice, read.RID, models.ReminderWeekly, base.Add(time.Hour))
+	set(bob, run.RID, models.ReminderDaily, base)
+
+	// Setting it again replaces the reminder
+	weekly := set(alice, run.RID, models.ReminderWeekly, base.Add(3*time.Hour))
+	if weekly.ID != daily.ID || weekly.Frequency != models.ReminderWeekly {
+		t.Errorf("second Set returned %+v, want the first reminder changed", weekly)
+	}
+
+	list, err := repos.Reminders.ListByUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("ListByUser: %v", err)
+	}
+	if len(list) != 2 || list[0].RID != read.RID || list[1].RID != run.RID {
+		t.Fatalf("ListByUser returned %+v, want the one due next first", list)
+	}
+
+	if err := repos.Reminders.Reschedule(ctx, weekly.ID, "Asia/Tokyo", base); err != nil {
+		t.Fatalf("Reschedule: %v", err)
+	}
+	list, _ = repos.Reminders.ListByUser(ctx, alice.ID)
+	if list[0].RID != run.RID || list[0].Timezone != "Asia/Tokyo" {
+		t.Errorf("ListByUser after Reschedule returned %+v", list)
+	}
+
+	if deleted, err := repos.Reminders.Delete(ctx, alice.ID, run.RID); err != nil || !deleted {
+		t.Errorf("Delete returned %v, %v", deleted, err)
+	}
+	if deleted, _ := repos.Reminders.Delete(ctx, alice.ID, run.RID); deleted {
+		t.Error("second Delete reported a reminder")
+	}
+	if list, _ := repos.Reminders.ListByUser(ctx, bob.ID); len(list) != 1 {
+		t.Errorf("Delete removed Bob's reminder too: %+v", list)
+	}
+
+	// Due reminders come the one due the longest first, and are advanced only once
+	if _, err := repos.Reminders.Due(ctx, base.Add(-time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Due before any is due returned %v, want ErrNotFound", err)
+	}
+	due, err := repos.Reminders.Due(ctx, base.Add(2*time.Hour))
+	if err != nil || due.UserID != bob.ID {
+		t.Fatalf("Due returned %+v, %v, want Bob's", due, err)
+	}
+	sentAt := base.Add(2 * time.Hour)
+	if advanced, err := repos.Reminders.Advance(ctx, due, base.AddDate(0, 0, 1), sentAt); err != nil || !advanced {
+		t.Errorf("Advance returned %v, %v", advanced, err)
+	}
+	if advanced, err := repos.Reminders.Advance(ctx, due, base.AddDate(0, 0, 2), sentAt); err != nil || advanced {
+		t.Errorf("second Advance returned %v, %v, want it refused", advanced, err)
+	}
+	list, _ = repos.Reminders.ListByUser(ctx, bob.ID)
+	if !list[0].NextRunAt.Equal(base.AddDate(0, 0, 1)) || list[0].LastSentAt == nil || !list[0].LastSentAt.Equal(sentAt) {
+		t.Errorf("ListByUser after Advance returned %+v", list)
+	}
+	if due, err := repos.Reminders.Due(ctx, base.Add(2*time.Hour)); err != nil || due.RID != read.RID {
+		t.Errorf("Due after Advance returned %+v, %v, want Alice's", due, err)
+	}
+}
+
+func testTokens(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+
+	insert := func(user models.User, hash string, created time.Time) models.PersonalAccessToken {
+		t.Helper()
+		token := models.PersonalAccessToken{ID: primitive.NewObjectID(), UserID: user.ID, Name: hash, TokenHash: hash, Scopes: []string{models.ScopeRead}, CreatedAt: created}
+		if err := repos.Tokens.Insert(ctx, token); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		return token
+	}
+	first := insert(alice, "hash-1", base)
+	second := insert(alice, "hash-2", base.Add(time.Hour))
+	insert(bob, "hash-3", base)
+
+	tokens, err := repos.Tokens.List(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := []primitive.ObjectID{}
+	for _, token := range tokens {
+		ids = append(ids, token.ID)
+	}
+	assertIDs(t, ids, []primitive.ObjectID{second.ID, first.ID})
+
+	found, err := repos.Tokens.FindActive(ctx, "hash-1")
+	if err != nil || found.ID != first.ID {
+		t.Errorf("FindActive returned %+v, %v", found, err)
+	}
+	if err := repos.Tokens.Touch(ctx, first.ID, base.Add(time.Minute)); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if found, _ := repos.Tokens.FindActive(ctx, "hash-1"); found.LastUsedAt == nil || !found.LastUsedAt.Equal(base.Add(time.Minute)) {
+		t.Errorf("last used at after Touch is %v", found.LastUsedAt)
+	}
+
+	// Only the owner revokes a token, and only once
+	if revoked, _ := repos.Tokens.Revoke(ctx, first.ID, bob.ID, base); revoked {
+		t.Error("Revoke by another user succeeded")
+	}
+	if revoked, err := repos.Tokens.Revoke(ctx, first.ID, alice.ID, base); err != nil || !revoked {
+		t.Errorf("Revoke returned %v, %v", revoked, err)
+	}
+	if revoked, _ := repos.Tokens.Revoke(ctx, first.ID, alice.ID, base); revoked {
+		t.Error("second Revoke succeeded")
+	}
+	if _, err := repos.Tokens.FindActive(ctx, "hash-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindActive of a revoked token returned %v, want ErrNotFound", err)
+	}
+	if count, err := repos.Tokens.CountActive(ctx, alice.ID); err != nil || count != 1 {
+		t.Errorf("CountActive returned %d, %v, want 1", count, err)
+	}
+}
+
+func testFollows(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+
+	follow := func(user, target models.User) bool {
+		t.Helper()
+		created, err := repos.Follows.Add(ctx, models.Follow{UserID: user.ID, TargetID: target.ID, CreatedAt: base})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		return created
+	}
+	if !follow(alice, bob) {
+		t.Error("Add of a new follow reported it existed")
+	}
+	if follow(alice, bob) {
+		t.Error("Add of an existing follow reported it was new")
+	}
+	follow(bob, alice)
+	follow(alice, carol)
+
+	if err := repos.Follows.RemoveBetween(ctx, bob.ID, alice.ID); err != nil {
+		t.Fatalf("RemoveBetween: %v", err)
+	}
+	if removed, _ := repos.Follows.Remove(ctx, alice.ID, bob.ID); removed {
+		t.Error("RemoveBetween left Alice following Bob")
+	}
+	if removed, _ := repos.Follows.Remove(ctx, bob.ID, alice.ID); removed {
+		t.Error("RemoveBetween left Bob following Alice")
+	}
+	if following, err := repos.Follows.Following(ctx, alice.ID); err != nil || len(following) != 1 || following[0] != carol.ID {
+		t.Errorf("Following returned %v, %v, want Carol", following, err)
+	}
+	if following, err := repos.Follows.Following(ctx, bob.ID); err != nil || len(following) != 0 {
+		t.Errorf("Following returned %v, %v for Bob, want nobody", following, err)
+	}
+	if removed, err := repos.Follows.Remove(ctx, alice.ID, carol.ID); err != nil || !removed {
+		t.Errorf("Remove returned %v, %v, RemoveBetween took other follows", removed, err)
+	}
+}
+
+func testNotifications(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+	rID := primitive.NewObjectID()
+
+	add := func(actor models.User, groupKey string, at time.Time) {
+		t.Helper()
+		err := repos.Notifications.Add(ctx, models.Notification{
+			UserID: alice.ID, Type: models.NotificationTypeLike, GroupKey: groupKey,
+			LastActorID: actor.ID, ResolutionID: &rID, CreatedAt: at, UpdatedAt: at,
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	list := func(unreadOnly bool) []NotificationSummary {
+		t.Helper()
+		notifications, err := repos.Notifications.List(ctx, NotificationQuery{UserID: alice.ID, UnreadOnly: unreadOnly, Limit: 10})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		return notifications
+	}
+
+	// Actors of one group are merged while it is unread
+	add(bob, "like:1", base)
+	add(carol, "like:1", base.Add(time.Minute))
+	add(bob, "like:1", base.Add(2*time.Minute))
+	add(carol, "like:2", base.Add(time.Hour))
+	notifications := list(false)
+	if len(notifications) != 2 {
+		t.Fatalf("List returned %d notifications, want 2", len(notifications))
+	}
+	liked := notifications[1]
+	if len(liked.ActorIDs) != 2 || liked.LastActorID != bob.ID || liked.Actor == nil || liked.Actor.Name != "bob" || liked.Read {
+		t.Errorf("merged notification is %+v, actor %+v", liked.Notification, liked.Actor)
+	}
+	if liked.ResolutionID == nil || *liked.ResolutionID != rID || !liked.CreatedAt.Equal(base) {
+		t.Errorf("merged notification is %+v, want the resolution and the first creation time", liked.Notification)
+	}
+
+	// Retracting falls back to the remaining actor and removes the notification after the last
+	if err := repos.Notifications.Retract(ctx, alice.ID, "like:1", bob.ID); err != nil {
+		t.Fatalf("Retract: %v", err)
+	}
+	notifications = list(false)
+	if len(notifications[1].ActorIDs) != 1 || notifications[1].LastActorID != carol.ID {
+		t.Errorf("notification after Retract is %+v", notifications[1].Notification)
+	}
+	repos.Notifications.Retract(ctx, alice.ID, "like:1", carol.ID)
+	if notifications = list(false); len(notifications) != 1 {
+		t.Errorf("List after retracting every actor returned %d notifications, want 1", len(notifications))
+	}
+
+	// Read notifications are no longer merged into
+	if marked, err := repos.Notifications.MarkRead(ctx, notifications[0].ID, bob.ID, base); err != nil || marked {
+		t.Errorf("MarkRead of another user's notification returned %v, %v", marked, err)
+	}
+	if marked, err := repos.Notifications.MarkRead(ctx, notifications[0].ID, alice.ID, base); err != nil || !marked {
+		t.Errorf("MarkRead returned %v, %v", marked, err)
+	}
+	add(bob, "like:2", base.Add(2*time.Hour))
+	if len(list(false)) != 2 || len(list(true)) != 1 {
+		t.Errorf("a new like after reading created %d notifications, %d unread", len(list(false)), len(list(true)))
+	}
+	if count, err := repos.Notifications.CountUnread(ctx, alice.ID); err != nil || count != 1 {
+		t.Errorf("CountUnread returned %d, %v, want 1", count, err)
+	}
+	if updated, err := repos.Notifications.MarkAllRead(ctx, alice.ID, base); err != nil || updated != 1 {
+		t.Errorf("MarkAllRead returned %d, %v, want 1", updated, err)
+	}
+	if count, _ := repos.Notifications.CountUnread(ctx, alice.ID); count != 0 {
+		t.Errorf("CountUnread after MarkAllRead returned %d", count)
+	}
+}
+
+func testReports(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice := seedUser(t, repos, "alice")
+	bob := seedUser(t, repos, "bob")
+	carol := seedUser(t, repos, "carol")
+	resolution := seedResolution(t, repos, alice, "Run", base, false)
+	comment := seedComment(t, repos, alice, resolution.RID, "Day one", base, false)
+
+	open := func(reporter models.User, targetType string, targetID primitive.ObjectID, reason string, at time.Time) bool {
+		t.Helper()
+		created, err := repos.Reports.Open(ctx, models.Report{
+			ID: primitive.NewObjectID(), ReporterID: reporter.ID, TargetType: targetType, TargetID: targetID,
+			TargetUserID: alice.ID, Reason: reason, Severity: models.ReportReasons[reason],
+			Status: models.ReportStatusOpen, CreatedAt: at, UpdatedAt: at,
+		})
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		return created
+	}
+	if !open(bob, models.ReportTargetComment, comment.ID, "spam", base) {
+		t.Error("Open of a new report reported it existed")
+	}
+	if open(bob, models.ReportTargetComment, comment.ID, "off_topic", base.Add(time.Minute)) {
+		t.Error("Open of a second report by the same user reported it was new")
+	}
+	open(carol, models.ReportTargetComment, comment.ID, "spam", base.Add(time.Hour))
+	open(bob, models.ReportTargetResolution, resolution.RID, "hate", base.Add(2*time.Hour))
+
+	queue, err := repos.Reports.Queue(ctx, QueueQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("Queue: %v", err)
+	}
+	if len(queue) != 2 {
+		t.Fatalf("Queue returned %d entries, want 2: %+v", len(queue), queue)
+	}
+	// The more severe report comes first even though it was reported once
+	hate, spam := queue[0], queue[1]
+	if hate.TargetID != resolution.RID || hate.Resolution == nil || hate.Resolution.Resolution != "Run" || hate.ReportCount != 1 {
+		t.Errorf("first queue entry is %+v, want the resolution", hate)
+	}
+	if spam.TargetID != comment.ID || spam.Comment == nil || spam.Comment.RID != resolution.RID || spam.ReportCount != 2 || spam.Severity != 1 {
+		t.Errorf("second queue entry is %+v, want the comment reported twice", spam)
+	}
+	if len(spam.Reasons) != 2 || !spam.FirstReportedAt.Equal(base) || !spam.LastReportedAt.Equal(base.Add(time.Hour)) {
+		t.Errorf("second queue entry is %+v, want both reasons and the report times", spam)
+	}
+	if spam.TargetUser == nil || spam.TargetUser.Name != "alice" {
+		t.Errorf("second queue entry has target user %+v, want alice", spam.TargetUser)
+	}
+	if comments, _ := repos.Reports.Queue(ctx, QueueQuery{TargetType: models.ReportTargetComment, Limit: 10}); len(comments) != 1 {
+		t.Errorf("Queue of comments returned %d entries, want 1", len(comments))
+	}
+
+	// Closing takes the target out of the queue, automatic reports have no reporter
+	closed, err := repos.Reports.Close(ctx, models.ReportTargetComment, comment.ID, ReportOutcome{Status: models.ReportStatusActioned, Action: "hide", ResolvedBy: carol.ID, At: base})
+	if err != nil || closed != 2 {
+		t.Errorf("Close returned %d, %v, want 2", closed, err)
+	}
+	err = repos.Reports.Insert(ctx, models.Report{
+		ID: primitive.NewObjectID(), TargetType: models.ReportTargetComment, TargetID: comment.ID, TargetUserID: alice.ID,
+		Reason: models.ReportReasonContentPolicy, Severity: 1, Status: models.ReportStatusOpen, CreatedAt: base, UpdatedAt: base,
+	})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	queue, _ = repos.Reports.Queue(ctx, QueueQuery{TargetType: models.ReportTargetComment, Limit: 10})
+	if len(queue) != 1 || queue[0].ReportCount != 1 || queue[0].Reasons[0] != models.ReportReasonContentPolicy {
+		t.Errorf("Queue after closing returned %+v, want only the new report", queue)
+	}
+	if page, _ := repos.Reports.Queue(ctx, QueueQuery{Skip: 1, Limit: 1}); len(page) != 1 || page[0].TargetID != comment.ID {
+		t.Errorf("second page of the queue is %+v, want the comment", page)
+	}
+
+	if err := repos.Warnings.Insert(ctx, models.Warning{UserID: alice.ID, ModeratorID: carol.ID, Reason: "spam", TargetType: models.ReportTargetComment, TargetID: comment.ID, CreatedAt: base}); err != nil {
+		t.Errorf("inserting warning: %v", err)
+	}
+}
+
+func testAuditLogs(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+	insert := func(actor *primitive.ObjectID, action, targetID string, at time.Time) primitive.ObjectID {
+		t.Helper()
+		entry := models.AuditLog{ID: primitive.NewObjectID(), ActorID: actor, Action: action, TargetType: "user", TargetID: targetID, CreatedAt: at}
+		if err := repos.AuditLogs.Insert(ctx, entry); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		return entry.ID
+	}
+	login := insert(&alice, "auth.login", alice.Hex(), base)
+	role := insert(&alice, "user.role_changed", bob.Hex(), base.Add(time.Hour))
+	system := insert(nil, "user.role_changed", alice.Hex(), base.Add(2*time.Hour))
+	ban := insert(&bob, "user.status_changed", alice.Hex(), base.Add(3*time.Hour))
+
+	list := func(query AuditQuery) []primitive.ObjectID {
+		t.Helper()
+		query.Limit = 10
+		entries, err := repos.AuditLogs.List(ctx, query)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		ids := []primitive.ObjectID{}
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	from, to := base.Add(time.Hour), base.Add(2*time.Hour)
+	assertIDs(t, list(AuditQuery{}), []primitive.ObjectID{ban, system, role, login})
+	assertIDs(t, list(AuditQuery{ActorID: &alice}), []primitive.ObjectID{role, login})
+	assertIDs(t, list(AuditQuery{Action: "user.role_changed"}), []primitive.ObjectID{system, role})
+	assertIDs(t, list(AuditQuery{TargetType: "user", TargetID: alice.Hex()}), []primitive.ObjectID{ban, system, login})
+	assertIDs(t, list(AuditQuery{From: &from, To: &to}), []primitive.ObjectID{system, role})
+	assertIDs(t, list(AuditQuery{Skip: 3}), []primitive.ObjectID{login})
+}
+
+func testMagicLinks(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	for _, link := range []models.MagicLink{
+		{ID: "current", Email: "alice@example.com", ExpiresAt: base.Add(15 * time.Minute), CreatedAt: base},
+		{ID: "expired", Email: "alice@example.com", ExpiresAt: base, CreatedAt: base.Add(-15 * time.Minute)},
+	} {
+		if err := repos.MagicLinks.Insert(ctx, link); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	for _, tc := range []struct{ name, id, email string }{
+		{"unknown link", "nope", "alice@example.com"},
+		{"link of another email", "current", "bob@example.com"},
+		{"expired link", "expired", "alice@example.com"},
+	} {
+		if _, err := repos.MagicLinks.Redeem(ctx, tc.id, tc.email, base.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Redeem of the %s returned %v, want ErrNotFound", tc.name, err)
+		}
+	}
+
+	link, err := repos.MagicLinks.Redeem(ctx, "current", "alice@example.com", base.Add(time.Minute))
+	if err != nil || link.Email != "alice@example.com" || link.UsedAt == nil || !link.UsedAt.Equal(base.Add(time.Minute)) {
+		t.Errorf("Redeem returned %+v, %v", link, err)
+	}
+	if _, err := repos.MagicLinks.Redeem(ctx, "current", "alice@example.com", base.Add(2*time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Redeem returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,1818 @@
+package repository
+
+import (
+	"context"
+	"nyr/models"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps everything in maps, the repositories over it behave like the
+// Mongo ones so handlers can be tested without a database
+type memoryStore struct {
+	sync.Mutex
+	users         map[primitive.ObjectID]models.User
+	resolutions   map[primitive.ObjectID]models.Resolution
+	comments      map[primitive.ObjectID]models.Comments
+	reactions     map[primitive.ObjectID]models.Reaction
+	blocks        []models.Block
+	bookmarks     map[primitive.ObjectID]models.Bookmark
+	webhooks      map[primitive.ObjectID]models.Webhook
+	deliveries    map[primitive.ObjectID]models.WebhookDelivery
+	reminders     map[primitive.ObjectID]models.Reminder
+	tokens        map[primitive.ObjectID]models.PersonalAccessToken
+	follows       []models.Follow
+	notifications map[primitive.ObjectID]models.Notification
+	reports       map[primitive.ObjectID]models.Report
+	warnings      []models.Warning
+	auditLogs     []models.AuditLog
+	magicLinks    map[string]models.MagicLink
+}
+
+// NewMemory returns empty repositories that live in memory
+func NewMemory() Repositories {
+	store := &memoryStore{
+		users:         map[primitive.ObjectID]models.User{},
+		resolutions:   map[primitive.ObjectID]models.Resolution{},
+		comments:      map[primitive.ObjectID]models.Comments{},
+		reactions:     map[primitive.ObjectID]models.Reaction{},
+		bookmarks:     map[primitive.ObjectID]models.Bookmark{},
+		webhooks:      map[primitive.ObjectID]models.Webhook{},
+		deliveries:    map[primitive.ObjectID]models.WebhookDelivery{},
+		reminders:     map[primitive.ObjectID]models.Reminder{},
+		tokens:        map[primitive.ObjectID]models.PersonalAccessToken{},
+		notifications: map[primitive.ObjectID]models.Notification{},
+		reports:       map[primitive.ObjectID]models.Report{},
+		magicLinks:    map[string]models.MagicLink{},
+	}
+	return Repositories{
+		Users:         memoryUsers{store},
+		Resolutions:   memoryResolutions{store},
+		Comments:      memoryComments{store},
+		Reactions:     memoryReactions{store},
+		Blocks:        memoryBlocks{store},
+		Bookmarks:     memoryBookmarks{store},
+		Webhooks:      memoryWebhooks{store},
+		Deliveries:    memoryDeliveries{store},
+		Reminders:     memoryReminders{store},
+		Tokens:        memoryTokens{store},
+		Follows:       memoryFollows{store},
+		Notifications: memoryNotifications{store},
+		Reports:       memoryReports{store},
+		Warnings:      memoryWarnings{store},
+		AuditLogs:     memoryAuditLogs{store},
+		MagicLinks:    memoryMagicLinks{store},
+	}
+}
+
+func contains(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// page returns the items of a page like the Mongo skip and limit stages
+func page[T any](items []T, skip, limit int) []T {
+	if skip >= len(items) {
+		return items[:0]
+	}
+	items = items[skip:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// newer orders by creation time and then by ID, newest first like the Mongo sorts
+func newer(aTime, bTime time.Time, aID, bID primitive.ObjectID) bool {
+	if !aTime.Equal(bTime) {
+		return aTime.After(bTime)
+	}
+	return aID.Hex() > bID.Hex()
+}
+
+type memoryUsers struct {
+	store *memoryStore
+}
+
+func (r memoryUsers) Insert(ctx context.Context, user models.User) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	for _, other := range r.store.users {
+		if (user.Email != "" && other.Email == user.Email) || (user.Handle != "" && other.Handle == user.Handle) {
+			return ErrDuplicate
+		}
+	}
+	r.store.users[user.ID] = user
+	return nil
+}
+
+func (r memoryUsers) FindByID(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) FindByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for _, user := range r.store.users {
+		for _, identity := range user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return user, nil
+			}
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) LinkIdentityByEmail(ctx context.Context, email string, identity models.Identity) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	email = models.NormalizeEmail(email)
+	for id, user := range r.store.users {
+		if user.Email == email && (len(user.Identities) == 0 || verifiedBy(user, email)) {
+			if r.store.identityLinked(identity) {
+				return models.User{}, ErrIdentityLinked
+			}
+			user.Identities = append(append([]models.Identity{}, user.Identities...), identity)
+			user.UpdatedAt = time.Now()
+			r.store.users[id] = user
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+// identityLinked reports whether a user has the identity, the caller holds the lock
+func (s *memoryStore) identityLinked(identity models.Identity) bool {
+	for _, user := range s.users {
+		for _, existing := range user.Identities {
+			if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifiedBy reports whether one of the user's identities has the email verified
+func verifiedBy(user models.User, email string) bool {
+	for _, identity := range user.Identities {
+		if identity.Email == email && identity.EmailVerified {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryUsers) AddIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.store.identityLinked(identity) {
+		return ErrIdentityLinked
+	}
+	user.Identities = append(append([]models.Identity{}, user.Identities...), identity)
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) AddSoleIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, existing := range user.Identities {
+		if existing.Provider == identity.Provider {
+			return ErrDuplicate
+		}
+	}
+	if r.store.identityLinked(identity) {
+		return ErrIdentityLinked
+	}
+	user.Identities = append(append([]models.Identity{}, user.Identities...), identity)
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) RemoveIdentity(ctx context.Context, id primitive.ObjectID, provider, subject string) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok || len(user.Identities) < 2 {
+		return false, nil
+	}
+	remaining := []models.Identity{}
+	for _, identity := range user.Identities {
+		if identity.Provider != provider || identity.Subject != subject {
+			remaining = append(remaining, identity)
+		}
+	}
+	if len(remaining) == len(user.Identities) {
+		return false, nil
+	}
+	user.Identities = remaining
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return true, nil
+}
+
+func (r memoryUsers) UpdateName(ctx context.Context, id primitive.ObjectID, name string) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	before, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	after := before
+	after.Name = name
+	r.store.users[id] = after
+	return before, nil
+}
+
+func (r memoryUsers) UpdateHandle(ctx context.Context, id primitive.ObjectID, handle string) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	before, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	for _, user := range r.store.users {
+		if user.ID != id && user.Handle == handle {
+			return models.User{}, ErrDuplicate
+		}
+	}
+	after := before
+	after.Handle = handle
+	after.UpdatedAt = time.Now()
+	r.store.users[id] = after
+	return before, nil
+}
+
+func (r memoryUsers) FindByHandles(ctx context.Context, handles []string) ([]models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	users := []models.User{}
+	for _, user := range r.store.users {
+		for _, handle := range handles {
+			if user.Handle != "" && user.Handle == handle {
+				users = append(users, user)
+			}
+		}
+	}
+	return users, nil
+}
+
+func (r memoryUsers) UpdateEmailPreferences(ctx context.Context, id primitive.ObjectID, preferences map[string]bool) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	merged := map[string]bool{}
+	for category, enabled := range user.EmailPreferences {
+		merged[category] = enabled
+	}
+	for category, enabled := range preferences {
+		merged[category] = enabled
+	}
+	user.EmailPreferences = merged
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return user, nil
+}
+
+func (r memoryUsers) UpdateNotificationPreferences(ctx context.Context, id primitive.ObjectID, preferences map[string]bool) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	merged := map[string]bool{}
+	for notificationType, enabled := range user.NotificationPreferences {
+		merged[notificationType] = enabled
+	}
+	for notificationType, enabled := range preferences {
+		merged[notificationType] = enabled
+	}
+	user.NotificationPreferences = merged
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return user, nil
+}
+
+func (r memoryUsers) UpdateTimezone(ctx context.Context, id primitive.ObjectID, timezone string) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Timezone = timezone
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	users := []models.User{}
+	for _, user := range r.store.users {
+		role := user.Role
+		if role == "" {
+			role = models.RoleUser
+		}
+		if query.Role == "" || role == query.Role {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return newer(users[i].CreatedAt, users[j].CreatedAt, users[i].ID, users[j].ID)
+	})
+	return page(users, query.Skip, query.Limit), nil
+}
+
+func (r memoryUsers) SetRole(ctx context.Context, id primitive.ObjectID, role string) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	before, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	if before.Role == models.RoleAdmin && role != models.RoleAdmin {
+		admins := 0
+		for _, user := range r.store.users {
+			if user.Role == models.RoleAdmin {
+				admins++
+			}
+		}
+		if admins < 2 {
+			return before, ErrLastAdmin
+		}
+	}
+	after := before
+	after.Role = role
+	after.UpdatedAt = time.Now()
+	r.store.users[id] = after
+	return before, nil
+}
+
+func (r memoryUsers) FindBySignUpEmails(ctx context.Context, emails []string) ([]models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	users := []models.User{}
+	for _, user := range r.store.users {
+		if containsString(emails, user.Email) && user.SignedUpVerified() {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r memoryUsers) GrantAdmin(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	user, ok := r.store.users[id]
+	if !ok || user.Role == models.RoleAdmin {
+		return false, nil
+	}
+	user.Role = models.RoleAdmin
+	user.UpdatedAt = time.Now()
+	r.store.users[id] = user
+	return true, nil
+}
+
+func (r memoryUsers) ClaimDigest(ctx context.Context, at time.Time, period time.Duration) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, user := range r.store.users {
+		if !user.EmailPreferences[models.EmailCategoryDigest] ||
+			user.EffectiveStatus(at) != models.UserStatusActive ||
+			(user.LastDigestAt != nil && user.LastDigestAt.After(at.Add(-period))) {
+			continue
+		}
+		user.LastDigestAt = &at
+		r.store.users[id] = user
+		return user, nil
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) UpdateStatus(ctx context.Context, id primitive.ObjectID, update StatusUpdate) (models.User, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	before, ok := r.store.users[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	after := before
+	after.Status = update.Status
+	after.StatusReason = update.Reason
+	after.SuspendedUntil = update.SuspendedUntil
+	after.UpdatedAt = update.At
+	r.store.users[id] = after
+	return before, nil
+}
+
+type memoryResolutions struct {
+	store *memoryStore
+}
+
+func (r memoryResolutions) Insert(ctx context.Context, resolution models.Resolution) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if resolution.AdoptedFrom != nil {
+		for _, existing := range r.store.resolutions {
+			if existing.UserID == resolution.UserID && existing.AdoptedFrom != nil && *existing.AdoptedFrom == *resolution.AdoptedFrom {
+				return ErrDuplicate
+			}
+		}
+	}
+	if resolution.RID.IsZero() {
+		resolution.RID = primitive.NewObjectID()
+	}
+	r.store.resolutions[resolution.RID] = resolution
+	return nil
+}
+
+func (r memoryResolutions) FindAdoption(ctx context.Context, userID, sourceID primitive.ObjectID) (models.Resolution, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for _, resolution := range r.store.resolutions {
+		if resolution.UserID == userID && resolution.AdoptedFrom != nil && *resolution.AdoptedFrom == sourceID {
+			return resolution, nil
+		}
+	}
+	return models.Resolution{}, ErrNotFound
+}
+
+func (r memoryResolutions) Adopters(ctx context.Context, query AdopterQuery) ([]Adopter, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+
+	adopters := []Adopter{}
+	for _, resolution := range r.store.resolutions {
+		if resolution.AdoptedFrom == nil || *resolution.AdoptedFrom != query.RID || resolution.Hidden || contains(query.Viewer.Hidden, resolution.UserID) {
+			continue
+		}
+		adopters = append(adopters, Adopter{
+			RID:        resolution.RID,
+			UserDetail: r.store.author(resolution.UserID),
+			AdoptedAt:  resolution.CreatedAt,
+		})
+	}
+
+	sort.Slice(adopters, func(i, j int) bool {
+		a, b := adopters[i], adopters[j]
+		return newer(a.AdoptedAt, b.AdoptedAt, a.RID, b.RID)
+	})
+
+	adopters = page(adopters, query.Skip, query.Limit)
+	return adopters, nil
+}
+
+func (r memoryResolutions) FindVisible(ctx context.Context, id primitive.ObjectID) (models.Resolution, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	resolution, ok := r.store.resolutions[id]
+	if !ok || resolution.Hidden {
+		return models.Resolution{}, ErrNotFound
+	}
+	return resolution, nil
+}
+
+func (r memoryResolutions) Find(ctx context.Context, id primitive.ObjectID) (models.Resolution, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	resolution, ok := r.store.resolutions[id]
+	if !ok {
+		return models.Resolution{}, ErrNotFound
+	}
+	return resolution, nil
+}
+
+func (r memoryResolutions) Hide(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	resolution, ok := r.store.resolutions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	resolution.Hidden = true
+	resolution.UpdatedAt = at
+	r.store.resolutions[id] = resolution
+	return nil
+}
+
+// summary counts the reactions and visible comments of a resolution, the caller holds the lock
+func (s *memoryStore) summary(resolution models.Resolution) Summary {
+	summary := Summary{
+		ID:          resolution.RID,
+		UserID:      resolution.UserID,
+		Resolution:  resolution.Resolution,
+		Tags:        resolution.Tags,
+		Mentions:    resolution.Mentions,
+		Hidden:      resolution.Hidden,
+		AdoptedFrom: resolution.AdoptedFrom,
+		CreatedAt:   resolution.CreatedAt,
+		UpdatedAt:   resolution.UpdatedAt,
+	}
+	summary.ReactionCounts = s.reactionCounts(models.ReactionTargetResolution, resolution.RID)
+	summary.LikeCount = summary.ReactionCounts[models.ReactionLike]
+	for _, comment := range s.comments {
+		if comment.RID == resolution.RID && !comment.Hidden {
+			summary.CommentCount++
+		}
+	}
+	for _, copied := range s.resolutions {
+		if copied.AdoptedFrom != nil && *copied.AdoptedFrom == resolution.RID && !copied.Hidden {
+			summary.AdoptionCount++
+		}
+	}
+	return summary
+}
+
+func (r memoryResolutions) Feed(ctx context.Context, query FeedQuery) ([]Summary, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+
+	summaries := []Summary{}
+	for _, resolution := range r.store.resolutions {
+		if resolution.Hidden || contains(query.Viewer.Hidden, resolution.UserID) ||
+			(query.Authors != nil && !contains(query.Authors, resolution.UserID)) ||
+			resolution.CreatedAt.Before(query.Since) {
+			continue
+		}
+		summaries = append(summaries, r.store.summary(resolution))
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if query.Sort == SortNewest {
+			return newer(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+		}
+		if a.LikeCount != b.LikeCount {
+			return a.LikeCount > b.LikeCount
+		}
+		return a.ID.Hex() > b.ID.Hex()
+	})
+
+	summaries = page(summaries, query.Skip, query.Limit)
+
+	for i := range summaries {
+		if user, ok := r.store.users[summaries[i].UserID]; ok {
+			summaries[i].UserName = user.Name
+		}
+	}
+	return summaries, nil
+}
+
+func (r memoryResolutions) Activity(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]Activity, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+
+	own := []models.Resolution{}
+	for _, resolution := range r.store.resolutions {
+		if resolution.UserID == userID && !resolution.Hidden {
+			own = append(own, resolution)
+		}
+	}
+	sort.Slice(own, func(i, j int) bool {
+		return newer(own[j].CreatedAt, own[i].CreatedAt, own[j].RID, own[i].RID)
+	})
+
+	activity := []Activity{}
+	for _, resolution := range own {
+		a := Activity{ID: resolution.RID, Resolution: resolution.Resolution}
+		for _, reaction := range r.store.reactions {
+			if reaction.TargetType == models.ReactionTargetResolution && reaction.TargetID == resolution.RID &&
+				reaction.Type == models.ReactionLike && reaction.UserID != userID && !reaction.CreatedAt.Before(since) {
+				a.Likes++
+			}
+		}
+		for _, comment := range r.store.comments {
+			if comment.RID != resolution.RID || comment.CreatedAt.Before(since) {
+				continue
+			}
+			if comment.UserID == userID {
+				a.CheckIns++
+			} else if !comment.Hidden {
+				a.Comments++
+			}
+		}
+		activity = append(activity, a)
+	}
+	return activity, nil
+}
+
+func (r memoryResolutions) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]Summary, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+
+	summaries := []Summary{}
+	for _, resolution := range r.store.resolutions {
+		if resolution.UserID == userID {
+			summaries = append(summaries, r.store.summary(resolution))
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		return newer(b.CreatedAt, a.CreatedAt, b.ID, a.ID)
+	})
+	return summaries, nil
+}
+
+// author returns the public part of a user, the caller holds the lock
+func (s *memoryStore) author(id primitive.ObjectID) *Author {
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	return &Author{ID: user.ID, Name: user.Name, Image: user.Image}
+}
+
+func (r memoryResolutions) Detail(ctx context.Context, id primitive.ObjectID, query DetailQuery) (Detail, error) {
+	viewer := query.Viewer
+	r.store.Lock()
+	defer r.store.Unlock()
+
+	resolution, ok := r.store.resolutions[id]
+	if !ok || resolution.Hidden || contains(viewer.Blockers, resolution.UserID) {
+		return Detail{}, ErrNotFound
+	}
+
+	summary := r.store.summary(resolution)
+	detail := Detail{
+		ID:             resolution.RID,
+		Resolution:     resolution.Resolution,
+		Tags:           resolution.Tags,
+		Mentions:       resolution.Mentions,
+		LikeCount:      summary.LikeCount,
+		ReactionCounts: summary.ReactionCounts,
+		AdoptionCount:  summary.AdoptionCount,
+		AdoptedFrom:    resolution.AdoptedFrom,
+		UserDetail:     r.store.author(resolution.UserID),
+		Comments:       []CommentDetail{},
+		CreatedAt:      resolution.CreatedAt,
+		UpdatedAt:      resolution.UpdatedAt,
+	}
+	if detail.Tags == nil {
+		detail.Tags = []string{}
+	}
+	if resolution.AdoptedFrom != nil {
+		source, ok := r.store.resolutions[*resolution.AdoptedFrom]
+		if ok && !source.Hidden && !contains(viewer.Blockers, source.UserID) {
+			detail.Source = &Source{
+				ID:         source.RID,
+				Resolution: source.Resolution,
+				UserDetail: r.store.author(source.UserID),
+				CreatedAt:  source.CreatedAt,
+			}
+		}
+	}
+
+	for _, comment := range r.store.comments {
+		if comment.RID != id || comment.Hidden || contains(viewer.Hidden, comment.UserID) {
+			continue
+		}
+		counts := r.store.reactionCounts(models.ReactionTargetComment, comment.ID)
+		detail.Comments = append(detail.Comments, CommentDetail{
+			ID:             comment.ID,
+			UserID:         comment.UserID,
+			RID:            comment.RID,
+			Comment:        comment.Comment,
+			Mentions:       comment.Mentions,
+			ParentID:       comment.ParentID,
+			Kind:           comment.Kind,
+			LikeCount:      counts[models.ReactionLike],
+			ReactionCounts: counts,
+			UserDetail:     r.store.author(comment.UserID),
+			CreatedAt:      comment.CreatedAt,
+			UpdatedAt:      comment.UpdatedAt,
+		})
+	}
+	sort.Slice(detail.Comments, func(i, j int) bool {
+		a, b := detail.Comments[i], detail.Comments[j]
+		if query.CommentSort == CommentSortTop {
+			if ra, rb := total(a.ReactionCounts), total(b.ReactionCounts); ra != rb {
+				return ra > rb
+			}
+		}
+		return newer(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	})
+	detail.CommentCount = int64(len(detail.Comments))
+	return detail, nil
+}
+
+type memoryComments struct {
+	store *memoryStore
+}
+
+func (r memoryComments) Insert(ctx context.Context, comment models.Comments) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if comment.ID.IsZero() {
+		comment.ID = primitive.NewObjectID()
+	}
+	r.store.comments[comment.ID] = comment
+	return nil
+}
+
+func (r memoryComments) FindVisible(ctx context.Context, id, rID primitive.ObjectID) (models.Comments, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	comment, ok := r.store.comments[id]
+	if !ok || comment.RID != rID || comment.Hidden {
+		return models.Comments{}, ErrNotFound
+	}
+	return comment, nil
+}
+
+func (r memoryComments) Find(ctx context.Context, id primitive.ObjectID) (models.Comments, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	comment, ok := r.store.comments[id]
+	if !ok {
+		return models.Comments{}, ErrNotFound
+	}
+	return comment, nil
+}
+
+func (r memoryComments) Hide(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	comment, ok := r.store.comments[id]
+	if !ok {
+		return ErrNotFound
+	}
+	comment.Hidden = true
+	comment.UpdatedAt = at
+	r.store.comments[id] = comment
+	return nil
+}
+
+// reactionCounts counts the reactions to a target by type, the caller holds the lock
+func (s *memoryStore) reactionCounts(targetType string, targetID primitive.ObjectID) map[string]int64 {
+	counts := map[string]int64{}
+	for _, reaction := range s.reactions {
+		if reaction.TargetType == targetType && reaction.TargetID == targetID {
+			counts[reaction.Type]++
+		}
+	}
+	return counts
+}
+
+// total adds up reaction counts
+func total(counts map[string]int64) int64 {
+	var sum int64
+	for _, count := range counts {
+		sum += count
+	}
+	return sum
+}
+
+type memoryReactions struct {
+	store *memoryStore
+}
+
+func (r memoryReactions) Set(ctx context.Context, reaction models.Reaction) (string, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, existing := range r.store.reactions {
+		if existing.UserID == reaction.UserID && existing.TargetType == reaction.TargetType && existing.TargetID == reaction.TargetID {
+			previous := existing.Type
+			existing.Type = reaction.Type
+			existing.UpdatedAt = reaction.UpdatedAt
+			r.store.reactions[id] = existing
+			return previous, nil
+		}
+	}
+	if reaction.ID.IsZero() {
+		reaction.ID = primitive.NewObjectID()
+	}
+	r.store.reactions[reaction.ID] = reaction
+	return "", nil
+}
+
+func (r memoryReactions) Remove(ctx context.Context, userID primitive.ObjectID, targetType string, targetID primitive.ObjectID, reactionType string) (string, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, reaction := range r.store.reactions {
+		if reaction.UserID == userID && reaction.TargetType == targetType && reaction.TargetID == targetID &&
+			(reactionType == "" || reaction.Type == reactionType) {
+			delete(r.store.reactions, id)
+			return reaction.Type, nil
+		}
+	}
+	return "", nil
+}
+
+func (r memoryReactions) Counts(ctx context.Context, targetType string, targetID primitive.ObjectID) (map[string]int64, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	return r.store.reactionCounts(targetType, targetID), nil
+}
+
+func (r memoryReactions) ByUser(ctx context.Context, userID primitive.ObjectID, targetType string, targetIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	reactions := map[primitive.ObjectID]string{}
+	for _, reaction := range r.store.reactions {
+		if reaction.UserID == userID && reaction.TargetType == targetType && contains(targetIDs, reaction.TargetID) {
+			reactions[reaction.TargetID] = reaction.Type
+		}
+	}
+	return reactions, nil
+}
+
+type memoryBlocks struct {
+	store *memoryStore
+}
+
+func (r memoryBlocks) Add(ctx context.Context, block models.Block) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for _, b := range r.store.blocks {
+		if b.UserID == block.UserID && b.TargetID == block.TargetID && b.Kind == block.Kind {
+			return false, nil
+		}
+	}
+	if block.ID.IsZero() {
+		block.ID = primitive.NewObjectID()
+	}
+	r.store.blocks = append(r.store.blocks, block)
+	return true, nil
+}
+
+func (r memoryBlocks) Remove(ctx context.Context, userID, targetID primitive.ObjectID, kind string) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for i, b := range r.store.blocks {
+		if b.UserID == userID && b.TargetID == targetID && b.Kind == kind {
+			r.store.blocks = append(r.store.blocks[:i], r.store.blocks[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryBlocks) Exists(ctx context.Context, userID, targetID primitive.ObjectID, kind string) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for _, b := range r.store.blocks {
+		if b.UserID == userID && b.TargetID == targetID && b.Kind == kind {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryBlocks) Involving(ctx context.Context, userID primitive.ObjectID) ([]models.Block, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	blocks := []models.Block{}
+	for _, b := range r.store.blocks {
+		if b.UserID == userID || (b.TargetID == userID && b.Kind == models.BlockKindBlock) {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks, nil
+}
+
+func (r memoryBlocks) List(ctx context.Context, userID primitive.ObjectID, kind string) ([]BlockSummary, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	blocks := []BlockSummary{}
+	for _, b := range r.store.blocks {
+		if b.UserID == userID && (kind == "" || b.Kind == kind) {
+			blocks = append(blocks, BlockSummary{
+				ID:         b.ID,
+				TargetID:   b.TargetID,
+				Kind:       b.Kind,
+				CreatedAt:  b.CreatedAt,
+				UserDetail: r.store.author(b.TargetID),
+			})
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return newer(blocks[i].CreatedAt, blocks[j].CreatedAt, blocks[i].ID, blocks[j].ID)
+	})
+	return blocks, nil
+}
+
+type memoryBookmarks struct {
+	store *memoryStore
+}
+
+func (r memoryBookmarks) Set(ctx context.Context, bookmark models.Bookmark) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, existing := range r.store.bookmarks {
+		if existing.UserID == bookmark.UserID && existing.RID == bookmark.RID {
+			existing.Collection = bookmark.Collection
+			existing.UpdatedAt = bookmark.UpdatedAt
+			r.store.bookmarks[id] = existing
+			return false, nil
+		}
+	}
+	if bookmark.ID.IsZero() {
+		bookmark.ID = primitive.NewObjectID()
+	}
+	r.store.bookmarks[bookmark.ID] = bookmark
+	return true, nil
+}
+
+func (r memoryBookmarks) Remove(ctx context.Context, userID, rID primitive.ObjectID) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, bookmark := range r.store.bookmarks {
+		if bookmark.UserID == userID && bookmark.RID == rID {
+			delete(r.store.bookmarks, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryBookmarks) List(ctx context.Context, query BookmarkQuery) ([]BookmarkSummary, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+
+	bookmarks := []BookmarkSummary{}
+	for _, bookmark := range r.store.bookmarks {
+		if bookmark.UserID != query.UserID || (query.Collection != "" && bookmark.Collection != query.Collection) {
+			continue
+		}
+		resolution, ok := r.store.resolutions[bookmark.RID]
+		if !ok || resolution.Hidden || contains(query.Viewer.Hidden, resolution.UserID) {
+			continue
+		}
+		bookmarks = append(bookmarks, BookmarkSummary{
+			ID:         bookmark.ID,
+			Collection: bookmark.Collection,
+			CreatedAt:  bookmark.CreatedAt,
+			Resolution: r.store.summary(resolution),
+		})
+	}
+
+	sort.Slice(bookmarks, func(i, j int) bool {
+		a, b := bookmarks[i], bookmarks[j]
+		return newer(a.CreatedAt, b.CreatedAt, a.ID, b.ID)
+	})
+
+	bookmarks = page(bookmarks, query.Skip, query.Limit)
+
+	for i := range bookmarks {
+		if user, ok := r.store.users[bookmarks[i].Resolution.UserID]; ok {
+			bookmarks[i].Resolution.UserName = user.Name
+		}
+	}
+	return bookmarks, nil
+}
+
+func (r memoryBookmarks) Collections(ctx context.Context, userID primitive.ObjectID) ([]CollectionCount, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	counts := map[string]int64{}
+	for _, bookmark := range r.store.bookmarks {
+		if bookmark.UserID == userID && bookmark.Collection != "" {
+			counts[bookmark.Collection]++
+		}
+	}
+
+	collections := []CollectionCount{}
+	for name, count := range counts {
+		collections = append(collections, CollectionCount{Name: name, Count: count})
+	}
+	sort.Slice(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+	return collections, nil
+}
+
+func (r memoryBookmarks) BookmarkedBy(ctx context.Context, userID primitive.ObjectID, rIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	bookmarked := map[primitive.ObjectID]bool{}
+	for _, bookmark := range r.store.bookmarks {
+		if bookmark.UserID == userID && contains(rIDs, bookmark.RID) {
+			bookmarked[bookmark.RID] = true
+		}
+	}
+	return bookmarked, nil
+}
+
+// sameOwner compares webhook owners, nil is the owner of the global webhooks
+func sameOwner(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+type memoryWebhooks struct {
+	store *memoryStore
+}
+
+func (r memoryWebhooks) Insert(ctx context.Context, hook models.Webhook) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if hook.ID.IsZero() {
+		hook.ID = primitive.NewObjectID()
+	}
+	hook.Events = append([]string{}, hook.Events...)
+	r.store.webhooks[hook.ID] = hook
+	return nil
+}
+
+func (r memoryWebhooks) List(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hooks := []models.Webhook{}
+	for _, hook := range r.store.webhooks {
+		if sameOwner(hook.UserID, owner) {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return newer(hooks[i].CreatedAt, hooks[j].CreatedAt, hooks[i].ID, hooks[j].ID)
+	})
+	return hooks, nil
+}
+
+func (r memoryWebhooks) Count(ctx context.Context, owner *primitive.ObjectID) (int64, error) {
+	hooks, err := r.List(ctx, owner)
+	return int64(len(hooks)), err
+}
+
+func (r memoryWebhooks) Find(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID) (models.Webhook, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hook, ok := r.store.webhooks[id]
+	if !ok || !sameOwner(hook.UserID, owner) {
+		return models.Webhook{}, ErrNotFound
+	}
+	return hook, nil
+}
+
+func (r memoryWebhooks) FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hook, ok := r.store.webhooks[id]
+	if !ok {
+		return models.Webhook{}, ErrNotFound
+	}
+	return hook, nil
+}
+
+func (r memoryWebhooks) Update(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID, update WebhookUpdate) (models.Webhook, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hook, ok := r.store.webhooks[id]
+	if !ok || !sameOwner(hook.UserID, owner) {
+		return models.Webhook{}, ErrNotFound
+	}
+	hook.UpdatedAt = update.At
+	if update.URL != nil {
+		hook.URL = *update.URL
+	}
+	if update.Events != nil {
+		hook.Events = append([]string{}, update.Events...)
+	}
+	if update.Active != nil {
+		hook.Active = *update.Active
+		if hook.Active {
+			hook.ConsecutiveFailures = 0
+			hook.DisabledAt = nil
+		} else {
+			at := update.At
+			hook.DisabledAt = &at
+		}
+	}
+	r.store.webhooks[id] = hook
+	return hook, nil
+}
+
+func (r memoryWebhooks) Delete(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hook, ok := r.store.webhooks[id]
+	if !ok || !sameOwner(hook.UserID, owner) {
+		return false, nil
+	}
+	delete(r.store.webhooks, id)
+	return true, nil
+}
+
+func (r memoryWebhooks) Subscribed(ctx context.Context, event string, userID primitive.ObjectID) ([]models.Webhook, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hooks := []models.Webhook{}
+	for _, hook := range r.store.webhooks {
+		if !hook.Active || !(hook.UserID == nil || *hook.UserID == userID) {
+			continue
+		}
+		for _, e := range hook.Events {
+			if e == event {
+				hooks = append(hooks, hook)
+				break
+			}
+		}
+	}
+	return hooks, nil
+}
+
+func (r memoryWebhooks) RecordFailure(ctx context.Context, id primitive.ObjectID, disableAfter int, now time.Time) (models.Webhook, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	hook, ok := r.store.webhooks[id]
+	if !ok {
+		return models.Webhook{}, ErrNotFound
+	}
+	hook.ConsecutiveFailures++
+	if hook.Active && hook.ConsecutiveFailures >= disableAfter {
+		hook.Active = false
+		hook.DisabledAt = &now
+		hook.UpdatedAt = now
+	}
+	r.store.webhooks[id] = hook
+	return hook, nil
+}
+
+func (r memoryWebhooks) ResetFailures(ctx context.Context, id primitive.ObjectID) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if hook, ok := r.store.webhooks[id]; ok {
+		hook.ConsecutiveFailures = 0
+		r.store.webhooks[id] = hook
+	}
+	return nil
+}
+
+type memoryDeliveries struct {
+	store *memoryStore
+}
+
+func (r memoryDeliveries) Insert(ctx context.Context, delivery models.WebhookDelivery) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if delivery.ID.IsZero() {
+		delivery.ID = primitive.NewObjectID()
+	}
+	r.store.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (r memoryDeliveries) Find(ctx context.Context, id, webhookID primitive.ObjectID) (models.WebhookDelivery, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	delivery, ok := r.store.deliveries[id]
+	if !ok || delivery.WebhookID != webhookID {
+		return models.WebhookDelivery{}, ErrNotFound
+	}
+	return delivery, nil
+}
+
+func (r memoryDeliveries) List(ctx context.Context, query DeliveryQuery) ([]models.WebhookDelivery, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range r.store.deliveries {
+		if delivery.WebhookID == query.WebhookID && (query.Status == "" || delivery.Status == query.Status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return newer(deliveries[i].CreatedAt, deliveries[j].CreatedAt, deliveries[i].ID, deliveries[j].ID)
+	})
+
+	deliveries = page(deliveries, query.Skip, query.Limit)
+	return deliveries, nil
+}
+
+func (r memoryDeliveries) Claim(ctx context.Context, now, leaseUntil time.Time) (models.WebhookDelivery, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	var due *models.WebhookDelivery
+	for _, delivery := range r.store.deliveries {
+		if delivery.Status != models.WebhookDeliveryPending || delivery.NextAttemptAt == nil || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		if due == nil || delivery.NextAttemptAt.Before(*due.NextAttemptAt) {
+			d := delivery
+			due = &d
+		}
+	}
+	if due == nil {
+		return models.WebhookDelivery{}, ErrNotFound
+	}
+	claimed := *due
+	claimed.NextAttemptAt = &leaseUntil
+	r.store.deliveries[claimed.ID] = claimed
+	// Like the Mongo update, the claimed delivery is returned as it was before
+	return *due, nil
+}
+
+func (r memoryDeliveries) Save(ctx context.Context, delivery models.WebhookDelivery) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if _, ok := r.store.deliveries[delivery.ID]; !ok {
+		return ErrNotFound
+	}
+	r.store.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (r memoryDeliveries) DeleteByWebhook(ctx context.Context, webhookID primitive.ObjectID) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, delivery := range r.store.deliveries {
+		if delivery.WebhookID == webhookID {
+			delete(r.store.deliveries, id)
+		}
+	}
+	return nil
+}
+
+type memoryReminders struct {
+	store *memoryStore
+}
+
+func (r memoryReminders) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Reminder, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	reminders := []models.Reminder{}
+	for _, reminder := range r.store.reminders {
+		if reminder.UserID == userID {
+			reminders = append(reminders, reminder)
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool {
+		a, b := reminders[i], reminders[j]
+		if !a.NextRunAt.Equal(b.NextRunAt) {
+			return a.NextRunAt.Before(b.NextRunAt)
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	})
+	return reminders, nil
+}
+
+func (r memoryReminders) Set(ctx context.Context, reminder models.Reminder) (models.Reminder, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	reminder.ID = primitive.NewObjectID()
+	for _, existing := range r.store.reminders {
+		if existing.UserID == reminder.UserID && existing.RID == reminder.RID {
+			reminder.ID = existing.ID
+			reminder.CreatedAt = existing.CreatedAt
+			reminder.LastSentAt = existing.LastSentAt
+		}
+	}
+	r.store.reminders[reminder.ID] = reminder
+	return reminder, nil
+}
+
+func (r memoryReminders) Delete(ctx context.Context, userID, rID primitive.ObjectID) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, reminder := range r.store.reminders {
+		if reminder.UserID == userID && reminder.RID == rID {
+			delete(r.store.reminders, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryReminders) Reschedule(ctx context.Context, id primitive.ObjectID, timezone string, nextRunAt time.Time) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	reminder, ok := r.store.reminders[id]
+	if !ok {
+		return nil
+	}
+	reminder.Timezone = timezone
+	reminder.NextRunAt = nextRunAt
+	reminder.UpdatedAt = time.Now()
+	r.store.reminders[id] = reminder
+	return nil
+}
+
+func (r memoryReminders) Due(ctx context.Context, at time.Time) (models.Reminder, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	var due *models.Reminder
+	for _, reminder := range r.store.reminders {
+		if reminder.NextRunAt.After(at) {
+			continue
+		}
+		if due == nil || reminder.NextRunAt.Before(due.NextRunAt) {
+			reminder := reminder
+			due = &reminder
+		}
+	}
+	if due == nil {
+		return models.Reminder{}, ErrNotFound
+	}
+	return *due, nil
+}
+
+func (r memoryReminders) Advance(ctx context.Context, reminder models.Reminder, nextRunAt, sentAt time.Time) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	stored, ok := r.store.reminders[reminder.ID]
+	if !ok || !stored.NextRunAt.Equal(reminder.NextRunAt) {
+		return false, nil
+	}
+	stored.NextRunAt = nextRunAt
+	stored.LastSentAt = &sentAt
+	r.store.reminders[reminder.ID] = stored
+	return true, nil
+}
+
+type memoryMagicLinks struct {
+	store *memoryStore
+}
+
+func (r memoryMagicLinks) Insert(ctx context.Context, link models.MagicLink) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	r.store.magicLinks[link.ID] = link
+	return nil
+}
+
+func (r memoryMagicLinks) Redeem(ctx context.Context, id, email string, at time.Time) (models.MagicLink, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	link, ok := r.store.magicLinks[id]
+	if !ok || link.Email != email || link.UsedAt != nil || !link.ExpiresAt.After(at) {
+		return models.MagicLink{}, ErrNotFound
+	}
+	link.UsedAt = &at
+	r.store.magicLinks[id] = link
+	return link, nil
+}
+
+type memoryTokens struct {
+	store *memoryStore
+}
+
+func (r memoryTokens) Insert(ctx context.Context, token models.PersonalAccessToken) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	r.store.tokens[token.ID] = token
+	return nil
+}
+
+func (r memoryTokens) List(ctx context.Context, userID primitive.ObjectID) ([]models.PersonalAccessToken, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	tokens := []models.PersonalAccessToken{}
+	for _, token := range r.store.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return newer(tokens[i].CreatedAt, tokens[j].CreatedAt, tokens[i].ID, tokens[j].ID)
+	})
+	return tokens, nil
+}
+
+func (r memoryTokens) CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	var count int64
+	for _, token := range r.store.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryTokens) Revoke(ctx context.Context, id, userID primitive.ObjectID, at time.Time) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	token, ok := r.store.tokens[id]
+	if !ok || token.UserID != userID || token.RevokedAt != nil {
+		return false, nil
+	}
+	token.RevokedAt = &at
+	r.store.tokens[id] = token
+	return true, nil
+}
+
+func (r memoryTokens) FindActive(ctx context.Context, hash string) (models.PersonalAccessToken, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for _, token := range r.store.tokens {
+		if token.TokenHash == hash && token.RevokedAt == nil {
+			return token, nil
+		}
+	}
+	return models.PersonalAccessToken{}, ErrNotFound
+}
+
+func (r memoryTokens) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if token, ok := r.store.tokens[id]; ok {
+		token.LastUsedAt = &at
+		r.store.tokens[id] = token
+	}
+	return nil
+}
+
+type memoryFollows struct {
+	store *memoryStore
+}
+
+func (r memoryFollows) Add(ctx context.Context, follow models.Follow) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for _, f := range r.store.follows {
+		if f.UserID == follow.UserID && f.TargetID == follow.TargetID {
+			return false, nil
+		}
+	}
+	if follow.ID.IsZero() {
+		follow.ID = primitive.NewObjectID()
+	}
+	r.store.follows = append(r.store.follows, follow)
+	return true, nil
+}
+
+func (r memoryFollows) Remove(ctx context.Context, userID, targetID primitive.ObjectID) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for i, f := range r.store.follows {
+		if f.UserID == userID && f.TargetID == targetID {
+			r.store.follows = append(r.store.follows[:i], r.store.follows[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryFollows) Following(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	ids := []primitive.ObjectID{}
+	for _, f := range r.store.follows {
+		if f.UserID == userID {
+			ids = append(ids, f.TargetID)
+		}
+	}
+	return ids, nil
+}
+
+func (r memoryFollows) RemoveBetween(ctx context.Context, a, b primitive.ObjectID) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	remaining := []models.Follow{}
+	for _, f := range r.store.follows {
+		if (f.UserID != a || f.TargetID != b) && (f.UserID != b || f.TargetID != a) {
+			remaining = append(remaining, f)
+		}
+	}
+	r.store.follows = remaining
+	return nil
+}
+
+type memoryNotifications struct {
+	store *memoryStore
+}
+
+// unread returns the user's unread notification of the group, the caller holds the lock
+func (s *memoryStore) unread(userID primitive.ObjectID, groupKey string) (models.Notification, bool) {
+	for _, n := range s.notifications {
+		if n.UserID == userID && n.GroupKey == groupKey && !n.Read {
+			return n, true
+		}
+	}
+	return models.Notification{}, false
+}
+
+func (r memoryNotifications) Add(ctx context.Context, notification models.Notification) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	existing, ok := r.store.unread(notification.UserID, notification.GroupKey)
+	if !ok {
+		if notification.ID.IsZero() {
+			notification.ID = primitive.NewObjectID()
+		}
+		notification.ActorIDs = []primitive.ObjectID{notification.LastActorID}
+		r.store.notifications[notification.ID] = notification
+		return nil
+	}
+
+	existing.Type = notification.Type
+	existing.LastActorID = notification.LastActorID
+	existing.UpdatedAt = notification.UpdatedAt
+	if notification.ResolutionID != nil {
+		existing.ResolutionID = notification.ResolutionID
+	}
+	if notification.CommentID != nil {
+		existing.CommentID = notification.CommentID
+	}
+	if !contains(existing.ActorIDs, notification.LastActorID) {
+		existing.ActorIDs = append(append([]primitive.ObjectID{}, existing.ActorIDs...), notification.LastActorID)
+	}
+	r.store.notifications[existing.ID] = existing
+	return nil
+}
+
+func (r memoryNotifications) Retract(ctx context.Context, userID primitive.ObjectID, groupKey string, actorID primitive.ObjectID) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	existing, ok := r.store.unread(userID, groupKey)
+	if !ok {
+		return nil
+	}
+	actors := []primitive.ObjectID{}
+	for _, id := range existing.ActorIDs {
+		if id != actorID {
+			actors = append(actors, id)
+		}
+	}
+	if len(actors) == 0 {
+		delete(r.store.notifications, existing.ID)
+		return nil
+	}
+	existing.ActorIDs = actors
+	if existing.LastActorID == actorID {
+		existing.LastActorID = actors[len(actors)-1]
+	}
+	r.store.notifications[existing.ID] = existing
+	return nil
+}
+
+func (r memoryNotifications) List(ctx context.Context, query NotificationQuery) ([]NotificationSummary, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	notifications := []NotificationSummary{}
+	for _, n := range r.store.notifications {
+		if n.UserID == query.UserID && !(query.UnreadOnly && n.Read) {
+			notifications = append(notifications, NotificationSummary{Notification: n})
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i], notifications[j]
+		return newer(a.UpdatedAt, b.UpdatedAt, a.ID, b.ID)
+	})
+	notifications = page(notifications, query.Skip, query.Limit)
+	for i := range notifications {
+		notifications[i].Actor = r.store.author(notifications[i].LastActorID)
+	}
+	return notifications, nil
+}
+
+func (r memoryNotifications) CountUnread(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	var count int64
+	for _, n := range r.store.notifications {
+		if n.UserID == userID && !n.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryNotifications) MarkRead(ctx context.Context, id, userID primitive.ObjectID, at time.Time) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	n, ok := r.store.notifications[id]
+	if !ok || n.UserID != userID {
+		return false, nil
+	}
+	n.Read = true
+	n.ReadAt = &at
+	r.store.notifications[id] = n
+	return true, nil
+}
+
+func (r memoryNotifications) MarkAllRead(ctx context.Context, userID primitive.ObjectID, at time.Time) (int64, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	var count int64
+	for id, n := range r.store.notifications {
+		if n.UserID == userID && !n.Read {
+			n.Read = true
+			n.ReadAt = &at
+			r.store.notifications[id] = n
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryReports struct {
+	store *memoryStore
+}
+
+func (r memoryReports) Open(ctx context.Context, report models.Report) (bool, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	for id, existing := range r.store.reports {
+		if existing.ReporterID == report.ReporterID && existing.TargetType == report.TargetType &&
+			existing.TargetID == report.TargetID && existing.Status == models.ReportStatusOpen {
+			existing.Reason = report.Reason
+			existing.Details = report.Details
+			existing.Severity = report.Severity
+			existing.UpdatedAt = report.UpdatedAt
+			r.store.reports[id] = existing
+			return false, nil
+		}
+	}
+	if report.ID.IsZero() {
+		report.ID = primitive.NewObjectID()
+	}
+	report.Status = models.ReportStatusOpen
+	r.store.reports[report.ID] = report
+	return true, nil
+}
+
+func (r memoryReports) Insert(ctx context.Context, report models.Report) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if report.ID.IsZero() {
+		report.ID = primitive.NewObjectID()
+	}
+	r.store.reports[report.ID] = report
+	return nil
+}
+
+func (r memoryReports) Queue(ctx context.Context, query QueueQuery) ([]QueueEntry, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	entries := map[primitive.ObjectID]*QueueEntry{}
+	targetUsers := map[primitive.ObjectID]primitive.ObjectID{}
+	for _, report := range r.store.reports {
+		if report.Status != models.ReportStatusOpen || (query.TargetType != "" && report.TargetType != query.TargetType) {
+			continue
+		}
+		entry, ok := entries[report.TargetID]
+		if !ok {
+			entry = &QueueEntry{
+				TargetType:      report.TargetType,
+				TargetID:        report.TargetID,
+				Reasons:         []string{},
+				FirstReportedAt: report.CreatedAt,
+				LastReportedAt:  report.UpdatedAt,
+			}
+			entries[report.TargetID] = entry
+			targetUsers[report.TargetID] = report.TargetUserID
+		}
+		entry.ReportCount++
+		if report.Severity > entry.Severity {
+			entry.Severity = report.Severity
+		}
+		if !containsString(entry.Reasons, report.Reason) {
+			entry.Reasons = append(entry.Reasons, report.Reason)
+		}
+		if report.CreatedAt.Before(entry.FirstReportedAt) {
+			entry.FirstReportedAt = report.CreatedAt
+		}
+		if report.UpdatedAt.After(entry.LastReportedAt) {
+			entry.LastReportedAt = report.UpdatedAt
+		}
+	}
+
+	queue := []QueueEntry{}
+	for _, entry := range entries {
+		queue = append(queue, *entry)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := queue[i], queue[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.ReportCount != b.ReportCount {
+			return a.ReportCount > b.ReportCount
+		}
+		if !a.FirstReportedAt.Equal(b.FirstReportedAt) {
+			return a.FirstReportedAt.Before(b.FirstReportedAt)
+		}
+		return a.TargetID.Hex() < b.TargetID.Hex()
+	})
+	queue = page(queue, query.Skip, query.Limit)
+
+	for i := range queue {
+		if resolution, ok := r.store.resolutions[queue[i].TargetID]; ok {
+			queue[i].Resolution = &QueuedResolution{ID: resolution.RID, Resolution: resolution.Resolution, Hidden: resolution.Hidden}
+		}
+		if comment, ok := r.store.comments[queue[i].TargetID]; ok {
+			queue[i].Comment = &QueuedComment{ID: comment.ID, Comment: comment.Comment, RID: comment.RID, Hidden: comment.Hidden}
+		}
+		if user, ok := r.store.users[targetUsers[queue[i].TargetID]]; ok {
+			queue[i].TargetUser = &QueuedUser{ID: user.ID, Name: user.Name, Image: user.Image, Status: user.Status, SuspendedUntil: user.SuspendedUntil}
+		}
+	}
+	return queue, nil
+}
+
+func (r memoryReports) Close(ctx context.Context, targetType string, targetID primitive.ObjectID, outcome ReportOutcome) (int64, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	var count int64
+	for id, report := range r.store.reports {
+		if report.TargetType != targetType || report.TargetID != targetID || report.Status != models.ReportStatusOpen {
+			continue
+		}
+		resolvedBy, at := outcome.ResolvedBy, outcome.At
+		report.Status = outcome.Status
+		report.Action = outcome.Action
+		report.ResolvedBy = &resolvedBy
+		report.ResolvedAt = &at
+		report.UpdatedAt = at
+		r.store.reports[id] = report
+		count++
+	}
+	return count, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryWarnings struct {
+	store *memoryStore
+}
+
+func (r memoryWarnings) Insert(ctx context.Context, warning models.Warning) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if warning.ID.IsZero() {
+		warning.ID = primitive.NewObjectID()
+	}
+	r.store.warnings = append(r.store.warnings, warning)
+	return nil
+}
+
+type memoryAuditLogs struct {
+	store *memoryStore
+}
+
+func (r memoryAuditLogs) Insert(ctx context.Context, entry models.AuditLog) error {
+	r.store.Lock()
+	defer r.store.Unlock()
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	r.store.auditLogs = append(r.store.auditLogs, entry)
+	return nil
+}
+
+func (r memoryAuditLogs) List(ctx context.Context, query AuditQuery) ([]models.AuditLog, error) {
+	r.store.Lock()
+	defer r.store.Unlock()
+	entries := []models.AuditLog{}
+	for _, entry := range r.store.auditLogs {
+		switch {
+		case query.ActorID != nil && (entry.ActorID == nil || *entry.ActorID != *query.ActorID),
+			query.TargetType != "" && entry.TargetType != query.TargetType,
+			query.TargetID != "" && entry.TargetID != query.TargetID,
+			query.Action != "" && entry.Action != query.Action,
+			query.From != nil && entry.CreatedAt.Before(*query.From),
+			query.To != nil && entry.CreatedAt.After(*query.To):
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return newer(entries[i].CreatedAt, entries[j].CreatedAt, entries[i].ID, entries[j].ID)
+	})
+	return page(entries, query.Skip, query.Limit), nil
+}
@@ -0,0 +1,7 @@
+package repository
+
+import "testing"
+
+func TestMemoryContract(t *testing.T) {
+	testContract(t, func(t *testing.T) Repositories { return NewMemory() })
+}
@@ -0,0 +1,1807 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"nyr/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongo returns repositories backed by the collections of the database
+func NewMongo(database *mongo.Database) Repositories {
+	return Repositories{
+		Users:         mongoUsers{database.Collection("users")},
+		Resolutions:   mongoResolutions{database.Collection("resolutions")},
+		Comments:      mongoComments{database.Collection("comments")},
+		Reactions:     mongoReactions{database.Collection("reactions")},
+		Blocks:        mongoBlocks{database.Collection("blocks")},
+		Bookmarks:     mongoBookmarks{database.Collection("bookmarks")},
+		Webhooks:      mongoWebhooks{database.Collection("webhooks")},
+		Deliveries:    mongoDeliveries{database.Collection("webhook_deliveries")},
+		Reminders:     mongoReminders{database.Collection("reminders")},
+		Tokens:        mongoTokens{database.Collection("personal_access_tokens")},
+		Follows:       mongoFollows{database.Collection("follows")},
+		Notifications: mongoNotifications{database.Collection("notifications")},
+		Reports:       mongoReports{database.Collection("reports")},
+		Warnings:      mongoWarnings{database.Collection("warnings")},
+		AuditLogs:     mongoAuditLogs{database.Collection("audit_logs")},
+		MagicLinks:    mongoMagicLinks{database.Collection("magic_links")},
+	}
+}
+
+// notFound turns the driver's missing document error into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+type mongoUsers struct {
+	collection *mongo.Collection
+}
+
+func (r mongoUsers) Insert(ctx context.Context, user models.User) error {
+	_, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r mongoUsers) FindByID(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	return user, notFound(err)
+}
+
+func (r mongoUsers) FindByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	return user, notFound(err)
+}
+
+func (r mongoUsers) LinkIdentityByEmail(ctx context.Context, email string, identity models.Identity) (models.User, error) {
+	email = models.NormalizeEmail(email)
+	var user models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"email": email, "$or": []bson.M{
+			{"identities": bson.M{"$elemMatch": bson.M{"email": email, "email_verified": true}}},
+			// Accounts from before identities were stored signed up with a verified Google address
+			{"identities": bson.M{"$exists": false}},
+			{"identities": bson.M{"$size": 0}},
+		}},
+		bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) {
+		return user, ErrIdentityLinked
+	}
+	return user, notFound(err)
+}
+
+func (r mongoUsers) AddIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityLinked
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r mongoUsers) AddSoleIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "identities.provider": bson.M{"$ne": identity.Provider}},
+		bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	// The unique index settles two users linking the same identity at once
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityLinked
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	// Nothing matched, either the user is missing or already has an identity at the provider
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrDuplicate
+}
+
+func (r mongoUsers) RemoveIdentity(ctx context.Context, id primitive.ObjectID, provider, subject string) (bool, error) {
+	// Matching on the size of the array makes the check and the removal atomic
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{
+			"_id":          id,
+			"identities":   bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+			"identities.1": bson.M{"$exists": true},
+		},
+		bson.M{
+			"$pull": bson.M{"identities": bson.M{"provider": provider, "subject": subject}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r mongoUsers) UpdateName(ctx context.Context, id primitive.ObjectID, name string) (models.User, error) {
+	var before models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"name": name}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	return before, notFound(err)
+}
+
+func (r mongoUsers) UpdateHandle(ctx context.Context, id primitive.ObjectID, handle string) (models.User, error) {
+	var before models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"handle": handle, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if mongo.IsDuplicateKeyError(err) {
+		return before, ErrDuplicate
+	}
+	return before, notFound(err)
+}
+
+func (r mongoUsers) FindByHandles(ctx context.Context, handles []string) ([]models.User, error) {
+	users := []models.User{}
+	if len(handles) == 0 {
+		return users, nil
+	}
+	cursor, err := r.collection.Find(ctx, bson.M{"handle": bson.M{"$in": handles}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r mongoUsers) UpdateEmailPreferences(ctx context.Context, id primitive.ObjectID, preferences map[string]bool) (models.User, error) {
+	set := bson.M{"updated_at": time.Now()}
+	for category, enabled := range preferences {
+		set["email_preferences."+category] = enabled
+	}
+	var user models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	return user, notFound(err)
+}
+
+func (r mongoUsers) UpdateNotificationPreferences(ctx context.Context, id primitive.ObjectID, preferences map[string]bool) (models.User, error) {
+	set := bson.M{"updated_at": time.Now()}
+	for notificationType, enabled := range preferences {
+		set["notification_preferences."+notificationType] = enabled
+	}
+	var user models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	return user, notFound(err)
+}
+
+func (r mongoUsers) UpdateTimezone(ctx context.Context, id primitive.ObjectID, timezone string) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"timezone": timezone, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r mongoUsers) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	filter := bson.M{}
+	if query.Role == models.RoleUser {
+		filter["role"] = bson.M{"$in": []interface{}{nil, models.RoleUser}}
+	} else if query.Role != "" {
+		filter["role"] = query.Role
+	}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(query.Skip)).
+		SetLimit(int64(query.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r mongoUsers) SetRole(ctx context.Context, id primitive.ObjectID, role string) (models.User, error) {
+	var before models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err != nil || before.Role != models.RoleAdmin || role == models.RoleAdmin {
+		return before, notFound(err)
+	}
+
+	// Counting after the update, rather than before, means two admins demoting each other at
+	// the same time can't both succeed. The demotion is undone when no admin is left.
+	admins, err := r.collection.CountDocuments(ctx, bson.M{"role": models.RoleAdmin})
+	if err == nil && admins > 0 {
+		return before, nil
+	}
+	if _, restoreErr := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "role": role},
+		bson.M{"$set": bson.M{"role": models.RoleAdmin, "updated_at": time.Now()}},
+	); restoreErr != nil {
+		return before, restoreErr
+	}
+	if err != nil {
+		return before, err
+	}
+	return before, ErrLastAdmin
+}
+
+func (r mongoUsers) FindBySignUpEmails(ctx context.Context, emails []string) ([]models.User, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"email": bson.M{"$in": emails}, "identities.0.email_verified": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []models.User
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	// The first identity's email has to be the user's own, which a query can't compare
+	users := []models.User{}
+	for _, user := range found {
+		if user.SignedUpVerified() {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r mongoUsers) GrantAdmin(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "role": bson.M{"$ne": models.RoleAdmin}},
+		bson.M{"$set": bson.M{"role": models.RoleAdmin, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r mongoUsers) ClaimDigest(ctx context.Context, at time.Time, period time.Duration) (models.User, error) {
+	var user models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"email_preferences." + models.EmailCategoryDigest: true,
+			"status": bson.M{"$ne": models.UserStatusBanned},
+			// Like EffectiveStatus, a suspension that has run out doesn't count
+			"$and": []bson.M{
+				{"$or": []bson.M{
+					{"status": bson.M{"$ne": models.UserStatusSuspended}},
+					{"suspended_until": bson.M{"$not": bson.M{"$gt": at}}},
+				}},
+				{"$or": []bson.M{
+					{"last_digest_at": bson.M{"$exists": false}},
+					{"last_digest_at": bson.M{"$lte": at.Add(-period)}},
+				}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_digest_at": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	return user, notFound(err)
+}
+
+func (r mongoUsers) UpdateStatus(ctx context.Context, id primitive.ObjectID, update StatusUpdate) (models.User, error) {
+	set := bson.M{"status": update.Status, "updated_at": update.At}
+	unset := bson.M{}
+	if update.Reason != "" {
+		set["status_reason"] = update.Reason
+	} else {
+		unset["status_reason"] = ""
+	}
+	if update.SuspendedUntil != nil {
+		set["suspended_until"] = *update.SuspendedUntil
+	} else {
+		unset["suspended_until"] = ""
+	}
+	change := bson.M{"$set": set}
+	if len(unset) > 0 {
+		change["$unset"] = unset
+	}
+
+	var before models.User
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, change,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	return before, notFound(err)
+}
+
+type mongoResolutions struct {
+	collection *mongo.Collection
+}
+
+func (r mongoResolutions) Insert(ctx context.Context, resolution models.Resolution) error {
+	// The unique index on user_id and adopted_from allows one copy per user and resolution
+	_, err := r.collection.InsertOne(ctx, resolution)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r mongoResolutions) FindAdoption(ctx context.Context, userID, sourceID primitive.ObjectID) (models.Resolution, error) {
+	var resolution models.Resolution
+	err := r.collection.FindOne(ctx, bson.M{"user_id": userID, "adopted_from": sourceID}).Decode(&resolution)
+	return resolution, notFound(err)
+}
+
+func (r mongoResolutions) Adopters(ctx context.Context, query AdopterQuery) ([]Adopter, error) {
+	match := bson.M{"adopted_from": query.RID, "hidden": bson.M{"$ne": true}}
+	if len(query.Viewer.Hidden) > 0 {
+		match["user_id"] = bson.M{"$nin": query.Viewer.Hidden}
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{"$skip": query.Skip},
+		{"$limit": query.Limit},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "user_id",
+				"foreignField": "_id",
+				"as":           "user",
+				"pipeline":     []bson.M{authorProjection},
+			},
+		},
+		{"$project": bson.M{"created_at": 1, "user_detail": bson.M{"$arrayElemAt": []interface{}{"$user", 0}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	adopters := []Adopter{}
+	if err := cursor.All(ctx, &adopters); err != nil {
+		return nil, err
+	}
+	return adopters, nil
+}
+
+func (r mongoResolutions) FindVisible(ctx context.Context, id primitive.ObjectID) (models.Resolution, error) {
+	var resolution models.Resolution
+	err := r.collection.FindOne(ctx, bson.M{"_id": id, "hidden": bson.M{"$ne": true}}).Decode(&resolution)
+	return resolution, notFound(err)
+}
+
+func (r mongoResolutions) Find(ctx context.Context, id primitive.ObjectID) (models.Resolution, error) {
+	var resolution models.Resolution
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&resolution)
+	return resolution, notFound(err)
+}
+
+func (r mongoResolutions) Hide(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return hide(ctx, r.collection, id, at)
+}
+
+// hide sets the hidden flag moderators use on a resolution or comment
+func hide(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, at time.Time) error {
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"hidden": true, "updated_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// reactionStages count the reactions to each target of the type by type into
+// reaction_counts, and the likes among them into like_count
+func reactionStages(targetType string) []bson.M {
+	return []bson.M{
+		{
+			"$lookup": bson.M{
+				"from":         "reactions",
+				"localField":   "_id",
+				"foreignField": "target_id",
+				"as":           "reactions",
+				"pipeline": []bson.M{
+					{"$match": bson.M{"target_type": targetType}},
+					{"$group": bson.M{"_id": "$type", "count": bson.M{"$sum": 1}}},
+				},
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"reaction_counts": bson.M{
+					"$arrayToObject": bson.M{
+						"$map": bson.M{"input": "$reactions", "as": "r", "in": bson.M{"k": "$$r._id", "v": "$$r.count"}},
+					},
+				},
+			},
+		},
+		{"$addFields": bson.M{"like_count": bson.M{"$ifNull": []interface{}{"$reaction_counts." + models.ReactionLike, 0}}}},
+		{"$project": bson.M{"reactions": 0}},
+	}
+}
+
+// countsStages count the reactions, the visible comments and the adoptions of each resolution
+var countsStages = append(append(reactionStages(models.ReactionTargetResolution),
+	bson.M{
+		"$lookup": bson.M{
+			"from":         "comments",
+			"localField":   "_id",
+			"foreignField": "r_id",
+			"as":           "comments",
+			"pipeline": []bson.M{
+				{"$match": bson.M{"hidden": bson.M{"$ne": true}}}, // Skip comments hidden by moderators
+			},
+		},
+	},
+	bson.M{"$addFields": bson.M{"comment_count": bson.M{"$size": "$comments"}}},
+	bson.M{"$project": bson.M{"comments": 0}}),
+	adoptionStages...,
+)
+
+// adoptionStages count the visible copies of each resolution into adoption_count
+var adoptionStages = []bson.M{
+	{
+		"$lookup": bson.M{
+			"from":         "resolutions",
+			"localField":   "_id",
+			"foreignField": "adopted_from",
+			"as":           "adoptions",
+			"pipeline": []bson.M{
+				{"$match": bson.M{"hidden": bson.M{"$ne": true}}},
+				{"$project": bson.M{"_id": 1}},
+			},
+		},
+	},
+	{"$addFields": bson.M{"adoption_count": bson.M{"$size": "$adoptions"}}},
+	{"$project": bson.M{"adoptions": 0}},
+}
+
+// userNameStages add the name of each resolution's author as user_name
+var userNameStages = []bson.M{
+	{
+		"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		},
+	},
+	{"$addFields": bson.M{"user_name": bson.M{"$arrayElemAt": []interface{}{"$user.name", 0}}}},
+	{"$project": bson.M{"user": 0}},
+}
+
+func (r mongoResolutions) Feed(ctx context.Context, query FeedQuery) ([]Summary, error) {
+	// Hidden resolutions are never listed, and neither are authors hidden from the viewer
+	match := bson.M{"hidden": bson.M{"$ne": true}}
+	authors := bson.M{}
+	if query.Authors != nil {
+		authors["$in"] = query.Authors
+	}
+	if len(query.Viewer.Hidden) > 0 {
+		authors["$nin"] = query.Viewer.Hidden
+	}
+	if len(authors) > 0 {
+		match["user_id"] = authors
+	}
+	if !query.Since.IsZero() {
+		match["created_at"] = bson.M{"$gte": query.Since}
+	}
+
+	sort := bson.D{{Key: "like_count", Value: -1}, {Key: "_id", Value: -1}}
+	if query.Sort == SortNewest {
+		sort = bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}
+	}
+
+	pipeline := []bson.M{{"$match": match}}
+	pipeline = append(pipeline, countsStages...)
+	pipeline = append(pipeline,
+		bson.M{"$sort": sort},
+		bson.M{"$skip": query.Skip},
+		bson.M{"$limit": query.Limit},
+	)
+	// Only the page needs the author's name
+	pipeline = append(pipeline, userNameStages...)
+	return r.summaries(ctx, pipeline)
+}
+
+func (r mongoResolutions) Activity(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]Activity, error) {
+	// count looks up the documents of the collection on each resolution since the time,
+	// matching the filter, into the field
+	count := func(collection, foreignField, field string, match bson.M) []bson.M {
+		match["created_at"] = bson.M{"$gte": since}
+		return []bson.M{
+			{
+				"$lookup": bson.M{
+					"from":         collection,
+					"localField":   "_id",
+					"foreignField": foreignField,
+					"as":           field,
+					"pipeline":     []bson.M{{"$match": match}, {"$project": bson.M{"_id": 1}}},
+				},
+			},
+			{"$addFields": bson.M{field: bson.M{"$size": "$" + field}}},
+		}
+	}
+
+	pipeline := []bson.M{{"$match": bson.M{"user_id": userID, "hidden": bson.M{"$ne": true}}}}
+	pipeline = append(pipeline, count("reactions", "target_id", "likes", bson.M{
+		"target_type": models.ReactionTargetResolution, "type": models.ReactionLike, "user_id": bson.M{"$ne": userID},
+	})...)
+	pipeline = append(pipeline, count("comments", "r_id", "comments", bson.M{"user_id": bson.M{"$ne": userID}, "hidden": bson.M{"$ne": true}})...)
+	// Comments by the owner count as checking in
+	pipeline = append(pipeline, count("comments", "r_id", "check_ins", bson.M{"user_id": userID})...)
+	pipeline = append(pipeline,
+		bson.M{"$sort": bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		bson.M{"$project": bson.M{"resolution": 1, "likes": 1, "comments": 1, "check_ins": 1}},
+	)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	activity := []Activity{}
+	if err := cursor.All(ctx, &activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+func (r mongoResolutions) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]Summary, error) {
+	pipeline := []bson.M{{"$match": bson.M{"user_id": userID}}}
+	pipeline = append(pipeline, countsStages...)
+	pipeline = append(pipeline, bson.M{"$sort": bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}})
+	return r.summaries(ctx, pipeline)
+}
+
+func (r mongoResolutions) summaries(ctx context.Context, pipeline []bson.M) ([]Summary, error) {
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	summaries := []Summary{}
+	if err := cursor.All(ctx, &summaries); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// authorProjection keeps the public fields of a user
+var authorProjection = bson.M{"$project": bson.M{"name": 1, "image": 1}}
+
+func (r mongoResolutions) Detail(ctx context.Context, id primitive.ObjectID, query DetailQuery) (Detail, error) {
+	// Users who blocked the viewer hide their resolutions from them, and hidden
+	// authors are left out of the comments
+	viewer := query.Viewer
+	resolutionMatch := bson.M{"_id": id, "hidden": bson.M{"$ne": true}}
+	if len(viewer.Blockers) > 0 {
+		resolutionMatch["user_id"] = bson.M{"$nin": viewer.Blockers}
+	}
+	commentMatch := bson.M{"hidden": bson.M{"$ne": true}}
+	if len(viewer.Hidden) > 0 {
+		commentMatch["user_id"] = bson.M{"$nin": viewer.Hidden}
+	}
+
+	// Newest comment first, or the most reacted to with the newest breaking ties
+	commentPipeline := append([]bson.M{{"$match": commentMatch}}, reactionStages(models.ReactionTargetComment)...)
+	commentSort := bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}
+	if query.CommentSort == CommentSortTop {
+		commentPipeline = append(commentPipeline, bson.M{"$addFields": bson.M{
+			"reaction_count": bson.M{"$sum": bson.M{
+				"$map": bson.M{"input": bson.M{"$objectToArray": "$reaction_counts"}, "in": "$$this.v"},
+			}},
+		}})
+		commentSort = append(bson.D{{Key: "reaction_count", Value: -1}}, commentSort...)
+	}
+	commentPipeline = append(commentPipeline, bson.M{"$sort": commentSort})
+
+	// The source of an adopted copy, unless it is hidden or by a blocker, with its author
+	sourceMatch := bson.M{"hidden": bson.M{"$ne": true}}
+	if len(viewer.Blockers) > 0 {
+		sourceMatch["user_id"] = bson.M{"$nin": viewer.Blockers}
+	}
+	sourcePipeline := []bson.M{
+		{"$match": sourceMatch},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "user_id",
+				"foreignField": "_id",
+				"as":           "user",
+				"pipeline":     []bson.M{authorProjection},
+			},
+		},
+		{"$project": bson.M{
+			"resolution":  1,
+			"created_at":  1,
+			"user_detail": bson.M{"$arrayElemAt": []interface{}{"$user", 0}},
+		}},
+	}
+
+	pipeline := append([]bson.M{{"$match": resolutionMatch}}, reactionStages(models.ReactionTargetResolution)...)
+	pipeline = append(pipeline, adoptionStages...)
+	pipeline = append(pipeline, []bson.M{
+		{
+			"$lookup": bson.M{
+				"from":         "resolutions",
+				"localField":   "adopted_from",
+				"foreignField": "_id",
+				"as":           "source",
+				"pipeline":     sourcePipeline,
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "comments",
+				"localField":   "_id",
+				"foreignField": "r_id",
+				"as":           "comments",
+				"pipeline":     commentPipeline,
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "user_id",
+				"foreignField": "_id",
+				"as":           "user",
+				"pipeline":     []bson.M{authorProjection},
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "comments.user_id",
+				"foreignField": "_id",
+				"as":           "comment_users",
+				"pipeline":     []bson.M{authorProjection},
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"comment_count": bson.M{"$size": "$comments"},
+				"user_detail":   bson.M{"$arrayElemAt": []interface{}{"$user", 0}},
+				"source":        bson.M{"$arrayElemAt": []interface{}{"$source", 0}},
+				"tags":          bson.M{"$ifNull": []interface{}{"$tags", []interface{}{}}},
+				// Attach each comment's author from the looked up users
+				"comments": bson.M{
+					"$map": bson.M{
+						"input": "$comments",
+						"as":    "comment",
+						"in": bson.M{
+							"$mergeObjects": []interface{}{
+								"$$comment",
+								bson.M{
+									"user_detail": bson.M{
+										"$arrayElemAt": []interface{}{
+											bson.M{
+												"$filter": bson.M{
+													"input": "$comment_users",
+													"as":    "cu",
+													"cond":  bson.M{"$eq": []interface{}{"$$cu._id", "$$comment.user_id"}},
+												},
+											},
+											0,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{"$project": bson.M{"user": 0, "comment_users": 0}},
+	}...)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return Detail{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []Detail
+	if err := cursor.All(ctx, &results); err != nil {
+		return Detail{}, err
+	}
+	if len(results) == 0 {
+		return Detail{}, ErrNotFound
+	}
+	return results[0], nil
+}
+
+type mongoComments struct {
+	collection *mongo.Collection
+}
+
+func (r mongoComments) Insert(ctx context.Context, comment models.Comments) error {
+	_, err := r.collection.InsertOne(ctx, comment)
+	return err
+}
+
+func (r mongoComments) FindVisible(ctx context.Context, id, rID primitive.ObjectID) (models.Comments, error) {
+	var comment models.Comments
+	err := r.collection.FindOne(ctx, bson.M{
+		"_id":    id,
+		"r_id":   rID,
+		"hidden": bson.M{"$ne": true},
+	}).Decode(&comment)
+	return comment, notFound(err)
+}
+
+func (r mongoComments) Find(ctx context.Context, id primitive.ObjectID) (models.Comments, error) {
+	var comment models.Comments
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&comment)
+	return comment, notFound(err)
+}
+
+func (r mongoComments) Hide(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return hide(ctx, r.collection, id, at)
+}
+
+type mongoReactions struct {
+	collection *mongo.Collection
+}
+
+func (r mongoReactions) Set(ctx context.Context, reaction models.Reaction) (string, error) {
+	if reaction.ID.IsZero() {
+		reaction.ID = primitive.NewObjectID()
+	}
+	filter := bson.M{"user_id": reaction.UserID, "target_type": reaction.TargetType, "target_id": reaction.TargetID}
+	update := bson.M{
+		"$set":         bson.M{"type": reaction.Type, "updated_at": reaction.UpdatedAt},
+		"$setOnInsert": bson.M{"_id": reaction.ID, "created_at": reaction.CreatedAt},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var before models.Reaction
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent upsert inserted the reaction first, the unique index kept it to one,
+		// so this time the update finds it
+		err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return before.Type, err
+}
+
+func (r mongoReactions) Remove(ctx context.Context, userID primitive.ObjectID, targetType string, targetID primitive.ObjectID, reactionType string) (string, error) {
+	filter := bson.M{"user_id": userID, "target_type": targetType, "target_id": targetID}
+	if reactionType != "" {
+		filter["type"] = reactionType
+	}
+
+	var removed models.Reaction
+	err := r.collection.FindOneAndDelete(ctx, filter).Decode(&removed)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return removed.Type, err
+}
+
+func (r mongoReactions) Counts(ctx context.Context, targetType string, targetID primitive.ObjectID) (map[string]int64, error) {
+	cursor, err := r.collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"target_type": targetType, "target_id": targetID}},
+		{"$group": bson.M{"_id": "$type", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Type  string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, g := range groups {
+		counts[g.Type] = g.Count
+	}
+	return counts, nil
+}
+
+func (r mongoReactions) ByUser(ctx context.Context, userID primitive.ObjectID, targetType string, targetIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	reactions := map[primitive.ObjectID]string{}
+	if len(targetIDs) == 0 {
+		return reactions, nil
+	}
+
+	// One query for the whole page instead of one per target
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"user_id": userID, "target_type": targetType, "target_id": bson.M{"$in": targetIDs}},
+		options.Find().SetProjection(bson.M{"target_id": 1, "type": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []models.Reaction
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, reaction := range found {
+		reactions[reaction.TargetID] = reaction.Type
+	}
+	return reactions, nil
+}
+
+type mongoBlocks struct {
+	collection *mongo.Collection
+}
+
+func (r mongoBlocks) Add(ctx context.Context, block models.Block) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"user_id": block.UserID, "target_id": block.TargetID, "kind": block.Kind},
+		bson.M{"$setOnInsert": bson.M{"created_at": block.CreatedAt}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedID != nil, nil
+}
+
+func (r mongoBlocks) Remove(ctx context.Context, userID, targetID primitive.ObjectID, kind string) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "target_id": targetID, "kind": kind})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r mongoBlocks) Exists(ctx context.Context, userID, targetID primitive.ObjectID, kind string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"user_id": userID, "target_id": targetID, "kind": kind})
+	return count > 0, err
+}
+
+func (r mongoBlocks) Involving(ctx context.Context, userID primitive.ObjectID) ([]models.Block, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"$or": []bson.M{
+			{"user_id": userID},
+			{"target_id": userID, "kind": models.BlockKindBlock},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	blocks := []models.Block{}
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func (r mongoBlocks) List(ctx context.Context, userID primitive.ObjectID, kind string) ([]BlockSummary, error) {
+	match := bson.M{"user_id": userID}
+	if kind != "" {
+		match["kind"] = kind
+	}
+	cursor, err := r.collection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "target_id",
+				"foreignField": "_id",
+				"as":           "user",
+				"pipeline":     []bson.M{authorProjection},
+			},
+		},
+		{
+			"$project": bson.M{
+				"target_id":   1,
+				"kind":        1,
+				"created_at":  1,
+				"user_detail": bson.M{"$arrayElemAt": []interface{}{"$user", 0}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	blocks := []BlockSummary{}
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+type mongoBookmarks struct {
+	collection *mongo.Collection
+}
+
+func (r mongoBookmarks) Set(ctx context.Context, bookmark models.Bookmark) (bool, error) {
+	if bookmark.ID.IsZero() {
+		bookmark.ID = primitive.NewObjectID()
+	}
+	update := bson.M{
+		"$set":         bson.M{"updated_at": bookmark.UpdatedAt},
+		"$setOnInsert": bson.M{"_id": bookmark.ID, "created_at": bookmark.CreatedAt},
+	}
+	if bookmark.Collection != "" {
+		update["$set"].(bson.M)["collection"] = bookmark.Collection
+	} else {
+		update["$unset"] = bson.M{"collection": ""}
+	}
+
+	filter := bson.M{"user_id": bookmark.UserID, "r_id": bookmark.RID}
+	result, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent upsert inserted the bookmark first, the unique index kept it to one,
+		// so this one updates it instead
+		result, err = r.collection.UpdateOne(ctx, filter, update)
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedID != nil, nil
+}
+
+func (r mongoBookmarks) Remove(ctx context.Context, userID, rID primitive.ObjectID) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "r_id": rID})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r mongoBookmarks) List(ctx context.Context, query BookmarkQuery) ([]BookmarkSummary, error) {
+	match := bson.M{"user_id": query.UserID}
+	if query.Collection != "" {
+		match["collection"] = query.Collection
+	}
+	resolutionMatch := bson.M{"hidden": bson.M{"$ne": true}}
+	if len(query.Viewer.Hidden) > 0 {
+		resolutionMatch["user_id"] = bson.M{"$nin": query.Viewer.Hidden}
+	}
+
+	// Bookmarks of resolutions that can't be shown are dropped before paging, and only the
+	// page is counted
+	resolutionPipeline := append([]bson.M{{"$match": resolutionMatch}}, countsStages...)
+	resolutionPipeline = append(resolutionPipeline, userNameStages...)
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			"$lookup": bson.M{
+				"from":         "resolutions",
+				"localField":   "r_id",
+				"foreignField": "_id",
+				"as":           "visible",
+				"pipeline":     []bson.M{{"$match": resolutionMatch}, {"$project": bson.M{"_id": 1}}},
+			},
+		},
+		{"$match": bson.M{"visible.0": bson.M{"$exists": true}}},
+		{"$skip": query.Skip},
+		{"$limit": query.Limit},
+		{
+			"$lookup": bson.M{
+				"from":         "resolutions",
+				"localField":   "r_id",
+				"foreignField": "_id",
+				"as":           "resolution",
+				"pipeline":     resolutionPipeline,
+			},
+		},
+		{"$unwind": "$resolution"},
+		{"$project": bson.M{"visible": 0}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	bookmarks := []BookmarkSummary{}
+	if err := cursor.All(ctx, &bookmarks); err != nil {
+		return nil, err
+	}
+	return bookmarks, nil
+}
+
+func (r mongoBookmarks) Collections(ctx context.Context, userID primitive.ObjectID) ([]CollectionCount, error) {
+	cursor, err := r.collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"user_id": userID, "collection": bson.M{"$exists": true}}},
+		{"$group": bson.M{"_id": "$collection", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	collections := []CollectionCount{}
+	if err := cursor.All(ctx, &collections); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+func (r mongoBookmarks) BookmarkedBy(ctx context.Context, userID primitive.ObjectID, rIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	bookmarked := map[primitive.ObjectID]bool{}
+	if len(rIDs) == 0 {
+		return bookmarked, nil
+	}
+
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"user_id": userID, "r_id": bson.M{"$in": rIDs}},
+		options.Find().SetProjection(bson.M{"r_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Bookmark
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, bookmark := range found {
+		bookmarked[bookmark.RID] = true
+	}
+	return bookmarked, nil
+}
+
+type mongoWebhooks struct {
+	collection *mongo.Collection
+}
+
+func (r mongoWebhooks) Insert(ctx context.Context, hook models.Webhook) error {
+	_, err := r.collection.InsertOne(ctx, hook)
+	return err
+}
+
+func (r mongoWebhooks) List(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": owner}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	hooks := []models.Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (r mongoWebhooks) Count(ctx context.Context, owner *primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"user_id": owner})
+}
+
+func (r mongoWebhooks) Find(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID) (models.Webhook, error) {
+	var hook models.Webhook
+	err := r.collection.FindOne(ctx, bson.M{"_id": id, "user_id": owner}).Decode(&hook)
+	return hook, notFound(err)
+}
+
+func (r mongoWebhooks) FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	var hook models.Webhook
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&hook)
+	return hook, notFound(err)
+}
+
+func (r mongoWebhooks) Update(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID, update WebhookUpdate) (models.Webhook, error) {
+	set := bson.M{"updated_at": update.At}
+	change := bson.M{"$set": set}
+	if update.URL != nil {
+		set["url"] = *update.URL
+	}
+	if update.Events != nil {
+		set["events"] = update.Events
+	}
+	if update.Active != nil {
+		set["active"] = *update.Active
+		if *update.Active {
+			set["consecutive_failures"] = 0
+			change["$unset"] = bson.M{"disabled_at": ""}
+		} else {
+			set["disabled_at"] = update.At
+		}
+	}
+
+	var hook models.Webhook
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "user_id": owner},
+		change,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&hook)
+	return hook, notFound(err)
+}
+
+func (r mongoWebhooks) Delete(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": owner})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r mongoWebhooks) Subscribed(ctx context.Context, event string, userID primitive.ObjectID) ([]models.Webhook, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"active":  true,
+		"events":  event,
+		"user_id": bson.M{"$in": []interface{}{nil, userID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	hooks := []models.Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (r mongoWebhooks) RecordFailure(ctx context.Context, id primitive.ObjectID, disableAfter int, now time.Time) (models.Webhook, error) {
+	var hook models.Webhook
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"consecutive_failures": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&hook)
+	if err != nil {
+		return hook, notFound(err)
+	}
+	if !hook.Active || hook.ConsecutiveFailures < disableAfter {
+		return hook, nil
+	}
+
+	// Only the attempt that finds the webhook still on turns it off
+	err = r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "active": true},
+		bson.M{"$set": bson.M{"active": false, "disabled_at": now, "updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&hook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		hook.Active = false
+		return hook, nil
+	}
+	return hook, err
+}
+
+func (r mongoWebhooks) ResetFailures(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "consecutive_failures": bson.M{"$gt": 0}},
+		bson.M{"$set": bson.M{"consecutive_failures": 0}},
+	)
+	return err
+}
+
+type mongoDeliveries struct {
+	collection *mongo.Collection
+}
+
+func (r mongoDeliveries) Insert(ctx context.Context, delivery models.WebhookDelivery) error {
+	_, err := r.collection.InsertOne(ctx, delivery)
+	return err
+}
+
+func (r mongoDeliveries) Find(ctx context.Context, id, webhookID primitive.ObjectID) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.collection.FindOne(ctx, bson.M{"_id": id, "webhook_id": webhookID}).Decode(&delivery)
+	return delivery, notFound(err)
+}
+
+func (r mongoDeliveries) List(ctx context.Context, query DeliveryQuery) ([]models.WebhookDelivery, error) {
+	filter := bson.M{"webhook_id": query.WebhookID}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(query.Skip)).
+		SetLimit(int64(query.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r mongoDeliveries) Claim(ctx context.Context, now, leaseUntil time.Time) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"status": models.WebhookDeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": leaseUntil}},
+		options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1}),
+	).Decode(&delivery)
+	return delivery, notFound(err)
+}
+
+func (r mongoDeliveries) Save(ctx context.Context, delivery models.WebhookDelivery) error {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r mongoDeliveries) DeleteByWebhook(ctx context.Context, webhookID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"webhook_id": webhookID})
+	return err
+}
+
+type mongoReminders struct {
+	collection *mongo.Collection
+}
+
+func (r mongoReminders) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Reminder, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "next_run_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reminders := []models.Reminder{}
+	if err := cursor.All(ctx, &reminders); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (r mongoReminders) Set(ctx context.Context, reminder models.Reminder) (models.Reminder, error) {
+	var stored models.Reminder
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"user_id": reminder.UserID, "r_id": reminder.RID},
+		bson.M{
+			"$set": bson.M{
+				"frequency":    reminder.Frequency,
+				"hour":         reminder.Hour,
+				"weekday":      reminder.Weekday,
+				"day_of_month": reminder.DayOfMonth,
+				"timezone":     reminder.Timezone,
+				"next_run_at":  reminder.NextRunAt,
+				"updated_at":   reminder.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{"created_at": reminder.CreatedAt},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	return stored, err
+}
+
+func (r mongoReminders) Delete(ctx context.Context, userID, rID primitive.ObjectID) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "r_id": rID})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r mongoReminders) Reschedule(ctx context.Context, id primitive.ObjectID, timezone string, nextRunAt time.Time) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"timezone": timezone, "next_run_at": nextRunAt, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+func (r mongoReminders) Due(ctx context.Context, at time.Time) (models.Reminder, error) {
+	var reminder models.Reminder
+	err := r.collection.FindOne(ctx,
+		bson.M{"next_run_at": bson.M{"$lte": at}},
+		options.FindOne().SetSort(bson.D{{Key: "next_run_at", Value: 1}}),
+	).Decode(&reminder)
+	return reminder, notFound(err)
+}
+
+func (r mongoReminders) Advance(ctx context.Context, reminder models.Reminder, nextRunAt, sentAt time.Time) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": reminder.ID, "next_run_at": reminder.NextRunAt},
+		bson.M{"$set": bson.M{"next_run_at": nextRunAt, "last_sent_at": sentAt}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+type mongoMagicLinks struct {
+	collection *mongo.Collection
+}
+
+func (r mongoMagicLinks) Insert(ctx context.Context, link models.MagicLink) error {
+	_, err := r.collection.InsertOne(ctx, link)
+	return err
+}
+
+func (r mongoMagicLinks) Redeem(ctx context.Context, id, email string, at time.Time) (models.MagicLink, error) {
+	// Checked and marked in one operation, so a link can't be redeemed twice at once
+	var link models.MagicLink
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "email": email, "used_at": nil, "expires_at": bson.M{"$gt": at}},
+		bson.M{"$set": bson.M{"used_at": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&link)
+	return link, notFound(err)
+}
+
+type mongoTokens struct {
+	collection *mongo.Collection
+}
+
+func (r mongoTokens) Insert(ctx context.Context, token models.PersonalAccessToken) error {
+	_, err := r.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r mongoTokens) List(ctx context.Context, userID primitive.ObjectID) ([]models.PersonalAccessToken, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.PersonalAccessToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r mongoTokens) CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"user_id": userID, "revoked_at": nil})
+}
+
+func (r mongoTokens) Revoke(ctx context.Context, id, userID primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r mongoTokens) FindActive(ctx context.Context, hash string) (models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := r.collection.FindOne(ctx, bson.M{"token_hash": hash, "revoked_at": nil}).Decode(&token)
+	return token, notFound(err)
+}
+
+func (r mongoTokens) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+type mongoFollows struct {
+	collection *mongo.Collection
+}
+
+func (r mongoFollows) Add(ctx context.Context, follow models.Follow) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"user_id": follow.UserID, "target_id": follow.TargetID},
+		bson.M{"$setOnInsert": bson.M{"created_at": follow.CreatedAt}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedID != nil, nil
+}
+
+func (r mongoFollows) Remove(ctx context.Context, userID, targetID primitive.ObjectID) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "target_id": targetID})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r mongoFollows) RemoveBetween(ctx context.Context, a, b primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"user_id": a, "target_id": b},
+		{"user_id": b, "target_id": a},
+	}})
+	return err
+}
+
+func (r mongoFollows) Following(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetProjection(bson.M{"target_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var follows []models.Follow
+	if err := cursor.All(ctx, &follows); err != nil {
+		return nil, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, f := range follows {
+		ids = append(ids, f.TargetID)
+	}
+	return ids, nil
+}
+
+type mongoNotifications struct {
+	collection *mongo.Collection
+}
+
+func (r mongoNotifications) Add(ctx context.Context, notification models.Notification) error {
+	set := bson.M{"type": notification.Type, "last_actor_id": notification.LastActorID, "updated_at": notification.UpdatedAt}
+	if notification.ResolutionID != nil {
+		set["r_id"] = *notification.ResolutionID
+	}
+	if notification.CommentID != nil {
+		set["comment_id"] = *notification.CommentID
+	}
+
+	filter := bson.M{"user_id": notification.UserID, "group_key": notification.GroupKey, "read": false}
+	update := bson.M{
+		"$set":         set,
+		"$addToSet":    bson.M{"actor_ids": notification.LastActorID},
+		"$setOnInsert": bson.M{"created_at": notification.CreatedAt},
+	}
+	_, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent event of the same group inserted the notification first,
+		// the unique index made this upsert fail, now it updates that notification
+		_, err = r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	}
+	return err
+}
+
+func (r mongoNotifications) Retract(ctx context.Context, userID primitive.ObjectID, groupKey string, actorID primitive.ObjectID) error {
+	filter := bson.M{"user_id": userID, "group_key": groupKey, "read": false}
+	_, err := r.collection.UpdateOne(ctx, filter, []bson.M{
+		{"$set": bson.M{"actor_ids": bson.M{"$filter": bson.M{
+			"input": "$actor_ids",
+			"cond":  bson.M{"$ne": []interface{}{"$$this", actorID}},
+		}}}},
+		// Fall back to the most recent remaining actor
+		{"$set": bson.M{"last_actor_id": bson.M{"$cond": []interface{}{
+			bson.M{"$eq": []interface{}{"$last_actor_id", actorID}},
+			bson.M{"$arrayElemAt": []interface{}{"$actor_ids", -1}},
+			"$last_actor_id",
+		}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	filter["actor_ids"] = bson.M{"$size": 0}
+	_, err = r.collection.DeleteOne(ctx, filter)
+	return err
+}
+
+func (r mongoNotifications) List(ctx context.Context, query NotificationQuery) ([]NotificationSummary, error) {
+	match := bson.M{"user_id": query.UserID}
+	if query.UnreadOnly {
+		match["read"] = false
+	}
+	cursor, err := r.collection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{"$skip": query.Skip},
+		{"$limit": query.Limit},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "last_actor_id",
+				"foreignField": "_id",
+				"as":           "actor",
+				"pipeline":     []bson.M{authorProjection},
+			},
+		},
+		{"$set": bson.M{"actor": bson.M{"$arrayElemAt": []interface{}{"$actor", 0}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []NotificationSummary{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (r mongoNotifications) CountUnread(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"user_id": userID, "read": false})
+}
+
+func (r mongoNotifications) MarkRead(ctx context.Context, id, userID primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "user_id": userID},
+		bson.M{"$set": bson.M{"read": true, "read_at": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r mongoNotifications) MarkAllRead(ctx context.Context, userID primitive.ObjectID, at time.Time) (int64, error) {
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "read": false},
+		bson.M{"$set": bson.M{"read": true, "read_at": at}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+type mongoReports struct {
+	collection *mongo.Collection
+}
+
+func (r mongoReports) Open(ctx context.Context, report models.Report) (bool, error) {
+	if report.ID.IsZero() {
+		report.ID = primitive.NewObjectID()
+	}
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{
+			"reporter_id": report.ReporterID,
+			"target_type": report.TargetType,
+			"target_id":   report.TargetID,
+			"status":      models.ReportStatusOpen,
+		},
+		bson.M{
+			"$set": bson.M{
+				"reason":     report.Reason,
+				"details":    report.Details,
+				"severity":   report.Severity,
+				"updated_at": report.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{
+				"_id":            report.ID,
+				"target_user_id": report.TargetUserID,
+				"created_at":     report.CreatedAt,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedID != nil, nil
+}
+
+func (r mongoReports) Insert(ctx context.Context, report models.Report) error {
+	_, err := r.collection.InsertOne(ctx, report)
+	return err
+}
+
+func (r mongoReports) Queue(ctx context.Context, query QueueQuery) ([]QueueEntry, error) {
+	match := bson.M{"status": models.ReportStatusOpen}
+	if query.TargetType != "" {
+		match["target_type"] = query.TargetType
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		// One queue entry per reported target
+		{
+			"$group": bson.M{
+				"_id":               bson.M{"target_type": "$target_type", "target_id": "$target_id"},
+				"target_user_id":    bson.M{"$first": "$target_user_id"},
+				"report_count":      bson.M{"$sum": 1},
+				"severity":          bson.M{"$max": "$severity"},
+				"reasons":           bson.M{"$addToSet": "$reason"},
+				"first_reported_at": bson.M{"$min": "$created_at"},
+				"last_reported_at":  bson.M{"$max": "$updated_at"},
+			},
+		},
+		{"$sort": bson.D{
+			{Key: "severity", Value: -1},
+			{Key: "report_count", Value: -1},
+			{Key: "first_reported_at", Value: 1},
+			{Key: "_id.target_id", Value: 1},
+		}},
+		{"$skip": query.Skip},
+		{"$limit": query.Limit},
+		// Attach the reported content so moderators don't have to look it up
+		{
+			"$lookup": bson.M{
+				"from":         "resolutions",
+				"localField":   "_id.target_id",
+				"foreignField": "_id",
+				"as":           "resolution",
+				"pipeline":     []bson.M{{"$project": bson.M{"resolution": 1, "hidden": 1}}},
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "comments",
+				"localField":   "_id.target_id",
+				"foreignField": "_id",
+				"as":           "comment",
+				"pipeline":     []bson.M{{"$project": bson.M{"comment": 1, "r_id": 1, "hidden": 1}}},
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "target_user_id",
+				"foreignField": "_id",
+				"as":           "target_user",
+				"pipeline":     []bson.M{{"$project": bson.M{"name": 1, "image": 1, "status": 1, "suspended_until": 1}}},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":               0,
+				"target_type":       "$_id.target_type",
+				"target_id":         "$_id.target_id",
+				"report_count":      1,
+				"severity":          1,
+				"reasons":           1,
+				"first_reported_at": 1,
+				"last_reported_at":  1,
+				"resolution":        bson.M{"$arrayElemAt": []interface{}{"$resolution", 0}},
+				"comment":           bson.M{"$arrayElemAt": []interface{}{"$comment", 0}},
+				"target_user":       bson.M{"$arrayElemAt": []interface{}{"$target_user", 0}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	queue := []QueueEntry{}
+	if err := cursor.All(ctx, &queue); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+func (r mongoReports) Close(ctx context.Context, targetType string, targetID primitive.ObjectID, outcome ReportOutcome) (int64, error) {
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{"target_type": targetType, "target_id": targetID, "status": models.ReportStatusOpen},
+		bson.M{"$set": bson.M{
+			"status":      outcome.Status,
+			"action":      outcome.Action,
+			"resolved_by": outcome.ResolvedBy,
+			"resolved_at": outcome.At,
+			"updated_at":  outcome.At,
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+type mongoWarnings struct {
+	collection *mongo.Collection
+}
+
+func (r mongoWarnings) Insert(ctx context.Context, warning models.Warning) error {
+	_, err := r.collection.InsertOne(ctx, warning)
+	return err
+}
+
+type mongoAuditLogs struct {
+	collection *mongo.Collection
+}
+
+func (r mongoAuditLogs) Insert(ctx context.Context, entry models.AuditLog) error {
+	_, err := r.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (r mongoAuditLogs) List(ctx context.Context, query AuditQuery) ([]models.AuditLog, error) {
+	filter := bson.M{}
+	if query.ActorID != nil {
+		filter["actor_id"] = *query.ActorID
+	}
+	if query.TargetType != "" {
+		filter["target_type"] = query.TargetType
+	}
+	if query.TargetID != "" {
+		filter["target_id"] = query.TargetID
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	createdAt := bson.M{}
+	if query.From != nil {
+		createdAt["$gte"] = *query.From
+	}
+	if query.To != nil {
+		createdAt["$lte"] = *query.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(query.Skip)).
+		SetLimit(int64(query.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.AuditLog{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"nyr/migrations"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestMongoContract runs the contract against a real server, set MONGO_TEST_URI to enable it.
+// CI always sets it, so there the test fails without it instead of skipping. Every subtest
+// gets its own migrated database which is dropped afterwards.
+func TestMongoContract(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" && os.Getenv("CI") != "" {
+		t.Fatal("MONGO_TEST_URI must be set in CI")
+	}
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	testContract(t, func(t *testing.T) Repositories {
+		database := client.Database("nyr_test_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() { database.Drop(context.Background()) })
+		if err := migrations.Run(context.Background(), database); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		return NewMongo(database)
+	})
+}
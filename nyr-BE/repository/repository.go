@@ -0,0 +1,585 @@
+// Package repository is the storage behind the handlers. Handlers only talk to the
+// interfaces here, MongoDB backs them in production and an in-memory store in tests.
+package repository
+
+import (
+	"context"
+	"errors"
+	"nyr/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned when the requested document doesn't exist or is not visible
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned when a unique value, like a handle, is already taken, or when a
+// user adopts the same resolution twice
+var ErrDuplicate = errors.New("already exists")
+
+// ErrIdentityLinked is returned when an identity provider account is linked to another user
+var ErrIdentityLinked = errors.New("identity linked to another user")
+
+// ErrLastAdmin is returned when a role change would leave the site without an admin
+var ErrLastAdmin = errors.New("last admin")
+
+// Orders the feed can be sorted in
+const (
+	SortLikes  = "likes"
+	SortNewest = "created_at"
+)
+
+// Orders the comments of a resolution can be sorted in, top has the most reactions first
+const (
+	CommentSortNewest = "newest"
+	CommentSortTop    = "top"
+)
+
+// Repositories bundles the repositories handed to the handlers
+type Repositories struct {
+	Users         Users
+	Resolutions   Resolutions
+	Comments      Comments
+	Reactions     Reactions
+	Blocks        Blocks
+	Bookmarks     Bookmarks
+	Webhooks      Webhooks
+	Deliveries    WebhookDeliveries
+	Reminders     Reminders
+	Tokens        Tokens
+	Follows       Follows
+	Notifications Notifications
+	Reports       Reports
+	Warnings      Warnings
+	AuditLogs     AuditLogs
+	MagicLinks    MagicLinks
+}
+
+type Users interface {
+	// Insert stores the user, or returns ErrDuplicate when another user has the email or handle
+	Insert(ctx context.Context, user models.User) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.User, error)
+	// FindByIdentity returns the user an identity provider account is linked to
+	FindByIdentity(ctx context.Context, provider, subject string) (models.User, error)
+	// LinkIdentityByEmail links the identity to the user with the email and returns the updated
+	// user. Only a user with a verified identity for the email, or with no identity at all from
+	// before identities were stored, is matched, so an unverified sign up can't claim the address.
+	// Emails are compared in the form models.NormalizeEmail returns. An identity another user
+	// has returns ErrIdentityLinked, here and in the other methods that link one.
+	LinkIdentityByEmail(ctx context.Context, email string, identity models.Identity) (models.User, error)
+	// AddIdentity links the identity to the user
+	AddIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error
+	// AddSoleIdentity links the identity unless the user already has one at its provider,
+	// then it returns ErrDuplicate
+	AddSoleIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error
+	// RemoveIdentity unlinks one identity from the user and reports whether it was removed.
+	// The user's last identity is never removed, otherwise they could no longer sign in.
+	RemoveIdentity(ctx context.Context, id primitive.ObjectID, provider, subject string) (bool, error)
+	// UpdateName renames the user and returns the user as it was before
+	UpdateName(ctx context.Context, id primitive.ObjectID, name string) (models.User, error)
+	// UpdateHandle sets the user's handle and returns the user as it was before, or
+	// ErrDuplicate when another user has the handle
+	UpdateHandle(ctx context.Context, id primitive.ObjectID, handle string) (models.User, error)
+	// FindByHandles returns the users with the handles, unknown handles are left out
+	FindByHandles(ctx context.Context, handles []string) ([]models.User, error)
+	// UpdateEmailPreferences turns the email categories on or off, leaving the others as they
+	// are, and returns the updated user
+	UpdateEmailPreferences(ctx context.Context, id primitive.ObjectID, preferences map[string]bool) (models.User, error)
+	// UpdateNotificationPreferences turns the notification types on or off, leaving the others
+	// as they are, and returns the updated user
+	UpdateNotificationPreferences(ctx context.Context, id primitive.ObjectID, preferences map[string]bool) (models.User, error)
+	UpdateTimezone(ctx context.Context, id primitive.ObjectID, timezone string) error
+	// List returns a page of the users, newest first, of one role when it is set. Users
+	// without a stored role are regular users.
+	List(ctx context.Context, query UserQuery) ([]models.User, error)
+	// SetRole changes the user's role and returns the user as it was before. Taking the role
+	// away from the last admin changes nothing and returns ErrLastAdmin.
+	SetRole(ctx context.Context, id primitive.ObjectID, role string) (models.User, error)
+	// UpdateStatus bans, suspends or reinstates the user and returns the user as it was before
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, update StatusUpdate) (models.User, error)
+	// FindBySignUpEmails returns the users with one of the emails that signed up with it
+	// verified, see models.User.SignedUpVerified
+	FindBySignUpEmails(ctx context.Context, emails []string) ([]models.User, error)
+	// GrantAdmin makes the user an admin and reports whether they weren't one already
+	GrantAdmin(ctx context.Context, id primitive.ObjectID) (bool, error)
+	// ClaimDigest returns a user who opted in to the digest and got none in the last period,
+	// after recording at as their last digest, or ErrNotFound when nobody is due. Banned and
+	// suspended users are never due.
+	ClaimDigest(ctx context.Context, at time.Time, period time.Duration) (models.User, error)
+}
+
+type Resolutions interface {
+	// Insert stores the resolution, or returns ErrDuplicate when it is an adoption of a
+	// resolution the user already adopted
+	Insert(ctx context.Context, resolution models.Resolution) error
+	// FindAdoption returns the user's copy of the source resolution, hidden or not
+	FindAdoption(ctx context.Context, userID, sourceID primitive.ObjectID) (models.Resolution, error)
+	// Adopters returns a page of the visible copies of a resolution with their authors,
+	// newest first. Authors hidden from the viewer are left out.
+	Adopters(ctx context.Context, query AdopterQuery) ([]Adopter, error)
+	// FindVisible returns the resolution unless moderators hid it
+	FindVisible(ctx context.Context, id primitive.ObjectID) (models.Resolution, error)
+	// Find returns the resolution, hidden or not
+	Find(ctx context.Context, id primitive.ObjectID) (models.Resolution, error)
+	// Hide takes the resolution out of every listing, for moderators
+	Hide(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	Feed(ctx context.Context, query FeedQuery) ([]Summary, error)
+	// Activity returns the user's visible resolutions, oldest first, with what happened on
+	// them since the given time
+	Activity(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]Activity, error)
+	// ListByUser returns all of the user's resolutions, hidden ones included, oldest first
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]Summary, error)
+	Detail(ctx context.Context, id primitive.ObjectID, query DetailQuery) (Detail, error)
+}
+
+type Comments interface {
+	Insert(ctx context.Context, comment models.Comments) error
+	// FindVisible returns a comment on the resolution unless moderators hid it
+	FindVisible(ctx context.Context, id, rID primitive.ObjectID) (models.Comments, error)
+	// Find returns the comment, hidden or not
+	Find(ctx context.Context, id primitive.ObjectID) (models.Comments, error)
+	// Hide takes the comment out of every listing, for moderators
+	Hide(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// Reactions keeps at most one reaction per user and target, a like is a reaction of type like
+type Reactions interface {
+	// Set stores the user's reaction, replacing their earlier one on the same target, and
+	// returns the type it replaced, empty when there was none
+	Set(ctx context.Context, reaction models.Reaction) (string, error)
+	// Remove deletes the user's reaction to the target and returns its type, empty when there
+	// was none. With a reaction type only a reaction of that type is deleted.
+	Remove(ctx context.Context, userID primitive.ObjectID, targetType string, targetID primitive.ObjectID, reactionType string) (string, error)
+	// Counts returns the number of reactions to the target by type
+	Counts(ctx context.Context, targetType string, targetID primitive.ObjectID) (map[string]int64, error)
+	// ByUser returns the user's reaction to each of the targets they reacted to
+	ByUser(ctx context.Context, userID primitive.ObjectID, targetType string, targetIDs []primitive.ObjectID) (map[primitive.ObjectID]string, error)
+}
+
+type Blocks interface {
+	// Add stores the block or mute and reports whether it is new
+	Add(ctx context.Context, block models.Block) (bool, error)
+	// Remove deletes the block or mute and reports whether there was one
+	Remove(ctx context.Context, userID, targetID primitive.ObjectID, kind string) (bool, error)
+	Exists(ctx context.Context, userID, targetID primitive.ObjectID, kind string) (bool, error)
+	// Involving returns the blocks and mutes by the user and the blocks against them
+	Involving(ctx context.Context, userID primitive.ObjectID) ([]models.Block, error)
+	// List returns the user's blocks and mutes with the users they target, newest first, of
+	// one kind when it is set
+	List(ctx context.Context, userID primitive.ObjectID, kind string) ([]BlockSummary, error)
+}
+
+type Bookmarks interface {
+	// Set bookmarks the resolution, or moves an existing bookmark to the bookmark's
+	// collection, and reports whether the bookmark is new
+	Set(ctx context.Context, bookmark models.Bookmark) (bool, error)
+	// Remove deletes the bookmark and reports whether there was one
+	Remove(ctx context.Context, userID, rID primitive.ObjectID) (bool, error)
+	// List returns a page of the user's bookmarks, newest first, with the resolutions.
+	// Bookmarks of resolutions that are hidden, or by authors hidden from the viewer, are left out.
+	List(ctx context.Context, query BookmarkQuery) ([]BookmarkSummary, error)
+	// Collections returns the user's collection names with their number of bookmarks, by name
+	Collections(ctx context.Context, userID primitive.ObjectID) ([]CollectionCount, error)
+	// BookmarkedBy reports which of the resolutions the user bookmarked
+	BookmarkedBy(ctx context.Context, userID primitive.ObjectID, rIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error)
+}
+
+// Webhooks are the endpoints events are delivered to. The owner of a webhook is the user
+// it belongs to, nil for the global webhooks set up by admins.
+type Webhooks interface {
+	Insert(ctx context.Context, hook models.Webhook) error
+	// List returns the owner's webhooks, newest first
+	List(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error)
+	Count(ctx context.Context, owner *primitive.ObjectID) (int64, error)
+	// Find returns the webhook if it belongs to the owner
+	Find(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID) (models.Webhook, error)
+	// FindByID returns the webhook whoever owns it
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Webhook, error)
+	// Update changes the owner's webhook and returns it as it is afterwards
+	Update(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID, update WebhookUpdate) (models.Webhook, error)
+	// Delete removes the owner's webhook and reports whether there was one
+	Delete(ctx context.Context, id primitive.ObjectID, owner *primitive.ObjectID) (bool, error)
+	// Subscribed returns the active webhooks for the event: the global ones and those of the user
+	Subscribed(ctx context.Context, event string, userID primitive.ObjectID) ([]models.Webhook, error)
+	// RecordFailure counts a failed attempt and turns the webhook off once disableAfter attempts
+	// in a row failed. It returns the webhook as it is afterwards.
+	RecordFailure(ctx context.Context, id primitive.ObjectID, disableAfter int, now time.Time) (models.Webhook, error)
+	// ResetFailures clears the failed attempts after a successful one
+	ResetFailures(ctx context.Context, id primitive.ObjectID) error
+}
+
+// WebhookDeliveries is the queue and log of events sent to webhooks
+type WebhookDeliveries interface {
+	Insert(ctx context.Context, delivery models.WebhookDelivery) error
+	// Find returns the delivery if it belongs to the webhook
+	Find(ctx context.Context, id, webhookID primitive.ObjectID) (models.WebhookDelivery, error)
+	// List returns a page of a webhook's deliveries, newest first
+	List(ctx context.Context, query DeliveryQuery) ([]models.WebhookDelivery, error)
+	// Claim returns the pending delivery that has been due the longest and pushes its next
+	// attempt to leaseUntil, so no one else sends it meanwhile. It returns ErrNotFound when
+	// nothing is due.
+	Claim(ctx context.Context, now, leaseUntil time.Time) (models.WebhookDelivery, error)
+	// Save stores the delivery in place of the one with its ID
+	Save(ctx context.Context, delivery models.WebhookDelivery) error
+	DeleteByWebhook(ctx context.Context, webhookID primitive.ObjectID) error
+}
+
+// Reminders are the users' check in schedules, at most one per user and resolution
+type Reminders interface {
+	// ListByUser returns the user's reminders, the one due next first
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Reminder, error)
+	// Set stores the reminder in place of the user's reminder for the same resolution and
+	// returns it as stored
+	Set(ctx context.Context, reminder models.Reminder) (models.Reminder, error)
+	// Delete removes the user's reminder for the resolution and reports whether there was one
+	Delete(ctx context.Context, userID, rID primitive.ObjectID) (bool, error)
+	// Reschedule moves the reminder to the timezone and sets when it is due next
+	Reschedule(ctx context.Context, id primitive.ObjectID, timezone string, nextRunAt time.Time) error
+	// Due returns the reminder that has been due the longest at the time, or ErrNotFound
+	Due(ctx context.Context, at time.Time) (models.Reminder, error)
+	// Advance moves the reminder to its next run and records it as sent, unless it was moved
+	// since it was loaded. It reports whether it moved it, so when several instances claim
+	// the same reminder only one of them sends it.
+	Advance(ctx context.Context, reminder models.Reminder, nextRunAt, sentAt time.Time) (bool, error)
+}
+
+// MagicLinks are the emailed sign in links, each of them can be redeemed once
+type MagicLinks interface {
+	Insert(ctx context.Context, link models.MagicLink) error
+	// Redeem marks the link with the ID and email as used and returns it. Links that expired
+	// or were used already return ErrNotFound, like unknown ones.
+	Redeem(ctx context.Context, id, email string, at time.Time) (models.MagicLink, error)
+}
+
+// Tokens are the users' personal access tokens, stored by their hash
+type Tokens interface {
+	Insert(ctx context.Context, token models.PersonalAccessToken) error
+	// List returns the user's tokens, revoked ones included, newest first
+	List(ctx context.Context, userID primitive.ObjectID) ([]models.PersonalAccessToken, error)
+	// CountActive counts the user's tokens that are not revoked
+	CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	// Revoke revokes the user's token and reports whether it was active
+	Revoke(ctx context.Context, id, userID primitive.ObjectID, at time.Time) (bool, error)
+	// FindActive returns the token with the hash unless it was revoked
+	FindActive(ctx context.Context, hash string) (models.PersonalAccessToken, error)
+	// Touch records when the token was last used
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+type Follows interface {
+	// Add stores the follow and reports whether it is new
+	Add(ctx context.Context, follow models.Follow) (bool, error)
+	// Remove deletes the follow and reports whether there was one
+	Remove(ctx context.Context, userID, targetID primitive.ObjectID) (bool, error)
+	// RemoveBetween deletes the follows between the two users, in both directions
+	RemoveBetween(ctx context.Context, a, b primitive.ObjectID) error
+	// Following returns the IDs of the users the user follows
+	Following(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error)
+}
+
+// Notifications are grouped by their group key while unread, see the notifications package
+type Notifications interface {
+	// Add adds the notification's last actor to the recipient's unread notification of the
+	// group, or creates it when there is none
+	Add(ctx context.Context, notification models.Notification) error
+	// Retract takes the actor out of the recipient's unread notification of the group and
+	// removes the notification once no actor is left
+	Retract(ctx context.Context, userID primitive.ObjectID, groupKey string, actorID primitive.ObjectID) error
+	// List returns a page of the user's notifications with their last actor, most recent
+	// activity first
+	List(ctx context.Context, query NotificationQuery) ([]NotificationSummary, error)
+	CountUnread(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	// MarkRead marks the user's notification read and reports whether they have it
+	MarkRead(ctx context.Context, id, userID primitive.ObjectID, at time.Time) (bool, error)
+	// MarkAllRead marks the user's unread notifications read and returns how many there were
+	MarkAllRead(ctx context.Context, userID primitive.ObjectID, at time.Time) (int64, error)
+}
+
+type Reports interface {
+	// Open stores the report unless the reporter already has an open report on the target,
+	// then that one takes the new reason and details. It reports whether the report is new.
+	Open(ctx context.Context, report models.Report) (bool, error)
+	Insert(ctx context.Context, report models.Report) error
+	// Queue returns a page of the targets with open reports, the most severe and most
+	// reported first, with the reported content
+	Queue(ctx context.Context, query QueueQuery) ([]QueueEntry, error)
+	// Close closes the open reports on the target with the outcome and returns how many
+	Close(ctx context.Context, targetType string, targetID primitive.ObjectID, outcome ReportOutcome) (int64, error)
+}
+
+type Warnings interface {
+	Insert(ctx context.Context, warning models.Warning) error
+}
+
+// AuditLogs is append-only, entries are never changed or removed
+type AuditLogs interface {
+	Insert(ctx context.Context, entry models.AuditLog) error
+	// List returns a page of the entries matching the query, newest first
+	List(ctx context.Context, query AuditQuery) ([]models.AuditLog, error)
+}
+
+// Viewer is what a logged in user must not see, see viewerFilters in the controllers
+type Viewer struct {
+	// Hidden authors are left out of feeds and comment lists
+	Hidden []primitive.ObjectID
+	// Resolutions by blockers can't be opened at all
+	Blockers []primitive.ObjectID
+}
+
+// FeedQuery selects a page of the feed. A non-nil Authors keeps only their resolutions, even
+// when it is empty, and a non-zero Since only the resolutions created since then.
+type FeedQuery struct {
+	Sort    string
+	Skip    int
+	Limit   int
+	Viewer  Viewer
+	Authors []primitive.ObjectID
+	Since   time.Time
+}
+
+// BookmarkQuery selects a page of a user's bookmarks, of one collection when it is set
+type BookmarkQuery struct {
+	UserID     primitive.ObjectID
+	Collection string
+	Skip       int
+	Limit      int
+	Viewer     Viewer
+}
+
+// AdopterQuery selects a page of the users who adopted a resolution
+type AdopterQuery struct {
+	RID    primitive.ObjectID
+	Skip   int
+	Limit  int
+	Viewer Viewer
+}
+
+// WebhookUpdate changes a webhook, nil fields are left as they are. Turning a webhook back on
+// clears its failures.
+type WebhookUpdate struct {
+	URL    *string
+	Events []string
+	Active *bool
+	At     time.Time
+}
+
+// DeliveryQuery selects a page of a webhook's deliveries, of one status when it is set
+type DeliveryQuery struct {
+	WebhookID primitive.ObjectID
+	Status    string
+	Skip      int
+	Limit     int
+}
+
+// UserQuery selects a page of the users, of one role when it is set
+type UserQuery struct {
+	Role  string
+	Skip  int
+	Limit int
+}
+
+// StatusUpdate is the new status of a user. An empty reason and a nil end of suspension
+// remove the ones stored.
+type StatusUpdate struct {
+	Status         string
+	Reason         string
+	SuspendedUntil *time.Time
+	At             time.Time
+}
+
+// NotificationQuery selects a page of a user's notifications, only unread ones with UnreadOnly
+type NotificationQuery struct {
+	UserID     primitive.ObjectID
+	UnreadOnly bool
+	Skip       int
+	Limit      int
+}
+
+// QueueQuery selects a page of the moderation queue, of one target type when it is set
+type QueueQuery struct {
+	TargetType string
+	Skip       int
+	Limit      int
+}
+
+// ReportOutcome is how a moderator closed the reports on a target
+type ReportOutcome struct {
+	Status     string
+	Action     string
+	ResolvedBy primitive.ObjectID
+	At         time.Time
+}
+
+// AuditQuery selects a page of the audit log, empty fields match every entry
+type AuditQuery struct {
+	ActorID    *primitive.ObjectID
+	TargetType string
+	TargetID   string
+	Action     string
+	From       *time.Time
+	To         *time.Time
+	Skip       int
+	Limit      int
+}
+
+// DetailQuery selects how a resolution's comments are listed
+type DetailQuery struct {
+	CommentSort string
+	Viewer      Viewer
+}
+
+// Summary is a resolution with its counts, as listed in the feed. ReactionCounts has the
+// number of reactions by type, the likes in LikeCount included.
+type Summary struct {
+	ID             primitive.ObjectID  `json:"_id" bson:"_id"`
+	UserID         primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Resolution     string              `json:"resolution" bson:"resolution"`
+	Tags           []string            `json:"tags" bson:"tags"`
+	Mentions       []models.Mention    `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	Hidden         bool                `json:"hidden,omitempty" bson:"hidden,omitempty"`
+	LikeCount      int64               `json:"like_count" bson:"like_count"`
+	CommentCount   int64               `json:"comment_count" bson:"comment_count"`
+	ReactionCounts map[string]int64    `json:"reaction_counts" bson:"reaction_counts"`
+	AdoptionCount  int64               `json:"adoption_count" bson:"adoption_count"`
+	AdoptedFrom    *primitive.ObjectID `json:"adopted_from,omitempty" bson:"adopted_from,omitempty"`
+	UserName       string              `json:"user_name,omitempty" bson:"user_name,omitempty"`
+	CreatedAt      time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// Activity counts the likes and visible comments others left on a resolution, and the
+// comments its author checked in with
+type Activity struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Resolution string             `bson:"resolution"`
+	Likes      int64              `bson:"likes"`
+	Comments   int64              `bson:"comments"`
+	CheckIns   int64              `bson:"check_ins"`
+}
+
+// BookmarkSummary is a bookmark with the resolution it saves
+type BookmarkSummary struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Collection string             `json:"collection,omitempty" bson:"collection,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	Resolution Summary            `json:"resolution" bson:"resolution"`
+}
+
+// CollectionCount is a bookmark collection and how many bookmarks it has
+type CollectionCount struct {
+	Name  string `json:"name" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// Adopter is a user who adopted a resolution, with their copy
+type Adopter struct {
+	RID        primitive.ObjectID `json:"r_id" bson:"_id"`
+	UserDetail *Author            `json:"user_detail,omitempty" bson:"user_detail,omitempty"`
+	AdoptedAt  time.Time          `json:"adopted_at" bson:"created_at"`
+}
+
+// Source is the resolution an adopted copy was made from
+type Source struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Resolution string             `json:"resolution" bson:"resolution"`
+	UserDetail *Author            `json:"user_detail,omitempty" bson:"user_detail,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Detail is a resolution with its counts, author and comments, in the order of the query.
+// An adopted copy has the resolution it was made from in Source, unless that one is hidden
+// or its author blocked the viewer.
+type Detail struct {
+	ID             primitive.ObjectID  `json:"_id" bson:"_id"`
+	Resolution     string              `json:"resolution" bson:"resolution"`
+	Tags           []string            `json:"tags" bson:"tags"`
+	Mentions       []models.Mention    `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	LikeCount      int64               `json:"like_count" bson:"like_count"`
+	CommentCount   int64               `json:"comment_count" bson:"comment_count"`
+	ReactionCounts map[string]int64    `json:"reaction_counts" bson:"reaction_counts"`
+	AdoptionCount  int64               `json:"adoption_count" bson:"adoption_count"`
+	AdoptedFrom    *primitive.ObjectID `json:"adopted_from,omitempty" bson:"adopted_from,omitempty"`
+	Source         *Source             `json:"source,omitempty" bson:"source,omitempty"`
+	UserDetail     *Author             `json:"user_detail,omitempty" bson:"user_detail,omitempty"`
+	Comments       []CommentDetail     `json:"comments" bson:"comments"`
+	CreatedAt      time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// CommentDetail is a comment with its author and reaction counts. MyReaction is the
+// viewer's reaction, the handlers fill it in.
+type CommentDetail struct {
+	ID             primitive.ObjectID  `json:"_id" bson:"_id"`
+	UserID         primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	RID            primitive.ObjectID  `json:"r_id" bson:"r_id"`
+	Comment        string              `json:"comment" bson:"comment"`
+	Mentions       []models.Mention    `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	ParentID       *primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Kind           string              `json:"kind,omitempty" bson:"kind,omitempty"`
+	LikeCount      int64               `json:"like_count" bson:"like_count"`
+	ReactionCounts map[string]int64    `json:"reaction_counts" bson:"reaction_counts"`
+	MyReaction     string              `json:"myReaction,omitempty" bson:"-"`
+	UserDetail     *Author             `json:"user_detail,omitempty" bson:"user_detail,omitempty"`
+	CreatedAt      time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// Author is the public part of a user shown next to their content
+type Author struct {
+	ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Name  string             `json:"name" bson:"name"`
+	Image string             `json:"image" bson:"image"`
+}
+
+// BlockSummary is a block or mute with the user it targets
+type BlockSummary struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	TargetID   primitive.ObjectID `json:"target_id" bson:"target_id"`
+	Kind       string             `json:"kind" bson:"kind"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UserDetail *Author            `json:"user_detail,omitempty" bson:"user_detail,omitempty"`
+}
+
+// NotificationSummary is a notification with the user who acted on it last
+type NotificationSummary struct {
+	models.Notification `bson:",inline"`
+	Actor               *Author `bson:"actor,omitempty"`
+}
+
+// QueueEntry is a target in the moderation queue with its open reports summed up and the
+// reported content. Resolution or Comment is set when the target is one, TargetUser is the
+// user responsible for it.
+type QueueEntry struct {
+	TargetType      string             `json:"target_type" bson:"target_type"`
+	TargetID        primitive.ObjectID `json:"target_id" bson:"target_id"`
+	ReportCount     int64              `json:"report_count" bson:"report_count"`
+	Severity        int                `json:"severity" bson:"severity"`
+	Reasons         []string           `json:"reasons" bson:"reasons"`
+	FirstReportedAt time.Time          `json:"first_reported_at" bson:"first_reported_at"`
+	LastReportedAt  time.Time          `json:"last_reported_at" bson:"last_reported_at"`
+	Resolution      *QueuedResolution  `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	Comment         *QueuedComment     `json:"comment,omitempty" bson:"comment,omitempty"`
+	TargetUser      *QueuedUser        `json:"target_user,omitempty" bson:"target_user,omitempty"`
+}
+
+type QueuedResolution struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Resolution string             `json:"resolution" bson:"resolution"`
+	Hidden     bool               `json:"hidden,omitempty" bson:"hidden,omitempty"`
+}
+
+type QueuedComment struct {
+	ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Comment string             `json:"comment" bson:"comment"`
+	RID     primitive.ObjectID `json:"r_id" bson:"r_id"`
+	Hidden  bool               `json:"hidden,omitempty" bson:"hidden,omitempty"`
+}
+
+type QueuedUser struct {
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	Name           string             `json:"name" bson:"name"`
+	Image          string             `json:"image" bson:"image"`
+	Status         string             `json:"status,omitempty" bson:"status,omitempty"`
+	SuspendedUntil *time.Time         `json:"suspended_until,omitempty" bson:"suspended_until,omitempty"`
+}
@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/utils"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type remindersResponse struct {
+	Reminders []models.Reminder `json:"reminders"`
+}
+
+type tokensResponse struct {
+	Tokens []models.PersonalAccessToken `json:"tokens"`
+}
+
+func TestReminders(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", func(u *models.User) { u.Timezone = "America/New_York" })
+	bob := s.user(t, "Bob", nil)
+	resolution := s.resolution(t, alice, "run a marathon", time.Now())
+	path := "/resolution/" + resolution.RID.Hex() + "/reminder"
+
+	t.Run("set", func(t *testing.T) {
+		w := s.as(alice, "PUT", path, gin.H{"frequency": models.ReminderWeekly, "hour": 7, "weekday": int(time.Friday)})
+		expectStatus(t, w, http.StatusOK)
+		var body struct {
+			Reminder models.Reminder `json:"reminder"`
+		}
+		decodeInto(t, w, &body)
+		got := body.Reminder
+		if got.ID.IsZero() || got.RID != resolution.RID || got.Frequency != models.ReminderWeekly || got.Timezone != "America/New_York" {
+			t.Fatalf("got %+v, want a weekly reminder in Alice's timezone", got)
+		}
+		next := got.NextRunAt.In(mustLoad(t, "America/New_York"))
+		if next.Weekday() != time.Friday || next.Hour() != 7 || !next.After(time.Now()) {
+			t.Errorf("next run at %s, want a coming Friday at 7 in New York", next)
+		}
+	})
+
+	t.Run("setting again replaces it", func(t *testing.T) {
+		expectStatus(t, s.as(alice, "PUT", path, gin.H{"frequency": models.ReminderDaily}), http.StatusOK)
+		var body remindersResponse
+		decodeInto(t, s.as(alice, "GET", "/reminders", nil), &body)
+		if len(body.Reminders) != 1 || body.Reminders[0].Frequency != models.ReminderDaily || body.Reminders[0].Hour != 9 {
+			t.Fatalf("got %+v, want one daily reminder at the default hour", body.Reminders)
+		}
+	})
+
+	t.Run("only for your own resolutions", func(t *testing.T) {
+		expectError(t, s.as(bob, "PUT", path, gin.H{"frequency": models.ReminderDaily}), http.StatusNotFound, "Resolution not found")
+		expectError(t, s.as(bob, "DELETE", path, nil), http.StatusNotFound, "Reminder not found")
+		var body remindersResponse
+		decodeInto(t, s.as(bob, "GET", "/reminders", nil), &body)
+		if len(body.Reminders) != 0 {
+			t.Errorf("Bob sees %+v", body.Reminders)
+		}
+	})
+
+	t.Run("timezone change reschedules at the same local hour", func(t *testing.T) {
+		w := s.as(alice, "PUT", "/profile/timezone", gin.H{"timezone": "Asia/Tokyo"})
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["timezone"]; got != "Asia/Tokyo" {
+			t.Errorf("got timezone %v", got)
+		}
+
+		user, err := s.repos.Users.FindByID(context.Background(), alice.ID)
+		if err != nil || user.Timezone != "Asia/Tokyo" {
+			t.Fatalf("got %q, %v, want the timezone stored", user.Timezone, err)
+		}
+		var body remindersResponse
+		decodeInto(t, s.as(alice, "GET", "/reminders", nil), &body)
+		if len(body.Reminders) != 1 {
+			t.Fatalf("got %d reminders", len(body.Reminders))
+		}
+		reminder := body.Reminders[0]
+		if next := reminder.NextRunAt.In(mustLoad(t, "Asia/Tokyo")); reminder.Timezone != "Asia/Tokyo" || next.Hour() != 9 {
+			t.Errorf("got %s in %s, want 9 o'clock in Tokyo", next, reminder.Timezone)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		expectStatus(t, s.as(alice, "DELETE", path, nil), http.StatusOK)
+		expectError(t, s.as(alice, "DELETE", path, nil), http.StatusNotFound, "Reminder not found")
+		var body remindersResponse
+		decodeInto(t, s.as(alice, "GET", "/reminders", nil), &body)
+		if len(body.Reminders) != 0 {
+			t.Errorf("got %+v after deleting", body.Reminders)
+		}
+	})
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return location
+}
+
+func TestTokens(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	s.resolution(t, alice, "run a marathon", time.Now())
+
+	w := s.as(alice, "POST", "/profile/tokens", gin.H{"name": " cli ", "scopes": []string{models.ScopeRead, models.ScopeRead}, "expires_in_days": 30})
+	expectStatus(t, w, http.StatusCreated)
+	var created struct {
+		Token   string                     `json:"token"`
+		Details models.PersonalAccessToken `json:"details"`
+	}
+	decodeInto(t, w, &created)
+
+	t.Run("created", func(t *testing.T) {
+		if !utils.IsPersonalAccessToken(created.Token) || created.Details.Name != "cli" || len(created.Details.Scopes) != 1 {
+			t.Fatalf("got %+v, want a token named cli with the read scope once", created)
+		}
+		if created.Details.ExpiresAt == nil || created.Details.ExpiresAt.Before(time.Now().AddDate(0, 0, 29)) {
+			t.Errorf("expires at %v, want in 30 days", created.Details.ExpiresAt)
+		}
+		if got := s.audit.actions(); len(got) != 1 || got[0] != audit.ActionTokenCreated {
+			t.Errorf("got audit actions %v", got)
+		}
+	})
+
+	t.Run("listed for their owner only", func(t *testing.T) {
+		var body tokensResponse
+		decodeInto(t, s.as(alice, "GET", "/profile/tokens", nil), &body)
+		if len(body.Tokens) != 1 || body.Tokens[0].ID != created.Details.ID {
+			t.Fatalf("got %+v, want the new token", body.Tokens)
+		}
+		decodeInto(t, s.as(bob, "GET", "/profile/tokens", nil), &body)
+		if len(body.Tokens) != 0 {
+			t.Errorf("Bob sees %+v", body.Tokens)
+		}
+		expectError(t, s.as(bob, "DELETE", "/profile/tokens/"+created.Details.ID.Hex(), nil), http.StatusNotFound, "Token not found")
+	})
+
+	t.Run("signs in with its scopes", func(t *testing.T) {
+		var feed feedResponse
+		w := s.request("GET", "/resolution/me", bearer(created.Token), nil)
+		expectStatus(t, w, http.StatusOK)
+		decodeInto(t, w, &feed)
+		if len(feed.Resolutions) != 1 {
+			t.Errorf("got %d resolutions, want Alice's one", len(feed.Resolutions))
+		}
+
+		expectError(t, s.request("POST", "/resolution", bearer(created.Token), gin.H{"resolution": "learn to juggle"}), http.StatusForbidden, "Token is missing the resolutions:write scope")
+		expectError(t, s.request("GET", "/profile/tokens", bearer(created.Token), nil), http.StatusForbidden, "This action requires signing in")
+		expectError(t, s.request("GET", "/stream/notifications", bearer(created.Token), nil), http.StatusForbidden, "This action requires signing in")
+
+		var body tokensResponse
+		decodeInto(t, s.as(alice, "GET", "/profile/tokens", nil), &body)
+		if len(body.Tokens) != 1 || body.Tokens[0].LastUsedAt == nil {
+			t.Errorf("got %+v, want the use recorded", body.Tokens)
+		}
+	})
+
+	t.Run("revoked tokens stop working", func(t *testing.T) {
+		expectStatus(t, s.as(alice, "DELETE", "/profile/tokens/"+created.Details.ID.Hex(), nil), http.StatusOK)
+		expectError(t, s.request("GET", "/resolution/me", bearer(created.Token), nil), http.StatusUnauthorized, "Invalid or expired token")
+		expectError(t, s.as(alice, "DELETE", "/profile/tokens/"+created.Details.ID.Hex(), nil), http.StatusNotFound, "Token not found")
+
+		var body tokensResponse
+		decodeInto(t, s.as(alice, "GET", "/profile/tokens", nil), &body)
+		if len(body.Tokens) != 1 || body.Tokens[0].RevokedAt == nil {
+			t.Errorf("got %+v, want the token listed as revoked", body.Tokens)
+		}
+		if got := s.audit.actions(); len(got) != 2 || got[1] != audit.ActionTokenRevoked {
+			t.Errorf("got audit actions %v", got)
+		}
+	})
+
+	t.Run("expired tokens stop working", func(t *testing.T) {
+		raw, hash, err := utils.GeneratePersonalAccessToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := time.Now().Add(-time.Minute)
+		if err := s.repos.Tokens.Insert(context.Background(), models.PersonalAccessToken{
+			ID:        primitive.NewObjectID(),
+			UserID:    alice.ID,
+			Name:      "old",
+			TokenHash: hash,
+			Scopes:    []string{models.ScopeRead},
+			ExpiresAt: &expired,
+			CreatedAt: time.Now().AddDate(0, -1, 0),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		expectError(t, s.request("GET", "/resolution/me", bearer(raw), nil), http.StatusUnauthorized, "Invalid or expired token")
+	})
+}
@@ -0,0 +1,522 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nyr/audit"
+	"nyr/config"
+	"nyr/controllers"
+	"nyr/mailer"
+	"nyr/models"
+	"nyr/providers"
+	"nyr/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeGoogle answers Google's tokeninfo endpoint, the ID token is the key into tokens
+func fakeGoogle(t *testing.T, tokens map[string]map[string]string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := tokens[r.URL.Query().Get("id_token")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_token"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		providers.Register(providers.NewGoogle(config.GoogleOAuthConfig))
+	})
+
+	providers.Register(&providers.Google{
+		Config:       config.GoogleOAuthConfig,
+		TokenInfoURL: srv.URL,
+		Client:       srv.Client(),
+	})
+}
+
+func TestGoogleLogin(t *testing.T) {
+	s := newTestServer(t)
+	existing := s.user(t, "Existing", nil)
+	s.user(t, "Banned", func(u *models.User) {
+		u.Status = models.UserStatusBanned
+		u.Identities = []models.Identity{{Provider: "google", Subject: "banned-sub", Email: u.Email}}
+	})
+	fakeGoogle(t, map[string]map[string]string{
+		"new":       {"sub": "new-sub", "email": "new@example.com", "email_verified": "true", "name": "New"},
+		"existing":  {"sub": "existing-sub", "email": existing.Email, "email_verified": "true", "name": "Existing"},
+		"uppercase": {"sub": "uppercase-sub", "email": " " + strings.ToUpper(existing.Email), "email_verified": "true", "name": "Existing"},
+		"banned":    {"sub": "banned-sub", "email": "banned@example.com", "email_verified": "true", "name": "Banned"},
+		"no-email":  {"sub": "no-email-sub", "email_verified": "false"},
+	})
+
+	login := func(token string) *httptest.ResponseRecorder {
+		return s.request("POST", "/auth/google/callback", "", map[string]string{"token": token})
+	}
+
+	t.Run("invalid body", func(t *testing.T) {
+		expectError(t, s.request("POST", "/auth/google/callback", "", "nope"), http.StatusBadRequest, "Invalid request")
+	})
+	t.Run("token Google rejects", func(t *testing.T) {
+		expectError(t, login("forged"), http.StatusUnauthorized, "Invalid Google token")
+	})
+	t.Run("token without email", func(t *testing.T) {
+		expectError(t, login("no-email"), http.StatusUnauthorized, "Invalid Google token")
+	})
+
+	t.Run("first login creates the user", func(t *testing.T) {
+		w := login("new")
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["firstlogin"] != true {
+			t.Errorf("got firstlogin %v, want true", body["firstlogin"])
+		}
+
+		// The session works on authenticated routes
+		token := body["token"].(string)
+		w = s.request("GET", "/resolution/me", bearer(token), nil)
+		expectStatus(t, w, http.StatusOK)
+
+		w = login("new")
+		expectStatus(t, w, http.StatusOK)
+		if again := decode(t, w); again["firstlogin"] != false || again["user"].(map[string]interface{})["id"] != body["user"].(map[string]interface{})["id"] {
+			t.Errorf("second login returned %v, want the same user without firstlogin", again)
+		}
+	})
+
+	t.Run("verified email links the existing user", func(t *testing.T) {
+		w := login("existing")
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["firstlogin"] != false || body["user"].(map[string]interface{})["id"] != existing.ID.Hex() {
+			t.Errorf("got %v, want the existing user", body)
+		}
+	})
+
+	t.Run("email case doesn't matter", func(t *testing.T) {
+		w := login("uppercase")
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if body["firstlogin"] != false || body["user"].(map[string]interface{})["id"] != existing.ID.Hex() {
+			t.Errorf("got %v, want the existing user", body)
+		}
+	})
+
+	t.Run("banned user is refused", func(t *testing.T) {
+		expectError(t, login("banned"), http.StatusForbidden, "Account banned")
+	})
+
+	t.Run("logins are audited", func(t *testing.T) {
+		got := strings.Join(s.audit.actions(), ",")
+		want := strings.Join([]string{audit.ActionLogin, audit.ActionLogin, audit.ActionLogin, audit.ActionLogin, audit.ActionLoginRefused}, ",")
+		if got != want {
+			t.Errorf("got audit actions %s, want %s", got, want)
+		}
+	})
+}
+
+func TestUnverifiedEmailDoesNotClaimAccount(t *testing.T) {
+	s := newTestServer(t)
+	fakeGoogle(t, map[string]map[string]string{
+		"attacker": {"sub": "attacker-sub", "email": "victim@example.com", "email_verified": "false", "name": "Attacker"},
+		"victim":   {"sub": "victim-sub", "email": "victim@example.com", "email_verified": "true", "name": "Victim"},
+	})
+	login := func(token string) string {
+		t.Helper()
+		w := s.request("POST", "/auth/google/callback", "", map[string]string{"token": token})
+		expectStatus(t, w, http.StatusOK)
+		return decode(t, w)["user"].(map[string]interface{})["id"].(string)
+	}
+
+	attacker := login("attacker")
+	victim := login("victim")
+	if attacker == victim {
+		t.Fatalf("the verified sign in joined the account the unverified one created")
+	}
+	if again := login("attacker"); again != attacker {
+		t.Errorf("the unverified identity signs in to %s, want its own account %s", again, attacker)
+	}
+
+	id, _ := primitive.ObjectIDFromHex(attacker)
+	user, err := s.repos.Users.FindByID(context.Background(), id)
+	if err != nil || user.Email != "" || len(user.Identities) != 1 {
+		t.Errorf("got %+v, %v, want the unverified account without the email or other identities", user, err)
+	}
+}
+
+func TestBootstrapAdminNeedsVerifiedEmail(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", "boss@example.com, Chief@example.com")
+	s := newTestServer(t)
+	fakeGoogle(t, map[string]map[string]string{
+		"unverified": {"sub": "boss-sub", "email": "boss@example.com", "email_verified": "false", "name": "Boss"},
+		"verified":   {"sub": "chief-sub", "email": "chief@example.com", "email_verified": "true", "name": "Chief"},
+	})
+
+	role := func(token string) interface{} {
+		t.Helper()
+		w := s.request("POST", "/auth/google/callback", "", map[string]string{"token": token})
+		expectStatus(t, w, http.StatusOK)
+		return decode(t, w)["user"].(map[string]interface{})["role"]
+	}
+
+	if got := role("unverified"); got != models.RoleUser {
+		t.Errorf("unverified admin email got role %v, want %s", got, models.RoleUser)
+	}
+	if got := role("verified"); got != models.RoleAdmin {
+		t.Errorf("verified admin email got role %v, want %s", got, models.RoleAdmin)
+	}
+
+	granted := 0
+	for _, entry := range s.audit.entries {
+		if entry.Action == audit.ActionRoleChanged && entry.Changes["role"].After == models.RoleAdmin {
+			granted++
+		}
+	}
+	if granted != 1 {
+		t.Errorf("got %d audited admin grants, want 1", granted)
+	}
+}
+
+func TestBootstrapAdmins(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", "boss@example.com, chief@example.com, owner@example.com")
+	s := newTestServer(t)
+	identity := func(email string, verified bool) func(*models.User) {
+		return func(u *models.User) {
+			u.Identities = []models.Identity{{Provider: "google", Subject: u.Name, Email: email, EmailVerified: verified}}
+		}
+	}
+	boss := s.user(t, "Boss", identity("boss@example.com", true))
+	chief := s.user(t, "Chief", identity("chief@example.com", false))
+	other := s.user(t, "Other", identity("other@example.com", true))
+	// Signed up unverified and got the owner's verified identity linked afterwards
+	owner := s.user(t, "Owner", func(u *models.User) {
+		u.Identities = []models.Identity{
+			{Provider: "github", Subject: "owner-1", Email: u.Email},
+			{Provider: "google", Subject: "owner-2", Email: u.Email, EmailVerified: true},
+		}
+	})
+
+	controllers.BootstrapAdmins()
+	controllers.BootstrapAdmins()
+
+	for _, tc := range []struct {
+		user models.User
+		role string
+	}{
+		{boss, models.RoleAdmin},
+		{chief, models.RoleUser},
+		{other, models.RoleUser},
+		{owner, models.RoleUser},
+	} {
+		if user, err := s.repos.Users.FindByID(context.Background(), tc.user.ID); err != nil || user.Role != tc.role {
+			t.Errorf("%s has role %q (%v), want %s", tc.user.Name, user.Role, err, tc.role)
+		}
+	}
+	if got := s.audit.actions(); len(got) != 1 || got[0] != audit.ActionRoleChanged || s.audit.last().TargetID != boss.ID.Hex() {
+		t.Errorf("got audit actions %v, want Boss's promotion once", got)
+	}
+}
+
+// sentMail stands in for the mailer and keeps the messages it is asked to send
+type sentMail struct {
+	sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *sentMail) Send(ctx context.Context, msg mailer.Message) error {
+	m.Lock()
+	defer m.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func captureMail(t *testing.T) *sentMail {
+	t.Helper()
+	m := &sentMail{}
+	previous := mailer.Default
+	mailer.Default = m
+	t.Cleanup(func() { mailer.Default = previous })
+	return m
+}
+
+func TestMagicLink(t *testing.T) {
+	t.Setenv("MAGIC_LINK_URL", "https://app.example.com/sign-in")
+	s := newTestServer(t)
+	mail := captureMail(t)
+	existing := s.user(t, "Existing", nil)
+
+	// request asks for a link and returns the token in the email
+	request := func(t *testing.T, email string) string {
+		t.Helper()
+		expectStatus(t, s.request("POST", "/auth/magic-link", "", gin.H{"email": email}), http.StatusOK)
+		mail.Lock()
+		defer mail.Unlock()
+		msg := mail.messages[len(mail.messages)-1]
+		if msg.To != models.NormalizeEmail(email) {
+			t.Errorf("sent to %q, want %q", msg.To, models.NormalizeEmail(email))
+		}
+		_, rest, ok := strings.Cut(msg.Text, "https://app.example.com/sign-in?token=")
+		if !ok {
+			t.Fatalf("no sign in link in %q", msg.Text)
+		}
+		token, err := url.QueryUnescape(strings.Fields(rest)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	verify := func(token string) *httptest.ResponseRecorder {
+		return s.request("POST", "/auth/magic-link/verify", "", gin.H{"token": token})
+	}
+
+	t.Run("first sign in creates the user", func(t *testing.T) {
+		w := verify(request(t, "new@example.com"))
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["firstlogin"] != true || body["user"].(map[string]interface{})["email"] != "new@example.com" {
+			t.Errorf("got %v, want a new user", body)
+		}
+	})
+
+	t.Run("signs in the account with the email whatever its case", func(t *testing.T) {
+		w := verify(request(t, strings.ToUpper(existing.Email)))
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["firstlogin"] != false || body["user"].(map[string]interface{})["id"] != existing.ID.Hex() {
+			t.Errorf("got %v, want the existing user", body)
+		}
+	})
+
+	t.Run("links work once", func(t *testing.T) {
+		token := request(t, existing.Email)
+		expectStatus(t, verify(token), http.StatusOK)
+		expectError(t, verify(token), http.StatusUnauthorized, "Invalid or expired sign in link")
+	})
+
+	t.Run("doesn't sign in to an account an unverified identity created", func(t *testing.T) {
+		providers.Register(stubProvider{})
+		w := s.request("POST", "/auth/stub/callback", "", gin.H{"code": "ok:victim", "state": s.startSignIn(t), "verifier": testVerifier})
+		expectStatus(t, w, http.StatusOK)
+		claimed := decode(t, w)["user"].(map[string]interface{})["id"]
+
+		w = verify(request(t, "victim@stub.example.com"))
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if user := body["user"].(map[string]interface{}); body["firstlogin"] != true || user["id"] == claimed || user["email"] != "victim@stub.example.com" {
+			t.Errorf("got %v, want a new account with the address", body)
+		}
+	})
+
+	t.Run("leaves an address to the account that holds it", func(t *testing.T) {
+		// Stored before unverified emails were kept off accounts
+		held := s.user(t, "Held", func(u *models.User) {
+			u.Identities = []models.Identity{{Provider: "github", Subject: "held", Email: u.Email}}
+		})
+		w := verify(request(t, held.Email))
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		if user := body["user"].(map[string]interface{}); body["firstlogin"] != true || user["id"] == held.ID.Hex() || user["email"] != "" {
+			t.Errorf("got %v, want a new account without the address", body)
+		}
+	})
+}
+
+func TestVerifyToken(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	banned := s.user(t, "Banned", func(u *models.User) { u.Status = models.UserStatusBanned })
+	tokenFor := func(id primitive.ObjectID) string {
+		return bearer(signToken(jwt.MapClaims{"user_id": id.Hex(), "exp": time.Now().Add(time.Hour).Unix()}))
+	}
+
+	cases := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer nope", http.StatusUnauthorized},
+		{"valid", tokenFor(alice.ID), http.StatusOK},
+		{"banned", tokenFor(banned.ID), http.StatusForbidden},
+		{"unknown user", tokenFor(primitive.NewObjectID()), http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectStatus(t, s.request("GET", "/verify-token", tc.authorization, nil), tc.status)
+		})
+	}
+}
+
+func TestPublicRoutes(t *testing.T) {
+	s := newTestServer(t)
+	providers.Register(stubProvider{})
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		status  int
+		message string
+	}{
+		{"health", "GET", "/health", nil, http.StatusOK, ""},
+		{"providers", "GET", "/auth/providers", nil, http.StatusOK, ""},
+		{"google redirect", "GET", "/auth/google?challenge=" + testChallenge, nil, http.StatusTemporaryRedirect, ""},
+		{"google redirect without challenge", "GET", "/auth/google", nil, http.StatusBadRequest, "A challenge is required"},
+		{"provider redirect", "GET", "/auth/stub?challenge=" + testChallenge, nil, http.StatusTemporaryRedirect, ""},
+		{"provider redirect without challenge", "GET", "/auth/stub", nil, http.StatusBadRequest, "A challenge is required"},
+		{"provider redirect with malformed challenge", "GET", "/auth/stub?challenge=plain", nil, http.StatusBadRequest, "A challenge is required"},
+		{"unknown provider", "GET", "/auth/nope", nil, http.StatusNotFound, "Unknown identity provider"},
+		{"provider callback without code", "POST", "/auth/stub/callback", gin.H{}, http.StatusBadRequest, "Invalid request"},
+		{"unknown provider callback", "POST", "/auth/nope/callback", gin.H{"code": "c", "state": "s", "verifier": "v"}, http.StatusNotFound, "Unknown identity provider"},
+		{"provider callback without verifier", "POST", "/auth/stub/callback", gin.H{"code": "c", "state": "s"}, http.StatusBadRequest, "Invalid request"},
+		{"provider callback with forged state", "POST", "/auth/stub/callback", gin.H{"code": "c", "state": "forged", "verifier": testVerifier}, http.StatusBadRequest, "Invalid state"},
+		{"magic link for invalid email", "POST", "/auth/magic-link", gin.H{"email": "nope"}, http.StatusBadRequest, "A valid email is required"},
+		{"magic link without token", "POST", "/auth/magic-link/verify", gin.H{}, http.StatusBadRequest, "Invalid request"},
+		{"magic link with bad token", "POST", "/auth/magic-link/verify", gin.H{"token": "nope"}, http.StatusUnauthorized, "Invalid or expired sign in link"},
+		{"unsubscribe with bad link", "GET", "/unsubscribe?user=u&category=digest&token=t", nil, http.StatusBadRequest, "Invalid unsubscribe link"},
+		{"one-click unsubscribe with bad link", "POST", "/unsubscribe", nil, http.StatusBadRequest, "Invalid unsubscribe link"},
+		{"stream of invalid resolution", "GET", "/stream/resolutions/nope", nil, http.StatusBadRequest, "Invalid resolution ID"},
+		{"stream of unknown resolution", "GET", "/stream/resolutions/" + primitive.NewObjectID().Hex(), nil, http.StatusNotFound, "Resolution not found"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.request(tc.method, tc.path, "", tc.body)
+			if tc.message == "" {
+				expectStatus(t, w, tc.status)
+			} else {
+				expectError(t, w, tc.status, tc.message)
+			}
+		})
+	}
+}
+
+// stubProvider is a provider without a real sign in page, for the generic provider routes.
+// Codes of the form "ok:<subject>" exchange for that account, others are refused.
+type stubProvider struct{}
+
+func (stubProvider) Name() string { return "stub" }
+func (stubProvider) AuthCodeURL(state string) string {
+	return "https://example.com/login?state=" + state
+}
+func (stubProvider) Exchange(ctx context.Context, code string) (*providers.Identity, error) {
+	subject, ok := strings.CutPrefix(code, "ok:")
+	if !ok {
+		return nil, providers.ErrInvalidIdentity
+	}
+	return &providers.Identity{Provider: "stub", Subject: subject, Email: subject + "@stub.example.com", Name: subject}, nil
+}
+
+// testVerifier is the verifier the app would keep for a sign in, testChallenge is sent with the redirect
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+var testChallenge = utils.OAuthChallenge(testVerifier)
+
+// startSignIn follows the redirect to the stub provider like a browser and returns the state
+func (s *testServer) startSignIn(t *testing.T) string {
+	t.Helper()
+	w := s.request("GET", "/auth/stub?challenge="+testChallenge, "", nil)
+	expectStatus(t, w, http.StatusTemporaryRedirect)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	return location.Query().Get("state")
+}
+
+func TestProviderSignIn(t *testing.T) {
+	s := newTestServer(t)
+	providers.Register(stubProvider{})
+	state := s.startSignIn(t)
+
+	// Someone else's browser can't redeem the state, it doesn't hold the verifier
+	for _, verifier := range []string{"attacker-verifier-attacker-verifier-attacker", ""} {
+		w := s.request("POST", "/auth/stub/callback", "", gin.H{"code": "ok:alice", "state": state, "verifier": verifier})
+		if verifier == "" {
+			expectError(t, w, http.StatusBadRequest, "Invalid request")
+		} else {
+			expectError(t, w, http.StatusBadRequest, "Invalid state")
+		}
+	}
+
+	// The browser that started the sign in can, the provider decides about the code
+	expectError(t, s.request("POST", "/auth/stub/callback", "", gin.H{"code": "refused", "state": state, "verifier": testVerifier}),
+		http.StatusUnauthorized, "Failed to sign in with stub")
+
+	w := s.request("POST", "/auth/stub/callback", "", gin.H{"code": "ok:alice", "state": state, "verifier": testVerifier})
+	expectStatus(t, w, http.StatusOK)
+	body := decode(t, w)
+	if body["token"] == "" || body["firstlogin"] != true {
+		t.Errorf("got %v, want a session for a new user", body)
+	}
+	// The stub doesn't verify emails, so the account doesn't get one
+	user, err := s.repos.Users.FindByIdentity(context.Background(), "stub", "alice")
+	if err != nil || user.Name != "alice" || user.Email != "" {
+		t.Errorf("got %+v, %v, want the user created from the identity without its email", user, err)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	s := newTestServer(t)
+	providers.Register(stubProvider{})
+	alice := s.user(t, "Alice", func(u *models.User) {
+		u.Identities = []models.Identity{{Provider: "stub", Subject: "alice-1", LinkedAt: time.Now()}}
+	})
+	bob := s.user(t, "Bob", nil)
+
+	link := func(as models.User, code string) *httptest.ResponseRecorder {
+		return s.as(as, "POST", "/profile/identities/stub", gin.H{"code": code, "state": s.startSignIn(t), "verifier": testVerifier})
+	}
+	identities := func() []string {
+		user, _ := s.repos.Users.FindByID(context.Background(), alice.ID)
+		subjects := []string{}
+		for _, identity := range user.Identities {
+			subjects = append(subjects, identity.Subject)
+		}
+		return subjects
+	}
+
+	expectError(t, s.as(alice, "POST", "/profile/identities/stub", gin.H{"code": "ok:alice-2", "state": s.startSignIn(t), "verifier": "someone-elses-verifier-someone-elses-verifier"}),
+		http.StatusBadRequest, "Invalid state")
+
+	expectStatus(t, link(alice, "ok:alice-2"), http.StatusCreated)
+	expectStatus(t, link(alice, "ok:alice-2"), http.StatusOK)
+	expectError(t, link(bob, "ok:alice-2"), http.StatusConflict, "Identity is linked to another account")
+	if got := identities(); strings.Join(got, ",") != "alice-1,alice-2" {
+		t.Fatalf("got identities %v after linking", got)
+	}
+	if got := strings.Join(s.audit.actions(), ","); got != audit.ActionIdentityLinked {
+		t.Errorf("got audit actions %q, want one %s", got, audit.ActionIdentityLinked)
+	}
+
+	// With two accounts at the provider the subject picks one, the other stays linked
+	expectError(t, s.as(alice, "DELETE", "/profile/identities/stub", nil),
+		http.StatusBadRequest, "Several identities are linked at this provider, choose one with subject")
+	expectError(t, s.as(alice, "DELETE", "/profile/identities/stub?subject=unknown", nil),
+		http.StatusConflict, "Identity is not linked or is the only way to sign in")
+	expectStatus(t, s.as(alice, "DELETE", "/profile/identities/stub?subject=alice-1", nil), http.StatusOK)
+	if got := identities(); strings.Join(got, ",") != "alice-2" {
+		t.Fatalf("got identities %v after unlinking alice-1", got)
+	}
+
+	// The last identity can't be unlinked, with or without its subject
+	expectError(t, s.as(alice, "DELETE", "/profile/identities/stub", nil),
+		http.StatusConflict, "Identity is not linked or is the only way to sign in")
+	expectError(t, s.as(alice, "DELETE", "/profile/identities/stub?subject=alice-2", nil),
+		http.StatusConflict, "Identity is not linked or is the only way to sign in")
+	if got := identities(); strings.Join(got, ",") != "alice-2" {
+		t.Errorf("got identities %v, want the last one kept", got)
+	}
+}
@@ -0,0 +1,338 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/repository"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type queueResponse struct {
+	Queue []repository.QueueEntry `json:"queue"`
+}
+
+type auditResponse struct {
+	Entries []models.AuditLog `json:"entries"`
+}
+
+func TestAdminRoles(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	alice := s.user(t, "Alice", nil)
+	s.user(t, "Mod", func(u *models.User) { u.Role = models.RoleModerator })
+	rolePath := func(u models.User) string { return "/admin/users/" + u.ID.Hex() + "/role" }
+
+	listed := func(t *testing.T, as models.User, query string) []primitive.ObjectID {
+		t.Helper()
+		var body struct {
+			Users []models.User `json:"users"`
+		}
+		decodeInto(t, s.as(as, "GET", "/admin/users"+query, nil), &body)
+		ids := []primitive.ObjectID{}
+		for _, u := range body.Users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	t.Run("grant", func(t *testing.T) {
+		w := s.as(admin, "PUT", rolePath(alice), gin.H{"role": models.RoleModerator})
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["role"]; got != models.RoleModerator {
+			t.Errorf("got role %v", got)
+		}
+		if len(listed(t, admin, "?role=moderator")) != 2 {
+			t.Errorf("want Alice listed with the moderators")
+		}
+		// Moderator routes open up right away
+		expectStatus(t, s.as(alice, "GET", "/moderation/reports", nil), http.StatusOK)
+		if got := s.audit.last(); got.Action != audit.ActionRoleChanged || got.Changes["role"].Before != models.RoleUser {
+			t.Errorf("got audit entry %+v", got)
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		expectStatus(t, s.as(admin, "DELETE", rolePath(alice), nil), http.StatusOK)
+		expectIDs(t, listed(t, admin, "?role=user"), alice.ID)
+		expectError(t, s.as(alice, "GET", "/moderation/reports", nil), http.StatusForbidden, "You don't have permission to do this")
+	})
+
+	t.Run("the last admin stays", func(t *testing.T) {
+		expectError(t, s.as(admin, "DELETE", rolePath(admin), nil), http.StatusConflict, "Can't remove the last admin")
+		expectIDs(t, listed(t, admin, "?role=admin"), admin.ID)
+
+		expectStatus(t, s.as(admin, "PUT", rolePath(alice), gin.H{"role": models.RoleAdmin}), http.StatusOK)
+		expectStatus(t, s.as(admin, "DELETE", rolePath(admin), nil), http.StatusOK)
+		expectIDs(t, listed(t, alice, "?role=admin"), alice.ID)
+		expectError(t, s.as(alice, "DELETE", rolePath(alice), nil), http.StatusConflict, "Can't remove the last admin")
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		expectError(t, s.as(alice, "PUT", "/admin/users/"+primitive.NewObjectID().Hex()+"/role", gin.H{"role": models.RoleModerator}), http.StatusNotFound, "User not found")
+	})
+}
+
+func TestUserStatus(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	alice := s.user(t, "Alice", nil)
+	path := "/admin/users/" + alice.ID.Hex()
+
+	stored := func(t *testing.T) models.User {
+		t.Helper()
+		user, err := s.repos.Users.FindByID(context.Background(), alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	t.Run("suspend", func(t *testing.T) {
+		expectStatus(t, s.as(admin, "POST", path+"/suspend", gin.H{"reason": " spam ", "days": 3}), http.StatusOK)
+		user := stored(t)
+		if user.Status != models.UserStatusSuspended || user.StatusReason != "spam" || user.SuspendedUntil == nil ||
+			user.SuspendedUntil.Before(time.Now().AddDate(0, 0, 2)) {
+			t.Fatalf("got %s %q until %v, want suspended for spam for 3 days", user.Status, user.StatusReason, user.SuspendedUntil)
+		}
+		expectError(t, s.as(alice, "GET", "/notifications", nil), http.StatusForbidden, "Account suspended")
+	})
+
+	t.Run("ban clears the suspension", func(t *testing.T) {
+		expectStatus(t, s.as(admin, "POST", path+"/ban", gin.H{"reason": "abuse"}), http.StatusOK)
+		user := stored(t)
+		if user.Status != models.UserStatusBanned || user.StatusReason != "abuse" || user.SuspendedUntil != nil {
+			t.Fatalf("got %s %q until %v, want banned for abuse", user.Status, user.StatusReason, user.SuspendedUntil)
+		}
+		expectError(t, s.as(alice, "GET", "/notifications", nil), http.StatusForbidden, "Account banned")
+	})
+
+	t.Run("reinstate", func(t *testing.T) {
+		expectStatus(t, s.as(admin, "POST", path+"/reinstate", nil), http.StatusOK)
+		user := stored(t)
+		if user.Status != models.UserStatusActive || user.StatusReason != "" || user.SuspendedUntil != nil {
+			t.Fatalf("got %s %q until %v, want active", user.Status, user.StatusReason, user.SuspendedUntil)
+		}
+		expectStatus(t, s.as(alice, "GET", "/notifications", nil), http.StatusOK)
+
+		entry := s.audit.last()
+		if entry.Action != audit.ActionStatusChanged || entry.Changes["status"].Before != models.UserStatusBanned ||
+			entry.Changes["status_reason"].Before != "abuse" || entry.Changes["status_reason"].After != nil {
+			t.Errorf("got audit entry %+v", entry)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		expectError(t, s.as(admin, "POST", "/admin/users/"+primitive.NewObjectID().Hex()+"/ban", gin.H{"reason": "spam"}), http.StatusNotFound, "User not found")
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+
+	start := time.Now().Add(-time.Second)
+	expectStatus(t, s.as(admin, "PUT", "/admin/users/"+alice.ID.Hex()+"/role", gin.H{"role": models.RoleModerator}), http.StatusOK)
+	expectStatus(t, s.as(admin, "POST", "/admin/users/"+bob.ID.Hex()+"/ban", gin.H{"reason": "spam"}), http.StatusOK)
+	expectStatus(t, s.as(alice, "POST", "/profile/tokens", gin.H{"name": "cli", "scopes": []string{models.ScopeRead}}), http.StatusCreated)
+
+	for _, tc := range []struct {
+		name  string
+		query url.Values
+		want  []string
+	}{
+		{"everything, newest first", nil, []string{audit.ActionTokenCreated, audit.ActionStatusChanged, audit.ActionRoleChanged}},
+		{"by actor", url.Values{"actor": {admin.ID.Hex()}}, []string{audit.ActionStatusChanged, audit.ActionRoleChanged}},
+		{"by action", url.Values{"action": {audit.ActionRoleChanged}}, []string{audit.ActionRoleChanged}},
+		{"by target", url.Values{"target_type": {audit.TargetUser}, "target_id": {bob.ID.Hex()}}, []string{audit.ActionStatusChanged}},
+		{"from", url.Values{"from": {start.Format(time.RFC3339)}}, []string{audit.ActionTokenCreated, audit.ActionStatusChanged, audit.ActionRoleChanged}},
+		{"to", url.Values{"to": {start.Format(time.RFC3339)}}, []string{}},
+		{"paged", url.Values{"limit": {"1"}, "page": {"2"}}, []string{audit.ActionStatusChanged}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(admin, "GET", "/admin/audit?"+tc.query.Encode(), nil)
+			expectStatus(t, w, http.StatusOK)
+			var body auditResponse
+			decodeInto(t, w, &body)
+			got := []string{}
+			for _, e := range body.Entries {
+				got = append(got, e.Action)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+
+	expectError(t, s.as(admin, "GET", "/admin/audit?actor=nope", nil), http.StatusBadRequest, "Invalid actor ID")
+	expectError(t, s.as(admin, "GET", "/admin/audit?from=yesterday", nil), http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+}
+
+func TestReports(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	moderator := s.user(t, "Mod", func(u *models.User) { u.Role = models.RoleModerator })
+	resolution := s.resolution(t, alice, "run a marathon", time.Now())
+	comment := s.comment(t, alice, resolution.RID, "first!", time.Now())
+
+	report := func(as models.User, targetType string, targetID primitive.ObjectID, reason string) *httptest.ResponseRecorder {
+		return s.as(as, "POST", "/reports", gin.H{"target_type": targetType, "target_id": targetID.Hex(), "reason": reason})
+	}
+
+	t.Run("created", func(t *testing.T) {
+		w := report(bob, models.ReportTargetResolution, resolution.RID, "spam")
+		expectStatus(t, w, http.StatusCreated)
+		if id, _ := decode(t, w)["report_id"].(string); id == "" || id == primitive.NilObjectID.Hex() {
+			t.Errorf("got report ID %q", id)
+		}
+	})
+
+	t.Run("reporting again updates the report", func(t *testing.T) {
+		w := report(bob, models.ReportTargetResolution, resolution.RID, "harassment")
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["message"]; got != "You already reported this, your report was updated" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		expectError(t, report(alice, models.ReportTargetUser, alice.ID, "spam"), http.StatusBadRequest, "You can't report yourself")
+		expectError(t, report(alice, models.ReportTargetResolution, resolution.RID, "spam"), http.StatusBadRequest, "You can't report yourself")
+		expectError(t, report(bob, models.ReportTargetComment, primitive.NewObjectID(), "spam"), http.StatusNotFound, "Reported content not found")
+		expectError(t, report(bob, "post", resolution.RID, "spam"), http.StatusBadRequest, "target_type must be resolution, comment or user")
+	})
+
+	t.Run("queued by severity", func(t *testing.T) {
+		expectStatus(t, report(carol, models.ReportTargetComment, comment.ID, "spam"), http.StatusCreated)
+		expectStatus(t, report(carol, models.ReportTargetResolution, resolution.RID, "spam"), http.StatusCreated)
+		expectStatus(t, report(bob, models.ReportTargetUser, carol.ID, "spam"), http.StatusCreated)
+
+		var body queueResponse
+		decodeInto(t, s.as(moderator, "GET", "/moderation/reports", nil), &body)
+		if len(body.Queue) != 3 {
+			t.Fatalf("got %+v, want three targets", body.Queue)
+		}
+		first := body.Queue[0]
+		if first.TargetID != resolution.RID || first.ReportCount != 2 || first.Severity != models.ReportReasons["harassment"] ||
+			first.Resolution == nil || first.Resolution.Resolution != resolution.Resolution ||
+			first.TargetUser == nil || first.TargetUser.ID != alice.ID {
+			t.Errorf("got %+v first, want the resolution with both reports", first)
+		}
+		if second := body.Queue[1]; second.TargetID != comment.ID || second.Comment == nil || second.Comment.Comment != comment.Comment {
+			t.Errorf("got %+v second, want the comment reported first", second)
+		}
+
+		decodeInto(t, s.as(moderator, "GET", "/moderation/reports?target_type=user", nil), &body)
+		if len(body.Queue) != 1 || body.Queue[0].TargetID != carol.ID || body.Queue[0].TargetUser == nil || body.Queue[0].TargetUser.Name != "Carol" {
+			t.Errorf("got %+v, want only Carol", body.Queue)
+		}
+	})
+}
+
+func TestModeration(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	moderator := s.user(t, "Mod", func(u *models.User) { u.Role = models.RoleModerator })
+	otherModerator := s.user(t, "Other Mod", func(u *models.User) { u.Role = models.RoleModerator })
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	resolution := s.resolution(t, alice, "run a marathon", time.Now())
+	comment := s.comment(t, alice, resolution.RID, "buy my course", time.Now())
+
+	expectStatus(t, s.as(bob, "POST", "/reports", gin.H{"target_type": models.ReportTargetResolution, "target_id": resolution.RID.Hex(), "reason": "spam"}), http.StatusCreated)
+	expectStatus(t, s.as(bob, "POST", "/reports", gin.H{"target_type": models.ReportTargetComment, "target_id": comment.ID.Hex(), "reason": "spam"}), http.StatusCreated)
+	expectStatus(t, s.as(bob, "POST", "/reports", gin.H{"target_type": models.ReportTargetUser, "target_id": otherModerator.ID.Hex(), "reason": "other"}), http.StatusCreated)
+
+	act := func(as models.User, targetType string, targetID primitive.ObjectID, body gin.H) *httptest.ResponseRecorder {
+		body["target_type"] = targetType
+		body["target_id"] = targetID.Hex()
+		return s.as(as, "POST", "/moderation/actions", body)
+	}
+	queued := func(t *testing.T) []primitive.ObjectID {
+		t.Helper()
+		var body queueResponse
+		decodeInto(t, s.as(moderator, "GET", "/moderation/reports", nil), &body)
+		ids := []primitive.ObjectID{}
+		for _, e := range body.Queue {
+			ids = append(ids, e.TargetID)
+		}
+		return ids
+	}
+
+	t.Run("hide", func(t *testing.T) {
+		w := act(moderator, models.ReportTargetResolution, resolution.RID, gin.H{"action": "hide"})
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["reports_closed"]; got != float64(1) {
+			t.Errorf("closed %v reports, want 1", got)
+		}
+		expectError(t, s.request("GET", "/resolution/"+resolution.RID.Hex(), "", nil), http.StatusNotFound, "Resolution not found")
+		expectIDs(t, queued(t), comment.ID, otherModerator.ID)
+
+		entry := s.audit.last()
+		if entry.Action != audit.ActionModeration || entry.TargetID != resolution.RID.Hex() || entry.Metadata["action"] != "hide" {
+			t.Errorf("got audit entry %+v", entry)
+		}
+	})
+
+	t.Run("only content can be hidden", func(t *testing.T) {
+		expectError(t, act(moderator, models.ReportTargetUser, alice.ID, gin.H{"action": "hide"}), http.StatusBadRequest, "Only resolutions and comments can be hidden")
+	})
+
+	t.Run("warn the comment's author", func(t *testing.T) {
+		expectError(t, act(moderator, models.ReportTargetComment, comment.ID, gin.H{"action": "warn"}), http.StatusBadRequest, "A reason is required to warn a user")
+		expectStatus(t, act(moderator, models.ReportTargetComment, comment.ID, gin.H{"action": "warn", "reason": "no ads"}), http.StatusOK)
+		expectIDs(t, queued(t), otherModerator.ID)
+		if entry := s.audit.last(); entry.Metadata["target_user_id"] != alice.ID.Hex() {
+			t.Errorf("got audit entry %+v, want Alice warned", entry)
+		}
+	})
+
+	t.Run("only admins act against staff", func(t *testing.T) {
+		expectError(t, act(moderator, models.ReportTargetUser, otherModerator.ID, gin.H{"action": "suspend", "reason": "rude"}), http.StatusForbidden, "Only admins can act against moderators and admins")
+		expectIDs(t, queued(t), otherModerator.ID)
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		w := act(admin, models.ReportTargetUser, otherModerator.ID, gin.H{"action": "suspend", "reason": "rude", "suspend_days": 2})
+		expectStatus(t, w, http.StatusOK)
+		if decode(t, w)["suspended_until"] == nil {
+			t.Errorf("got %s, want the end of the suspension", w.Body.String())
+		}
+		user, err := s.repos.Users.FindByID(context.Background(), otherModerator.ID)
+		if err != nil || user.Status != models.UserStatusSuspended || user.StatusReason != "rude" || user.SuspendedUntil == nil {
+			t.Fatalf("got %+v, %v, want the moderator suspended", user, err)
+		}
+		expectError(t, s.as(otherModerator, "GET", "/moderation/reports", nil), http.StatusForbidden, "Account suspended")
+		expectIDs(t, queued(t))
+	})
+
+	t.Run("dismiss", func(t *testing.T) {
+		expectStatus(t, s.as(bob, "POST", "/reports", gin.H{"target_type": models.ReportTargetUser, "target_id": alice.ID.Hex(), "reason": "other"}), http.StatusCreated)
+		w := act(moderator, models.ReportTargetUser, alice.ID, gin.H{"action": "dismiss"})
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["reports_closed"]; got != float64(1) {
+			t.Errorf("closed %v reports, want 1", got)
+		}
+		expectIDs(t, queued(t))
+	})
+
+	t.Run("unknown target", func(t *testing.T) {
+		expectError(t, act(moderator, models.ReportTargetComment, primitive.NewObjectID(), gin.H{"action": "dismiss"}), http.StatusNotFound, "Target not found")
+	})
+}
@@ -0,0 +1,210 @@
+package routes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nyr/emails"
+	"nyr/models"
+	"nyr/repository"
+	"nyr/utils"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type notificationsResponse struct {
+	Notifications []struct {
+		ID         primitive.ObjectID `json:"id"`
+		Type       string             `json:"type"`
+		Message    string             `json:"message"`
+		Actor      *repository.Author `json:"actor"`
+		ActorCount int                `json:"actor_count"`
+		Read       bool               `json:"read"`
+	} `json:"notifications"`
+	UnreadCount int64 `json:"unread_count"`
+}
+
+func (s *testServer) notifications(t *testing.T, as models.User, query string) notificationsResponse {
+	t.Helper()
+	w := s.as(as, "GET", "/notifications"+query, nil)
+	expectStatus(t, w, http.StatusOK)
+	var body notificationsResponse
+	decodeInto(t, w, &body)
+	return body
+}
+
+func TestNotifications(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	first := s.resolution(t, alice, "run a marathon", time.Now())
+	second := s.resolution(t, alice, "learn to juggle", time.Now())
+
+	expectStatus(t, s.as(bob, "PUT", "/resolution/"+first.RID.Hex()+"/like", nil), http.StatusOK)
+	expectStatus(t, s.as(bob, "PUT", "/resolution/"+second.RID.Hex()+"/like", nil), http.StatusOK)
+	expectStatus(t, s.as(bob, "POST", "/users/"+alice.ID.Hex()+"/follow", nil), http.StatusCreated)
+
+	t.Run("listed newest first", func(t *testing.T) {
+		got := s.notifications(t, alice, "")
+		if len(got.Notifications) != 3 || got.UnreadCount != 3 {
+			t.Fatalf("got %+v, want three unread", got)
+		}
+		if got.Notifications[0].Type != models.NotificationTypeFollow || got.Notifications[2].Message != "Bob liked your resolution" {
+			t.Errorf("got %+v, want the follow first and the first like last", got.Notifications)
+		}
+		if page := s.notifications(t, alice, "?limit=2&page=2"); len(page.Notifications) != 1 || page.UnreadCount != 3 {
+			t.Errorf("second page got %+v", page)
+		}
+		if other := s.notifications(t, bob, ""); len(other.Notifications) != 0 {
+			t.Errorf("Bob sees %+v", other.Notifications)
+		}
+	})
+
+	t.Run("read one", func(t *testing.T) {
+		id := s.notifications(t, alice, "").Notifications[0].ID
+		expectError(t, s.as(bob, "POST", "/notifications/"+id.Hex()+"/read", nil), http.StatusNotFound, "Notification not found")
+		expectStatus(t, s.as(alice, "POST", "/notifications/"+id.Hex()+"/read", nil), http.StatusOK)
+
+		got := s.notifications(t, alice, "?unread=true")
+		if len(got.Notifications) != 2 || got.UnreadCount != 2 {
+			t.Fatalf("got %+v, want two unread", got)
+		}
+		for _, n := range got.Notifications {
+			if n.ID == id || n.Read {
+				t.Errorf("got %+v among the unread", n)
+			}
+		}
+	})
+
+	t.Run("read notifications start a new group", func(t *testing.T) {
+		// The follow was read, a new follower gets a notification of their own
+		expectStatus(t, s.as(carol, "POST", "/users/"+alice.ID.Hex()+"/follow", nil), http.StatusCreated)
+		got := s.notifications(t, alice, "")
+		if len(got.Notifications) != 4 || got.UnreadCount != 3 || got.Notifications[0].Message != "Carol started following you" {
+			t.Errorf("got %+v, want Carol's follow on its own", got)
+		}
+	})
+
+	t.Run("read all", func(t *testing.T) {
+		w := s.as(alice, "POST", "/notifications/read-all", nil)
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["updated"]; got != float64(3) {
+			t.Errorf("updated %v, want 3", got)
+		}
+		if got := s.notifications(t, alice, "?unread=true"); len(got.Notifications) != 0 || got.UnreadCount != 0 {
+			t.Errorf("got %+v unread", got)
+		}
+	})
+
+	t.Run("preferences", func(t *testing.T) {
+		w := s.as(alice, "PUT", "/notifications/preferences", gin.H{models.NotificationTypeLike: false})
+		expectStatus(t, w, http.StatusOK)
+		var body struct {
+			Preferences map[string]bool `json:"preferences"`
+		}
+		decodeInto(t, w, &body)
+		if body.Preferences[models.NotificationTypeLike] || !body.Preferences[models.NotificationTypeFollow] {
+			t.Errorf("got %v, want likes off and the rest on", body.Preferences)
+		}
+		decodeInto(t, s.as(alice, "GET", "/notifications/preferences", nil), &body)
+		if body.Preferences[models.NotificationTypeLike] {
+			t.Errorf("got %v after turning likes off", body.Preferences)
+		}
+
+		expectStatus(t, s.as(carol, "PUT", "/resolution/"+first.RID.Hex()+"/like", nil), http.StatusOK)
+		if got := s.notifications(t, alice, "?unread=true"); len(got.Notifications) != 0 {
+			t.Errorf("got %+v with likes turned off", got.Notifications)
+		}
+	})
+
+	t.Run("email preferences", func(t *testing.T) {
+		var body struct {
+			Preferences map[string]bool `json:"preferences"`
+		}
+		decodeInto(t, s.as(alice, "GET", "/notifications/email-preferences", nil), &body)
+		if body.Preferences[models.EmailCategoryDigest] || !body.Preferences[models.EmailCategoryComments] {
+			t.Errorf("got %v, want the defaults", body.Preferences)
+		}
+		expectStatus(t, s.as(alice, "PUT", "/notifications/email-preferences", gin.H{models.EmailCategoryDigest: true}), http.StatusOK)
+		decodeInto(t, s.as(alice, "GET", "/notifications/email-preferences", nil), &body)
+		if !body.Preferences[models.EmailCategoryDigest] {
+			t.Errorf("got %v after opting in to the digest", body.Preferences)
+		}
+	})
+}
+
+func TestUnsubscribe(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	link := func(category, token string) string {
+		query := url.Values{"user": {alice.ID.Hex()}, "category": {category}, "token": {token}}
+		return "/unsubscribe?" + query.Encode()
+	}
+	valid := link(models.EmailCategoryComments, utils.UnsubscribeToken(alice.ID.Hex(), models.EmailCategoryComments))
+	subscribed := func() bool {
+		t.Helper()
+		user, err := s.repos.Users.FindByID(context.Background(), alice.ID)
+		if err != nil {
+			t.Fatalf("loading user: %v", err)
+		}
+		return emails.Enabled(user, models.EmailCategoryComments)
+	}
+
+	t.Run("opening the link only asks to confirm", func(t *testing.T) {
+		w := s.request("GET", valid, "", nil)
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["unsubscribed"] != false || body["category"] != models.EmailCategoryComments {
+			t.Errorf("got %v", body)
+		}
+
+		req := httptest.NewRequest("GET", valid, nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		w = httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		expectStatus(t, w, http.StatusOK)
+		if page := w.Body.String(); !strings.Contains(page, `<form method="post">`) || !strings.Contains(page, emails.Reason(models.EmailCategoryComments)) {
+			t.Errorf("got page %s, want a confirmation form", page)
+		}
+
+		if !subscribed() {
+			t.Error("GET unsubscribed the user")
+		}
+	})
+
+	t.Run("links signed with another key are refused", func(t *testing.T) {
+		mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET_KEY")))
+		mac.Write([]byte("unsubscribe:" + alice.ID.Hex() + ":" + models.EmailCategoryComments))
+		withJWTKey := link(models.EmailCategoryComments, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+		otherCategory := link(models.EmailCategoryDigest, utils.UnsubscribeToken(alice.ID.Hex(), models.EmailCategoryComments))
+		for _, path := range []string{withJWTKey, otherCategory} {
+			expectError(t, s.request("POST", path, "", nil), http.StatusBadRequest, "Invalid unsubscribe link")
+		}
+		if !subscribed() {
+			t.Error("a forged link unsubscribed the user")
+		}
+	})
+
+	t.Run("one-click POST unsubscribes", func(t *testing.T) {
+		req := httptest.NewRequest("POST", valid, strings.NewReader("List-Unsubscribe=One-Click"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["unsubscribed"] != true {
+			t.Errorf("got %v", body)
+		}
+		if subscribed() {
+			t.Error("the user is still subscribed")
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"nyr/ratelimit"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitStore keeps the rate limit buckets. It defaults to an in-memory store,
+// set a shared store before calling InitRoutes to limit across instances.
+var RateLimitStore ratelimit.Store
+
+// Rate limit policies, each route group that shares a policy shares its buckets
+var (
+	feedRateLimit       = ratelimit.Policy{Name: "feed", Limit: 60, Window: time.Minute}
+	readRateLimit       = ratelimit.Policy{Name: "read", Limit: 120, Window: time.Minute}
+	resolutionRateLimit = ratelimit.Policy{Name: "resolution", Limit: 20, Window: time.Hour}
+	commentRateLimit    = ratelimit.Policy{Name: "comment", Limit: 20, Window: time.Minute}
+	likeRateLimit       = ratelimit.Policy{Name: "like", Limit: 60, Window: time.Minute}
+	reportRateLimit     = ratelimit.Policy{Name: "report", Limit: 20, Window: time.Hour}
+	loginRateLimit      = ratelimit.Policy{Name: "login", Limit: 10, Window: time.Minute}
+	magicLinkRateLimit  = ratelimit.Policy{Name: "magic-link", Limit: 5, Window: 15 * time.Minute}
+	// Follows, blocks and mutes
+	relationshipRateLimit = ratelimit.Policy{Name: "relationship", Limit: 30, Window: time.Minute}
+	// Changes to the profile, access tokens, reminders, notification and webhook settings,
+	// and account links
+	settingsRateLimit = ratelimit.Policy{Name: "settings", Limit: 30, Window: time.Minute}
+	// Slash commands and modal submissions, counted per Slack user
+	slackRateLimit      = ratelimit.Policy{Name: "slack", Limit: 20, Window: time.Minute}
+	adminRateLimit      = ratelimit.Policy{Name: "admin", Limit: 60, Window: time.Minute}
+	moderationRateLimit = ratelimit.Policy{Name: "moderation", Limit: 60, Window: time.Minute}
+)
+
+// TrustProxies sets the comma separated proxies whose X-Forwarded-For is trusted. Without
+// any, the client IP is the address of the connection, a client can't pick its own IP and
+// with it a fresh rate limit bucket.
+func TrustProxies(router *gin.Engine, proxies string) error {
+	if proxies == "" {
+		return router.SetTrustedProxies(nil)
+	}
+	return router.SetTrustedProxies(strings.Split(proxies, ","))
+}
@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"nyr/models"
+	"nyr/ratelimit"
+	"nyr/slack/slacktest"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// requestFrom sends an anonymous request from remoteAddr, claiming forwardedFor in X-Forwarded-For
+func (s *testServer) requestFrom(method, path, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader([]byte("{}")))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestForwardedForDoesNotBypassRateLimits(t *testing.T) {
+	s := newTestServer(t)
+
+	// Every request claims another client, but they all come from the same connection
+	for i := 0; i < magicLinkRateLimit.Limit; i++ {
+		w := s.requestFrom("POST", "/auth/magic-link", "203.0.113.7:1234", "198.51.100."+strconv.Itoa(i))
+		if w.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was limited", i+1)
+		}
+	}
+	w := s.requestFrom("POST", "/auth/magic-link", "203.0.113.7:1234", "198.51.100.250")
+	expectError(t, w, http.StatusTooManyRequests, "Too many requests, please slow down")
+}
+
+func TestTrustedProxyForwardsClientIP(t *testing.T) {
+	s := newTestServer(t)
+	if err := TrustProxies(s.router, "203.0.113.7"); err != nil {
+		t.Fatalf("TrustProxies: %v", err)
+	}
+
+	// Behind the trusted proxy each forwarded client has its own bucket
+	for i := 0; i <= magicLinkRateLimit.Limit; i++ {
+		w := s.requestFrom("POST", "/auth/magic-link", "203.0.113.7:1234", "198.51.100."+strconv.Itoa(i))
+		if w.Code == http.StatusTooManyRequests {
+			t.Fatalf("client %d was limited by the others", i+1)
+		}
+	}
+
+	if err := TrustProxies(s.router, "not-an-ip"); err == nil {
+		t.Error("TrustProxies accepted an invalid proxy")
+	}
+}
+
+func TestRoutesAreRateLimited(t *testing.T) {
+	s := newTestServer(t)
+	user := s.user(t, "Alice", nil)
+	id := primitive.NewObjectID().Hex()
+
+	cases := []struct {
+		route  route
+		policy string
+	}{
+		{route{"PUT", "/resolution/" + id + "/reminder"}, settingsRateLimit.String()},
+		{route{"DELETE", "/resolution/" + id + "/reminder"}, settingsRateLimit.String()},
+		{route{"GET", "/webhooks"}, readRateLimit.String()},
+		{route{"POST", "/webhooks"}, settingsRateLimit.String()},
+		{route{"PUT", "/webhooks/" + id}, settingsRateLimit.String()},
+		{route{"DELETE", "/webhooks/" + id}, settingsRateLimit.String()},
+		{route{"GET", "/webhooks/" + id + "/deliveries"}, readRateLimit.String()},
+		{route{"POST", "/webhooks/" + id + "/deliveries/" + id + "/replay"}, settingsRateLimit.String()},
+		{route{"GET", "/integrations/slack/link"}, settingsRateLimit.String()},
+		{route{"POST", "/integrations/slack/link"}, settingsRateLimit.String()},
+		{route{"GET", "/blocks"}, readRateLimit.String()},
+		{route{"POST", "/blocks"}, relationshipRateLimit.String()},
+		{route{"DELETE", "/blocks/" + id}, relationshipRateLimit.String()},
+		{route{"POST", "/users/" + id + "/follow"}, relationshipRateLimit.String()},
+		{route{"DELETE", "/users/" + id + "/follow"}, relationshipRateLimit.String()},
+		{route{"GET", "/notifications"}, readRateLimit.String()},
+		{route{"POST", "/notifications/" + id + "/read"}, readRateLimit.String()},
+		{route{"POST", "/notifications/read-all"}, readRateLimit.String()},
+		{route{"GET", "/notifications/preferences"}, readRateLimit.String()},
+		{route{"PUT", "/notifications/preferences"}, settingsRateLimit.String()},
+		{route{"GET", "/notifications/email-preferences"}, readRateLimit.String()},
+		{route{"PUT", "/notifications/email-preferences"}, settingsRateLimit.String()},
+		{route{"PUT", "/profile"}, settingsRateLimit.String()},
+		{route{"PUT", "/profile/timezone"}, settingsRateLimit.String()},
+		{route{"PUT", "/profile/handle"}, settingsRateLimit.String()},
+		{route{"POST", "/profile/identities/google"}, settingsRateLimit.String()},
+		{route{"DELETE", "/profile/identities/google"}, settingsRateLimit.String()},
+		{route{"GET", "/profile/tokens"}, readRateLimit.String()},
+		{route{"POST", "/profile/tokens"}, settingsRateLimit.String()},
+		{route{"DELETE", "/profile/tokens/" + id}, settingsRateLimit.String()},
+	}
+	for _, tc := range cases {
+		t.Run(tc.route.String(), func(t *testing.T) {
+			w := s.as(user, tc.route.method, tc.route.path, nil)
+			if got := w.Header().Get("RateLimit-Policy"); got != tc.policy {
+				t.Errorf("got RateLimit-Policy %q, want %q", got, tc.policy)
+			}
+		})
+	}
+}
+
+func TestRouteGroupsReturnTooManyRequests(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", "slack-secret")
+	s := newTestServer(t)
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	moderator := s.user(t, "Mod", func(u *models.User) { u.Role = models.RoleModerator })
+	alice := s.user(t, "Alice", nil)
+	slash := func(slackUserID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, slacktest.NewSlashCommandRequest("/integrations/slack/commands", "slack-secret", "T1", slackUserID, "help"))
+		return w
+	}
+
+	cases := []struct {
+		name   string
+		policy ratelimit.Policy
+		send   func() *httptest.ResponseRecorder
+	}{
+		{"admin", adminRateLimit, func() *httptest.ResponseRecorder { return s.as(admin, "GET", "/admin/users", nil) }},
+		{"moderation", moderationRateLimit, func() *httptest.ResponseRecorder { return s.as(moderator, "GET", "/moderation/reports", nil) }},
+		{"profile", settingsRateLimit, func() *httptest.ResponseRecorder {
+			return s.as(alice, "DELETE", "/profile/identities/google", nil)
+		}},
+		{"slack", slackRateLimit, func() *httptest.ResponseRecorder { return slash("U1") }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < tc.policy.Limit; i++ {
+				if w := tc.send(); w.Code == http.StatusTooManyRequests {
+					t.Fatalf("request %d was limited", i+1)
+				}
+			}
+			expectError(t, tc.send(), http.StatusTooManyRequests, "Too many requests, please slow down")
+		})
+	}
+
+	t.Run("slack users have their own limit", func(t *testing.T) {
+		// All of them come from Slack's servers
+		expectStatus(t, slash("U2"), http.StatusOK)
+	})
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"net/http"
+	"nyr/models"
+	"nyr/repository"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFollows(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	alicePath := "/users/" + alice.ID.Hex() + "/follow"
+
+	t.Run("follow notifies once per follower", func(t *testing.T) {
+		expectStatus(t, s.as(bob, "POST", alicePath, nil), http.StatusCreated)
+		w := s.as(bob, "POST", alicePath, nil)
+		expectStatus(t, w, http.StatusOK)
+		if got := decode(t, w)["message"]; got != "You already follow this user" {
+			t.Errorf("got %q following twice", got)
+		}
+		expectStatus(t, s.as(carol, "POST", alicePath, nil), http.StatusCreated)
+
+		got := s.notifications(t, alice, "")
+		if len(got.Notifications) != 1 || got.UnreadCount != 1 {
+			t.Fatalf("got %+v, want one grouped follow notification", got)
+		}
+		n := got.Notifications[0]
+		if n.Type != models.NotificationTypeFollow || n.ActorCount != 2 || n.Actor == nil || n.Actor.ID != carol.ID ||
+			n.Message != "Carol and 1 other started following you" {
+			t.Errorf("got %+v, want Carol and Bob", n)
+		}
+	})
+
+	t.Run("unfollow takes the follower back out", func(t *testing.T) {
+		expectStatus(t, s.as(carol, "DELETE", alicePath, nil), http.StatusOK)
+		expectError(t, s.as(carol, "DELETE", alicePath, nil), http.StatusNotFound, "You don't follow this user")
+
+		got := s.notifications(t, alice, "")
+		if len(got.Notifications) != 1 || got.Notifications[0].ActorCount != 1 || got.Notifications[0].Message != "Bob started following you" {
+			t.Fatalf("got %+v, want only Bob left", got.Notifications)
+		}
+		expectStatus(t, s.as(bob, "DELETE", alicePath, nil), http.StatusOK)
+		if got := s.notifications(t, alice, ""); len(got.Notifications) != 0 {
+			t.Errorf("got %+v once nobody follows", got.Notifications)
+		}
+	})
+
+	t.Run("unknown users can't be followed", func(t *testing.T) {
+		expectError(t, s.as(bob, "POST", "/users/"+primitive.NewObjectID().Hex()+"/follow", nil), http.StatusNotFound, "User not found")
+	})
+
+	t.Run("blocking ends the follow both ways", func(t *testing.T) {
+		expectStatus(t, s.as(bob, "POST", alicePath, nil), http.StatusCreated)
+		expectStatus(t, s.as(alice, "POST", "/users/"+bob.ID.Hex()+"/follow", nil), http.StatusCreated)
+		expectStatus(t, s.as(alice, "POST", "/blocks", gin.H{"user_id": bob.ID.Hex()}), http.StatusCreated)
+
+		expectError(t, s.as(bob, "DELETE", alicePath, nil), http.StatusNotFound, "You don't follow this user")
+		expectError(t, s.as(alice, "DELETE", "/users/"+bob.ID.Hex()+"/follow", nil), http.StatusNotFound, "You don't follow this user")
+		expectError(t, s.as(bob, "POST", alicePath, nil), http.StatusForbidden, "You can't follow this user")
+	})
+}
+
+func TestListBlocks(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	s.block(t, alice, bob, models.BlockKindBlock)
+	s.block(t, alice, carol, models.BlockKindMute)
+	s.block(t, bob, alice, models.BlockKindBlock)
+
+	var body struct {
+		Blocks []repository.BlockSummary `json:"blocks"`
+	}
+	for _, tc := range []struct {
+		query string
+		want  []primitive.ObjectID
+	}{
+		{"", []primitive.ObjectID{carol.ID, bob.ID}},
+		{"?kind=block", []primitive.ObjectID{bob.ID}},
+		{"?kind=mute", []primitive.ObjectID{carol.ID}},
+	} {
+		decodeInto(t, s.as(alice, "GET", "/blocks"+tc.query, nil), &body)
+		got := []primitive.ObjectID{}
+		for _, b := range body.Blocks {
+			got = append(got, b.TargetID)
+			if b.UserDetail == nil || b.UserDetail.ID != b.TargetID {
+				t.Errorf("%s: block of %s has user detail %+v", tc.query, b.TargetID.Hex(), b.UserDetail)
+			}
+		}
+		expectIDs(t, got, tc.want...)
+	}
+
+	expectStatus(t, s.as(alice, "DELETE", "/blocks/"+carol.ID.Hex()+"?kind=mute", nil), http.StatusOK)
+	decodeInto(t, s.as(alice, "GET", "/blocks", nil), &body)
+	if len(body.Blocks) != 1 || body.Blocks[0].TargetID != bob.ID {
+		t.Errorf("got %+v after unmuting Carol", body.Blocks)
+	}
+}
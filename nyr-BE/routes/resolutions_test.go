@@ -0,0 +1,965 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"nyr/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type feedResponse struct {
+	Resolutions []struct {
+		ID           primitive.ObjectID `json:"_id"`
+		UserName     string             `json:"user_name"`
+		LikeCount    int64              `json:"like_count"`
+		CommentCount int64              `json:"comment_count"`
+		HasLiked     bool               `json:"hasLiked"`
+		MyReaction   string             `json:"myReaction"`
+	} `json:"resolutions"`
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+}
+
+type detailResponse struct {
+	Data struct {
+		ID             primitive.ObjectID `json:"_id"`
+		LikeCount      int64              `json:"like_count"`
+		CommentCount   int64              `json:"comment_count"`
+		ReactionCounts map[string]int64   `json:"reaction_counts"`
+		Comments       []struct {
+			ID             primitive.ObjectID `json:"_id"`
+			Comment        string             `json:"comment"`
+			Kind           string             `json:"kind"`
+			LikeCount      int64              `json:"like_count"`
+			ReactionCounts map[string]int64   `json:"reaction_counts"`
+			MyReaction     string             `json:"myReaction"`
+		} `json:"comments"`
+	} `json:"data"`
+	HasLiked   bool   `json:"hasLiked"`
+	MyReaction string `json:"myReaction"`
+}
+
+func decodeInto(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+}
+
+func (f feedResponse) ids() []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, r := range f.Resolutions {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, got []primitive.ObjectID, want ...primitive.ObjectID) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d resolutions %v, want %v", len(got), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("resolution %d is %s, want %s (got %v)", i, got[i].Hex(), want[i].Hex(), got)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+
+	start := time.Now().Add(-time.Hour)
+	first := s.resolution(t, alice, "read 12 books", start)
+	second := s.resolution(t, bob, "learn Go", start.Add(time.Minute))
+	third := s.resolution(t, carol, "run a marathon", start.Add(2*time.Minute))
+	s.like(t, alice, second.RID)
+	s.like(t, carol, second.RID)
+	s.like(t, bob, first.RID)
+
+	cases := []struct {
+		name  string
+		query string
+		want  []primitive.ObjectID
+		page  int
+		limit int
+	}{
+		{"likes by default", "", []primitive.ObjectID{second.RID, first.RID, third.RID}, 1, 15},
+		{"newest", "?sort=created_at", []primitive.ObjectID{third.RID, second.RID, first.RID}, 1, 15},
+		{"unknown sort uses likes", "?sort=name", []primitive.ObjectID{second.RID, first.RID, third.RID}, 1, 15},
+		{"first page", "?sort=created_at&limit=2", []primitive.ObjectID{third.RID, second.RID}, 1, 2},
+		{"second page", "?sort=created_at&limit=2&page=2", []primitive.ObjectID{first.RID}, 2, 2},
+		{"past the end", "?limit=2&page=3", nil, 3, 2},
+		{"invalid page", "?sort=created_at&limit=1&page=0", []primitive.ObjectID{third.RID}, 1, 1},
+		{"invalid limit", "?limit=nope", []primitive.ObjectID{second.RID, first.RID, third.RID}, 1, 6},
+		{"limit is capped", "?limit=500", []primitive.ObjectID{second.RID, first.RID, third.RID}, 1, 50},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.request("GET", "/resolution"+tc.query, "", nil)
+			expectStatus(t, w, http.StatusOK)
+			var feed feedResponse
+			decodeInto(t, w, &feed)
+			expectIDs(t, feed.ids(), tc.want...)
+			if feed.Page != tc.page || feed.Limit != tc.limit {
+				t.Errorf("got page %d limit %d, want page %d limit %d", feed.Page, feed.Limit, tc.page, tc.limit)
+			}
+		})
+	}
+
+	t.Run("counts and authors", func(t *testing.T) {
+		w := s.request("GET", "/resolution", "", nil)
+		var feed feedResponse
+		decodeInto(t, w, &feed)
+		top := feed.Resolutions[0]
+		if top.UserName != "Bob" || top.LikeCount != 2 || top.HasLiked {
+			t.Errorf("got %+v, want Bob's resolution with 2 likes not liked by an anonymous viewer", top)
+		}
+	})
+}
+
+// TestPostsMiddleware checks that the public reads work for everyone, and that only a
+// valid session adds the viewer specific fields
+func TestPostsMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	banned := s.user(t, "Banned", func(u *models.User) { u.Status = models.UserStatusBanned })
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	s.like(t, alice, resolution.RID)
+	s.like(t, banned, resolution.RID)
+
+	expired := signToken(jwt.MapClaims{"user_id": alice.ID.Hex(), "exp": time.Now().Add(-time.Minute).Unix()})
+	bannedToken := signToken(jwt.MapClaims{"user_id": banned.ID.Hex(), "exp": time.Now().Add(time.Hour).Unix()})
+	aliceToken := signToken(jwt.MapClaims{"user_id": alice.ID.Hex(), "exp": time.Now().Add(time.Hour).Unix()})
+
+	cases := []struct {
+		name          string
+		authorization string
+		hasLiked      bool
+	}{
+		{"anonymous", "", false},
+		{"not bearer", "Token " + aliceToken, false},
+		{"not a JWT", "Bearer nope", false},
+		{"expired", bearer(expired), false},
+		{"banned", bearer(bannedToken), false},
+		{"signed in", bearer(aliceToken), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.request("GET", "/resolution", tc.authorization, nil)
+			expectStatus(t, w, http.StatusOK)
+			var feed feedResponse
+			decodeInto(t, w, &feed)
+			expectIDs(t, feed.ids(), resolution.RID)
+			if feed.Resolutions[0].HasLiked != tc.hasLiked {
+				t.Errorf("feed hasLiked is %v, want %v", feed.Resolutions[0].HasLiked, tc.hasLiked)
+			}
+
+			w = s.request("GET", "/resolution/"+resolution.RID.Hex(), tc.authorization, nil)
+			expectStatus(t, w, http.StatusOK)
+			var detail detailResponse
+			decodeInto(t, w, &detail)
+			if detail.HasLiked != tc.hasLiked || detail.Data.LikeCount != 2 {
+				t.Errorf("got hasLiked %v with %d likes, want %v with 2", detail.HasLiked, detail.Data.LikeCount, tc.hasLiked)
+			}
+		})
+	}
+}
+
+func TestBlockedContent(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	byAlice := s.resolution(t, alice, "learn Go", time.Now().Add(-time.Minute))
+	byBob := s.resolution(t, bob, "read 12 books", time.Now())
+	s.block(t, alice, bob, models.BlockKindBlock)
+	s.block(t, carol, alice, models.BlockKindMute)
+
+	feedOf := func(u models.User) []primitive.ObjectID {
+		w := s.as(u, "GET", "/resolution?sort=created_at", nil)
+		expectStatus(t, w, http.StatusOK)
+		var feed feedResponse
+		decodeInto(t, w, &feed)
+		return feed.ids()
+	}
+
+	t.Run("blocker doesn't see the blocked", func(t *testing.T) {
+		expectIDs(t, feedOf(alice), byAlice.RID)
+	})
+	t.Run("blocked doesn't see the blocker", func(t *testing.T) {
+		expectIDs(t, feedOf(bob), byBob.RID)
+	})
+	t.Run("muted author is left out", func(t *testing.T) {
+		expectIDs(t, feedOf(carol), byBob.RID)
+	})
+	t.Run("anonymous sees everything", func(t *testing.T) {
+		w := s.request("GET", "/resolution?sort=created_at", "", nil)
+		var feed feedResponse
+		decodeInto(t, w, &feed)
+		expectIDs(t, feed.ids(), byBob.RID, byAlice.RID)
+	})
+	t.Run("blocked can't open the resolution", func(t *testing.T) {
+		expectError(t, s.as(bob, "GET", "/resolution/"+byAlice.RID.Hex(), nil), http.StatusNotFound, "Resolution not found")
+	})
+	t.Run("muted resolution can still be opened", func(t *testing.T) {
+		expectStatus(t, s.as(carol, "GET", "/resolution/"+byAlice.RID.Hex(), nil), http.StatusOK)
+	})
+	t.Run("blocked can't like", func(t *testing.T) {
+		w := s.as(bob, "POST", "/resolution/likes", map[string]string{"r_id": byAlice.RID.Hex()})
+		expectError(t, w, http.StatusForbidden, "You can't interact with this resolution")
+	})
+	t.Run("blocked can't comment", func(t *testing.T) {
+		w := s.as(bob, "POST", "/resolution/comments", map[string]string{"r_id": byAlice.RID.Hex(), "comment": "hi"})
+		expectError(t, w, http.StatusForbidden, "You can't interact with this resolution")
+	})
+}
+
+func TestGetResolutionByID(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+
+	expectError(t, s.request("GET", "/resolution/nope", "", nil), http.StatusBadRequest, "Invalid resolution ID")
+	expectError(t, s.request("GET", "/resolution/"+primitive.NewObjectID().Hex(), "", nil), http.StatusNotFound, "Resolution not found")
+
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	w := s.request("GET", "/resolution/"+resolution.RID.Hex(), "", nil)
+	expectStatus(t, w, http.StatusOK)
+	var detail detailResponse
+	decodeInto(t, w, &detail)
+	if detail.Data.ID != resolution.RID || detail.Data.Comments == nil {
+		t.Errorf("got %+v, want the resolution with an empty comment list", detail.Data)
+	}
+}
+
+func TestToggleLike(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	body := map[string]string{"r_id": resolution.RID.Hex()}
+
+	likeCount := func() int64 {
+		w := s.request("GET", "/resolution/"+resolution.RID.Hex(), "", nil)
+		var detail detailResponse
+		decodeInto(t, w, &detail)
+		return detail.Data.LikeCount
+	}
+
+	cases := []struct {
+		name   string
+		as     models.User
+		body   interface{}
+		status int
+		likes  int64
+	}{
+		{"missing resolution", bob, map[string]string{}, http.StatusBadRequest, 0},
+		{"unknown resolution", bob, map[string]string{"r_id": primitive.NewObjectID().Hex()}, http.StatusNotFound, 0},
+		{"like", bob, body, http.StatusCreated, 1},
+		{"like by the owner", alice, body, http.StatusCreated, 2},
+		{"unlike", bob, body, http.StatusOK, 1},
+		{"like again", bob, body, http.StatusCreated, 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectStatus(t, s.as(tc.as, "POST", "/resolution/likes", tc.body), tc.status)
+			if got := likeCount(); got != tc.likes {
+				t.Errorf("got %d likes, want %d", got, tc.likes)
+			}
+		})
+	}
+}
+
+func TestSetLike(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	s.block(t, alice, carol, models.BlockKindBlock)
+	path := "/resolution/" + resolution.RID.Hex() + "/like"
+
+	cases := []struct {
+		name    string
+		as      models.User
+		method  string
+		path    string
+		status  int
+		message string
+		liked   bool
+		likes   float64
+	}{
+		{"invalid ID", bob, "PUT", "/resolution/nope/like", http.StatusBadRequest, "Invalid resolution ID", false, 0},
+		{"unknown resolution", bob, "PUT", "/resolution/" + primitive.NewObjectID().Hex() + "/like", http.StatusNotFound, "Resolution not found", false, 0},
+		{"blocked", carol, "PUT", path, http.StatusForbidden, "You can't interact with this resolution", false, 0},
+		{"unlike without a like", bob, "DELETE", path, http.StatusOK, "", false, 0},
+		{"like", bob, "PUT", path, http.StatusOK, "", true, 1},
+		{"like again", bob, "PUT", path, http.StatusOK, "", true, 1},
+		{"like by the owner", alice, "PUT", path, http.StatusOK, "", true, 2},
+		{"unlike", bob, "DELETE", path, http.StatusOK, "", false, 1},
+		{"unlike again", bob, "DELETE", path, http.StatusOK, "", false, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(tc.as, tc.method, tc.path, nil)
+			if tc.message != "" {
+				expectError(t, w, tc.status, tc.message)
+				return
+			}
+			expectStatus(t, w, tc.status)
+			body := decode(t, w)
+			if body["liked"] != tc.liked || body["like_count"] != tc.likes {
+				t.Errorf("got liked %v with %v likes, want %v with %v", body["liked"], body["like_count"], tc.liked, tc.likes)
+			}
+		})
+	}
+
+	// Users can always take their like back, like removing a bookmark
+	unlike := func(t *testing.T, as models.User, path string, likes float64) {
+		t.Helper()
+		w := s.as(as, "DELETE", path, nil)
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["liked"] != false || body["like_count"] != likes {
+			t.Errorf("got liked %v with %v likes, want false with %v", body["liked"], body["like_count"], likes)
+		}
+	}
+	t.Run("unlike after being blocked", func(t *testing.T) {
+		dave := s.user(t, "Dave", nil)
+		s.like(t, dave, resolution.RID)
+		s.block(t, alice, dave, models.BlockKindBlock)
+		unlike(t, dave, path, 1)
+	})
+	t.Run("unlike a hidden resolution", func(t *testing.T) {
+		hidden := s.resolution(t, alice, "hidden", time.Now())
+		s.like(t, bob, hidden.RID)
+		if err := s.repos.Resolutions.Hide(context.Background(), hidden.RID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		unlike(t, bob, "/resolution/"+hidden.RID.Hex()+"/like", 0)
+	})
+	t.Run("unlike an unknown resolution", func(t *testing.T) {
+		for _, path := range []string{"/like", "/reaction"} {
+			w := s.as(bob, "DELETE", "/resolution/"+primitive.NewObjectID().Hex()+path, nil)
+			expectError(t, w, http.StatusNotFound, "Resolution not found")
+		}
+	})
+}
+
+func TestReactions(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	s.block(t, alice, carol, models.BlockKindBlock)
+	s.like(t, alice, resolution.RID)
+	path := "/resolution/" + resolution.RID.Hex() + "/reaction"
+
+	cases := []struct {
+		name     string
+		as       models.User
+		method   string
+		body     interface{}
+		status   int
+		message  string
+		reaction string
+		counts   map[string]float64
+	}{
+		{"missing type", bob, "PUT", gin.H{}, http.StatusBadRequest, "", "", nil},
+		{"unknown type", bob, "PUT", gin.H{"type": "angry"}, http.StatusBadRequest, "Unknown reaction: angry", "", nil},
+		{"blocked", carol, "PUT", gin.H{"type": models.ReactionCheer}, http.StatusForbidden, "You can't interact with this resolution", "", nil},
+		{"remove without a reaction", bob, "DELETE", nil, http.StatusOK, "", "", map[string]float64{"like": 1}},
+		{"cheer", bob, "PUT", gin.H{"type": models.ReactionCheer}, http.StatusOK, "", "cheer", map[string]float64{"like": 1, "cheer": 1}},
+		{"cheer again", bob, "PUT", gin.H{"type": models.ReactionCheer}, http.StatusOK, "", "cheer", map[string]float64{"like": 1, "cheer": 1}},
+		{"change to like", bob, "PUT", gin.H{"type": models.ReactionLike}, http.StatusOK, "", "like", map[string]float64{"like": 2}},
+		{"change to me too", bob, "PUT", gin.H{"type": models.ReactionMeToo}, http.StatusOK, "", "me_too", map[string]float64{"like": 1, "me_too": 1}},
+		{"remove", bob, "DELETE", nil, http.StatusOK, "", "", map[string]float64{"like": 1}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(tc.as, tc.method, path, tc.body)
+			if tc.message != "" {
+				expectError(t, w, tc.status, tc.message)
+				return
+			}
+			expectStatus(t, w, tc.status)
+			if tc.status != http.StatusOK {
+				return
+			}
+			body := decode(t, w)
+			if body["reaction"] != tc.reaction || body["liked"] != (tc.reaction == models.ReactionLike) {
+				t.Errorf("got reaction %v and liked %v, want %q", body["reaction"], body["liked"], tc.reaction)
+			}
+			counts, _ := body["reaction_counts"].(map[string]interface{})
+			if len(counts) != len(tc.counts) {
+				t.Fatalf("got reaction counts %v, want %v", counts, tc.counts)
+			}
+			for reaction, count := range tc.counts {
+				if counts[reaction] != count {
+					t.Errorf("got reaction counts %v, want %v", counts, tc.counts)
+				}
+			}
+			if body["like_count"] != tc.counts[models.ReactionLike] {
+				t.Errorf("got %v likes, want %v", body["like_count"], tc.counts[models.ReactionLike])
+			}
+		})
+	}
+
+	// Unliking leaves other reactions alone
+	s.as(bob, "PUT", path, gin.H{"type": models.ReactionYouGotThis})
+	expectStatus(t, s.as(bob, "DELETE", "/resolution/"+resolution.RID.Hex()+"/like", nil), http.StatusOK)
+
+	t.Run("feed and detail show the viewer's reaction", func(t *testing.T) {
+		var feed feedResponse
+		decodeInto(t, s.as(bob, "GET", "/resolution", nil), &feed)
+		if len(feed.Resolutions) != 1 || feed.Resolutions[0].MyReaction != models.ReactionYouGotThis || feed.Resolutions[0].HasLiked {
+			t.Errorf("feed returned %+v, want bob's you_got_this", feed.Resolutions)
+		}
+
+		var detail detailResponse
+		decodeInto(t, s.as(bob, "GET", "/resolution/"+resolution.RID.Hex(), nil), &detail)
+		if detail.MyReaction != models.ReactionYouGotThis || detail.HasLiked {
+			t.Errorf("detail has myReaction %q and hasLiked %v, want you_got_this", detail.MyReaction, detail.HasLiked)
+		}
+		if counts := detail.Data.ReactionCounts; counts[models.ReactionLike] != 1 || counts[models.ReactionYouGotThis] != 1 || detail.Data.LikeCount != 1 {
+			t.Errorf("detail has reaction counts %v and %d likes", counts, detail.Data.LikeCount)
+		}
+	})
+}
+
+func TestCommentReactions(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	dave := s.user(t, "Dave", nil)
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	other := s.resolution(t, alice, "read more", time.Now())
+	byBob := s.comment(t, bob, resolution.RID, "good luck", time.Now().Add(-time.Minute))
+	byCarol := s.comment(t, carol, resolution.RID, "me too", time.Now())
+	s.block(t, bob, dave, models.BlockKindBlock)
+	s.react(t, bob, resolution.RID, models.ReactionCheer)
+	base := "/resolution/" + resolution.RID.Hex() + "/comments/"
+	path := base + byBob.ID.Hex() + "/reaction"
+
+	cases := []struct {
+		name    string
+		as      models.User
+		method  string
+		path    string
+		body    interface{}
+		status  int
+		message string
+		counts  map[string]float64
+	}{
+		{"invalid comment ID", alice, "PUT", base + "nope/reaction", gin.H{"type": models.ReactionLike}, http.StatusBadRequest, "Invalid comment ID", nil},
+		{"unknown comment", alice, "PUT", base + primitive.NewObjectID().Hex() + "/reaction", gin.H{"type": models.ReactionLike}, http.StatusNotFound, "Comment not found", nil},
+		{"comment on another resolution", alice, "PUT", "/resolution/" + other.RID.Hex() + "/comments/" + byBob.ID.Hex() + "/reaction", gin.H{"type": models.ReactionLike}, http.StatusNotFound, "Comment not found", nil},
+		{"unknown type", alice, "PUT", path, gin.H{"type": "angry"}, http.StatusBadRequest, "Unknown reaction: angry", nil},
+		{"blocked by the author", dave, "PUT", path, gin.H{"type": models.ReactionLike}, http.StatusForbidden, "You can't interact with this comment", nil},
+		{"cheer", alice, "PUT", path, gin.H{"type": models.ReactionCheer}, http.StatusOK, "", map[string]float64{"cheer": 1}},
+		{"change to like", alice, "PUT", path, gin.H{"type": models.ReactionLike}, http.StatusOK, "", map[string]float64{"like": 1}},
+		{"like by another user", carol, "PUT", path, gin.H{"type": models.ReactionLike}, http.StatusOK, "", map[string]float64{"like": 2}},
+		{"remove", alice, "DELETE", path, nil, http.StatusOK, "", map[string]float64{"like": 1}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(tc.as, tc.method, tc.path, tc.body)
+			if tc.message != "" {
+				expectError(t, w, tc.status, tc.message)
+				return
+			}
+			expectStatus(t, w, tc.status)
+			body := decode(t, w)
+			if body["comment_id"] != byBob.ID.Hex() {
+				t.Errorf("got comment ID %v, want %s", body["comment_id"], byBob.ID.Hex())
+			}
+			counts, _ := body["reaction_counts"].(map[string]interface{})
+			if len(counts) != len(tc.counts) {
+				t.Fatalf("got reaction counts %v, want %v", counts, tc.counts)
+			}
+			for reaction, count := range tc.counts {
+				if counts[reaction] != count {
+					t.Errorf("got reaction counts %v, want %v", counts, tc.counts)
+				}
+			}
+		})
+	}
+
+	t.Run("remove after being blocked", func(t *testing.T) {
+		reaction := models.Reaction{ID: primitive.NewObjectID(), UserID: dave.ID, TargetType: models.ReactionTargetComment, TargetID: byCarol.ID, Type: models.ReactionCheer}
+		if _, err := s.repos.Reactions.Set(context.Background(), reaction); err != nil {
+			t.Fatal(err)
+		}
+		s.block(t, carol, dave, models.BlockKindBlock)
+		w := s.as(dave, "DELETE", base+byCarol.ID.Hex()+"/reaction", nil)
+		expectStatus(t, w, http.StatusOK)
+		if body := decode(t, w); body["reaction"] != "" || len(body["reaction_counts"].(map[string]interface{})) != 0 {
+			t.Errorf("got %v, want the cheer removed", body)
+		}
+		expectError(t, s.as(dave, "DELETE", "/resolution/"+other.RID.Hex()+"/comments/"+byCarol.ID.Hex()+"/reaction", nil), http.StatusNotFound, "Comment not found")
+	})
+	t.Run("remove from an unknown comment", func(t *testing.T) {
+		expectError(t, s.as(dave, "DELETE", base+primitive.NewObjectID().Hex()+"/reaction", nil), http.StatusNotFound, "Comment not found")
+	})
+
+	t.Run("detail shows the comments' reactions", func(t *testing.T) {
+		var detail detailResponse
+		decodeInto(t, s.as(carol, "GET", "/resolution/"+resolution.RID.Hex(), nil), &detail)
+		if len(detail.Data.Comments) != 2 || detail.Data.Comments[0].ID != byCarol.ID {
+			t.Fatalf("got comments %+v, want the newest first", detail.Data.Comments)
+		}
+
+		var sorted detailResponse
+		decodeInto(t, s.as(carol, "GET", "/resolution/"+resolution.RID.Hex()+"?comment_sort=top", nil), &sorted)
+		top := sorted.Data.Comments[0]
+		if top.ID != byBob.ID || top.LikeCount != 1 || top.ReactionCounts[models.ReactionLike] != 1 || top.MyReaction != models.ReactionLike {
+			t.Errorf("got top comment %+v, want bob's with carol's like", top)
+		}
+		if sorted.Data.Comments[1].MyReaction != "" {
+			t.Errorf("got myReaction %q on a comment carol didn't react to", sorted.Data.Comments[1].MyReaction)
+		}
+		if sorted.Data.LikeCount != 0 || sorted.Data.ReactionCounts[models.ReactionCheer] != 1 {
+			t.Errorf("comment reactions changed the resolution's counts to %v", sorted.Data.ReactionCounts)
+		}
+	})
+}
+
+func TestCreateComment(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	resolution := s.resolution(t, alice, "learn Go", time.Now())
+	rID := resolution.RID.Hex()
+
+	cases := []struct {
+		name    string
+		as      models.User
+		body    map[string]interface{}
+		status  int
+		message string
+	}{
+		{"missing resolution", bob, map[string]interface{}{"comment": "nice"}, http.StatusBadRequest, "ResolutionID is required"},
+		{"unknown kind", bob, map[string]interface{}{"r_id": rID, "comment": "nice", "kind": "update"}, http.StatusBadRequest, "kind must be empty or checkin"},
+		{"empty", bob, map[string]interface{}{"r_id": rID, "comment": "   "}, http.StatusBadRequest, "Comment cannot be empty"},
+		{"unknown resolution", bob, map[string]interface{}{"r_id": primitive.NewObjectID().Hex(), "comment": "nice"}, http.StatusNotFound, "Resolution not found"},
+		{"check in by someone else", bob, map[string]interface{}{"r_id": rID, "comment": "done", "kind": models.CommentKindCheckIn}, http.StatusForbidden, "Only the owner can check in on a resolution"},
+		{"reply to an unknown comment", bob, map[string]interface{}{"r_id": rID, "comment": "nice", "parent_id": primitive.NewObjectID().Hex()}, http.StatusNotFound, "Parent comment not found"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectError(t, s.as(tc.as, "POST", "/resolution/comments", tc.body), tc.status, tc.message)
+		})
+	}
+
+	t.Run("comment, check in and reply", func(t *testing.T) {
+		w := s.as(bob, "POST", "/resolution/comments", map[string]interface{}{"r_id": rID, "comment": " good luck "})
+		expectStatus(t, w, http.StatusCreated)
+		commentID := decode(t, w)["comment_id"].(string)
+
+		w = s.as(alice, "POST", "/resolution/comments", map[string]interface{}{"r_id": rID, "comment": "chapter one done", "kind": models.CommentKindCheckIn})
+		expectStatus(t, w, http.StatusCreated)
+
+		w = s.as(alice, "POST", "/resolution/comments", map[string]interface{}{"r_id": rID, "comment": "thanks", "parent_id": commentID})
+		expectStatus(t, w, http.StatusCreated)
+
+		w = s.request("GET", "/resolution/"+rID, "", nil)
+		var detail detailResponse
+		decodeInto(t, w, &detail)
+		if detail.Data.CommentCount != 3 || len(detail.Data.Comments) != 3 {
+			t.Fatalf("got %d comments, want 3", len(detail.Data.Comments))
+		}
+		if oldest := detail.Data.Comments[2]; oldest.ID.Hex() != commentID || oldest.Comment != "good luck" {
+			t.Errorf("got oldest comment %+v, want the trimmed first comment", oldest)
+		}
+		if checkIn := detail.Data.Comments[1]; checkIn.Kind != models.CommentKindCheckIn {
+			t.Errorf("got kind %q, want %q", checkIn.Kind, models.CommentKindCheckIn)
+		}
+	})
+}
+
+func TestCreateResolution(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+
+	expectError(t, s.as(alice, "POST", "/resolution", "{"), http.StatusBadRequest, "unexpected EOF")
+	expectError(t, s.as(alice, "POST", "/resolution", map[string]string{"resolution": "  "}), http.StatusBadRequest, "Resolution cannot be empty")
+
+	w := s.as(alice, "POST", "/resolution", map[string]interface{}{"resolution": "learn Go", "tags": []string{"code"}})
+	expectStatus(t, w, http.StatusCreated)
+	rID := decode(t, w)["r_id"].(string)
+
+	w = s.as(alice, "GET", "/resolution/me", nil)
+	expectStatus(t, w, http.StatusOK)
+	var mine struct {
+		Resolutions []struct {
+			ID         primitive.ObjectID `json:"_id"`
+			Resolution string             `json:"resolution"`
+			Tags       []string           `json:"tags"`
+		} `json:"resolutions"`
+	}
+	decodeInto(t, w, &mine)
+	if len(mine.Resolutions) != 1 || mine.Resolutions[0].ID.Hex() != rID || mine.Resolutions[0].Tags[0] != "code" {
+		t.Errorf("got %+v, want the new resolution", mine.Resolutions)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+
+	expectError(t, s.as(alice, "PUT", "/profile", map[string]string{"name": " "}), http.StatusBadRequest, "Name cannot be empty")
+	expectStatus(t, s.as(alice, "PUT", "/profile", map[string]string{"name": "Alice Doe"}), http.StatusOK)
+
+	user, err := s.repos.Users.FindByID(context.Background(), alice.ID)
+	if err != nil || user.Name != "Alice Doe" {
+		t.Errorf("got name %q (%v), want Alice Doe", user.Name, err)
+	}
+}
+
+func TestUpdateHandle(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", func(u *models.User) { u.Handle = "bob" })
+
+	cases := []struct {
+		name    string
+		handle  string
+		status  int
+		message string
+		want    string
+	}{
+		{"too short", "al", http.StatusBadRequest, "Handle must be 3 to 30 letters, digits or underscores", ""},
+		{"invalid characters", "alice!", http.StatusBadRequest, "Handle must be 3 to 30 letters, digits or underscores", ""},
+		{"taken", "Bob", http.StatusConflict, "Handle is already taken", ""},
+		{"set", " @Alice_1 ", http.StatusOK, "", "alice_1"},
+		{"set again", "alice_1", http.StatusOK, "", "alice_1"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(alice, "PUT", "/profile/handle", gin.H{"handle": tc.handle})
+			if tc.message != "" {
+				expectError(t, w, tc.status, tc.message)
+				return
+			}
+			expectStatus(t, w, tc.status)
+			user, err := s.repos.Users.FindByID(context.Background(), alice.ID)
+			if err != nil || user.Handle != tc.want {
+				t.Errorf("got handle %q (%v), want %q", user.Handle, err, tc.want)
+			}
+		})
+	}
+
+	if user, _ := s.repos.Users.FindByID(context.Background(), bob.ID); user.Handle != "bob" {
+		t.Errorf("bob's handle changed to %q", user.Handle)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	s := newTestServer(t)
+	withHandle := func(handle string) func(*models.User) {
+		return func(u *models.User) { u.Handle = handle }
+	}
+	alice := s.user(t, "Alice", withHandle("alice"))
+	bob := s.user(t, "Bob", withHandle("bob"))
+	carol := s.user(t, "Carol", withHandle("carol"))
+	dave := s.user(t, "Dave", withHandle("dave"))
+	s.user(t, "Erin", func(u *models.User) {
+		u.Handle = "erin"
+		u.Status = models.UserStatusBanned
+	})
+	s.block(t, carol, alice, models.BlockKindBlock)
+	s.block(t, bob, dave, models.BlockKindBlock)
+
+	handles := func(t *testing.T, mentions interface{}) []string {
+		t.Helper()
+		list, _ := mentions.([]interface{})
+		got := []string{}
+		for _, m := range list {
+			mention := m.(map[string]interface{})
+			got = append(got, fmt.Sprintf("%s@%v-%v", mention["handle"], mention["start"], mention["end"]))
+		}
+		return got
+	}
+	expectHandles := func(t *testing.T, got []string, want ...string) {
+		t.Helper()
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got mentions %v, want %v", got, want)
+		}
+	}
+
+	// Carol blocked alice, erin is banned and nobody has the handle frank
+	w := s.as(alice, "POST", "/resolution", gin.H{"resolution": "run with @Bob, @carol, @erin and @frank", "mentions": []gin.H{{"handle": "dave"}}})
+	expectStatus(t, w, http.StatusCreated)
+	body := decode(t, w)
+	expectHandles(t, handles(t, body["mentions"]), "bob@9-13")
+	rID := body["r_id"].(string)
+
+	w = s.request("GET", "/resolution/"+rID, "", nil)
+	expectStatus(t, w, http.StatusOK)
+	expectHandles(t, handles(t, decode(t, w)["data"].(map[string]interface{})["mentions"]), "bob@9-13")
+
+	// On bob's resolution dave can't be mentioned, bob blocked him
+	byBob := s.resolution(t, bob, "learn Go", time.Now())
+	w = s.as(alice, "POST", "/resolution/comments", gin.H{"r_id": byBob.RID.Hex(), "comment": "@dave @bob and @alice go!"})
+	expectStatus(t, w, http.StatusCreated)
+	expectHandles(t, handles(t, decode(t, w)["mentions"]), "bob@6-10", "alice@15-21")
+
+	w = s.as(alice, "POST", "/resolution/comments", gin.H{"r_id": rID, "comment": "@dave join us"})
+	expectStatus(t, w, http.StatusCreated)
+	expectHandles(t, handles(t, decode(t, w)["mentions"]), "dave@0-5")
+
+	w = s.request("GET", "/resolution/"+byBob.RID.Hex(), "", nil)
+	comments := decode(t, w)["data"].(map[string]interface{})["comments"].([]interface{})
+	if len(comments) != 1 {
+		t.Fatalf("got %d comments, want 1", len(comments))
+	}
+	expectHandles(t, handles(t, comments[0].(map[string]interface{})["mentions"]), "bob@6-10", "alice@15-21")
+}
+
+func TestBookmarks(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	first := s.resolution(t, bob, "run a marathon", time.Now().Add(-time.Hour))
+	second := s.resolution(t, bob, "read more", time.Now())
+	byCarol := s.resolution(t, carol, "learn Go", time.Now())
+	s.block(t, carol, alice, models.BlockKindBlock)
+	path := func(r models.Resolution) string { return "/resolution/" + r.RID.Hex() + "/bookmark" }
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		status  int
+		message string
+	}{
+		{"invalid ID", "PUT", "/resolution/nope/bookmark", nil, http.StatusBadRequest, "Invalid resolution ID"},
+		{"unknown resolution", "PUT", "/resolution/" + primitive.NewObjectID().Hex() + "/bookmark", nil, http.StatusNotFound, "Resolution not found"},
+		{"blocked", "PUT", path(byCarol), nil, http.StatusForbidden, "You can't interact with this resolution"},
+		{"long collection", "PUT", path(first), gin.H{"collection": strings.Repeat("a", 51)}, http.StatusBadRequest, "Collection names can be at most 50 characters"},
+		{"bookmark without a body", "PUT", path(first), nil, http.StatusCreated, ""},
+		{"bookmark again into a collection", "PUT", path(first), gin.H{"collection": " fitness "}, http.StatusOK, ""},
+		{"bookmark another", "PUT", path(second), gin.H{}, http.StatusCreated, ""},
+		{"remove", "DELETE", path(second), nil, http.StatusOK, ""},
+		{"remove again", "DELETE", path(second), nil, http.StatusOK, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(alice, tc.method, tc.path, tc.body)
+			if tc.message != "" {
+				expectError(t, w, tc.status, tc.message)
+				return
+			}
+			expectStatus(t, w, tc.status)
+			if bookmarked := decode(t, w)["bookmarked"]; bookmarked != (tc.method == "PUT") {
+				t.Errorf("got bookmarked %v", bookmarked)
+			}
+		})
+	}
+
+	type bookmarksResponse struct {
+		Bookmarks []struct {
+			Collection string `json:"collection"`
+			Resolution struct {
+				ID primitive.ObjectID `json:"_id"`
+			} `json:"resolution"`
+		} `json:"bookmarks"`
+		Page int `json:"page"`
+	}
+
+	t.Run("list", func(t *testing.T) {
+		s.as(alice, "PUT", path(second), nil)
+
+		var list bookmarksResponse
+		decodeInto(t, s.as(alice, "GET", "/bookmarks", nil), &list)
+		if len(list.Bookmarks) != 2 || list.Bookmarks[0].Resolution.ID != second.RID || list.Bookmarks[1].Collection != "fitness" {
+			t.Errorf("got bookmarks %+v, want the newest first", list.Bookmarks)
+		}
+
+		var page bookmarksResponse
+		decodeInto(t, s.as(alice, "GET", "/bookmarks?collection=fitness&page=1&limit=1", nil), &page)
+		if len(page.Bookmarks) != 1 || page.Bookmarks[0].Resolution.ID != first.RID || page.Page != 1 {
+			t.Errorf("got %+v, want the fitness bookmark", page)
+		}
+
+		w := s.as(alice, "GET", "/bookmarks/collections", nil)
+		expectStatus(t, w, http.StatusOK)
+		collections := decode(t, w)["collections"].([]interface{})
+		if len(collections) != 1 || collections[0].(map[string]interface{})["name"] != "fitness" {
+			t.Errorf("got collections %v, want fitness", collections)
+		}
+
+		var others bookmarksResponse
+		decodeInto(t, s.as(bob, "GET", "/bookmarks", nil), &others)
+		if len(others.Bookmarks) != 0 {
+			t.Errorf("bob sees alice's bookmarks %+v", others.Bookmarks)
+		}
+	})
+
+	t.Run("feed and detail show the bookmarks", func(t *testing.T) {
+		var feed struct {
+			Resolutions []struct {
+				ID            primitive.ObjectID `json:"_id"`
+				HasBookmarked bool               `json:"hasBookmarked"`
+			} `json:"resolutions"`
+		}
+		decodeInto(t, s.as(alice, "GET", "/resolution?sort=created_at", nil), &feed)
+		for _, r := range feed.Resolutions {
+			if want := r.ID == first.RID || r.ID == second.RID; r.HasBookmarked != want {
+				t.Errorf("resolution %s has hasBookmarked %v, want %v", r.ID.Hex(), r.HasBookmarked, want)
+			}
+		}
+
+		w := s.as(alice, "GET", "/resolution/"+first.RID.Hex(), nil)
+		expectStatus(t, w, http.StatusOK)
+		if decode(t, w)["hasBookmarked"] != true {
+			t.Error("detail doesn't show the bookmark")
+		}
+	})
+}
+
+func TestAdoptions(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	carol := s.user(t, "Carol", nil)
+	s.block(t, alice, carol, models.BlockKindBlock)
+
+	w := s.as(alice, "POST", "/resolution", gin.H{"resolution": "Run a marathon", "tags": []string{"health"}, "adopted_from": primitive.NewObjectID()})
+	expectStatus(t, w, http.StatusCreated)
+	original, _ := primitive.ObjectIDFromHex(decode(t, w)["r_id"].(string))
+	path := "/resolution/" + original.Hex() + "/adopt"
+
+	var adopted primitive.ObjectID
+	t.Run("adopt", func(t *testing.T) {
+		w := s.as(bob, "POST", path, nil)
+		expectStatus(t, w, http.StatusCreated)
+		body := decode(t, w)
+		if body["adopted_from"] != original.Hex() {
+			t.Errorf("got adopted_from %v, want the original", body["adopted_from"])
+		}
+		adopted, _ = primitive.ObjectIDFromHex(body["r_id"].(string))
+
+		// Adopting again points at the copy bob already has
+		w = s.as(bob, "POST", path, nil)
+		expectError(t, w, http.StatusConflict, "You already adopted this resolution")
+		if got := decode(t, w)["r_id"]; got != adopted.Hex() {
+			t.Errorf("got r_id %v, want bob's copy", got)
+		}
+	})
+
+	cases := []struct {
+		name    string
+		user    models.User
+		path    string
+		status  int
+		message string
+	}{
+		{"invalid ID", bob, "/resolution/nope/adopt", http.StatusBadRequest, "Invalid resolution ID"},
+		{"unknown resolution", bob, "/resolution/" + primitive.NewObjectID().Hex() + "/adopt", http.StatusNotFound, "Resolution not found"},
+		{"own resolution", alice, path, http.StatusBadRequest, "You can't adopt your own resolution"},
+		{"blocked", carol, path, http.StatusForbidden, "You can't interact with this resolution"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectError(t, s.as(tc.user, "POST", tc.path, nil), tc.status, tc.message)
+		})
+	}
+
+	t.Run("original shows the adopters", func(t *testing.T) {
+		var detail struct {
+			Data struct {
+				AdoptionCount int64               `json:"adoption_count"`
+				AdoptedFrom   *primitive.ObjectID `json:"adopted_from"`
+			} `json:"data"`
+		}
+		decodeInto(t, s.request("GET", "/resolution/"+original.Hex(), "", nil), &detail)
+		if detail.Data.AdoptionCount != 1 || detail.Data.AdoptedFrom != nil {
+			t.Errorf("got %+v, want one adoption and no source", detail.Data)
+		}
+
+		var list struct {
+			Adopters []struct {
+				RID        primitive.ObjectID `json:"r_id"`
+				UserDetail struct {
+					Name string `json:"name"`
+				} `json:"user_detail"`
+			} `json:"adopters"`
+		}
+		w := s.request("GET", "/resolution/"+original.Hex()+"/adopters", "", nil)
+		expectStatus(t, w, http.StatusOK)
+		decodeInto(t, w, &list)
+		if len(list.Adopters) != 1 || list.Adopters[0].RID != adopted || list.Adopters[0].UserDetail.Name != "Bob" {
+			t.Errorf("got adopters %+v, want bob's copy", list.Adopters)
+		}
+
+		expectError(t, s.as(carol, "GET", "/resolution/"+original.Hex()+"/adopters", nil), http.StatusNotFound, "Resolution not found")
+		expectError(t, s.request("GET", "/resolution/nope/adopters", "", nil), http.StatusBadRequest, "Invalid resolution ID")
+	})
+
+	t.Run("copy shows its source", func(t *testing.T) {
+		var detail struct {
+			Data struct {
+				Resolution  string              `json:"resolution"`
+				Tags        []string            `json:"tags"`
+				AdoptedFrom *primitive.ObjectID `json:"adopted_from"`
+				Source      *struct {
+					ID         primitive.ObjectID `json:"_id"`
+					UserDetail struct {
+						Name string `json:"name"`
+					} `json:"user_detail"`
+				} `json:"source"`
+			} `json:"data"`
+		}
+		decodeInto(t, s.request("GET", "/resolution/"+adopted.Hex(), "", nil), &detail)
+		copied := detail.Data
+		if copied.Resolution != "Run a marathon" || len(copied.Tags) != 1 || copied.Tags[0] != "health" {
+			t.Errorf("got copy %+v, want the original's text and tags", copied)
+		}
+		if copied.AdoptedFrom == nil || *copied.AdoptedFrom != original {
+			t.Errorf("got adopted_from %v, want the original", copied.AdoptedFrom)
+		}
+		if copied.Source == nil || copied.Source.ID != original || copied.Source.UserDetail.Name != "Alice" {
+			t.Errorf("got source %+v, want the original by alice", copied.Source)
+		}
+	})
+}
+
+func TestStreamFeed(t *testing.T) {
+	s := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/stream/feed", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("got Content-Type %q, want text/event-stream", got)
+	}
+}
@@ -2,21 +2,46 @@ package routes
 
 import (
 	"nyr/controllers"
+	"nyr/emails"
 	"nyr/middleware"
+	"nyr/models"
+	"nyr/notifications"
+	"nyr/ratelimit"
+	"nyr/repository"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func InitRoutes(router *gin.Engine) {
+// InitRoutes registers every route, with the handlers reading and writing through repos
+func InitRoutes(router *gin.Engine, repos repository.Repositories) {
+	controllers.SetRepositories(repos)
+	middleware.SetUsers(repos.Users)
+	middleware.SetTokens(repos.Tokens)
+	notifications.SetRepositories(repos)
+	emails.SetRepositories(repos)
+
 	// health check
 	router.GET("/health", controllers.HealthCheck)
 
 	// cors middleware
 	router.Use(middleware.CorsMiddleware())
 
+	if RateLimitStore == nil {
+		RateLimitStore = ratelimit.NewMemoryStore(time.Minute)
+	}
+	limit := func(policy ratelimit.Policy) gin.HandlerFunc {
+		return middleware.RateLimit(RateLimitStore, policy)
+	}
+
 	// auth routes
 	router.GET("/auth/google", controllers.GoogleLogin)
-	router.POST("/auth/google/callback", controllers.GoogleLoginLatest)
+	router.POST("/auth/google/callback", limit(loginRateLimit), controllers.GoogleLoginLatest)
+	router.POST("/auth/magic-link", limit(magicLinkRateLimit), controllers.RequestMagicLink)
+	router.POST("/auth/magic-link/verify", limit(loginRateLimit), controllers.VerifyMagicLink)
+	router.GET("/auth/providers", controllers.ListProviders)
+	router.GET("/auth/:provider", controllers.ProviderLogin)
+	router.POST("/auth/:provider/callback", limit(loginRateLimit), controllers.ProviderCallback)
 
 	// token verification
 	router.GET("/verify-token", controllers.VerifyTokenHandler)
@@ -24,20 +49,145 @@ func InitRoutes(router *gin.Engine) {
 	// resolution routes
 	resolutionRoutes := router.Group("resolution")
 	{
-		resolutionRoutes.GET("", middleware.PostsMiddleware(), controllers.GetResolutions)
-		resolutionRoutes.GET("/:id", middleware.PostsMiddleware(), controllers.GetResolutionByID)
+		resolutionRoutes.GET("", middleware.PostsMiddleware(), limit(feedRateLimit), controllers.GetResolutions)
+		resolutionRoutes.GET("/:id", middleware.PostsMiddleware(), limit(readRateLimit), controllers.GetResolutionByID)
+		resolutionRoutes.GET("/:id/adopters", middleware.PostsMiddleware(), limit(readRateLimit), controllers.ListAdopters)
 
 		resolutionRoutes.Use(middleware.AuthMiddleware())
-		resolutionRoutes.POST("", controllers.CreateResolution)
-		resolutionRoutes.POST("/likes", controllers.ToggleLikeResolution)
-		resolutionRoutes.POST("/comments", controllers.CreateComment)
-		resolutionRoutes.GET("/me", controllers.GetUserResolutions)
+		resolutionRoutes.POST("", middleware.RequireScope(models.ScopeWriteResolutions), limit(resolutionRateLimit), controllers.CreateResolution)
+		resolutionRoutes.POST("/:id/adopt", middleware.RequireScope(models.ScopeWriteResolutions), limit(resolutionRateLimit), controllers.AdoptResolution)
+		resolutionRoutes.POST("/likes", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.ToggleLikeResolution)
+		resolutionRoutes.PUT("/:id/like", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.LikeResolution)
+		resolutionRoutes.DELETE("/:id/like", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.UnlikeResolution)
+		resolutionRoutes.PUT("/:id/reaction", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.ReactToResolution)
+		resolutionRoutes.DELETE("/:id/reaction", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.RemoveReaction)
+		resolutionRoutes.PUT("/:id/comments/:comment_id/reaction", middleware.RequireScope(models.ScopeWriteComments), limit(likeRateLimit), controllers.ReactToComment)
+		resolutionRoutes.DELETE("/:id/comments/:comment_id/reaction", middleware.RequireScope(models.ScopeWriteComments), limit(likeRateLimit), controllers.RemoveCommentReaction)
+		resolutionRoutes.POST("/comments", middleware.RequireScope(models.ScopeWriteComments), limit(commentRateLimit), controllers.CreateComment)
+		resolutionRoutes.PUT("/:id/bookmark", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.BookmarkResolution)
+		resolutionRoutes.DELETE("/:id/bookmark", middleware.RequireScope(models.ScopeWriteResolutions), limit(likeRateLimit), controllers.UnbookmarkResolution)
+		resolutionRoutes.GET("/me", middleware.RequireScope(models.ScopeRead), limit(readRateLimit), controllers.GetUserResolutions)
+		resolutionRoutes.PUT("/:id/reminder", middleware.RequireScope(models.ScopeWriteResolutions), limit(settingsRateLimit), controllers.SetReminder)
+		resolutionRoutes.DELETE("/:id/reminder", middleware.RequireScope(models.ScopeWriteResolutions), limit(settingsRateLimit), controllers.DeleteReminder)
+	}
+
+	// check in reminders
+	router.GET("/reminders", middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), limit(readRateLimit), controllers.ListReminders)
+
+	// saved resolutions
+	bookmarkRoutes := router.Group("bookmarks")
+	{
+		bookmarkRoutes.Use(middleware.AuthMiddleware(), middleware.RequireScope(models.ScopeRead), limit(readRateLimit))
+		bookmarkRoutes.GET("", controllers.ListBookmarks)
+		bookmarkRoutes.GET("/collections", controllers.ListBookmarkCollections)
 	}
 
 	// user routes
 	profileRoutes := router.Group("profile")
 	{
-		profileRoutes.Use(middleware.AuthMiddleware())
-		profileRoutes.PUT("", controllers.UpdateUser)
+		profileRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly())
+		profileRoutes.PUT("", limit(settingsRateLimit), controllers.UpdateUser)
+		profileRoutes.PUT("/timezone", limit(settingsRateLimit), controllers.UpdateTimezone)
+		profileRoutes.PUT("/handle", limit(settingsRateLimit), controllers.UpdateHandle)
+		profileRoutes.POST("/identities/:provider", limit(settingsRateLimit), controllers.LinkIdentity)
+		profileRoutes.DELETE("/identities/:provider", limit(settingsRateLimit), controllers.UnlinkIdentity)
+		profileRoutes.GET("/tokens", limit(readRateLimit), controllers.ListTokens)
+		profileRoutes.POST("/tokens", limit(settingsRateLimit), controllers.CreateToken)
+		profileRoutes.DELETE("/tokens/:id", limit(settingsRateLimit), controllers.RevokeToken)
+	}
+
+	// admin routes
+	adminRoutes := router.Group("admin")
+	{
+		adminRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly(), middleware.RequireRole(models.RoleAdmin), limit(adminRateLimit))
+		adminRoutes.GET("/users", controllers.ListUsersByRole)
+		adminRoutes.PUT("/users/:id/role", controllers.GrantRole)
+		adminRoutes.DELETE("/users/:id/role", controllers.RevokeRole)
+		adminRoutes.POST("/users/:id/ban", controllers.BanUser)
+		adminRoutes.POST("/users/:id/suspend", controllers.SuspendUser)
+		adminRoutes.POST("/users/:id/reinstate", controllers.ReinstateUser)
+		adminRoutes.GET("/audit", controllers.GetAuditLog)
+		adminRoutes.GET("/webhooks", controllers.ListWebhooks)
+		adminRoutes.POST("/webhooks", controllers.CreateWebhook)
+		adminRoutes.PUT("/webhooks/:id", controllers.UpdateWebhook)
+		adminRoutes.DELETE("/webhooks/:id", controllers.DeleteWebhook)
+		adminRoutes.GET("/webhooks/:id/deliveries", controllers.ListWebhookDeliveries)
+		adminRoutes.POST("/webhooks/:id/deliveries/:delivery_id/replay", controllers.ReplayWebhookDelivery)
+	}
+
+	// webhook routes, for the logged in user's own resolutions
+	webhookRoutes := router.Group("webhooks")
+	{
+		webhookRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly())
+		webhookRoutes.GET("", limit(readRateLimit), controllers.ListWebhooks)
+		webhookRoutes.POST("", limit(settingsRateLimit), controllers.CreateWebhook)
+		webhookRoutes.PUT("/:id", limit(settingsRateLimit), controllers.UpdateWebhook)
+		webhookRoutes.DELETE("/:id", limit(settingsRateLimit), controllers.DeleteWebhook)
+		webhookRoutes.GET("/:id/deliveries", limit(readRateLimit), controllers.ListWebhookDeliveries)
+		webhookRoutes.POST("/:id/deliveries/:delivery_id/replay", limit(settingsRateLimit), controllers.ReplayWebhookDelivery)
+	}
+
+	// Slack integration, the slash command and interactivity requests are signed by Slack
+	slackRoutes := router.Group("integrations/slack")
+	{
+		slackRoutes.POST("/commands", middleware.VerifySlackSignature(), limit(slackRateLimit), controllers.SlackCommand)
+		slackRoutes.POST("/interactivity", middleware.VerifySlackSignature(), limit(slackRateLimit), controllers.SlackInteractivity)
+		slackRoutes.GET("/link", middleware.AuthMiddleware(), middleware.SessionOnly(), limit(settingsRateLimit), controllers.PreviewSlackLink)
+		slackRoutes.POST("/link", middleware.AuthMiddleware(), middleware.SessionOnly(), limit(settingsRateLimit), controllers.LinkSlackAccount)
+	}
+
+	// block and mute routes
+	blockRoutes := router.Group("blocks")
+	{
+		blockRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly())
+		blockRoutes.GET("", limit(readRateLimit), controllers.ListBlocks)
+		blockRoutes.POST("", limit(relationshipRateLimit), controllers.BlockUser)
+		blockRoutes.DELETE("/:user_id", limit(relationshipRateLimit), controllers.UnblockUser)
+	}
+
+	// follow routes
+	userRoutes := router.Group("users")
+	{
+		userRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly(), limit(relationshipRateLimit))
+		userRoutes.POST("/:id/follow", controllers.FollowUser)
+		userRoutes.DELETE("/:id/follow", controllers.UnfollowUser)
+	}
+
+	// notification routes
+	notificationRoutes := router.Group("notifications")
+	{
+		notificationRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly())
+		notificationRoutes.GET("", limit(readRateLimit), controllers.GetNotifications)
+		notificationRoutes.POST("/:id/read", limit(readRateLimit), controllers.MarkNotificationRead)
+		notificationRoutes.POST("/read-all", limit(readRateLimit), controllers.MarkAllNotificationsRead)
+		notificationRoutes.GET("/preferences", limit(readRateLimit), controllers.GetNotificationPreferences)
+		notificationRoutes.PUT("/preferences", limit(settingsRateLimit), controllers.UpdateNotificationPreferences)
+		notificationRoutes.GET("/email-preferences", limit(readRateLimit), controllers.GetEmailPreferences)
+		notificationRoutes.PUT("/email-preferences", limit(settingsRateLimit), controllers.UpdateEmailPreferences)
+	}
+
+	// unsubscribe links in emails are signed, so they work without signing in.
+	// Opening one only asks to confirm, the POST unsubscribes.
+	router.GET("/unsubscribe", limit(readRateLimit), controllers.ConfirmUnsubscribe)
+	router.POST("/unsubscribe", limit(readRateLimit), controllers.Unsubscribe)
+
+	// realtime routes, streamed as Server-Sent Events
+	streamRoutes := router.Group("stream")
+	{
+		streamRoutes.GET("/feed", middleware.PostsMiddleware(), limit(readRateLimit), controllers.StreamFeed)
+		streamRoutes.GET("/resolutions/:id", middleware.PostsMiddleware(), limit(readRateLimit), controllers.StreamResolution)
+		// notifications are the account's own, like its settings they aren't shared with tokens
+		streamRoutes.GET("/notifications", middleware.AuthMiddleware(), middleware.SessionOnly(), limit(readRateLimit), controllers.StreamNotifications)
+	}
+
+	// report routes
+	router.POST("/reports", middleware.AuthMiddleware(), middleware.SessionOnly(), limit(reportRateLimit), controllers.CreateReport)
+
+	// moderation routes
+	moderationRoutes := router.Group("moderation")
+	{
+		moderationRoutes.Use(middleware.AuthMiddleware(), middleware.SessionOnly(), middleware.RequireRole(models.RoleModerator), limit(moderationRateLimit))
+		moderationRoutes.GET("/reports", controllers.GetModerationQueue)
+		moderationRoutes.POST("/actions", controllers.ModerateTarget)
 	}
 }
@@ -0,0 +1,543 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"nyr/audit"
+	"nyr/config"
+	"nyr/migrations"
+	"nyr/models"
+	"nyr/providers"
+	"nyr/repository"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The suite runs in process against the in-memory repositories, or against a fresh
+// database when MONGO_TEST_URI points at a local mongod
+var mongoClient *mongo.Client
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	config.InitializeOAuthConfig()
+	providers.Initialize()
+
+	if uri := os.Getenv("MONGO_TEST_URI"); uri != "" {
+		var err error
+		mongoClient, err = mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	os.Exit(m.Run())
+}
+
+// auditRecorder keeps audit entries in memory so tests can check them, and passes them
+// on to the repository so the audit log route lists them
+type auditRecorder struct {
+	sync.Mutex
+	entries []models.AuditLog
+	next    audit.Store
+}
+
+func (r *auditRecorder) Insert(ctx context.Context, entry models.AuditLog) error {
+	r.Lock()
+	r.entries = append(r.entries, entry)
+	r.Unlock()
+	return r.next.Insert(ctx, entry)
+}
+
+func (r *auditRecorder) actions() []string {
+	r.Lock()
+	defer r.Unlock()
+	actions := []string{}
+	for _, e := range r.entries {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+// last returns the most recent entry
+func (r *auditRecorder) last() models.AuditLog {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.entries) == 0 {
+		return models.AuditLog{}
+	}
+	return r.entries[len(r.entries)-1]
+}
+
+type testServer struct {
+	router *gin.Engine
+	repos  repository.Repositories
+	audit  *auditRecorder
+}
+
+// newTestServer builds the router on empty storage with fresh rate limits
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{}
+
+	if mongoClient != nil {
+		database := mongoClient.Database("nyr_test_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() { database.Drop(context.Background()) })
+		if err := migrations.Run(context.Background(), database); err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		s.repos = repository.NewMongo(database)
+	} else {
+		s.repos = repository.NewMemory()
+	}
+
+	s.audit = &auditRecorder{next: s.repos.AuditLogs}
+	audit.SetStore(s.audit)
+	RateLimitStore = nil
+	s.router = gin.New()
+	if err := TrustProxies(s.router, ""); err != nil {
+		t.Fatalf("distrusting proxies: %v", err)
+	}
+	InitRoutes(s.router, s.repos)
+	return s
+}
+
+// user stores a user, change can adjust it before it is stored
+func (s *testServer) user(t *testing.T, name string, change func(*models.User)) models.User {
+	t.Helper()
+	user := models.User{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Email:     strings.ToLower(name) + "@example.com",
+		Role:      models.RoleUser,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if change != nil {
+		change(&user)
+	}
+	if err := s.repos.Users.Insert(context.Background(), user); err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	return user
+}
+
+func (s *testServer) resolution(t *testing.T, owner models.User, text string, created time.Time) models.Resolution {
+	t.Helper()
+	resolution := models.Resolution{
+		RID:        primitive.NewObjectID(),
+		UserID:     owner.ID,
+		Resolution: text,
+		Tags:       []string{},
+		CreatedAt:  created,
+		UpdatedAt:  created,
+	}
+	if err := s.repos.Resolutions.Insert(context.Background(), resolution); err != nil {
+		t.Fatalf("inserting resolution: %v", err)
+	}
+	return resolution
+}
+
+func (s *testServer) comment(t *testing.T, author models.User, rID primitive.ObjectID, text string, created time.Time) models.Comments {
+	t.Helper()
+	comment := models.Comments{ID: primitive.NewObjectID(), UserID: author.ID, RID: rID, Comment: text, CreatedAt: created, UpdatedAt: created}
+	if err := s.repos.Comments.Insert(context.Background(), comment); err != nil {
+		t.Fatalf("inserting comment: %v", err)
+	}
+	return comment
+}
+
+func (s *testServer) like(t *testing.T, user models.User, rID primitive.ObjectID) {
+	t.Helper()
+	s.react(t, user, rID, models.ReactionLike)
+}
+
+func (s *testServer) react(t *testing.T, user models.User, rID primitive.ObjectID, reactionType string) {
+	t.Helper()
+	reaction := models.Reaction{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		TargetType: models.ReactionTargetResolution,
+		TargetID:   rID,
+		Type:       reactionType,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if _, err := s.repos.Reactions.Set(context.Background(), reaction); err != nil {
+		t.Fatalf("setting reaction: %v", err)
+	}
+}
+
+func (s *testServer) block(t *testing.T, user, target models.User, kind string) {
+	t.Helper()
+	if _, err := s.repos.Blocks.Add(context.Background(), models.Block{UserID: user.ID, TargetID: target.ID, Kind: kind, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("inserting block: %v", err)
+	}
+}
+
+// request sends a request, body is encoded as JSON unless it is a string or nil.
+// An empty authorization sends no Authorization header.
+func (s *testServer) request(method, path, authorization string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		encoded, _ := json.Marshal(b)
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// as sends a request with a session token for the user
+func (s *testServer) as(user models.User, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return s.request(method, path, bearer(signToken(jwt.MapClaims{
+		"user_id": user.ID.Hex(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})), body)
+}
+
+// signToken signs claims like a login does, with the key the middleware reads from JWT_SECRET_KEY
+func signToken(claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+func bearer(token string) string {
+	return "Bearer " + token
+}
+
+// decode parses a JSON response body
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+func expectError(t *testing.T, w *httptest.ResponseRecorder, status int, message string) {
+	t.Helper()
+	expectStatus(t, w, status)
+	if got := decode(t, w)["error"]; got != message {
+		t.Fatalf("got error %q, want %q", got, message)
+	}
+}
+
+type route struct {
+	method string
+	path   string
+}
+
+// url fills the route parameters with values that parse but don't exist
+func (r route) url() string {
+	parts := strings.Split(r.path, "/")
+	for i, part := range parts {
+		switch {
+		case part == ":provider":
+			parts[i] = "google"
+		case strings.HasPrefix(part, ":"):
+			parts[i] = primitive.NewObjectID().Hex()
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (r route) String() string {
+	return r.method + " " + r.path
+}
+
+// authenticatedRoutes are all routes behind AuthMiddleware
+var authenticatedRoutes = []route{
+	{"POST", "/resolution"},
+	{"POST", "/resolution/:id/adopt"},
+	{"POST", "/resolution/likes"},
+	{"PUT", "/resolution/:id/like"},
+	{"DELETE", "/resolution/:id/like"},
+	{"PUT", "/resolution/:id/reaction"},
+	{"DELETE", "/resolution/:id/reaction"},
+	{"PUT", "/resolution/:id/comments/:comment_id/reaction"},
+	{"DELETE", "/resolution/:id/comments/:comment_id/reaction"},
+	{"PUT", "/resolution/:id/bookmark"},
+	{"DELETE", "/resolution/:id/bookmark"},
+	{"GET", "/bookmarks"},
+	{"GET", "/bookmarks/collections"},
+	{"POST", "/resolution/comments"},
+	{"GET", "/resolution/me"},
+	{"PUT", "/resolution/:id/reminder"},
+	{"DELETE", "/resolution/:id/reminder"},
+	{"GET", "/reminders"},
+	{"PUT", "/profile"},
+	{"PUT", "/profile/timezone"},
+	{"PUT", "/profile/handle"},
+	{"POST", "/profile/identities/:provider"},
+	{"DELETE", "/profile/identities/:provider"},
+	{"GET", "/profile/tokens"},
+	{"POST", "/profile/tokens"},
+	{"DELETE", "/profile/tokens/:id"},
+	{"GET", "/admin/users"},
+	{"PUT", "/admin/users/:id/role"},
+	{"DELETE", "/admin/users/:id/role"},
+	{"POST", "/admin/users/:id/ban"},
+	{"POST", "/admin/users/:id/suspend"},
+	{"POST", "/admin/users/:id/reinstate"},
+	{"GET", "/admin/audit"},
+	{"GET", "/admin/webhooks"},
+	{"POST", "/admin/webhooks"},
+	{"PUT", "/admin/webhooks/:id"},
+	{"DELETE", "/admin/webhooks/:id"},
+	{"GET", "/admin/webhooks/:id/deliveries"},
+	{"POST", "/admin/webhooks/:id/deliveries/:delivery_id/replay"},
+	{"GET", "/webhooks"},
+	{"POST", "/webhooks"},
+	{"PUT", "/webhooks/:id"},
+	{"DELETE", "/webhooks/:id"},
+	{"GET", "/webhooks/:id/deliveries"},
+	{"POST", "/webhooks/:id/deliveries/:delivery_id/replay"},
+	{"GET", "/integrations/slack/link"},
+	{"POST", "/integrations/slack/link"},
+	{"GET", "/blocks"},
+	{"POST", "/blocks"},
+	{"DELETE", "/blocks/:user_id"},
+	{"POST", "/users/:id/follow"},
+	{"DELETE", "/users/:id/follow"},
+	{"GET", "/notifications"},
+	{"POST", "/notifications/:id/read"},
+	{"POST", "/notifications/read-all"},
+	{"GET", "/notifications/preferences"},
+	{"PUT", "/notifications/preferences"},
+	{"GET", "/notifications/email-preferences"},
+	{"PUT", "/notifications/email-preferences"},
+	{"GET", "/stream/notifications"},
+	{"POST", "/reports"},
+	{"GET", "/moderation/reports"},
+	{"POST", "/moderation/actions"},
+}
+
+// publicRoutes can be called without signing in, each has its own tests
+var publicRoutes = []route{
+	{"GET", "/health"},
+	{"GET", "/auth/google"},
+	{"POST", "/auth/google/callback"},
+	{"POST", "/auth/magic-link"},
+	{"POST", "/auth/magic-link/verify"},
+	{"GET", "/auth/providers"},
+	{"GET", "/auth/:provider"},
+	{"POST", "/auth/:provider/callback"},
+	{"GET", "/verify-token"},
+	{"GET", "/resolution"},
+	{"GET", "/resolution/:id"},
+	{"GET", "/resolution/:id/adopters"},
+	{"POST", "/integrations/slack/commands"},
+	{"POST", "/integrations/slack/interactivity"},
+	{"GET", "/unsubscribe"},
+	{"POST", "/unsubscribe"},
+	{"GET", "/stream/feed"},
+	{"GET", "/stream/resolutions/:id"},
+}
+
+// TestEveryRouteIsTested fails when a route is added without adding it to the tables above
+func TestEveryRouteIsTested(t *testing.T) {
+	s := newTestServer(t)
+
+	tested := map[string]bool{}
+	for _, r := range append(append([]route{}, authenticatedRoutes...), publicRoutes...) {
+		if tested[r.String()] {
+			t.Errorf("%s is listed twice", r)
+		}
+		tested[r.String()] = true
+	}
+
+	registered := map[string]bool{}
+	for _, info := range s.router.Routes() {
+		r := route{info.Method, info.Path}
+		registered[r.String()] = true
+		if !tested[r.String()] {
+			t.Errorf("%s is not covered by the route tests", r)
+		}
+	}
+	for r := range tested {
+		if !registered[r] {
+			t.Errorf("%s is tested but not registered", r)
+		}
+	}
+}
+
+func TestAuthMiddlewareRejects(t *testing.T) {
+	s := newTestServer(t)
+	banned := s.user(t, "Banned", func(u *models.User) {
+		u.Status = models.UserStatusBanned
+		u.StatusReason = "spam"
+	})
+	suspended := s.user(t, "Suspended", func(u *models.User) {
+		until := time.Now().Add(24 * time.Hour)
+		u.Status = models.UserStatusSuspended
+		u.SuspendedUntil = &until
+	})
+	valid := jwt.MapClaims{"user_id": primitive.NewObjectID().Hex(), "exp": time.Now().Add(time.Hour).Unix()}
+
+	wrongKey, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString([]byte("not the key"))
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	cases := []struct {
+		name          string
+		authorization string
+		status        int
+		message       string
+	}{
+		{"missing header", "", http.StatusUnauthorized, "Authorization header is missing"},
+		{"not bearer", "Token abc", http.StatusUnauthorized, "Authorization format is incorrect"},
+		{"extra parts", "Bearer a b", http.StatusUnauthorized, "Authorization format is incorrect"},
+		{"not a JWT", "Bearer not-a-jwt", http.StatusUnauthorized, "Invalid or expired token"},
+		{"wrong key", bearer(wrongKey), http.StatusUnauthorized, "Invalid or expired token"},
+		{"unsigned", bearer(unsigned), http.StatusUnauthorized, "Invalid or expired token"},
+		{"expired", bearer(signToken(jwt.MapClaims{"user_id": banned.ID.Hex(), "exp": time.Now().Add(-time.Minute).Unix()})), http.StatusUnauthorized, "Invalid or expired token"},
+		{"no user", bearer(signToken(jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})), http.StatusUnauthorized, "Invalid token claims"},
+		{"unknown user", bearer(signToken(valid)), http.StatusUnauthorized, "User not found"},
+		{"banned", bearer(signToken(jwt.MapClaims{"user_id": banned.ID.Hex(), "exp": time.Now().Add(time.Hour).Unix()})), http.StatusForbidden, "Account banned"},
+		{"suspended", bearer(signToken(jwt.MapClaims{"user_id": suspended.ID.Hex(), "exp": time.Now().Add(time.Hour).Unix()})), http.StatusForbidden, "Account suspended"},
+	}
+
+	for _, r := range authenticatedRoutes {
+		for _, tc := range cases {
+			t.Run(r.String()+"/"+tc.name, func(t *testing.T) {
+				w := s.request(r.method, r.url(), tc.authorization, "{}")
+				expectError(t, w, tc.status, tc.message)
+			})
+		}
+	}
+}
+
+func TestRoleRoutes(t *testing.T) {
+	s := newTestServer(t)
+	user := s.user(t, "Alice", nil)
+	moderator := s.user(t, "Mod", func(u *models.User) { u.Role = models.RoleModerator })
+
+	for _, r := range authenticatedRoutes {
+		var allowed []models.User
+		switch {
+		case strings.HasPrefix(r.path, "/admin/"):
+		case strings.HasPrefix(r.path, "/moderation/"):
+			allowed = []models.User{moderator}
+		default:
+			continue
+		}
+
+		t.Run(r.String(), func(t *testing.T) {
+			for _, u := range []models.User{user, moderator} {
+				w := s.as(u, r.method, r.url(), "{}")
+				isAllowed := len(allowed) > 0 && allowed[0].ID == u.ID
+				if !isAllowed {
+					expectError(t, w, http.StatusForbidden, "You don't have permission to do this")
+				} else if w.Code == http.StatusForbidden {
+					t.Errorf("%s was refused for a %s", r, u.Role)
+				}
+			}
+		})
+	}
+}
+
+// TestValidation sends requests that handlers must refuse before touching storage
+func TestValidation(t *testing.T) {
+	s := newTestServer(t)
+	user := s.user(t, "Alice", nil)
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	moderator := s.user(t, "Mod", func(u *models.User) { u.Role = models.RoleModerator })
+
+	cases := []struct {
+		name    string
+		as      models.User
+		method  string
+		path    string
+		body    interface{}
+		status  int
+		message string
+	}{
+		{"reminder with invalid ID", user, "PUT", "/resolution/nope/reminder", gin.H{"frequency": "daily"}, 400, "Invalid resolution ID"},
+		{"reminder with unknown frequency", user, "PUT", "/resolution/" + primitive.NewObjectID().Hex() + "/reminder", gin.H{"frequency": "hourly"}, 400, "frequency must be daily, weekly or monthly"},
+		{"reminder hour out of range", user, "PUT", "/resolution/" + primitive.NewObjectID().Hex() + "/reminder", gin.H{"frequency": "daily", "hour": 24}, 400, "hour must be between 0 and 23"},
+		{"delete reminder with invalid ID", user, "DELETE", "/resolution/nope/reminder", nil, 400, "Invalid resolution ID"},
+		{"unknown timezone", user, "PUT", "/profile/timezone", gin.H{"timezone": "Mars/Olympus"}, 400, "Unknown timezone, use an IANA name like Europe/Berlin"},
+		{"link identity without code", user, "POST", "/profile/identities/google", gin.H{}, 400, "Invalid request"},
+		{"link identity of unknown provider", user, "POST", "/profile/identities/nope", gin.H{"code": "c", "state": "s", "verifier": "v"}, 404, "Unknown identity provider"},
+		{"link identity with bad state", user, "POST", "/profile/identities/google", gin.H{"code": "c", "state": "s", "verifier": "v"}, 400, "Invalid state"},
+		{"token without name", user, "POST", "/profile/tokens", gin.H{"name": "", "scopes": []string{models.ScopeRead}}, 400, "Key: 'Name' Error:Field validation for 'Name' failed on the 'required' tag"},
+		{"revoke token with invalid ID", user, "DELETE", "/profile/tokens/nope", nil, 400, "Invalid token ID"},
+		{"list users with unknown role", admin, "GET", "/admin/users?role=king", nil, 400, "Unknown role"},
+		{"grant unknown role", admin, "PUT", "/admin/users/" + primitive.NewObjectID().Hex() + "/role", gin.H{"role": "king"}, 400, "Unknown role"},
+		{"revoke role with invalid ID", admin, "DELETE", "/admin/users/nope/role", nil, 400, "Invalid user ID"},
+		{"ban without reason", admin, "POST", "/admin/users/" + primitive.NewObjectID().Hex() + "/ban", gin.H{}, 400, "A reason is required"},
+		{"suspend for too long", admin, "POST", "/admin/users/" + primitive.NewObjectID().Hex() + "/suspend", gin.H{"reason": "spam", "days": 400}, 400, "days must be between 1 and 365"},
+		{"reinstate yourself", admin, "POST", "/admin/users/" + admin.ID.Hex() + "/reinstate", nil, 400, "You can't change your own status"},
+		{"admin webhook with http URL", admin, "POST", "/admin/webhooks", gin.H{"url": "http://example.com", "events": []string{models.WebhookEventResolutionCreated}}, 400, "url must use https"},
+		{"admin webhook with invalid ID", admin, "PUT", "/admin/webhooks/nope", gin.H{}, 400, "Invalid webhook ID"},
+		{"delete admin webhook with invalid ID", admin, "DELETE", "/admin/webhooks/nope", nil, 400, "Invalid webhook ID"},
+		{"admin deliveries with invalid ID", admin, "GET", "/admin/webhooks/nope/deliveries", nil, 400, "Invalid webhook ID"},
+		{"admin replay with invalid delivery ID", admin, "POST", "/admin/webhooks/" + primitive.NewObjectID().Hex() + "/deliveries/nope/replay", nil, 400, "Invalid delivery ID"},
+		{"webhook without events", user, "POST", "/webhooks", gin.H{"url": "https://example.com"}, 400, "Key: 'Events' Error:Field validation for 'Events' failed on the 'required' tag"},
+		{"webhook to the metadata service", user, "POST", "/webhooks", gin.H{"url": "https://169.254.169.254/latest/meta-data", "events": []string{models.WebhookEventResolutionCreated}}, 400, "url must point to a public address"},
+		{"webhook to loopback", user, "POST", "/webhooks", gin.H{"url": "https://[::1]:8080/hook", "events": []string{models.WebhookEventResolutionCreated}}, 400, "url must point to a public address"},
+		{"webhook to localhost", user, "POST", "/webhooks", gin.H{"url": "https://localhost:8080/hook", "events": []string{models.WebhookEventResolutionCreated}}, 400, "url must point to a public address"},
+		{"webhook to a private network", user, "POST", "/webhooks", gin.H{"url": "https://10.0.0.5/hook", "events": []string{models.WebhookEventResolutionCreated}}, 400, "url must point to a public address"},
+		{"webhook with unknown event", user, "POST", "/webhooks", gin.H{"url": "https://example.com", "events": []string{"nope"}}, 400, "Unknown event: nope"},
+		{"webhook with invalid ID", user, "PUT", "/webhooks/nope", gin.H{}, 400, "Invalid webhook ID"},
+		{"delete webhook with invalid ID", user, "DELETE", "/webhooks/nope", nil, 400, "Invalid webhook ID"},
+		{"deliveries with invalid ID", user, "GET", "/webhooks/nope/deliveries", nil, 400, "Invalid webhook ID"},
+		{"replay with invalid webhook ID", user, "POST", "/webhooks/nope/deliveries/" + primitive.NewObjectID().Hex() + "/replay", nil, 400, "Invalid webhook ID"},
+		{"slack link with bad token", user, "POST", "/integrations/slack/link", gin.H{"token": "nope"}, 401, "Invalid or expired link"},
+		{"slack link preview with bad token", user, "GET", "/integrations/slack/link?token=nope", nil, 401, "Invalid or expired link"},
+		{"block yourself", user, "POST", "/blocks", gin.H{"user_id": user.ID.Hex()}, 400, "You can't block yourself"},
+		{"block with unknown kind", user, "POST", "/blocks", gin.H{"user_id": admin.ID.Hex(), "kind": "ignore"}, 400, "kind must be block or mute"},
+		{"block unknown user", user, "POST", "/blocks", gin.H{"user_id": primitive.NewObjectID().Hex()}, 404, "User not found"},
+		{"list blocks of unknown kind", user, "GET", "/blocks?kind=ignore", nil, 400, "kind must be block or mute"},
+		{"unblock with invalid ID", user, "DELETE", "/blocks/nope", nil, 400, "Invalid user ID"},
+		{"unblock someone not blocked", user, "DELETE", "/blocks/" + admin.ID.Hex(), nil, 404, "User is not blocked"},
+		{"follow yourself", user, "POST", "/users/" + user.ID.Hex() + "/follow", nil, 400, "You can't follow yourself"},
+		{"follow with invalid ID", user, "POST", "/users/nope/follow", nil, 400, "Invalid user ID"},
+		{"unfollow with invalid ID", user, "DELETE", "/users/nope/follow", nil, 400, "Invalid user ID"},
+		{"read notification with invalid ID", user, "POST", "/notifications/nope/read", nil, 400, "Invalid notification ID"},
+		{"unknown notification type", user, "PUT", "/notifications/preferences", gin.H{"nope": false}, 400, "Unknown notification type: nope"},
+		{"unknown email category", user, "PUT", "/notifications/email-preferences", gin.H{"nope": false}, 400, "Unknown email category: nope"},
+		{"report with unknown reason", user, "POST", "/reports", gin.H{"target_type": "user", "target_id": admin.ID.Hex(), "reason": "boring"}, 400, "Unknown reason"},
+		{"moderation without action", moderator, "POST", "/moderation/actions", gin.H{"target_type": "user"}, 400, "Key: 'TargetID' Error:Field validation for 'TargetID' failed on the 'required' tag\nKey: 'Action' Error:Field validation for 'Action' failed on the 'required' tag"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := s.as(tc.as, tc.method, tc.path, tc.body)
+			expectError(t, w, tc.status, tc.message)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nyr/models"
+	"nyr/repository"
+	"nyr/slack/slacktest"
+	"nyr/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSlackRoutes(t *testing.T) {
+	t.Run("not configured", func(t *testing.T) {
+		t.Setenv("SLACK_SIGNING_SECRET", "")
+		s := newTestServer(t)
+		for _, path := range []string{"/integrations/slack/commands", "/integrations/slack/interactivity"} {
+			expectError(t, s.request("POST", path, "", nil), http.StatusServiceUnavailable, "Slack integration is not configured")
+		}
+	})
+
+	t.Setenv("SLACK_SIGNING_SECRET", "slack-secret")
+	s := newTestServer(t)
+
+	t.Run("unsigned", func(t *testing.T) {
+		for _, path := range []string{"/integrations/slack/commands", "/integrations/slack/interactivity"} {
+			expectStatus(t, s.request("POST", path, "", nil), http.StatusUnauthorized)
+		}
+	})
+	t.Run("signed with another secret", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, slacktest.NewSlashCommandRequest("/integrations/slack/commands", "other", "T1", "U1", "help"))
+		expectStatus(t, w, http.StatusUnauthorized)
+	})
+	t.Run("help", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, slacktest.NewSlashCommandRequest("/integrations/slack/commands", "slack-secret", "T1", "U1", "help"))
+		expectStatus(t, w, http.StatusOK)
+		if text, _ := decode(t, w)["text"].(string); !strings.HasPrefix(text, "Usage:") {
+			t.Errorf("got %q, want the usage", text)
+		}
+	})
+	t.Run("invalid interactivity payload", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, slacktest.NewViewSubmissionRequest("/integrations/slack/interactivity", "slack-secret", "T1", "U1", "nope", nil))
+		expectStatus(t, w, http.StatusOK)
+	})
+}
+
+// slash runs "/resolve <text>" as the Slack user and returns Slack's reply
+func (s *testServer) slash(t *testing.T, slackUserID, text string) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, slacktest.NewSlashCommandRequest("/integrations/slack/commands", "slack-secret", "T1", slackUserID, text))
+	expectStatus(t, w, http.StatusOK)
+	return decode(t, w)
+}
+
+// slackUser stores a user with the Slack account linked
+func (s *testServer) slackUser(t *testing.T, name, slackUserID string) models.User {
+	t.Helper()
+	return s.user(t, name, func(u *models.User) {
+		u.Identities = []models.Identity{{Provider: "slack", Subject: "T1:" + slackUserID, LinkedAt: time.Now()}}
+	})
+}
+
+func TestSlackLink(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", "slack-secret")
+	t.Setenv("SLACK_LINK_URL", "https://app.example.com/slack/link")
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+
+	reply := s.slash(t, "U1", "link")
+	text, _ := reply["text"].(string)
+	if reply["response_type"] != "ephemeral" || !strings.Contains(text, "@tester in example") {
+		t.Fatalf("got %v, want a private link naming the Slack account", reply)
+	}
+	_, link, _ := strings.Cut(text, "https://app.example.com/slack/link?token=")
+	link, _, _ = strings.Cut(link, "\n")
+	token, err := url.QueryUnescape(link)
+	if err != nil || token == "" {
+		t.Fatalf("no token in %q", text)
+	}
+
+	// The app shows which workspace and user the link connects before anything is linked
+	w := s.as(alice, "GET", "/integrations/slack/link?token="+url.QueryEscape(token), nil)
+	expectStatus(t, w, http.StatusOK)
+	preview := decode(t, w)
+	if preview["team_domain"] != "example" || preview["slack_user_name"] != "tester" || preview["slack_user_id"] != "U1" {
+		t.Errorf("got preview %v", preview)
+	}
+	expectError(t, s.as(alice, "POST", "/integrations/slack/link", gin.H{"token": token}),
+		http.StatusBadRequest, "Confirm the Slack workspace and user to link")
+	if _, err := s.repos.Users.FindByIdentity(context.Background(), "slack", "T1:U1"); err == nil {
+		t.Fatal("linked the Slack account without confirmation")
+	}
+
+	expectStatus(t, s.as(alice, "POST", "/integrations/slack/link", gin.H{"token": token, "confirm": true}), http.StatusCreated)
+	if user, err := s.repos.Users.FindByIdentity(context.Background(), "slack", "T1:U1"); err != nil || user.ID != alice.ID {
+		t.Errorf("got %+v, %v, want the Slack account linked to alice", user, err)
+	}
+	expectStatus(t, s.as(alice, "POST", "/integrations/slack/link", gin.H{"token": token, "confirm": true}), http.StatusOK)
+	expectError(t, s.as(bob, "POST", "/integrations/slack/link", gin.H{"token": token, "confirm": true}),
+		http.StatusConflict, "Slack account is linked to another account")
+
+	// One Slack account per user
+	other, err := utils.NewSlackLinkToken("T1", "example", "U2", "other", time.Minute)
+	if err != nil {
+		t.Fatalf("signing link: %v", err)
+	}
+	expectError(t, s.as(alice, "POST", "/integrations/slack/link", gin.H{"token": other, "confirm": true}),
+		http.StatusConflict, "Another Slack account is linked, unlink it first")
+
+	// Two users confirming the same Slack account at once, only one of them gets it
+	carol := s.user(t, "Carol", nil)
+	dave := s.user(t, "Dave", nil)
+	contested, err := utils.NewSlackLinkToken("T1", "example", "U3", "contested", time.Minute)
+	if err != nil {
+		t.Fatalf("signing link: %v", err)
+	}
+	contenders := []models.User{carol, dave}
+	results := make([]*httptest.ResponseRecorder, len(contenders))
+	var wg sync.WaitGroup
+	for i, user := range contenders {
+		wg.Add(1)
+		go func(i int, user models.User) {
+			defer wg.Done()
+			results[i] = s.as(user, "POST", "/integrations/slack/link", gin.H{"token": contested, "confirm": true})
+		}(i, user)
+	}
+	wg.Wait()
+	owner, err := s.repos.Users.FindByIdentity(context.Background(), "slack", "T1:U3")
+	if err != nil {
+		t.Fatalf("Slack account not linked: %v", err)
+	}
+	for i, w := range results {
+		if contenders[i].ID == owner.ID {
+			expectStatus(t, w, http.StatusCreated)
+		} else {
+			expectError(t, w, http.StatusConflict, "Slack account is linked to another account")
+		}
+	}
+}
+
+func TestSlackComments(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", "slack-secret")
+	s := newTestServer(t)
+	owner := s.slackUser(t, "Owner", "U1")
+	friend := s.slackUser(t, "Friend", "U2")
+	blocked := s.slackUser(t, "Blocked", "U3")
+	resolution := s.resolution(t, owner, "run a marathon", time.Now())
+	s.block(t, owner, blocked, models.BlockKindBlock)
+	rID := resolution.RID.Hex()
+
+	comments := func() []repository.CommentDetail {
+		t.Helper()
+		detail, err := s.repos.Resolutions.Detail(context.Background(), resolution.RID, repository.DetailQuery{})
+		if err != nil {
+			t.Fatalf("loading resolution: %v", err)
+		}
+		return detail.Comments
+	}
+
+	refused := []struct {
+		name    string
+		slackID string
+		text    string
+		reply   string
+	}{
+		{"unlinked user", "U9", "comment " + rID + " hi", "Your Slack account isn't connected yet, run `/resolve link` first"},
+		{"blocked user", "U3", "comment " + rID + " hi", "You can't interact with this resolution"},
+		{"policy rejection", "U2", "comment " + rID + " " + strings.Repeat("a ", 600), "Text can't be longer than 1000 characters"},
+		{"check in by someone else", "U2", "checkin " + rID + " ran 5k", "Only the owner can check in on a resolution"},
+		{"unknown resolution", "U2", "comment " + primitive.NewObjectID().Hex() + " hi", "Resolution not found"},
+	}
+	for _, tc := range refused {
+		t.Run(tc.name, func(t *testing.T) {
+			reply := s.slash(t, tc.slackID, tc.text)
+			if reply["response_type"] != "ephemeral" || reply["text"] != tc.reply {
+				t.Errorf("got %v, want the private reply %q", reply, tc.reply)
+			}
+		})
+	}
+	if got := comments(); len(got) != 0 {
+		t.Fatalf("refused commands stored comments %+v", got)
+	}
+
+	// A blocked user's modal shows the error next to the input
+	t.Run("modal of a blocked user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, slacktest.NewViewSubmissionRequest("/integrations/slack/interactivity", "slack-secret", "T1", "U3", "comment", map[string]string{"r_id": rID, "text": "hi"}))
+		expectStatus(t, w, http.StatusOK)
+		body := decode(t, w)
+		errs, _ := body["errors"].(map[string]interface{})
+		if body["response_action"] != "errors" || errs["text"] != "You can't interact with this resolution" {
+			t.Errorf("got %v", body)
+		}
+	})
+
+	t.Run("check in by the owner", func(t *testing.T) {
+		reply := s.slash(t, "U1", "checkin "+rID+" ran 5k")
+		if reply["response_type"] != "in_channel" || reply["text"] != "Owner checked in: ran 5k" {
+			t.Errorf("got %v, want the check in shown in the channel", reply)
+		}
+	})
+	t.Run("comment", func(t *testing.T) {
+		reply := s.slash(t, "U2", "comment "+rID+" good luck")
+		if reply["response_type"] != "ephemeral" || reply["text"] != "Comment posted" {
+			t.Errorf("got %v", reply)
+		}
+	})
+	t.Run("comment from a modal", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, slacktest.NewViewSubmissionRequest("/integrations/slack/interactivity", "slack-secret", "T1", "U2", "comment", map[string]string{"r_id": rID, "text": "you got this"}))
+		expectStatus(t, w, http.StatusOK)
+		if w.Body.Len() != 0 {
+			t.Errorf("got %s, want the modal closed", w.Body.String())
+		}
+	})
+
+	got := map[string]string{}
+	for _, comment := range comments() {
+		author := "owner"
+		if comment.UserID == friend.ID {
+			author = "friend"
+		}
+		got[comment.Comment] = author + "/" + comment.Kind
+	}
+	want := map[string]string{"ran 5k": "owner/" + models.CommentKindCheckIn, "good luck": "friend/", "you got this": "friend/"}
+	if len(got) != len(want) {
+		t.Fatalf("got comments %v, want %v", got, want)
+	}
+	for text, author := range want {
+		if got[text] != author {
+			t.Errorf("comment %q is %q, want %q", text, got[text], author)
+		}
+	}
+}
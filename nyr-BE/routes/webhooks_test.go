@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"nyr/audit"
+	"nyr/models"
+	"nyr/repository"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type webhookResponse struct {
+	Secret  string         `json:"secret"`
+	Webhook models.Webhook `json:"webhook"`
+}
+
+type deliveriesResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// createWebhook registers a webhook through the API, on the admin routes for a global one
+func (s *testServer) createWebhook(t *testing.T, as models.User, prefix string, events ...string) models.Webhook {
+	t.Helper()
+	w := s.as(as, "POST", prefix+"/webhooks", gin.H{"url": "https://example.com/hook", "events": events})
+	expectStatus(t, w, http.StatusCreated)
+	var created webhookResponse
+	decodeInto(t, w, &created)
+	if created.Secret == "" {
+		t.Fatal("the secret was not returned")
+	}
+	return created.Webhook
+}
+
+func (s *testServer) deliveries(t *testing.T, as models.User, path string) []models.WebhookDelivery {
+	t.Helper()
+	w := s.as(as, "GET", path, nil)
+	expectStatus(t, w, http.StatusOK)
+	var body deliveriesResponse
+	decodeInto(t, w, &body)
+	return body.Deliveries
+}
+
+func TestWebhooks(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user(t, "Alice", nil)
+	bob := s.user(t, "Bob", nil)
+	admin := s.user(t, "Admin", func(u *models.User) { u.Role = models.RoleAdmin })
+	resolution := s.resolution(t, alice, "run a marathon", time.Now())
+
+	own := s.createWebhook(t, alice, "", models.WebhookEventCommentCreated, models.WebhookEventResolutionCreated, models.WebhookEventCommentCreated)
+	global := s.createWebhook(t, admin, "/admin", models.WebhookEventResolutionCreated)
+	ownPath := "/webhooks/" + own.ID.Hex()
+
+	t.Run("created", func(t *testing.T) {
+		if !own.Active || own.UserID == nil || *own.UserID != alice.ID || len(own.Events) != 2 {
+			t.Errorf("got %+v, want an active webhook of Alice with the two events", own)
+		}
+		if global.UserID != nil {
+			t.Errorf("global webhook belongs to %s", global.UserID.Hex())
+		}
+	})
+
+	t.Run("listed for their owner only", func(t *testing.T) {
+		var body struct {
+			Webhooks []models.Webhook `json:"webhooks"`
+		}
+		for _, tc := range []struct {
+			as   models.User
+			path string
+			want primitive.ObjectID
+		}{
+			{alice, "/webhooks", own.ID},
+			{admin, "/admin/webhooks", global.ID},
+		} {
+			decodeInto(t, s.as(tc.as, "GET", tc.path, nil), &body)
+			if len(body.Webhooks) != 1 || body.Webhooks[0].ID != tc.want {
+				t.Errorf("%s listed %v, want only %s", tc.path, body.Webhooks, tc.want.Hex())
+			}
+		}
+		decodeInto(t, s.as(bob, "GET", "/webhooks", nil), &body)
+		if len(body.Webhooks) != 0 {
+			t.Errorf("Bob sees %v", body.Webhooks)
+		}
+	})
+
+	t.Run("other users can't manage it", func(t *testing.T) {
+		expectError(t, s.as(bob, "PUT", ownPath, gin.H{"active": false}), http.StatusNotFound, "Webhook not found")
+		expectError(t, s.as(bob, "DELETE", ownPath, nil), http.StatusNotFound, "Webhook not found")
+		expectError(t, s.as(bob, "GET", ownPath+"/deliveries", nil), http.StatusNotFound, "Webhook not found")
+		expectError(t, s.as(admin, "PUT", "/admin"+ownPath, gin.H{"active": false}), http.StatusNotFound, "Webhook not found")
+	})
+
+	t.Run("events queue deliveries where they happen", func(t *testing.T) {
+		expectStatus(t, s.as(bob, "POST", "/resolution/comments", gin.H{"r_id": resolution.RID.Hex(), "comment": "go Alice"}), http.StatusCreated)
+		expectStatus(t, s.as(bob, "POST", "/resolution", gin.H{"resolution": "learn to juggle"}), http.StatusCreated)
+
+		// Bob's new resolution isn't Alice's, so only the global webhook receives it
+		got := s.deliveries(t, alice, ownPath+"/deliveries")
+		if len(got) != 1 || got[0].Event != models.WebhookEventCommentCreated || got[0].Status != models.WebhookDeliveryPending {
+			t.Fatalf("Alice's webhook got %+v, want one pending comment delivery", got)
+		}
+		got = s.deliveries(t, admin, "/admin/webhooks/"+global.ID.Hex()+"/deliveries")
+		if len(got) != 1 || got[0].Event != models.WebhookEventResolutionCreated {
+			t.Fatalf("the global webhook got %+v, want one resolution delivery", got)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		original := s.deliveries(t, alice, ownPath+"/deliveries")[0]
+		w := s.as(alice, "POST", ownPath+"/deliveries/"+original.ID.Hex()+"/replay", nil)
+		expectStatus(t, w, http.StatusAccepted)
+		var body struct {
+			Delivery models.WebhookDelivery `json:"delivery"`
+		}
+		decodeInto(t, w, &body)
+		replay := body.Delivery
+		if replay.ID == original.ID || replay.ReplayOf == nil || *replay.ReplayOf != original.ID ||
+			replay.Payload != original.Payload || replay.Status != models.WebhookDeliveryPending {
+			t.Errorf("got replay %+v of %+v", replay, original)
+		}
+
+		if got := s.deliveries(t, alice, ownPath+"/deliveries?status=pending"); len(got) != 2 {
+			t.Errorf("got %d pending deliveries, want the original and the replay", len(got))
+		}
+		expectError(t, s.as(alice, "POST", ownPath+"/deliveries/"+primitive.NewObjectID().Hex()+"/replay", nil), http.StatusNotFound, "Delivery not found")
+		expectError(t, s.as(bob, "POST", ownPath+"/deliveries/"+original.ID.Hex()+"/replay", nil), http.StatusNotFound, "Webhook not found")
+	})
+
+	t.Run("turned off", func(t *testing.T) {
+		w := s.as(alice, "PUT", ownPath, gin.H{"active": false})
+		expectStatus(t, w, http.StatusOK)
+		var body webhookResponse
+		decodeInto(t, w, &body)
+		if body.Webhook.Active || body.Webhook.DisabledAt == nil {
+			t.Errorf("got %+v, want a disabled webhook", body.Webhook)
+		}
+
+		original := s.deliveries(t, alice, ownPath+"/deliveries")[0]
+		expectError(t, s.as(alice, "POST", ownPath+"/deliveries/"+original.ID.Hex()+"/replay", nil), http.StatusConflict, "Webhook is disabled, turn it on before replaying")
+
+		// A disabled webhook receives nothing new
+		expectStatus(t, s.as(bob, "POST", "/resolution/comments", gin.H{"r_id": resolution.RID.Hex(), "comment": "still going?"}), http.StatusCreated)
+		if got := s.deliveries(t, alice, ownPath+"/deliveries"); len(got) != 2 {
+			t.Errorf("got %d deliveries, want no new one", len(got))
+		}
+	})
+
+	t.Run("deleted with its deliveries", func(t *testing.T) {
+		expectStatus(t, s.as(alice, "DELETE", ownPath, nil), http.StatusOK)
+		if entry := s.audit.last(); entry.Action != audit.ActionWebhookDeleted || entry.TargetType != audit.TargetWebhook ||
+			entry.TargetID != own.ID.Hex() || entry.ActorID == nil || *entry.ActorID != alice.ID {
+			t.Errorf("got audit entry %+v, want Alice deleting the webhook", entry)
+		}
+		expectError(t, s.as(alice, "GET", ownPath+"/deliveries", nil), http.StatusNotFound, "Webhook not found")
+		left, err := s.repos.Deliveries.List(context.Background(), repository.DeliveryQuery{WebhookID: own.ID, Limit: 10})
+		if err != nil || len(left) != 0 {
+			t.Errorf("got %d deliveries left (%v), want none", len(left), err)
+		}
+	})
+
+	t.Run("at most ten per owner", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			s.createWebhook(t, bob, "", models.WebhookEventLikeUpdated)
+		}
+		w := s.as(bob, "POST", "/webhooks", gin.H{"url": "https://example.com/hook", "events": []string{models.WebhookEventLikeUpdated}})
+		expectError(t, w, http.StatusConflict, "Too many webhooks, delete one first")
+	})
+}
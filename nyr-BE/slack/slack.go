@@ -0,0 +1,111 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Requests older than this are rejected, so a captured request can't be replayed later
+const maxRequestAge = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid Slack signature")
+	ErrStaleRequest     = errors.New("stale Slack request")
+)
+
+// Sign returns the X-Slack-Signature value of a request body sent at the timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the X-Slack-Signature and X-Slack-Request-Timestamp headers of a request
+func Verify(secret, timestamp, signature string, body []byte, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return ErrStaleRequest
+	}
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SlashCommand is the form Slack posts when a user runs a slash command
+type SlashCommand struct {
+	TeamID      string
+	TeamDomain  string
+	UserID      string
+	UserName    string
+	ChannelID   string
+	Command     string
+	Text        string
+	ResponseURL string
+}
+
+// ParseSlashCommand reads a slash command from the posted form
+func ParseSlashCommand(form url.Values) SlashCommand {
+	return SlashCommand{
+		TeamID:      form.Get("team_id"),
+		TeamDomain:  form.Get("team_domain"),
+		UserID:      form.Get("user_id"),
+		UserName:    form.Get("user_name"),
+		ChannelID:   form.Get("channel_id"),
+		Command:     form.Get("command"),
+		Text:        form.Get("text"),
+		ResponseURL: form.Get("response_url"),
+	}
+}
+
+// InteractionPayload is the JSON Slack posts in the payload field when a user interacts with
+// a message or submits a modal. Only the fields used here are decoded.
+type InteractionPayload struct {
+	Type string `json:"type"`
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	View struct {
+		CallbackID string `json:"callback_id"`
+		State      struct {
+			Values map[string]map[string]struct {
+				Value string `json:"value"`
+			} `json:"values"`
+		} `json:"state"`
+	} `json:"view"`
+}
+
+// Value returns the submitted value of the input in the block, by convention the
+// input's action_id is the same as the block_id
+func (p InteractionPayload) Value(blockID string) string {
+	return p.View.State.Values[blockID][blockID].Value
+}
+
+// Message is a reply to a slash command
+type Message struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// Ephemeral is a reply only the user who ran the command sees
+func Ephemeral(text string) Message {
+	return Message{ResponseType: "ephemeral", Text: text}
+}
+
+// InChannel is a reply everyone in the channel sees
+func InChannel(text string) Message {
+	return Message{ResponseType: "in_channel", Text: text}
+}
@@ -0,0 +1,66 @@
+// Package slacktest builds signed requests shaped like the ones Slack sends, so the Slack
+// endpoints can be exercised locally without a workspace.
+package slacktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nyr/slack"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewSlashCommandRequest returns a signed slash command request, like running
+// "/resolve <text>" as the user in the team
+func NewSlashCommandRequest(target, secret, teamID, userID, text string) *http.Request {
+	form := url.Values{}
+	form.Set("token", "deprecated-verification-token")
+	form.Set("team_id", teamID)
+	form.Set("team_domain", "example")
+	form.Set("channel_id", "C0000000001")
+	form.Set("channel_name", "general")
+	form.Set("user_id", userID)
+	form.Set("user_name", "tester")
+	form.Set("command", "/resolve")
+	form.Set("text", text)
+	form.Set("response_url", "https://hooks.slack.com/commands/T0000/0000/fake")
+	form.Set("trigger_id", "0000.0000.fake")
+	return signedRequest(target, secret, form.Encode())
+}
+
+// NewViewSubmissionRequest returns a signed interactivity request for a submitted modal.
+// Each value becomes an input whose block_id and action_id are the key.
+func NewViewSubmissionRequest(target, secret, teamID, userID, callbackID string, values map[string]string) *http.Request {
+	state := map[string]map[string]map[string]string{}
+	for key, value := range values {
+		state[key] = map[string]map[string]string{key: {"type": "plain_text_input", "value": value}}
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type": "view_submission",
+		"team": map[string]string{"id": teamID, "domain": "example"},
+		"user": map[string]string{"id": userID, "username": "tester", "team_id": teamID},
+		"view": map[string]interface{}{
+			"id":          "V0000000001",
+			"type":        "modal",
+			"callback_id": callbackID,
+			"state":       map[string]interface{}{"values": state},
+		},
+	})
+
+	form := url.Values{}
+	form.Set("payload", string(payload))
+	return signedRequest(target, secret, form.Encode())
+}
+
+func signedRequest(target, secret, body string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", slack.Sign(secret, timestamp, []byte(body)))
+	return req
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const magicLinkAudience = "magic_link"
+
+// MagicLinkClaims identify a single magic link by its ID and the email it was sent to
+type MagicLinkClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// NewMagicLinkToken signs a magic link token that expires after ttl
+func NewMagicLinkToken(id, email string, ttl time.Duration) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, MagicLinkClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Audience:  jwt.ClaimStrings{magicLinkAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}).SignedString(jwtSecretKey)
+}
+
+// VerifyMagicLinkToken checks the signature and expiry of a magic link token.
+// It does not check whether the link was already used.
+func VerifyMagicLinkToken(tokenString string) (*MagicLinkClaims, error) {
+	claims := &MagicLinkClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecretKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if !claims.VerifyAudience(magicLinkAudience, true) || claims.ID == "" || claims.Email == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can be told apart from JWTs
+const PersonalAccessTokenPrefix = "nyr_pat_"
+
+// GeneratePersonalAccessToken returns a new random token and the hash to store for it
+func GeneratePersonalAccessToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashPersonalAccessToken(token), nil
+}
+
+// HashPersonalAccessToken returns the hex encoded SHA-256 of a token
+func HashPersonalAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsPersonalAccessToken reports whether a bearer token looks like a personal access token
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const slackLinkAudience = "slack_link"
+
+// SlackLinkClaims identify the Slack user who asked to link their ResolveXYZ account.
+// The workspace domain and user name are shown when the link is opened, so whoever opens
+// it can see which Slack account they are about to connect.
+type SlackLinkClaims struct {
+	TeamID        string `json:"team_id"`
+	TeamDomain    string `json:"team_domain"`
+	SlackUserID   string `json:"slack_user_id"`
+	SlackUserName string `json:"slack_user_name"`
+	jwt.RegisteredClaims
+}
+
+// NewSlackLinkToken signs a token for the account linking link that expires after ttl
+func NewSlackLinkToken(teamID, teamDomain, slackUserID, slackUserName string, ttl time.Duration) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, SlackLinkClaims{
+		TeamID:        teamID,
+		TeamDomain:    teamDomain,
+		SlackUserID:   slackUserID,
+		SlackUserName: slackUserName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{slackLinkAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}).SignedString(jwtSecretKey)
+}
+
+// VerifySlackLinkToken checks the signature and expiry of an account linking token
+func VerifySlackLinkToken(tokenString string) (*SlackLinkClaims, error) {
+	claims := &SlackLinkClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecretKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if !claims.VerifyAudience(slackLinkAudience, true) || claims.TeamID == "" || claims.SlackUserID == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
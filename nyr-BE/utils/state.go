@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// stateClaims ties an OAuth state parameter to the provider it was issued for and to the
+// browser that started the sign in
+type stateClaims struct {
+	Provider  string `json:"provider"`
+	Challenge string `json:"challenge"`
+	jwt.RegisteredClaims
+}
+
+// OAuthChallenge returns the challenge for a verifier: its SHA-256, base64url encoded
+// without padding, like PKCE's S256 method
+func OAuthChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidOAuthChallenge reports whether the challenge looks like one made by OAuthChallenge
+func ValidOAuthChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// NewOAuthState returns a signed, short lived state parameter for the given provider.
+// The app keeps a random verifier until the callback and sends its challenge here, so
+// the state can only be redeemed by the browser that started the sign in.
+func NewOAuthState(provider, challenge string) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, stateClaims{
+		Provider:  provider,
+		Challenge: challenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(10 * time.Minute)),
+		},
+	}).SignedString(jwtSecretKey)
+}
+
+// VerifyOAuthState checks that the state was issued by us for the given provider, has not
+// expired and was started by whoever holds the verifier. Without the last check an attacker
+// could have a victim's browser complete the attacker's own sign in.
+func VerifyOAuthState(state, provider, verifier string) error {
+	claims := &stateClaims{}
+	token, err := jwt.ParseWithClaims(state, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecretKey, nil
+	})
+	if err != nil || !token.Valid {
+		return errors.New("invalid state")
+	}
+
+	if claims.Provider != provider {
+		return errors.New("state was issued for another provider")
+	}
+	if claims.Challenge == "" || subtle.ConstantTimeCompare([]byte(OAuthChallenge(verifier)), []byte(claims.Challenge)) != 1 {
+		return errors.New("state was issued to another browser")
+	}
+
+	return nil
+}
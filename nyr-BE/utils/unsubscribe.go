@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// unsubscribeKey signs the unsubscribe links. It is derived from the JWT secret instead of
+// being the secret itself, so nothing signed for one purpose is valid for the other.
+var unsubscribeKey = deriveKey("unsubscribe links")
+
+// deriveKey returns a key for one purpose, the HMAC-SHA256 of the purpose under the JWT secret
+func deriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, jwtSecretKey)
+	mac.Write([]byte("nyr key:" + purpose))
+	return mac.Sum(nil)
+}
+
+// UnsubscribeToken signs a user and email category, so the unsubscribe link in an
+// email works without signing in but can't be changed to another user or category
+func UnsubscribeToken(userID, category string) string {
+	mac := hmac.New(sha256.New, unsubscribeKey)
+	mac.Write([]byte("unsubscribe:" + userID + ":" + category))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyUnsubscribeToken reports whether the token was made for the user and category
+func VerifyUnsubscribeToken(userID, category, token string) bool {
+	return hmac.Equal([]byte(UnsubscribeToken(userID, category)), []byte(token))
+}
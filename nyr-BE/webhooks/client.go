@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a webhook URL resolves to an address inside our own network
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// errRedirect is returned for endpoints that redirect, deliveries never follow them
+var errRedirect = errors.New("webhook endpoint redirected, redirects are not followed")
+
+// client sends the deliveries. The address is checked after DNS resolution, right before
+// connecting, so a host that resolves to a private address later is refused too.
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: dialControl,
+		}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return errRedirect
+	},
+}
+
+// dialControl refuses connections to addresses that aren't public
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	if !PublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, ip)
+	}
+	return nil
+}
+
+// PublicIP reports whether deliveries may be sent to the address. Loopback, private,
+// link-local, multicast and unspecified addresses are refused, unless WEBHOOK_ALLOW_PRIVATE
+// is set for local development.
+func PublicIP(ip net.IP) bool {
+	if os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true" {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
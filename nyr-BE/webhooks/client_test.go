@@ -0,0 +1,80 @@
+package webhooks
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	cases := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tc := range cases {
+		if got := PublicIP(net.ParseIP(tc.ip)); got != tc.public {
+			t.Errorf("PublicIP(%s) = %v, want %v", tc.ip, got, tc.public)
+		}
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	if !PublicIP(net.ParseIP("127.0.0.1")) {
+		t.Error("PublicIP refused loopback with WEBHOOK_ALLOW_PRIVATE set")
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	_, err := client.Post(server.URL, "application/json", nil)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("posting to %s returned %v, want ErrPrivateAddress", server.URL, err)
+	}
+	if calls != 0 {
+		t.Errorf("the endpoint was called %d times", calls)
+	}
+}
+
+func TestClientRefusesRedirects(t *testing.T) {
+	// The test servers listen on loopback
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	_, err := client.Post(server.URL, "application/json", nil)
+	if !errors.Is(err, errRedirect) {
+		t.Fatalf("posting to a redirect returned %v, want errRedirect", err)
+	}
+	if redirected {
+		t.Error("the redirect was followed")
+	}
+}
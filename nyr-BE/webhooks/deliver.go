@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"nyr/models"
+	"nyr/repository"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAttempts = 8
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	// A claimed delivery is retried by another instance if it isn't finished in time
+	deliveryLease = 2 * time.Minute
+	// Failed attempts in a row after which a webhook is disabled
+	disableAfterFailures = 10
+)
+
+// RunDeliveries sends due deliveries, checking every interval until ctx is done
+func RunDeliveries(ctx context.Context, repos repository.Repositories, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deliverDue(ctx, repos)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue claims due deliveries one at a time by pushing their next attempt past the
+// lease, so several instances never send the same attempt
+func deliverDue(ctx context.Context, repos repository.Repositories) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		delivery, err := repos.Deliveries.Claim(ctx, now, now.Add(deliveryLease))
+		if errors.Is(err, repository.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error claiming webhook delivery: %v", err)
+			return
+		}
+		attempt(ctx, repos, delivery)
+	}
+}
+
+// attempt sends the delivery once and records the outcome. Failed attempts are retried with
+// exponential backoff until maxAttempts, and count towards disabling the webhook.
+func attempt(ctx context.Context, repos repository.Repositories, delivery models.WebhookDelivery) {
+	now := time.Now()
+	delivery.UpdatedAt = now
+
+	hook, err := repos.Webhooks.FindByID(ctx, delivery.WebhookID)
+	if err != nil || !hook.Active {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.LastError = "webhook is disabled or deleted"
+		delivery.NextAttemptAt = nil
+		if err := repos.Deliveries.Save(ctx, delivery); err != nil {
+			log.Printf("Error updating webhook delivery: %v", err)
+		}
+		return
+	}
+
+	statusCode, sendErr := send(ctx, hook, delivery)
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	if sendErr == nil {
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = sendErr.Error()
+		if delivery.Attempts >= maxAttempts {
+			delivery.Status = models.WebhookDeliveryFailed
+			delivery.NextAttemptAt = nil
+		} else {
+			next := now.Add(backoff(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+		}
+	}
+	if err := repos.Deliveries.Save(ctx, delivery); err != nil {
+		log.Printf("Error updating webhook delivery: %v", err)
+	}
+
+	if sendErr == nil {
+		if hook.ConsecutiveFailures > 0 {
+			if err := repos.Webhooks.ResetFailures(ctx, hook.ID); err != nil {
+				log.Printf("Error resetting webhook failures: %v", err)
+			}
+		}
+		return
+	}
+
+	updated, err := repos.Webhooks.RecordFailure(ctx, hook.ID, disableAfterFailures, now)
+	if err != nil {
+		log.Printf("Error recording webhook failure: %v", err)
+		return
+	}
+	if !updated.Active && updated.ConsecutiveFailures == disableAfterFailures {
+		log.Printf("Disabled webhook %s after %d failed attempts", hook.ID.Hex(), updated.ConsecutiveFailures)
+	}
+}
+
+// send posts the payload with its signature and reports the response status. Any status
+// outside 2xx is an error.
+func send(ctx context.Context, hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ResolveXYZ-Webhooks/1.0")
+	req.Header.Set("X-Resolve-Event", delivery.Event)
+	req.Header.Set("X-Resolve-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Resolve-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Resolve-Signature", Sign(hook.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the wait before the next attempt: 30s, 1m, 2m, ... capped at maxBackoff
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff << (attempts - 1)
+	if wait > maxBackoff || wait <= 0 {
+		return maxBackoff
+	}
+	return wait
+}
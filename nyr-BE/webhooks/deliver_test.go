@@ -0,0 +1,269 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"nyr/models"
+	"nyr/pubsub"
+	"nyr/repository"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", 1700000000, []byte(`{"a":1}`))
+	want := "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if Sign("other", 1700000000, []byte(`{"a":1}`)) == want || Sign("secret", 1700000001, []byte(`{"a":1}`)) == want {
+		t.Error("the signature doesn't depend on the secret and timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tc := range cases {
+		if got := backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %s, want %s", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+// endpoint is a webhook receiver answering with status, it counts the requests it gets
+type endpoint struct {
+	*httptest.Server
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+func newEndpoint(t *testing.T, status int, check func(r *http.Request, body []byte)) *endpoint {
+	t.Helper()
+	// The test server listens on loopback
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	e := &endpoint{}
+	e.status.Store(int32(status))
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.requests.Add(1)
+		if check != nil {
+			body, _ := io.ReadAll(r.Body)
+			check(r, body)
+		}
+		w.WriteHeader(int(e.status.Load()))
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func addWebhook(t *testing.T, repos repository.Repositories, owner *primitive.ObjectID, url string, events ...string) models.Webhook {
+	t.Helper()
+	hook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		UserID:    owner,
+		URL:       url,
+		Secret:    "whsec_test",
+		Events:    events,
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := repos.Webhooks.Insert(context.Background(), hook); err != nil {
+		t.Fatalf("inserting webhook: %v", err)
+	}
+	return hook
+}
+
+func queued(t *testing.T, repos repository.Repositories, hook models.Webhook) []models.WebhookDelivery {
+	t.Helper()
+	deliveries, err := repos.Deliveries.List(context.Background(), repository.DeliveryQuery{WebhookID: hook.ID, Limit: 100})
+	if err != nil {
+		t.Fatalf("listing deliveries: %v", err)
+	}
+	return deliveries
+}
+
+func TestEnqueue(t *testing.T) {
+	repos := repository.NewMemory()
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	global := addWebhook(t, repos, nil, "https://example.com/global", models.WebhookEventCommentCreated)
+	own := addWebhook(t, repos, &owner, "https://example.com/own", models.WebhookEventCommentCreated, models.WebhookEventLikeUpdated)
+	others := addWebhook(t, repos, &other, "https://example.com/other", models.WebhookEventCommentCreated)
+	likesOnly := addWebhook(t, repos, &owner, "https://example.com/likes", models.WebhookEventLikeUpdated)
+	off := addWebhook(t, repos, &owner, "https://example.com/off", models.WebhookEventCommentCreated)
+	active := false
+	if _, err := repos.Webhooks.Update(ctx, off.ID, &owner, repository.WebhookUpdate{Active: &active, At: time.Now()}); err != nil {
+		t.Fatalf("turning webhook off: %v", err)
+	}
+
+	event := pubsub.NewEvent("resolution:x", models.WebhookEventCommentCreated, other, map[string]string{"comment": "hi"})
+	if err := Enqueue(ctx, repos, event, owner); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	// Events webhooks can't subscribe to are never queued
+	if err := Enqueue(ctx, repos, pubsub.NewEvent("user:x", pubsub.EventNotificationCreated, other, nil), owner); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	for _, tc := range []struct {
+		hook models.Webhook
+		want int
+	}{
+		{global, 1},
+		{own, 1},
+		{others, 0},
+		{likesOnly, 0},
+		{off, 0},
+	} {
+		if got := queued(t, repos, tc.hook); len(got) != tc.want {
+			t.Errorf("%s got %d deliveries, want %d", tc.hook.URL, len(got), tc.want)
+		}
+	}
+
+	delivery := queued(t, repos, own)[0]
+	var payload struct {
+		ID   string            `json:"id"`
+		Type string            `json:"type"`
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(delivery.Payload), &payload); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	if payload.ID != event.ID || delivery.EventID != event.ID || payload.Type != event.Type || payload.Data["comment"] != "hi" {
+		t.Errorf("got delivery %+v with payload %+v, want the event %+v", delivery, payload, event)
+	}
+	if delivery.Status != models.WebhookDeliveryPending || delivery.NextAttemptAt == nil {
+		t.Errorf("got delivery %+v, want it pending and due", delivery)
+	}
+}
+
+// queue stores n deliveries of the webhook that are due now
+func queue(t *testing.T, repos repository.Repositories, hook models.Webhook, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		event := pubsub.NewEvent("feed", models.WebhookEventResolutionCreated, primitive.NilObjectID, map[string]int{"n": i})
+		if err := Enqueue(context.Background(), repos, event, primitive.NilObjectID); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+}
+
+func TestDeliverySucceeds(t *testing.T) {
+	repos := repository.NewMemory()
+	var hook models.Webhook
+	server := newEndpoint(t, http.StatusNoContent, func(r *http.Request, body []byte) {
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Resolve-Timestamp"), 10, 64)
+		if got, want := r.Header.Get("X-Resolve-Signature"), Sign(hook.Secret, timestamp, body); got != want {
+			t.Errorf("got signature %s, want %s", got, want)
+		}
+		if r.Header.Get("X-Resolve-Event") != models.WebhookEventResolutionCreated {
+			t.Errorf("got event header %q", r.Header.Get("X-Resolve-Event"))
+		}
+	})
+	hook = addWebhook(t, repos, nil, server.URL, models.WebhookEventResolutionCreated)
+	queue(t, repos, hook, 1)
+
+	deliverDue(context.Background(), repos)
+
+	delivery := queued(t, repos, hook)[0]
+	if delivery.Status != models.WebhookDeliverySucceeded || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusNoContent ||
+		delivery.DeliveredAt == nil || delivery.NextAttemptAt != nil {
+		t.Errorf("got %+v, want a delivery that succeeded on the first attempt", delivery)
+	}
+	if got := server.requests.Load(); got != 1 {
+		t.Errorf("the endpoint got %d requests, want 1", got)
+	}
+}
+
+func TestFailedDeliveryIsRetried(t *testing.T) {
+	repos := repository.NewMemory()
+	server := newEndpoint(t, http.StatusInternalServerError, nil)
+	hook := addWebhook(t, repos, nil, server.URL, models.WebhookEventResolutionCreated)
+	queue(t, repos, hook, 1)
+
+	before := time.Now()
+	deliverDue(context.Background(), repos)
+
+	delivery := queued(t, repos, hook)[0]
+	if delivery.Status != models.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusInternalServerError || delivery.LastError == "" {
+		t.Fatalf("got %+v, want a pending delivery with one failed attempt", delivery)
+	}
+	if delivery.NextAttemptAt == nil || delivery.NextAttemptAt.Before(before.Add(baseBackoff)) {
+		t.Errorf("next attempt at %v, want after the backoff", delivery.NextAttemptAt)
+	}
+
+	// Nothing is due until the backoff passed
+	deliverDue(context.Background(), repos)
+	if got := server.requests.Load(); got != 1 {
+		t.Errorf("the endpoint got %d requests, want 1", got)
+	}
+
+	// The last attempt gives up
+	delivery.Attempts = maxAttempts - 1
+	attempt(context.Background(), repos, delivery)
+	delivery = queued(t, repos, hook)[0]
+	if delivery.Status != models.WebhookDeliveryFailed || delivery.Attempts != maxAttempts || delivery.NextAttemptAt != nil {
+		t.Errorf("got %+v, want a failed delivery without a next attempt", delivery)
+	}
+}
+
+func TestWebhookDisabledAfterFailures(t *testing.T) {
+	repos := repository.NewMemory()
+	ctx := context.Background()
+	server := newEndpoint(t, http.StatusBadGateway, nil)
+	hook := addWebhook(t, repos, nil, server.URL, models.WebhookEventResolutionCreated)
+
+	// A success in between starts the count again
+	queue(t, repos, hook, disableAfterFailures-1)
+	deliverDue(ctx, repos)
+	server.status.Store(http.StatusOK)
+	queue(t, repos, hook, 1)
+	deliverDue(ctx, repos)
+	if hook, _ = repos.Webhooks.FindByID(ctx, hook.ID); !hook.Active || hook.ConsecutiveFailures != 0 {
+		t.Fatalf("got %+v, want an active webhook without failures", hook)
+	}
+
+	server.status.Store(http.StatusBadGateway)
+	queue(t, repos, hook, disableAfterFailures)
+	deliverDue(ctx, repos)
+	hook, _ = repos.Webhooks.FindByID(ctx, hook.ID)
+	if hook.Active || hook.DisabledAt == nil || hook.ConsecutiveFailures != disableAfterFailures {
+		t.Fatalf("got %+v, want a webhook disabled after %d failures", hook, disableAfterFailures)
+	}
+
+	// Deliveries due for a disabled webhook fail without being sent
+	sent := server.requests.Load()
+	for _, delivery := range queued(t, repos, hook)[:1] {
+		delivery.NextAttemptAt = &time.Time{}
+		if err := repos.Deliveries.Save(ctx, delivery); err != nil {
+			t.Fatalf("saving delivery: %v", err)
+		}
+	}
+	deliverDue(ctx, repos)
+	if got := server.requests.Load(); got != sent {
+		t.Errorf("the endpoint got %d more requests", got-sent)
+	}
+	delivery := queued(t, repos, hook)[0]
+	if delivery.Status != models.WebhookDeliveryFailed || delivery.LastError != "webhook is disabled or deleted" {
+		t.Errorf("got %+v, want a failed delivery", delivery)
+	}
+}
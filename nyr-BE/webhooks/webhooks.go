@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"nyr/models"
+	"nyr/pubsub"
+	"nyr/repository"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Enqueue stores a pending delivery of the event for each webhook subscribed to it: the
+// global ones and those of owner, the user whose resolution the event is about. The
+// handlers call it right where the event happens instead of relying on the bus, which
+// drops events for subscribers that fall behind.
+func Enqueue(ctx context.Context, repos repository.Repositories, event pubsub.Event, owner primitive.ObjectID) error {
+	if !subscribable(event.Type) {
+		return nil
+	}
+
+	hooks, err := repos.Webhooks.Subscribed(ctx, event.Type, owner)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         event.ID,
+		"type":       event.Type,
+		"created_at": event.CreatedAt,
+		"data":       event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, hook := range hooks {
+		err := repos.Deliveries.Insert(ctx, models.WebhookDelivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     hook.ID,
+			EventID:       event.ID,
+			Event:         event.Type,
+			Payload:       string(payload),
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subscribable(eventType string) bool {
+	for _, e := range models.WebhookEvents {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature header value of a delivery: the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the webhook's secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}